@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"flag"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -12,36 +16,135 @@ import (
 	"github.com/dlion/faceit_challenge/internal/api/http"
 	"github.com/dlion/faceit_challenge/internal/api/http/handlers"
 	"github.com/dlion/faceit_challenge/internal/domain/services/user"
-	repositories "github.com/dlion/faceit_challenge/internal/repositories/mongo"
+	"github.com/dlion/faceit_challenge/internal/domain/services/webhook"
+	"github.com/dlion/faceit_challenge/internal/outbox"
+	"github.com/dlion/faceit_challenge/internal/pagetoken"
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	memoryrepo "github.com/dlion/faceit_challenge/internal/repositories/memory"
+	mongorepo "github.com/dlion/faceit_challenge/internal/repositories/mongo"
+	postgresrepo "github.com/dlion/faceit_challenge/internal/repositories/postgres"
+	sqliterepo "github.com/dlion/faceit_challenge/internal/repositories/sqlite"
+	dispatcher "github.com/dlion/faceit_challenge/internal/webhook"
+	"github.com/dlion/faceit_challenge/pkg/cloudevents"
 	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/dlion/faceit_challenge/pkg/notifier/kafkapublisher"
+	"github.com/dlion/faceit_challenge/pkg/notifier/natspublisher"
 	"github.com/dlion/faceit_challenge/pkg/proto"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/nats-io/nats.go"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
-	WR_TIMEOUT      = 15
-	IDLE_TIMEOUT    = 60
-	MONGODB_ENV_VAR = "MONGODB_URI"
+	WR_TIMEOUT         = 15
+	IDLE_TIMEOUT       = 60
+	MONGODB_ENV_VAR    = "MONGODB_URI"
+	POSTGRES_ENV_VAR   = "POSTGRES_DSN"
+	SQLITE_ENV_VAR     = "SQLITE_PATH"
+	REPOSITORY_ENV_VAR = "USER_REPOSITORY"
+	PAGE_TOKEN_ENV_VAR = "PAGE_TOKEN_SECRET"
+
+	CHANGE_STREAM_ENV_VAR    = "MONGODB_CHANGE_STREAM"
+	CHANGE_STREAM_ID_ENV_VAR = "CHANGE_STREAM_ID"
+
+	SUBSCRIBER_BUFFER_ENV_VAR        = "SUBSCRIBER_BUFFER_SIZE"
+	SUBSCRIBER_BACKPRESSURE_ENV_VAR  = "SUBSCRIBER_BACKPRESSURE"
+	SUBSCRIBER_BLOCK_TIMEOUT_ENV_VAR = "SUBSCRIBER_BLOCK_TIMEOUT"
+
+	WEBSOCKET_ORIGINS_ENV_VAR = "WEBSOCKET_ALLOWED_ORIGINS"
+
+	NATS_URL_ENV_VAR            = "NATS_URL"
+	NATS_STREAM_ENV_VAR         = "NATS_STREAM"
+	NATS_SUBJECT_PREFIX_ENV_VAR = "NATS_SUBJECT_PREFIX"
+	// The subject of an operation is set by NATS_SUBJECT_ followed by its type in upper case.
+	NATS_SUBJECT_ENV_VAR_PREFIX = "NATS_SUBJECT_"
+
+	KAFKA_BROKERS_ENV_VAR  = "KAFKA_BROKERS"
+	KAFKA_TOPIC_ENV_VAR    = "KAFKA_TOPIC"
+	KAFKA_ENCODING_ENV_VAR = "KAFKA_ENCODING"
+
+	CLOUDEVENTS_SOURCE_ENV_VAR       = "CLOUDEVENTS_SOURCE"
+	WEBHOOK_CLOUDEVENTS_MODE_ENV_VAR = "WEBHOOK_CLOUDEVENTS_MODE"
+
+	DEFAULT_SQLITE_PATH = "users.db"
+
+	// The names the publishers of the outbox relay record their cursors with.
+	WEBHOOKS_PUBLISHER = "webhooks"
+	NATS_PUBLISHER     = "nats"
+	KAFKA_PUBLISHER    = "kafka"
+
+	MONGO_REPOSITORY    = "mongo"
+	MEMORY_REPOSITORY   = "memory"
+	POSTGRES_REPOSITORY = "postgres"
+	SQLITE_REPOSITORY   = "sqlite"
 )
 
+type userStorage interface {
+	repositories.UserRepository
+	repositories.Outbox
+	repositories.ChangeLog
+	repositories.PublishCursors
+	repositories.WebhookStore
+	handlers.Pinger
+}
+
 func main() {
+	repositoryType := flag.String("repository", getEnvVariableOrDefault(REPOSITORY_ENV_VAR, MONGO_REPOSITORY), "storage backend for the users (mongo, postgres, sqlite, memory)")
+	changeStream := flag.Bool("change-stream", os.Getenv(CHANGE_STREAM_ENV_VAR) == "true", "broadcast the changes of the users from the MongoDB change stream")
+	flag.Parse()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	mongodbURI := getMongoDBURIfromEnvVariable()
-	mongoClient := createMongoClient(ctx, mongodbURI)
-	userRepo := repositories.NewUserRepositoryMongoImpl(mongoClient)
-	userChangeNotifier := notifier.NewNotifier()
-	userService := user.NewUserService(userRepo, userChangeNotifier)
+	userRepo := createUserRepository(ctx, *repositoryType)
+	userChangeNotifier := notifier.NewNotifier(createSubscriberOptions()...)
+
+	// The changes are recorded by the repository in its outbox and broadcast by the relay,
+	// or by the change stream watcher, which only leaves the relay to keep the change log.
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+	// The changes are sent as CloudEvents from the source to the brokers, and to the clients asking for them.
+	eventSource := getEnvVariableOrDefault(CLOUDEVENTS_SOURCE_ENV_VAR, cloudevents.DEFAULT_SOURCE)
 
-	grpcServer := createGrpcServer(userService)
+	// The relay publishes the changes recorded in the outbox to the webhook dispatcher, which records
+	// their deliveries, and to every broker, each one from its own cursor. The ones broadcast from the
+	// change stream only are not published.
+	webhookDispatcher := dispatcher.NewDispatcher(userRepo, createDispatcherOptions(eventSource)...)
+	go webhookDispatcher.Run(relayCtx)
+	defer webhookDispatcher.Close()
+	relayOptions := []outbox.Option{outbox.WithPublisher(WEBHOOKS_PUBLISHER, webhookDispatcher)}
+	for _, brokerPublisher := range createBrokerPublishers(ctx, eventSource) {
+		defer brokerPublisher.publisher.Close()
+		relayOptions = append(relayOptions, outbox.WithPublisher(brokerPublisher.name, brokerPublisher.publisher))
+	}
+	if *changeStream {
+		go createChangeStreamWatcher(userRepo, userChangeNotifier).Run(relayCtx)
+		relayOptions = append(relayOptions, outbox.WithoutBroadcast())
+	}
+	outboxRelay := outbox.NewRelay(userRepo, userChangeNotifier, relayOptions...)
+	go outboxRelay.Run(relayCtx)
+
+	userService := user.NewUserService(userRepo, userChangeNotifier,
+		user.WithPageTokenCodec(createPageTokenCodec()),
+		user.WithOutboxRelay(outboxRelay),
+		user.WithChangeLog(userRepo),
+	)
+
+	webhookService := webhook.NewWebhookService(userRepo, webhook.WithDispatcher(webhookDispatcher))
+
+	grpcServer := createGrpcServer(userService, eventSource)
 	grpcServer.Start(":8080")
 
-	healthcheckHandler := handlers.NewHealthCheckHandler(mongoClient)
+	healthcheckHandler := handlers.NewHealthCheckHandler(userRepo)
 	userHandler := handlers.NewUserHandler(userService)
+	userHandler.EventSource = eventSource
+	webhookHandler := handlers.NewWebhookHandler(webhookService)
+	// Without allowed origins only the pages of the same origin can connect.
+	subscriptionHandler := handlers.NewSubscriptionHandler(userChangeNotifier, getEnvList(WEBSOCKET_ORIGINS_ENV_VAR))
+	subscriptionHandler.EventSource = eventSource
 
-	httpServer := defineHandlers(healthcheckHandler, userHandler)
+	httpServer := defineHandlers(healthcheckHandler, userHandler, webhookHandler, subscriptionHandler)
 	httpServer.Start()
 
 	c := make(chan os.Signal, 1)
@@ -51,16 +154,243 @@ func main() {
 	log.Printf("Received signal: %s. Initiating graceful shutdown...", sig)
 
 	shutdownServers(ctx, grpcServer, httpServer)
+	stopRelay()
 
 	log.Println("Server gracefully stopped")
 }
 
-func getMongoDBURIfromEnvVariable() string {
-	mongodbURI := os.Getenv(MONGODB_ENV_VAR)
-	if mongodbURI == "" {
-		log.Fatalf("%s environment variable is not set", MONGODB_ENV_VAR)
+func createUserRepository(ctx context.Context, repositoryType string) userStorage {
+	log.Printf("Using the %s repository", repositoryType)
+
+	switch repositoryType {
+	case MONGO_REPOSITORY:
+		mongodbURI := getEnvVariable(MONGODB_ENV_VAR)
+		mongoClient := createMongoClient(ctx, mongodbURI)
+		mongoRepo := mongorepo.NewUserRepositoryMongoImpl(mongoClient)
+		if err := mongoRepo.CreateIndexes(ctx); err != nil {
+			log.Fatalf("Failed to create the MongoDB indexes: %v", err)
+		}
+		if err := mongoRepo.BackfillSearchTerms(ctx); err != nil {
+			log.Fatalf("Failed to compute the search terms in MongoDB: %v", err)
+		}
+		if err := mongoRepo.BackfillVersions(ctx); err != nil {
+			log.Fatalf("Failed to set the versions of the users in MongoDB: %v", err)
+		}
+		if err := mongoRepo.BackfillEmptyFields(ctx); err != nil {
+			log.Fatalf("Failed to unset the empty fields of the users in MongoDB: %v", err)
+		}
+		return mongoRepo
+	case POSTGRES_REPOSITORY:
+		postgresDSN := getEnvVariable(POSTGRES_ENV_VAR)
+		postgresDB := createPostgresDB(ctx, postgresDSN)
+		postgresRepo := postgresrepo.NewUserRepositoryPostgresImpl(postgresDB)
+		if err := postgresRepo.BackfillSearchTerms(ctx); err != nil {
+			log.Fatalf("Failed to compute the search terms in PostgreSQL: %v", err)
+		}
+		return postgresRepo
+	case SQLITE_REPOSITORY:
+		sqlitePath := getEnvVariableOrDefault(SQLITE_ENV_VAR, DEFAULT_SQLITE_PATH)
+		sqliteDB := createSQLiteDB(ctx, sqlitePath)
+		sqliteRepo := sqliterepo.NewUserRepositorySQLiteImpl(sqliteDB)
+		if err := sqliteRepo.BackfillSearchTerms(ctx); err != nil {
+			log.Fatalf("Failed to compute the search terms in SQLite: %v", err)
+		}
+		return sqliteRepo
+	case MEMORY_REPOSITORY:
+		return memoryrepo.NewUserRepositoryMemoryImpl()
+	default:
+		log.Fatalf("Unknown repository type: %s", repositoryType)
+		return nil
+	}
+}
+
+func createChangeStreamWatcher(userRepo userStorage, userChangeNotifier notifier.Notifier) *mongorepo.ChangeStreamWatcher {
+	mongoRepo, ok := userRepo.(*mongorepo.UserRepositoryMongoImpl)
+	if !ok {
+		log.Fatalf("The change stream is only available with the %s repository", MONGO_REPOSITORY)
 	}
-	return mongodbURI
+
+	// Every replica resumes from its own token, the hostname is unique among the containers.
+	watcherId := os.Getenv(CHANGE_STREAM_ID_ENV_VAR)
+	if watcherId == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatalf("%s environment variable is not set and the hostname is unknown: %v", CHANGE_STREAM_ID_ENV_VAR, err)
+		}
+		watcherId = hostname
+	}
+
+	return mongorepo.NewChangeStreamWatcher(mongoRepo.Client(), userChangeNotifier, watcherId)
+}
+
+// createDispatcherOptions makes the webhooks receive the changes as CloudEvents if their mode is set.
+func createDispatcherOptions(eventSource string) []dispatcher.Option {
+	modeName := os.Getenv(WEBHOOK_CLOUDEVENTS_MODE_ENV_VAR)
+	if modeName == "" {
+		return nil
+	}
+
+	mode, err := cloudevents.ParseMode(modeName)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", WEBHOOK_CLOUDEVENTS_MODE_ENV_VAR, err)
+	}
+	return []dispatcher.Option{dispatcher.WithCloudEvents(eventSource, mode)}
+}
+
+// namedPublisher is a publisher of the changes, its name records how far it got in the change log.
+type namedPublisher struct {
+	name      string
+	publisher notifier.Publisher
+}
+
+// createBrokerPublishers returns the publishers to the brokers set by the environment, each retrying
+// its failed attempts.
+func createBrokerPublishers(ctx context.Context, eventSource string) []namedPublisher {
+	var publishers []namedPublisher
+	if publisher := createNatsPublisher(ctx, eventSource); publisher != nil {
+		publishers = append(publishers, namedPublisher{NATS_PUBLISHER, publisher})
+	}
+	if publisher := createKafkaPublisher(eventSource); publisher != nil {
+		publishers = append(publishers, namedPublisher{KAFKA_PUBLISHER, publisher})
+	}
+
+	for i := range publishers {
+		publishers[i].publisher = notifier.NewRetryPublisher(publishers[i].publisher, notifier.DEFAULT_PUBLISH_ATTEMPTS, notifier.DEFAULT_PUBLISH_BACKOFF)
+	}
+	return publishers
+}
+
+// createNatsPublisher connects to NATS if its URL is set, returning nil otherwise.
+func createNatsPublisher(ctx context.Context, eventSource string) notifier.Publisher {
+	natsURL := os.Getenv(NATS_URL_ENV_VAR)
+	if natsURL == "" {
+		return nil
+	}
+
+	conn, err := nats.Connect(natsURL, nats.Name("user-service"), nats.MaxReconnects(-1))
+	if err != nil {
+		log.Fatalf("Failed to connect to NATS: %v", err)
+	}
+
+	publisherOptions := []natspublisher.Option{natspublisher.WithSource(eventSource)}
+	if stream := os.Getenv(NATS_STREAM_ENV_VAR); stream != "" {
+		publisherOptions = append(publisherOptions, natspublisher.WithStream(stream))
+	}
+	if prefix := os.Getenv(NATS_SUBJECT_PREFIX_ENV_VAR); prefix != "" {
+		publisherOptions = append(publisherOptions, natspublisher.WithSubjectPrefix(prefix))
+	}
+	for _, operationType := range []string{notifier.ChangeOperationInsert, notifier.ChangeOperationUpdate, notifier.ChangeOperationDelete} {
+		if subject := os.Getenv(NATS_SUBJECT_ENV_VAR_PREFIX + strings.ToUpper(operationType)); subject != "" {
+			publisherOptions = append(publisherOptions, natspublisher.WithSubject(operationType, subject))
+		}
+	}
+
+	publisher, err := natspublisher.NewPublisher(conn, publisherOptions...)
+	if err != nil {
+		log.Fatalf("Failed to use NATS JetStream: %v", err)
+	}
+	if err := publisher.EnsureStream(ctx); err != nil {
+		log.Fatalf("Failed to create the NATS stream: %v", err)
+	}
+
+	log.Printf("Publishing the changes to NATS")
+	return publisher
+}
+
+// createKafkaPublisher connects to the Kafka brokers if they're set, returning nil otherwise.
+func createKafkaPublisher(eventSource string) notifier.Publisher {
+	brokers := getEnvList(KAFKA_BROKERS_ENV_VAR)
+	if len(brokers) == 0 {
+		return nil
+	}
+
+	publisherOptions := []kafkapublisher.Option{
+		kafkapublisher.WithTopic(getEnvVariableOrDefault(KAFKA_TOPIC_ENV_VAR, kafkapublisher.DEFAULT_TOPIC)),
+		kafkapublisher.WithSource(eventSource),
+	}
+	if encodingName := os.Getenv(KAFKA_ENCODING_ENV_VAR); encodingName != "" {
+		encoding, err := kafkapublisher.ParseEncoding(encodingName)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", KAFKA_ENCODING_ENV_VAR, err)
+		}
+		publisherOptions = append(publisherOptions, kafkapublisher.WithEncoding(encoding))
+	}
+
+	publisher, err := kafkapublisher.NewPublisher(brokers, publisherOptions...)
+	if err != nil {
+		log.Fatalf("Failed to create the Kafka client: %v", err)
+	}
+
+	log.Printf("Publishing the changes to Kafka")
+	return publisher
+}
+
+// createSubscriberOptions returns the buffer size and the backpressure policy of the subscribers
+// set by the environment, the notifier defaults are kept for the ones not set.
+func createSubscriberOptions() []notifier.SubscriberOption {
+	var subscriberOptions []notifier.SubscriberOption
+
+	if bufferSize := os.Getenv(SUBSCRIBER_BUFFER_ENV_VAR); bufferSize != "" {
+		size, err := strconv.Atoi(bufferSize)
+		if err != nil || size < 1 {
+			log.Fatalf("%s must be a positive number: %s", SUBSCRIBER_BUFFER_ENV_VAR, bufferSize)
+		}
+		subscriberOptions = append(subscriberOptions, notifier.WithBufferSize(size))
+	}
+
+	if policyName := os.Getenv(SUBSCRIBER_BACKPRESSURE_ENV_VAR); policyName != "" {
+		policy, err := notifier.ParseBackpressurePolicy(policyName)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", SUBSCRIBER_BACKPRESSURE_ENV_VAR, err)
+		}
+		subscriberOptions = append(subscriberOptions, notifier.WithBackpressurePolicy(policy))
+	}
+
+	if blockTimeout := os.Getenv(SUBSCRIBER_BLOCK_TIMEOUT_ENV_VAR); blockTimeout != "" {
+		timeout, err := time.ParseDuration(blockTimeout)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", SUBSCRIBER_BLOCK_TIMEOUT_ENV_VAR, err)
+		}
+		subscriberOptions = append(subscriberOptions, notifier.WithBlockTimeout(timeout))
+	}
+
+	return subscriberOptions
+}
+
+func createPageTokenCodec() *pagetoken.Codec {
+	secret := os.Getenv(PAGE_TOKEN_ENV_VAR)
+	if secret == "" {
+		log.Printf("%s environment variable is not set, page tokens won't survive a restart", PAGE_TOKEN_ENV_VAR)
+		return pagetoken.NewRandomCodec()
+	}
+	return pagetoken.NewCodec([]byte(secret))
+}
+
+func getEnvVariable(name string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		log.Fatalf("%s environment variable is not set", name)
+	}
+	return value
+}
+
+func getEnvVariableOrDefault(name, defaultValue string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvList splits a comma separated environment variable, skipping the empty values.
+func getEnvList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
 }
 
 func shutdownServers(ctx context.Context, grpcServer *grpc.Server, httpServer *http.Server) {
@@ -71,22 +401,33 @@ func shutdownServers(ctx context.Context, grpcServer *grpc.Server, httpServer *h
 	}
 }
 
-func defineHandlers(healthcheck *handlers.HealthCheckHandler, user *handlers.UserHandler) *http.Server {
+func defineHandlers(healthcheck *handlers.HealthCheckHandler, user *handlers.UserHandler, webhook *handlers.WebhookHandler, subscription *handlers.SubscriptionHandler) *http.Server {
 	httpServer := http.NewServer(":80", WR_TIMEOUT, IDLE_TIMEOUT)
 
 	httpServer.Router.HandleFunc("/api/health", healthcheck.HealthCheckHandler).Methods("GET")
 	httpServer.Router.HandleFunc("/api/users", user.GetUsersHandler).Methods("GET")
+	httpServer.Router.HandleFunc("/api/users/events", user.UserEventsHandler).Methods("GET")
+	httpServer.Router.HandleFunc("/api/users/ws", subscription.SubscriptionsHandler).Methods("GET")
 	httpServer.Router.HandleFunc("/api/user", user.AddUserHandler).Methods("POST")
+	httpServer.Router.HandleFunc("/api/user/{id}", user.GetUserHandler).Methods("GET")
 	httpServer.Router.HandleFunc("/api/user/{id}", user.UpdateUserHandler).Methods("PUT")
+	httpServer.Router.HandleFunc("/api/user/{id}", user.PatchUserHandler).Methods("PATCH")
 	httpServer.Router.HandleFunc("/api/user/{id}", user.RemoveUserHandler).Methods("DELETE")
+	httpServer.Router.HandleFunc("/api/webhooks", webhook.GetWebhooksHandler).Methods("GET")
+	httpServer.Router.HandleFunc("/api/webhooks", webhook.AddWebhookHandler).Methods("POST")
+	httpServer.Router.HandleFunc("/api/webhooks/dead-letters", webhook.GetDeadLettersHandler).Methods("GET")
+	httpServer.Router.HandleFunc("/api/webhooks/deliveries/{id}/redeliver", webhook.RedeliverHandler).Methods("POST")
+	httpServer.Router.HandleFunc("/api/webhooks/{id}", webhook.RemoveWebhookHandler).Methods("DELETE")
+	httpServer.Router.HandleFunc("/api/webhooks/{id}/deliveries", webhook.GetDeliveriesHandler).Methods("GET")
 	httpServer.HttpServer.Handler = httpServer.Router
 
 	return httpServer
 }
 
-func createGrpcServer(userService *user.UserServiceImpl) *grpc.Server {
+func createGrpcServer(userService *user.UserServiceImpl, eventSource string) *grpc.Server {
 	grpcServer := grpc.NewServer()
 	grpcUserHandler := grpc.NewUserGrpcHandler(userService)
+	grpcUserHandler.EventSource = eventSource
 	proto.RegisterUserServiceServer(grpcServer, grpcUserHandler)
 	return grpcServer
 }
@@ -99,3 +440,27 @@ func createMongoClient(ctx context.Context, mongodbURI string) *mongo.Client {
 	}
 	return mongoClient
 }
+
+func createPostgresDB(ctx context.Context, postgresDSN string) *sql.DB {
+	db, err := sql.Open("pgx", postgresDSN)
+	if err != nil {
+		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+
+	if err := postgresrepo.Migrate(ctx, db); err != nil {
+		log.Fatalf("Failed to migrate PostgreSQL: %v", err)
+	}
+	return db
+}
+
+func createSQLiteDB(ctx context.Context, sqlitePath string) *sql.DB {
+	db, err := sqliterepo.Open(sqlitePath)
+	if err != nil {
+		log.Fatalf("Failed to open SQLite: %v", err)
+	}
+
+	if err := sqliterepo.Migrate(ctx, db); err != nil {
+		log.Fatalf("Failed to migrate SQLite: %v", err)
+	}
+	return db
+}
@@ -2,18 +2,17 @@ package grpc
 
 import (
 	"context"
-	"errors"
 
 	"github.com/dlion/faceit_challenge/internal/domain/services/user"
-	repositories "github.com/dlion/faceit_challenge/internal/repositories/mongo"
 	"github.com/dlion/faceit_challenge/pkg/proto"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 type UserGrpcHandler struct {
 	proto.UnimplementedUserServiceServer
 	userService user.UserService
+	// EventSource is the CloudEvents source of the changes streamed by Watch,
+	// cloudevents.DEFAULT_SOURCE if empty.
+	EventSource string
 }
 
 func NewUserGrpcHandler(userService user.UserService) *UserGrpcHandler {
@@ -34,11 +33,7 @@ func (s *UserGrpcHandler) CreateUser(ctx context.Context, request *proto.CreateU
 
 	user, err := s.userService.NewUser(ctx, serviceReq)
 	if err != nil {
-		if errors.Is(err, repositories.ErrUserAlreadyExist) {
-			return nil, status.Errorf(codes.AlreadyExists, "user already exist in the db")
-		}
-
-		return nil, status.Error(codes.Internal, "can't create the user")
+		return nil, toStatusError(err, "can't create the user")
 	}
 
 	return toGrpcUser(user), nil
@@ -54,5 +49,6 @@ func toGrpcUser(user *user.User) *proto.User {
 		Country:   user.Country,
 		CreatedAt: user.CreatedAt,
 		UpdatedAt: user.UpdatedAt,
+		Version:   user.Version,
 	}
 }
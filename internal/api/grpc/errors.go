@@ -0,0 +1,59 @@
+package grpc
+
+import (
+	"log"
+
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// toStatusError translates a domain error to a gRPC status, the field violations are attached
+// as a google.rpc.BadRequest detail. Any other error is reported as internal hiding its details.
+func toStatusError(err error, fallbackMessage string) error {
+	kind := domainerrors.KindOf(err)
+	if kind == domainerrors.KindInternal {
+		return status.Error(codes.Internal, fallbackMessage)
+	}
+
+	st := status.New(codeOf(kind), domainerrors.MessageOf(err))
+
+	violations := domainerrors.ViolationsOf(err)
+	if len(violations) == 0 {
+		return st.Err()
+	}
+
+	badRequest := &errdetails.BadRequest{}
+	for _, violation := range violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       violation.Field,
+			Description: violation.Description,
+		})
+	}
+
+	stWithDetails, detailsErr := st.WithDetails(badRequest)
+	if detailsErr != nil {
+		log.Print("Can't attach the error details, ", detailsErr)
+		return st.Err()
+	}
+
+	return stWithDetails.Err()
+}
+
+func codeOf(kind domainerrors.Kind) codes.Code {
+	switch kind {
+	case domainerrors.KindNotFound:
+		return codes.NotFound
+	case domainerrors.KindConflict:
+		return codes.AlreadyExists
+	case domainerrors.KindInvalidArgument, domainerrors.KindValidation:
+		return codes.InvalidArgument
+	case domainerrors.KindOutOfRange:
+		return codes.OutOfRange
+	case domainerrors.KindFailedPrecondition:
+		return codes.FailedPrecondition
+	default:
+		return codes.Internal
+	}
+}
@@ -0,0 +1,45 @@
+package grpc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestToStatusError(t *testing.T) {
+	t.Run("Attach the field violations as BadRequest details", func(t *testing.T) {
+		err := domainerrors.NewValidation("invalid user", []domainerrors.FieldViolation{
+			{Field: "email", Description: "must be a valid email address"},
+		})
+
+		st := status.Convert(toStatusError(err, "can't create the user"))
+
+		assert.Equal(t, codes.InvalidArgument, st.Code())
+		assert.Equal(t, "invalid user", st.Message())
+		require.Len(t, st.Details(), 1)
+		badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+		require.True(t, ok)
+		assert.Equal(t, "email", badRequest.FieldViolations[0].Field)
+		assert.Equal(t, "must be a valid email address", badRequest.FieldViolations[0].Description)
+	})
+
+	t.Run("Translate the kind of the error to a code", func(t *testing.T) {
+		assert.Equal(t, codes.NotFound, status.Code(toStatusError(domainerrors.NewNotFound("user not found", nil), "")))
+		assert.Equal(t, codes.AlreadyExists, status.Code(toStatusError(domainerrors.NewConflict("user already exists", nil), "")))
+		assert.Equal(t, codes.InvalidArgument, status.Code(toStatusError(domainerrors.NewInvalidArgument("invalid user id", nil), "")))
+		assert.Equal(t, codes.OutOfRange, status.Code(toStatusError(domainerrors.NewOutOfRange("changes purged", nil), "")))
+	})
+
+	t.Run("Hide the details of internal errors", func(t *testing.T) {
+		st := status.Convert(toStatusError(errors.New("connection refused"), "can't get the users"))
+
+		assert.Equal(t, codes.Internal, st.Code())
+		assert.Equal(t, "can't get the users", st.Message())
+	})
+}
@@ -0,0 +1,17 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/dlion/faceit_challenge/pkg/proto"
+)
+
+func (s *UserGrpcHandler) GetUser(ctx context.Context, request *proto.GetUserRequest) (*proto.User, error) {
+
+	user, err := s.userService.GetUser(ctx, request.GetId())
+	if err != nil {
+		return nil, toStatusError(err, "can't get the user")
+	}
+
+	return toGrpcUser(user), nil
+}
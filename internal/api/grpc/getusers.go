@@ -2,75 +2,137 @@ package grpc
 
 import (
 	"context"
+	"strings"
+	"time"
 
 	filter "github.com/dlion/faceit_challenge/internal"
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/dlion/faceit_challenge/internal/domain/services/user"
 	"github.com/dlion/faceit_challenge/pkg/proto"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func (s *UserGrpcHandler) GetUsers(ctx context.Context, request *proto.GetUsersRequest) (*proto.GetUsersResponse, error) {
 
 	filter := request.GetFilter()
-	userFilter := toUserFilter(filter)
+	userFilter, err := toUserFilter(filter)
+	if err != nil {
+		return nil, toStatusError(err, "invalid filter")
+	}
+
+	if pageToken := request.GetPageToken(); pageToken != "" {
+		userFilter.PageToken = &pageToken
+	}
 
-	users, err := s.userService.GetUsers(ctx, userFilter)
+	page, err := s.userService.GetUsers(ctx, userFilter)
 	if err != nil {
-		return nil, status.Error(codes.Internal, "can't get the users")
+		return nil, toStatusError(err, "can't get the users")
 	}
 
-	userOutput := make([]*proto.User, len(users))
-	for i, u := range users {
+	return toGetUsersResponse(page), nil
+}
+
+func toGetUsersResponse(page *user.UsersPage) *proto.GetUsersResponse {
+	userOutput := make([]*proto.User, len(page.Users))
+	for i, u := range page.Users {
 		userOutput[i] = toGrpcUser(u)
 	}
 
-	return &proto.GetUsersResponse{Users: userOutput}, nil
+	return &proto.GetUsersResponse{
+		Users:         userOutput,
+		NextPageToken: page.NextPageToken,
+		Total:         page.Total,
+		Limit:         page.Limit,
+		Offset:        page.Offset,
+		HasMore:       page.HasMore,
+	}
 }
 
-func toUserFilter(userFilter *proto.UserFilter) *filter.UserFilter {
+func toUserFilter(userFilter *proto.UserFilter) (*filter.UserFilter, error) {
 	fbuilder := filter.NewFilterBuilder()
 
-	firstName := userFilter.FirstName
+	firstName := userFilter.GetFirstName()
 	if firstName != "" {
 		fbuilder.ByFirstName(&firstName)
 	}
 
-	lastName := userFilter.LastName
+	lastName := userFilter.GetLastName()
 	if lastName != "" {
 		fbuilder = fbuilder.ByLastName(&lastName)
 	}
 
-	nickname := userFilter.Nickname
+	nickname := userFilter.GetNickname()
 	if nickname != "" {
 		fbuilder = fbuilder.ByNickname(&nickname)
 	}
 
-	country := userFilter.Country
+	country := userFilter.GetCountry()
 	if country != "" {
 		fbuilder = fbuilder.ByCountry(&country)
 	}
 
-	email := userFilter.Email
+	email := userFilter.GetEmail()
 	if email != "" {
 		fbuilder = fbuilder.ByEmail(&email)
 	}
 
-	limit := userFilter.Limit
+	nicknamePrefix := userFilter.GetNicknamePrefix()
+	if nicknamePrefix != "" {
+		fbuilder = fbuilder.ByNicknamePrefix(&nicknamePrefix)
+	}
+
+	emailIgnoringCase := userFilter.GetEmailIgnoreCase()
+	if emailIgnoringCase != "" {
+		fbuilder = fbuilder.ByEmailIgnoringCase(&emailIgnoringCase)
+	}
+
+	if countries := userFilter.GetCountries(); len(countries) > 0 {
+		fbuilder = fbuilder.ByCountries(countries...)
+	}
+
+	fbuilder = fbuilder.
+		CreatedAfter(toTime(userFilter.GetCreatedAfter())).
+		CreatedBefore(toTime(userFilter.GetCreatedBefore())).
+		UpdatedSince(toTime(userFilter.GetUpdatedSince()))
+
+	limit := userFilter.GetLimit()
 	if limit > 0 {
 		fbuilder.WithLimit(&limit)
 	} else {
 		fbuilder.WithLimit(intToint64(10))
 	}
 
-	offset := userFilter.Offset
+	offset := userFilter.GetOffset()
 	if offset >= 0 {
 		fbuilder.WithOffset(&offset)
 	} else {
 		fbuilder.WithOffset(intToint64(0))
 	}
 
-	return fbuilder.Build()
+	if userFilter.GetIncludeTotal() {
+		fbuilder = fbuilder.IncludingTotal()
+	}
 
+	sort, err := filter.ParseSort(strings.Join(userFilter.GetSort(), ","))
+	if err != nil {
+		return nil, domainerrors.NewInvalidArgument("invalid sort", err, domainerrors.FieldViolation{
+			Field:       "filter.sort",
+			Description: err.Error(),
+		})
+	}
+	fbuilder = fbuilder.SortBy(sort...)
+
+	return fbuilder.Build(), nil
+
+}
+
+// toTime converts an optional timestamp, it returns nil if it's not set.
+func toTime(timestamp *timestamppb.Timestamp) *time.Time {
+	if timestamp == nil {
+		return nil
+	}
+	t := timestamp.AsTime()
+	return &t
 }
 
 func intToint64(value int) *int64 {
@@ -0,0 +1,49 @@
+package grpc
+
+import (
+	"testing"
+	"time"
+
+	filter "github.com/dlion/faceit_challenge/internal"
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/dlion/faceit_challenge/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestToUserFilter(t *testing.T) {
+	t.Run("Convert the filter operators", func(t *testing.T) {
+		createdAfter := time.Date(2024, 7, 19, 10, 58, 34, 0, time.UTC)
+		userFilter, err := toUserFilter(&proto.UserFilter{
+			NicknamePrefix:  "jo",
+			EmailIgnoreCase: "John@Example.com",
+			Countries:       []string{"UK", "IE"},
+			CreatedAfter:    timestamppb.New(createdAfter),
+			Sort:            []string{"-updated_at", "nickname"},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "jo", *userFilter.NicknamePrefix)
+		assert.Equal(t, "John@Example.com", *userFilter.EmailIgnoringCase)
+		assert.Equal(t, []string{"UK", "IE"}, userFilter.Countries)
+		assert.True(t, createdAfter.Equal(*userFilter.CreatedAfter))
+		assert.Nil(t, userFilter.CreatedBefore)
+		assert.Nil(t, userFilter.UpdatedSince)
+		assert.Equal(t, []filter.SortField{{Field: "updated_at", Descending: true}, {Field: "nickname"}}, userFilter.Sort)
+	})
+
+	t.Run("Convert a missing filter to the default one", func(t *testing.T) {
+		userFilter, err := toUserFilter(nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(10), *userFilter.Limit)
+		assert.Equal(t, filter.DEFAULT_SORT, userFilter.SortOrder())
+	})
+
+	t.Run("Return an invalid argument error for an unknown sort field", func(t *testing.T) {
+		_, err := toUserFilter(&proto.UserFilter{Sort: []string{"password"}})
+
+		assert.Equal(t, domainerrors.KindInvalidArgument, domainerrors.KindOf(err))
+	})
+}
@@ -0,0 +1,37 @@
+package grpc
+
+import (
+	"context"
+
+	filter "github.com/dlion/faceit_challenge/internal"
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/dlion/faceit_challenge/pkg/proto"
+)
+
+func (s *UserGrpcHandler) SearchUsers(ctx context.Context, request *proto.SearchUsersRequest) (*proto.GetUsersResponse, error) {
+	query := request.GetQuery()
+	if len(filter.SearchWords(query)) == 0 {
+		err := domainerrors.NewInvalidArgument("invalid query", nil, domainerrors.FieldViolation{
+			Field:       "query",
+			Description: "must contain at least a word made of letters or digits",
+		})
+		return nil, toStatusError(err, "invalid query")
+	}
+
+	userFilter, err := toUserFilter(request.GetFilter())
+	if err != nil {
+		return nil, toStatusError(err, "invalid filter")
+	}
+	userFilter.Search = &query
+
+	if pageToken := request.GetPageToken(); pageToken != "" {
+		userFilter.PageToken = &pageToken
+	}
+
+	page, err := s.userService.GetUsers(ctx, userFilter)
+	if err != nil {
+		return nil, toStatusError(err, "can't search the users")
+	}
+
+	return toGetUsersResponse(page), nil
+}
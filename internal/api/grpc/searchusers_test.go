@@ -0,0 +1,53 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dlion/faceit_challenge/internal/domain/services/user"
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	memoryRepositories "github.com/dlion/faceit_challenge/internal/repositories/memory"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/dlion/faceit_challenge/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSearchUsers(t *testing.T) {
+	ctx := context.Background()
+	userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+	for _, nickname := range []string{"johnd", "johnny", "jane"} {
+		_, err := userRepo.AddUser(ctx, repositories.NewRepoUser("testName", "testLastName", nickname, "testPassword", nickname+"@example.com", "UK"))
+		require.NoError(t, err)
+	}
+	handler := NewUserGrpcHandler(user.NewUserService(userRepo, notifier.NewNotifier()))
+
+	t.Run("Return the users matching the query", func(t *testing.T) {
+		response, err := handler.SearchUsers(ctx, &proto.SearchUsersRequest{Query: "John", Filter: &proto.UserFilter{IncludeTotal: true}})
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(2), response.GetTotal())
+		assert.ElementsMatch(t, []string{"johnd", "johnny"}, []string{response.Users[0].Nickname, response.Users[1].Nickname})
+	})
+
+	t.Run("Combine the query with the filter", func(t *testing.T) {
+		response, err := handler.SearchUsers(ctx, &proto.SearchUsersRequest{
+			Query:  "john",
+			Filter: &proto.UserFilter{Limit: 1, Sort: []string{"nickname"}},
+		})
+		require.NoError(t, err)
+
+		require.Len(t, response.Users, 1)
+		assert.Equal(t, "johnd", response.Users[0].Nickname)
+		assert.True(t, response.HasMore)
+		assert.NotEmpty(t, response.NextPageToken)
+	})
+
+	t.Run("Return an invalid argument error if the query has no words", func(t *testing.T) {
+		_, err := handler.SearchUsers(ctx, &proto.SearchUsersRequest{Query: "  "})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
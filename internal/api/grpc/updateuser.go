@@ -2,33 +2,77 @@ package grpc
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"slices"
 
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
 	"github.com/dlion/faceit_challenge/internal/domain/services/user"
-	repositories "github.com/dlion/faceit_challenge/internal/repositories/mongo"
 	"github.com/dlion/faceit_challenge/pkg/proto"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 func (s *UserGrpcHandler) UpdateUser(ctx context.Context, request *proto.UpdateUserRequest) (*proto.User, error) {
 	serviceReq := &user.UpdateUser{
+		Id:        request.GetId(),
 		FirstName: request.GetFirstName(),
 		LastName:  request.GetLastName(),
 		Nickname:  request.GetNickname(),
 		Email:     request.GetEmail(),
 		Password:  request.GetPassword(),
 		Country:   request.GetCountry(),
+
+		ExpectedVersion: request.GetExpectedVersion(),
+	}
+
+	if paths := request.GetUpdateMask().GetPaths(); len(paths) > 0 {
+		maskedReq, err := applyUpdateMask(serviceReq, paths)
+		if err != nil {
+			return nil, toStatusError(err, "can't update the user")
+		}
+		serviceReq = maskedReq
 	}
 
 	user, err := s.userService.UpdateUser(ctx, serviceReq)
 	if err != nil {
-		if errors.Is(err, repositories.ErrUserNotFound) {
-			return nil, status.Errorf(codes.AlreadyExists, "user not found in the db")
+		return nil, toStatusError(err, "can't update the user")
+	}
+
+	return toGrpcUser(user), nil
+}
+
+// applyUpdateMask returns the update of the fields in the paths of the mask only,
+// the empty ones are cleared.
+func applyUpdateMask(updateUser *user.UpdateUser, paths []string) (*user.UpdateUser, error) {
+	maskedUser := &user.UpdateUser{Id: updateUser.Id, ExpectedVersion: updateUser.ExpectedVersion}
+	fields := map[string]struct {
+		value  string
+		target *string
+	}{
+		"first_name": {updateUser.FirstName, &maskedUser.FirstName},
+		"last_name":  {updateUser.LastName, &maskedUser.LastName},
+		"nickname":   {updateUser.Nickname, &maskedUser.Nickname},
+		"email":      {updateUser.Email, &maskedUser.Email},
+		"password":   {updateUser.Password, &maskedUser.Password},
+		"country":    {updateUser.Country, &maskedUser.Country},
+	}
+
+	for _, path := range paths {
+		field, ok := fields[path]
+		if !ok {
+			return nil, domainerrors.NewInvalidArgument("invalid update mask", fmt.Errorf("unknown path %q", path), domainerrors.FieldViolation{
+				Field:       "update_mask",
+				Description: fmt.Sprintf("%q is not a field of the user that can be updated", path),
+			})
 		}
 
-		return nil, status.Error(codes.Internal, "can't update the user")
+		if field.value == "" {
+			if slices.Contains(maskedUser.ClearedFields, path) {
+				continue
+			}
+			maskedUser.ClearedFields = append(maskedUser.ClearedFields, path)
+		} else {
+			*field.target = field.value
+		}
 	}
 
-	return toGrpcUser(user), nil
+	return maskedUser, nil
 }
@@ -0,0 +1,85 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dlion/faceit_challenge/internal/domain/services/user"
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	memoryRepositories "github.com/dlion/faceit_challenge/internal/repositories/memory"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/dlion/faceit_challenge/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestUpdateUser(t *testing.T) {
+	ctx := context.Background()
+	newHandler := func(t *testing.T) (*UserGrpcHandler, string) {
+		userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+		addedUser, err := userRepo.AddUser(ctx, repositories.NewRepoUser("testName", "testLastName", "johnd", "testPassword", "johnd@example.com", "UK"))
+		require.NoError(t, err)
+		return NewUserGrpcHandler(user.NewUserService(userRepo, notifier.NewNotifier())), addedUser.Id.Hex()
+	}
+
+	t.Run("Update the non empty fields without a mask", func(t *testing.T) {
+		handler, id := newHandler(t)
+
+		updatedUser, err := handler.UpdateUser(ctx, &proto.UpdateUserRequest{Id: id, FirstName: "Paco"})
+		require.NoError(t, err)
+
+		assert.Equal(t, "Paco", updatedUser.FirstName)
+		assert.Equal(t, "testLastName", updatedUser.LastName)
+		assert.Equal(t, int64(2), updatedUser.Version)
+	})
+
+	t.Run("Update the fields of the mask only and clear the empty ones", func(t *testing.T) {
+		handler, id := newHandler(t)
+
+		updatedUser, err := handler.UpdateUser(ctx, &proto.UpdateUserRequest{
+			Id:         id,
+			FirstName:  "Paco",
+			Nickname:   "ignored",
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"first_name", "last_name", "country"}},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "Paco", updatedUser.FirstName)
+		assert.Empty(t, updatedUser.LastName)
+		assert.Empty(t, updatedUser.Country)
+		assert.Equal(t, "johnd", updatedUser.Nickname)
+	})
+
+	t.Run("Return an invalid argument error if the mask has an unknown path", func(t *testing.T) {
+		handler, id := newHandler(t)
+
+		_, err := handler.UpdateUser(ctx, &proto.UpdateUserRequest{
+			Id:         id,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"created_at"}},
+		})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("Return an invalid argument error if the mask clears the email", func(t *testing.T) {
+		handler, id := newHandler(t)
+
+		_, err := handler.UpdateUser(ctx, &proto.UpdateUserRequest{
+			Id:         id,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email"}},
+		})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("Return a failed precondition error if the user hasn't the expected version", func(t *testing.T) {
+		handler, id := newHandler(t)
+
+		_, err := handler.UpdateUser(ctx, &proto.UpdateUserRequest{Id: id, FirstName: "Paco", ExpectedVersion: 2})
+
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+}
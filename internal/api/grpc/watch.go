@@ -3,17 +3,36 @@ package grpc
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"strconv"
 
+	filter "github.com/dlion/faceit_challenge/internal"
+	"github.com/dlion/faceit_challenge/internal/domain/services/user"
+	"github.com/dlion/faceit_challenge/pkg/cloudevents"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
 	"github.com/dlion/faceit_challenge/pkg/proto"
-	"github.com/google/uuid"
-	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-func (s *UserGrpcHandler) Watch(_ *emptypb.Empty, server proto.UserService_WatchServer) error {
-	clientId := uuid.New().String()
-	channel := s.userService.GetChangeChannel(clientId)
-	defer s.userService.RemoveChannel(clientId)
+func (s *UserGrpcHandler) Watch(request *proto.WatchRequest, server proto.UserService_WatchServer) error {
+	changeFilter, err := toChangeFilter(request)
+	if err != nil {
+		return toStatusError(err, "invalid watch request")
+	}
+
+	stream := user.NewChangeStream(s.userService, changeFilter, request.FromSequence)
+	defer stream.Close()
+
+	send := func(change notifier.ChangeData) error {
+		return s.send(server, change)
+	}
+
+	if err := stream.Replay(server.Context(), send); err != nil {
+		return toReplayError(err)
+	}
 
 	for {
 		select {
@@ -27,19 +46,75 @@ func (s *UserGrpcHandler) Watch(_ *emptypb.Empty, server proto.UserService_Watch
 			}
 
 			return nil
-		case change, closed := <-channel:
+		case change, closed := <-stream.Changes():
 			if !closed {
+				if err := stream.Err(); err != nil {
+					lastSequence, hasSequence := stream.LastSequence()
+					return toDisconnectedError(err, lastSequence, hasSequence)
+				}
 				return nil
 			}
 
-			response := &proto.WatchResponse{
-				ChangeType: change.OperationType,
-				UserId:     change.UserId,
-			}
-			err := server.Send(response)
-			if err != nil {
-				return err
+			if err := stream.Send(server.Context(), change, send); err != nil {
+				return toReplayError(err)
 			}
 		}
 	}
 }
+
+// toReplayError returns the errors of the stream as they are, and translates those reading
+// the change log.
+func toReplayError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return toStatusError(err, "can't replay the changes")
+}
+
+// send streams the change with the attributes of its CloudEvent.
+func (s *UserGrpcHandler) send(server proto.UserService_WatchServer, change notifier.ChangeData) error {
+	source := s.EventSource
+	if source == "" {
+		source = cloudevents.DEFAULT_SOURCE
+	}
+
+	response, err := proto.NewWatchEvent(source, change)
+	if err != nil {
+		return status.Errorf(codes.Internal, "can't describe the change %s: %v", change.EventId, err)
+	}
+	return server.Send(response)
+}
+
+// toDisconnectedError reports a client disconnected for not keeping up with the changes as
+// RESOURCE_EXHAUSTED, with the sequence of the last change sent to resume from, if there's one.
+func toDisconnectedError(err error, lastSequence int64, hasSequence bool) error {
+	log.Print("Client disconnected by the server, ", err)
+
+	if !hasSequence {
+		return status.Error(codes.ResourceExhausted, "the client didn't keep up with the changes")
+	}
+
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf("the client didn't keep up with the changes, resume from the sequence %d", lastSequence))
+	stWithDetails, detailsErr := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   "SLOW_CONSUMER",
+		Domain:   "user.faceit.com",
+		Metadata: map[string]string{"last_sequence": strconv.FormatInt(lastSequence, 10)},
+	})
+	if detailsErr != nil {
+		log.Print("Can't attach the error details, ", detailsErr)
+		return st.Err()
+	}
+	return stWithDetails.Err()
+}
+
+func toChangeFilter(request *proto.WatchRequest) (*notifier.ChangeFilter, error) {
+	var userFilter *filter.UserFilter
+	if request.Filter != nil {
+		var err error
+		if userFilter, err = toUserFilter(request.Filter); err != nil {
+			return nil, err
+		}
+	}
+
+	return user.NewChangeFilter(userFilter, request.OperationTypes)
+}
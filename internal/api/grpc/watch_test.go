@@ -0,0 +1,343 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/domain/services/user"
+	"github.com/dlion/faceit_challenge/internal/outbox"
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	memoryRepositories "github.com/dlion/faceit_challenge/internal/repositories/memory"
+	"github.com/dlion/faceit_challenge/pkg/cloudevents"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/dlion/faceit_challenge/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// watchServer collects the responses sent to a Watch stream.
+type watchServer struct {
+	grpc.ServerStream
+	ctx       context.Context
+	responses chan *proto.WatchResponse
+}
+
+func newWatchServer(ctx context.Context, capacity int) *watchServer {
+	return &watchServer{ctx: ctx, responses: make(chan *proto.WatchResponse, capacity)}
+}
+
+func (w *watchServer) Context() context.Context {
+	return w.ctx
+}
+
+func (w *watchServer) Send(response *proto.WatchResponse) error {
+	w.responses <- response
+	return nil
+}
+
+func (w *watchServer) receive(t *testing.T) *proto.WatchResponse {
+	select {
+	case response := <-w.responses:
+		return response
+	case <-time.After(time.Second):
+		t.Fatal("Expected to receive a change")
+		return nil
+	}
+}
+
+func TestWatch(t *testing.T) {
+	type fixture struct {
+		userService *user.UserServiceImpl
+		userRepo    *memoryRepositories.UserRepositoryMemoryImpl
+		notifier    *notifier.NotifierImpl
+		relay       *outbox.Relay
+	}
+
+	newFixture := func(t *testing.T, subscriberOptions ...notifier.SubscriberOption) *fixture {
+		userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+		changeNotifier := notifier.NewNotifier(subscriberOptions...)
+		t.Cleanup(changeNotifier.Close)
+		relay := outbox.NewRelay(userRepo, changeNotifier)
+		userService := user.NewUserService(userRepo, changeNotifier, user.WithOutboxRelay(relay), user.WithChangeLog(userRepo))
+		return &fixture{userService: userService, userRepo: userRepo, notifier: changeNotifier, relay: relay}
+	}
+
+	addUsers := func(t *testing.T, f *fixture, count int) {
+		for i := 0; i < count; i++ {
+			nickname := fmt.Sprintf("nickname%d", time.Now().UnixNano())
+			_, err := f.userService.NewUser(context.Background(), &user.NewUser{Nickname: nickname, Email: nickname + "@test.com", Password: "testPassword"})
+			require.NoError(t, err)
+		}
+		_, err := f.relay.RelayPending(context.Background())
+		require.NoError(t, err)
+	}
+
+	watch := func(f *fixture, request *proto.WatchRequest) (*watchServer, <-chan error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		server := newWatchServer(ctx, 100)
+		done := make(chan error, 1)
+		go func() {
+			done <- NewUserGrpcHandler(f.userService).Watch(request, server)
+		}()
+		return server, done
+	}
+
+	waitForSubscriber := func() {
+		// The replayed changes are sent after subscribing, the live ones can then be relayed.
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Run("Replay the changes following the sequence before the live ones", func(t *testing.T) {
+		f := newFixture(t)
+		addUsers(t, f, 3)
+
+		fromSequence := int64(1)
+		server, _ := watch(f, &proto.WatchRequest{FromSequence: &fromSequence})
+		assert.Equal(t, int64(2), server.receive(t).Sequence)
+		assert.Equal(t, int64(3), server.receive(t).Sequence)
+
+		addUsers(t, f, 1)
+		response := server.receive(t)
+		assert.Equal(t, int64(4), response.Sequence)
+		assert.Equal(t, notifier.ChangeOperationInsert, response.ChangeType)
+		assert.NotEmpty(t, response.EventId)
+		assert.NotNil(t, response.Timestamp)
+		assert.Contains(t, response.ChangedFields, "nickname")
+		assert.Nil(t, response.Before)
+		assert.Equal(t, response.UserId, response.After.Id)
+		assert.NotEmpty(t, response.After.Nickname)
+	})
+
+	t.Run("Skip the changes broadcast again and read the missed ones from the log", func(t *testing.T) {
+		f := newFixture(t)
+		addUsers(t, f, 2)
+
+		fromSequence := int64(2)
+		server, _ := watch(f, &proto.WatchRequest{FromSequence: &fromSequence})
+		waitForSubscriber()
+
+		f.notifier.Broadcast(notifier.ChangeData{Sequence: 2, OperationType: notifier.ChangeOperationInsert})
+		// The change 3 is only logged, as if it had been dropped, and the 4 is broadcast.
+		_, err := f.userService.NewUser(context.Background(), &user.NewUser{Nickname: "missed", Email: "missed@test.com", Password: "testPassword"})
+		require.NoError(t, err)
+		entries, err := f.userRepo.PendingEntries(context.Background(), 10)
+		require.NoError(t, err)
+		require.NoError(t, f.userRepo.MarkDelivered(context.Background(), entries[0].Id))
+		addUsers(t, f, 1)
+
+		assert.Equal(t, int64(3), server.receive(t).Sequence)
+		assert.Equal(t, int64(4), server.receive(t).Sequence)
+		assert.Empty(t, server.responses)
+	})
+
+	t.Run("Send the changes without a sequence while resuming", func(t *testing.T) {
+		f := newFixture(t)
+		addUsers(t, f, 1)
+
+		fromSequence := int64(1)
+		server, _ := watch(f, &proto.WatchRequest{FromSequence: &fromSequence})
+		waitForSubscriber()
+
+		f.notifier.Broadcast(notifier.ChangeData{OperationType: notifier.ChangeOperationUpdate, UserId: "changedDirectly"})
+
+		response := server.receive(t)
+		assert.Equal(t, "changedDirectly", response.UserId)
+		assert.Zero(t, response.Sequence)
+	})
+
+	t.Run("Stream only the live changes without a sequence", func(t *testing.T) {
+		f := newFixture(t)
+		addUsers(t, f, 2)
+
+		server, _ := watch(f, &proto.WatchRequest{})
+		waitForSubscriber()
+		addUsers(t, f, 1)
+
+		assert.Equal(t, int64(3), server.receive(t).Sequence)
+	})
+
+	t.Run("Send the CloudEvents attributes of the changes", func(t *testing.T) {
+		f := newFixture(t)
+		addUsers(t, f, 1)
+
+		fromSequence := int64(0)
+		server, _ := watch(f, &proto.WatchRequest{FromSequence: &fromSequence})
+		response := server.receive(t)
+		assert.Equal(t, cloudevents.TYPE_USER_CREATED, response.Type)
+		assert.Equal(t, cloudevents.DEFAULT_SOURCE, response.Source)
+		assert.Equal(t, cloudevents.SPEC_VERSION, response.SpecVersion)
+		assert.Equal(t, response.UserId, response.Subject)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		handler := NewUserGrpcHandler(f.userService)
+		handler.EventSource = "/test"
+		server = newWatchServer(ctx, 1)
+		go func() {
+			_ = handler.Watch(&proto.WatchRequest{FromSequence: &fromSequence}, server)
+		}()
+		assert.Equal(t, "/test", server.receive(t).Source)
+	})
+
+	t.Run("Stream only the changes selected by the filter, replayed and live", func(t *testing.T) {
+		f := newFixture(t)
+		addCountryUser := func(t *testing.T, country string) string {
+			nickname := fmt.Sprintf("nickname%d", time.Now().UnixNano())
+			addedUser, err := f.userService.NewUser(context.Background(), &user.NewUser{Nickname: nickname, Email: nickname + "@test.com", Password: "testPassword", Country: country})
+			require.NoError(t, err)
+			_, err = f.relay.RelayPending(context.Background())
+			require.NoError(t, err)
+			return addedUser.Id
+		}
+		replayedId := addCountryUser(t, "UK")
+		addCountryUser(t, "ITA")
+
+		fromSequence := int64(0)
+		server, _ := watch(f, &proto.WatchRequest{
+			FromSequence:   &fromSequence,
+			Filter:         &proto.UserFilter{Countries: []string{"UK"}},
+			OperationTypes: []string{notifier.ChangeOperationInsert, notifier.ChangeOperationDelete},
+		})
+		assert.Equal(t, replayedId, server.receive(t).UserId)
+		waitForSubscriber()
+
+		addCountryUser(t, "ITA")
+		liveId := addCountryUser(t, "UK")
+		_, err := f.userService.UpdateUser(context.Background(), &user.UpdateUser{Id: liveId, FirstName: "Updated"})
+		require.NoError(t, err)
+		require.NoError(t, f.userService.RemoveUser(context.Background(), liveId, 0))
+		_, err = f.relay.RelayPending(context.Background())
+		require.NoError(t, err)
+
+		inserted := server.receive(t)
+		assert.Equal(t, liveId, inserted.UserId)
+		assert.Equal(t, int64(4), inserted.Sequence)
+		deleted := server.receive(t)
+		assert.Equal(t, notifier.ChangeOperationDelete, deleted.ChangeType)
+		assert.Equal(t, int64(6), deleted.Sequence)
+		assert.Empty(t, server.responses)
+	})
+
+	t.Run("Read the log only for the missed changes, not for the ones skipped by the filter", func(t *testing.T) {
+		userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+		changeNotifier := notifier.NewNotifier()
+		t.Cleanup(changeNotifier.Close)
+		relay := outbox.NewRelay(userRepo, changeNotifier)
+		changeLog := &countingChangeLog{ChangeLog: userRepo}
+		f := &fixture{
+			userService: user.NewUserService(userRepo, changeNotifier, user.WithOutboxRelay(relay), user.WithChangeLog(changeLog)),
+			userRepo:    userRepo,
+			notifier:    changeNotifier,
+			relay:       relay,
+		}
+
+		fromSequence := int64(0)
+		server, _ := watch(f, &proto.WatchRequest{FromSequence: &fromSequence, OperationTypes: []string{notifier.ChangeOperationDelete}})
+		waitForSubscriber()
+		require.Equal(t, 1, changeLog.calls())
+
+		addUsers(t, f, 3)
+		removedUser, err := f.userService.NewUser(context.Background(), &user.NewUser{Nickname: "removed", Email: "removed@test.com", Password: "testPassword"})
+		require.NoError(t, err)
+		require.NoError(t, f.userService.RemoveUser(context.Background(), removedUser.Id, 0))
+		_, err = f.relay.RelayPending(context.Background())
+		require.NoError(t, err)
+
+		deleted := server.receive(t)
+		assert.Equal(t, removedUser.Id, deleted.UserId)
+		assert.Equal(t, int64(5), deleted.Sequence)
+		assert.Equal(t, 1, changeLog.calls())
+	})
+
+	t.Run("Return an invalid argument error if an operation type is unknown", func(t *testing.T) {
+		f := newFixture(t)
+
+		_, done := watch(f, &proto.WatchRequest{OperationTypes: []string{"truncate"}})
+
+		select {
+		case err := <-done:
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		case <-time.After(time.Second):
+			t.Fatal("Expected the stream to end")
+		}
+	})
+
+	t.Run("Disconnect a slow client with the last sequence sent", func(t *testing.T) {
+		f := newFixture(t, notifier.WithBufferSize(1), notifier.WithBackpressurePolicy(notifier.DisconnectSlowConsumer))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		// The responses aren't buffered, so the changes pile up while the client doesn't receive them.
+		server := newWatchServer(ctx, 0)
+		done := make(chan error, 1)
+		go func() {
+			done <- NewUserGrpcHandler(f.userService).Watch(&proto.WatchRequest{}, server)
+		}()
+		waitForSubscriber()
+
+		addUsers(t, f, 5)
+
+		var lastSequence int64
+		for {
+			select {
+			case response := <-server.responses:
+				lastSequence = response.Sequence
+				continue
+			case err := <-done:
+				st := status.Convert(err)
+				assert.Equal(t, codes.ResourceExhausted, st.Code())
+				require.Len(t, st.Details(), 1)
+				errorInfo := st.Details()[0].(*errdetails.ErrorInfo)
+				assert.Equal(t, strconv.FormatInt(lastSequence, 10), errorInfo.Metadata["last_sequence"])
+				assert.Less(t, lastSequence, int64(5))
+			case <-time.After(time.Second):
+				t.Fatal("Expected the stream to end")
+			}
+			return
+		}
+	})
+
+	t.Run("Return an out of range error if the changes have been purged", func(t *testing.T) {
+		f := newFixture(t)
+		addUsers(t, f, 2)
+		require.NoError(t, f.userRepo.PurgeDelivered(context.Background(), time.Now().Add(time.Hour)))
+
+		fromSequence := int64(0)
+		_, done := watch(f, &proto.WatchRequest{FromSequence: &fromSequence})
+
+		select {
+		case err := <-done:
+			assert.Equal(t, codes.OutOfRange, status.Code(err))
+		case <-time.After(time.Second):
+			t.Fatal("Expected the stream to end")
+		}
+	})
+}
+
+// countingChangeLog counts how many times the changes are read from the log.
+type countingChangeLog struct {
+	repositories.ChangeLog
+	mu    sync.Mutex
+	count int
+}
+
+func (c *countingChangeLog) ChangesSince(ctx context.Context, sequence int64, limit int64) ([]*repositories.OutboxEntry, error) {
+	c.mu.Lock()
+	c.count++
+	c.mu.Unlock()
+	return c.ChangeLog.ChangesSince(ctx, sequence, limit)
+}
+
+func (c *countingChangeLog) calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.count
+}
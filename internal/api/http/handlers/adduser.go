@@ -12,18 +12,19 @@ func (u *UserHandler) AddUserHandler(w http.ResponseWriter, req *http.Request) {
 	var newUser user.NewUser
 	if err := json.NewDecoder(req.Body).Decode(&newUser); err != nil {
 		log.Print(err)
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		writeBadRequest(w, req, "Invalid request payload")
 		return
 	}
 
 	createdUser, err := u.UserService.NewUser(req.Context(), &newUser)
 	if err != nil {
 		log.Print(err)
-		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		writeError(w, req, err, "Failed to create user")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etagOf(createdUser.Version))
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(createdUser); err != nil {
 		log.Print(err)
@@ -44,7 +44,7 @@ func TestAddUserHandler(t *testing.T) {
 		Country:   "USA",
 		CreatedAt: time.Now().String(),
 		UpdatedAt: time.Now().String(),
-	})
+	}, nil)
 	handler := http.HandlerFunc(userHandler.AddUserHandler)
 
 	handler.ServeHTTP(rr, req)
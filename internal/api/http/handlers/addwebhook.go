@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/dlion/faceit_challenge/internal/domain/services/webhook"
+)
+
+func (h *WebhookHandler) AddWebhookHandler(w http.ResponseWriter, req *http.Request) {
+	var newWebhook webhook.NewWebhook
+	if err := json.NewDecoder(req.Body).Decode(&newWebhook); err != nil {
+		log.Print(err)
+		writeBadRequest(w, req, "Invalid request payload")
+		return
+	}
+
+	registeredWebhook, err := h.WebhookService.RegisterWebhook(req.Context(), &newWebhook)
+	if err != nil {
+		log.Print(err)
+		writeError(w, req, err, "Failed to register webhook")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, registeredWebhook)
+}
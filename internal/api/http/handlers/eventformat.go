@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/dlion/faceit_challenge/pkg/cloudevents"
+)
+
+// FORMAT_CLOUDEVENTS is the value of the format query parameter of the event streams sending
+// the changes as CloudEvents in the structured mode, instead of the bare changes.
+const FORMAT_CLOUDEVENTS = "cloudevents"
+
+// eventSource returns the CloudEvents source of the changes sent by a stream, from the format
+// query parameter: empty if the bare changes are sent.
+func eventSource(query url.Values, source string) (string, error) {
+	switch format := query.Get("format"); format {
+	case "":
+		return "", nil
+	case FORMAT_CLOUDEVENTS:
+		if source == "" {
+			source = cloudevents.DEFAULT_SOURCE
+		}
+		return source, nil
+	default:
+		return "", domainerrors.NewInvalidArgument("invalid format", fmt.Errorf("unknown format %q", format), domainerrors.FieldViolation{
+			Field:       "format",
+			Description: "must be " + FORMAT_CLOUDEVENTS + " or empty",
+		})
+	}
+}
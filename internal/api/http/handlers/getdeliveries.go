@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// GetDeliveriesHandler returns the most recent deliveries to a webhook, only the ones with the
+// status query parameter if given.
+func (h *WebhookHandler) GetDeliveriesHandler(w http.ResponseWriter, req *http.Request) {
+	id, ok := mux.Vars(req)["id"]
+	if !ok || id == "" {
+		log.Print("Get failed, it has been provided a bad ID")
+		writeBadRequest(w, req, "ID parameter missing in URL")
+		return
+	}
+
+	deliveries, err := h.WebhookService.GetDeliveries(req.Context(), id, req.URL.Query().Get("status"))
+	if err != nil {
+		log.Print("Get failed, ", err)
+		writeError(w, req, err, "Failed to get deliveries")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// GetDeadLettersHandler returns the most recent deliveries that failed all their attempts.
+func (h *WebhookHandler) GetDeadLettersHandler(w http.ResponseWriter, req *http.Request) {
+	deliveries, err := h.WebhookService.GetDeadLetters(req.Context())
+	if err != nil {
+		log.Print("Get failed, ", err)
+		writeError(w, req, err, "Failed to get dead letters")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, deliveries)
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (u *UserHandler) GetUserHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id, ok := vars["id"]
+	if !ok || id == "" {
+		log.Print("Get failed, it has been provided a bad ID")
+		writeBadRequest(w, req, "ID parameter missing in URL")
+		return
+	}
+
+	foundUser, err := u.UserService.GetUser(req.Context(), id)
+	if err != nil {
+		log.Print("Get failed, ", err)
+		writeError(w, req, err, "Failed to get user")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etagOf(foundUser.Version))
+	if err := json.NewEncoder(w).Encode(foundUser); err != nil {
+		log.Print(err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/dlion/faceit_challenge/internal/domain/services/user"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetUserHandler(t *testing.T) {
+	t.Run("Return an existing user", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/api/user/66981a71a4fd0f7ff33251b1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService}
+		mockedUserService.On("GetUser").Return(&user.User{
+			Id:        "66981a71a4fd0f7ff33251b1",
+			FirstName: "John",
+			LastName:  "Doe",
+			Nickname:  "johnd",
+			Email:     "john.doe@example.com",
+			Country:   "USA",
+			CreatedAt: time.Now().String(),
+			UpdatedAt: time.Now().String(),
+			Version:   4,
+		}, nil)
+		router := mux.NewRouter()
+		router.HandleFunc("/api/user/{id}", userHandler.GetUserHandler).Methods("GET")
+
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, `"4"`, rr.Header().Get("ETag"))
+
+		var foundUser user.User
+		err = json.NewDecoder(rr.Body).Decode(&foundUser)
+		assert.NoError(t, err)
+		assert.Equal(t, "66981a71a4fd0f7ff33251b1", foundUser.Id)
+		assert.Equal(t, "John", foundUser.FirstName)
+	})
+
+	t.Run("Return 404 if the user doesn't exist", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/api/user/66981a71a4fd0f7ff33251b1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService}
+		mockedUserService.On("GetUser").Return((*user.User)(nil), domainerrors.NewNotFound("user not found", nil))
+		router := mux.NewRouter()
+		router.HandleFunc("/api/user/{id}", userHandler.GetUserHandler).Methods("GET")
+
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, PROBLEM_CONTENT_TYPE, rr.Header().Get("Content-Type"))
+	})
+}
@@ -6,31 +6,71 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	filter "github.com/dlion/faceit_challenge/internal"
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/dlion/faceit_challenge/internal/domain/services/user"
 )
 
+// UsersResponse is a page of the users listing together with its pagination metadata.
+type UsersResponse struct {
+	Users []*user.User `json:"users"`
+	// Total is set only if include_total is true.
+	Total   *int64 `json:"total,omitempty"`
+	Limit   int64  `json:"limit"`
+	Offset  int64  `json:"offset"`
+	HasMore bool   `json:"has_more"`
+	// NextPageToken can be passed back as page_token to get the following page.
+	NextPageToken string    `json:"next_page_token,omitempty"`
+	Links         PageLinks `json:"links"`
+}
+
+type PageLinks struct {
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
 func (u *UserHandler) GetUsersHandler(w http.ResponseWriter, req *http.Request) {
 	queryParams := req.URL.Query()
 
-	filter := NewUserFilterFromQuery(queryParams)
+	filter, err := NewUserFilterFromQuery(queryParams)
+	if err != nil {
+		log.Print("Invalid users query, ", err)
+		writeError(w, req, err, "Invalid query")
+		return
+	}
 
 	log.Printf("Getting Users with limit %d and offset %d", *filter.Limit, *filter.Offset)
 
-	paginatedUsers, err := u.UserService.GetUsers(req.Context(), filter)
+	page, err := u.UserService.GetUsers(req.Context(), filter)
 	if err != nil {
-		log.Print("Can't get paginated users")
-		http.Error(w, "Can't get users", http.StatusInternalServerError)
+		log.Print("Can't get paginated users, ", err)
+		writeError(w, req, err, "Can't get users")
+		return
+	}
+
+	response := UsersResponse{
+		Users:         page.Users,
+		Total:         page.Total,
+		Limit:         page.Limit,
+		Offset:        page.Offset,
+		HasMore:       page.HasMore,
+		NextPageToken: page.NextPageToken,
+		Links:         pageLinks(req.URL, page),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(paginatedUsers); err != nil {
+	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Print(err)
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 	}
 }
 
-func NewUserFilterFromQuery(query url.Values) *filter.UserFilter {
+// NewUserFilterFromQuery builds the filter of the users listing from the query parameters,
+// returning an invalid argument error for the parameters that can't be parsed.
+func NewUserFilterFromQuery(query url.Values) (*filter.UserFilter, error) {
 	fbuilder := filter.NewFilterBuilder()
 
 	firstName := query.Get("first_name")
@@ -48,11 +88,48 @@ func NewUserFilterFromQuery(query url.Values) *filter.UserFilter {
 		fbuilder = fbuilder.ByNickname(&nickname)
 	}
 
-	country := query.Get("country")
-	if country != "" {
-		fbuilder = fbuilder.ByCountry(&country)
+	// A comma separated list of countries matches any of them.
+	countries := splitList(query.Get("country"))
+	if len(countries) > 1 {
+		fbuilder = fbuilder.ByCountries(countries...)
+	} else if len(countries) == 1 {
+		fbuilder = fbuilder.ByCountry(&countries[0])
+	}
+
+	nicknamePrefix := query.Get("nickname_prefix")
+	if nicknamePrefix != "" {
+		fbuilder = fbuilder.ByNicknamePrefix(&nicknamePrefix)
+	}
+
+	emailIgnoringCase := query.Get("email_ignore_case")
+	if emailIgnoringCase != "" {
+		fbuilder = fbuilder.ByEmailIgnoringCase(&emailIgnoringCase)
 	}
 
+	// q is a full-text search, the users are ranked by relevance unless a sort is given.
+	if search, ok := query["q"]; ok {
+		searchText := strings.Join(search, " ")
+		fbuilder = fbuilder.Search(&searchText)
+	}
+
+	createdAfter, err := parseTimeParam(query, "created_after")
+	if err != nil {
+		return nil, err
+	}
+	fbuilder = fbuilder.CreatedAfter(createdAfter)
+
+	createdBefore, err := parseTimeParam(query, "created_before")
+	if err != nil {
+		return nil, err
+	}
+	fbuilder = fbuilder.CreatedBefore(createdBefore)
+
+	updatedSince, err := parseTimeParam(query, "updated_since")
+	if err != nil {
+		return nil, err
+	}
+	fbuilder = fbuilder.UpdatedSince(updatedSince)
+
 	email := query.Get("email")
 	if email != "" {
 		fbuilder = fbuilder.ByEmail(&email)
@@ -65,6 +142,34 @@ func NewUserFilterFromQuery(query url.Values) *filter.UserFilter {
 		fbuilder.WithLimit(intToint64(10))
 	}
 
+	// The sort can be given both as a comma separated list and repeating the parameter.
+	sort, err := filter.ParseSort(strings.Join(query["sort"], ","))
+	if err != nil {
+		return nil, domainerrors.NewInvalidArgument("invalid sort", err, domainerrors.FieldViolation{
+			Field:       "sort",
+			Description: err.Error(),
+		})
+	}
+	fbuilder = fbuilder.SortBy(sort...)
+
+	pageToken := query.Get("page_token")
+	if pageToken != "" {
+		fbuilder = fbuilder.WithPageToken(&pageToken)
+	}
+
+	if includeTotal := query.Get("include_total"); includeTotal != "" {
+		include, err := strconv.ParseBool(includeTotal)
+		if err != nil {
+			return nil, domainerrors.NewInvalidArgument("invalid include_total", err, domainerrors.FieldViolation{
+				Field:       "include_total",
+				Description: "must be true or false",
+			})
+		}
+		if include {
+			fbuilder = fbuilder.IncludingTotal()
+		}
+	}
+
 	offsetStr := query.Get("offset")
 	if offset, err := strconv.Atoi(offsetStr); err == nil && offset >= 0 {
 		fbuilder.WithOffset(intToint64(offset))
@@ -72,7 +177,76 @@ func NewUserFilterFromQuery(query url.Values) *filter.UserFilter {
 		fbuilder.WithOffset(intToint64(0))
 	}
 
-	return fbuilder.Build()
+	return fbuilder.Build(), nil
+}
+
+// splitList splits a comma separated list skipping the empty values.
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// parseTimeParam parses an optional RFC 3339 time, it returns nil if the parameter is missing.
+func parseTimeParam(query url.Values, name string) (*time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	parsedTime, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, domainerrors.NewInvalidArgument("invalid "+name, err, domainerrors.FieldViolation{
+			Field:       name,
+			Description: "must be a RFC 3339 time, e.g. 2024-07-19T10:58:34Z",
+		})
+	}
+	return &parsedTime, nil
+}
+
+// pageLinks returns the URLs of the pages around the current one, keeping its filters.
+// The next page is selected in the same way as the current one, by page token or by offset,
+// while the previous one is always selected by offset since page tokens only go forward.
+func pageLinks(current *url.URL, page *user.UsersPage) PageLinks {
+	var links PageLinks
+
+	if page.HasMore {
+		if current.Query().Get("page_token") != "" {
+			links.Next = pageURL(current, func(query url.Values) {
+				query.Set("page_token", page.NextPageToken)
+			})
+		} else {
+			links.Next = pageURL(current, func(query url.Values) {
+				query.Set("offset", strconv.FormatInt(page.Offset+page.Limit, 10))
+			})
+		}
+	}
+
+	if page.Offset > 0 {
+		prevOffset := page.Offset - page.Limit
+		if prevOffset < 0 || page.Limit <= 0 {
+			prevOffset = 0
+		}
+		links.Prev = pageURL(current, func(query url.Values) {
+			query.Set("offset", strconv.FormatInt(prevOffset, 10))
+		})
+	}
+
+	return links
+}
+
+func pageURL(current *url.URL, setPage func(url.Values)) string {
+	query := current.Query()
+	query.Del("offset")
+	query.Del("page_token")
+	setPage(query)
+
+	page := url.URL{Path: current.Path, RawQuery: query.Encode()}
+	return page.String()
 }
 
 func intToint64(value int) *int64 {
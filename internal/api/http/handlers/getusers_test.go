@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
+	filter "github.com/dlion/faceit_challenge/internal"
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
 	"github.com/dlion/faceit_challenge/internal/domain/services/user"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
@@ -42,10 +46,182 @@ func TestGetUsersHandler(t *testing.T) {
 		UpdatedAt: time.Now().String(),
 	})
 
-	mockedUserService.On("GetUsers").Return(mockedUsers)
+	mockedUserService.On("GetUsers").Return(&user.UsersPage{Users: mockedUsers, Total: intToint64(2), Limit: 10})
 	router := mux.NewRouter()
 	router.HandleFunc("/api/users", userHandler.GetUsersHandler).Methods("GET")
 
 	router.ServeHTTP(rr, req)
 	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response UsersResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Len(t, response.Users, 2)
+	assert.Equal(t, intToint64(2), response.Total)
+	assert.False(t, response.HasMore)
+	assert.Empty(t, response.Links.Next)
+	assert.Empty(t, response.Links.Prev)
+}
+
+func TestGetUsersHandlerPageLinks(t *testing.T) {
+	mockedUsers := []*user.User{{Id: "66981a71a4fd0f7ff33251b1", Country: "UK"}}
+
+	t.Run("Link the pages around the current one by offset", func(t *testing.T) {
+		response := getUsersPage(t, "/api/users?country=UK&limit=2&offset=3", &user.UsersPage{
+			Users: mockedUsers, NextPageToken: "nextToken", Total: intToint64(40), Limit: 2, Offset: 3, HasMore: true,
+		})
+
+		assert.Equal(t, intToint64(40), response.Total)
+		assert.Equal(t, int64(2), response.Limit)
+		assert.Equal(t, int64(3), response.Offset)
+		assert.True(t, response.HasMore)
+		assert.Equal(t, "nextToken", response.NextPageToken)
+		assert.Equal(t, "/api/users?country=UK&limit=2&offset=5", response.Links.Next)
+		assert.Equal(t, "/api/users?country=UK&limit=2&offset=1", response.Links.Prev)
+	})
+
+	t.Run("Link the next page by page token if the current one is selected by page token", func(t *testing.T) {
+		response := getUsersPage(t, "/api/users?limit=2&page_token=currentToken", &user.UsersPage{
+			Users: mockedUsers, NextPageToken: "nextToken", Total: intToint64(40), Limit: 2, Offset: 6, HasMore: true,
+		})
+
+		assert.Equal(t, "/api/users?limit=2&page_token=nextToken", response.Links.Next)
+		assert.Equal(t, "/api/users?limit=2&offset=4", response.Links.Prev)
+	})
+
+	t.Run("Omit the total if it's not included", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/api/users", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService}
+		mockedUserService.On("GetUsers").Return(&user.UsersPage{Users: mockedUsers, Limit: 10})
+		router := mux.NewRouter()
+		router.HandleFunc("/api/users", userHandler.GetUsersHandler).Methods("GET")
+
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotContains(t, rr.Body.String(), `"total"`)
+	})
+
+	t.Run("Don't link a next page after the last one", func(t *testing.T) {
+		response := getUsersPage(t, "/api/users?limit=2&offset=1", &user.UsersPage{
+			Users: mockedUsers, Total: intToint64(2), Limit: 2, Offset: 1,
+		})
+
+		assert.False(t, response.HasMore)
+		assert.Empty(t, response.Links.Next)
+		assert.Equal(t, "/api/users?limit=2&offset=0", response.Links.Prev)
+	})
+}
+
+func getUsersPage(t *testing.T, target string, page *user.UsersPage) UsersResponse {
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	mockedUserService := new(MockUserService)
+	userHandler := UserHandler{UserService: mockedUserService}
+	mockedUserService.On("GetUsers").Return(page)
+	router := mux.NewRouter()
+	router.HandleFunc("/api/users", userHandler.GetUsersHandler).Methods("GET")
+
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response UsersResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	return response
+}
+
+func TestGetUsersHandlerWithInvalidSort(t *testing.T) {
+	req, err := http.NewRequest("GET", "/api/users?sort=-password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	mockedUserService := new(MockUserService)
+	userHandler := UserHandler{UserService: mockedUserService}
+	router := mux.NewRouter()
+	router.HandleFunc("/api/users", userHandler.GetUsersHandler).Methods("GET")
+
+	router.ServeHTTP(rr, req)
+	mockedUserService.AssertNotCalled(t, "GetUsers")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, PROBLEM_CONTENT_TYPE, rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), `"name":"sort"`)
+}
+
+func TestNewUserFilterFromQuery(t *testing.T) {
+	t.Run("Parse the sort given as a list or repeating the parameter", func(t *testing.T) {
+		for _, query := range []string{"sort=-updated_at,nickname", "sort=-updated_at&sort=nickname"} {
+			values, err := url.ParseQuery(query)
+			assert.NoError(t, err)
+
+			userFilter, err := NewUserFilterFromQuery(values)
+			assert.NoError(t, err)
+			assert.Equal(t, []filter.SortField{{Field: "updated_at", Descending: true}, {Field: "nickname"}}, userFilter.Sort)
+		}
+	})
+	t.Run("Parse the filter operators", func(t *testing.T) {
+		values, err := url.ParseQuery("country=UK,IE,FR&nickname_prefix=jo&email_ignore_case=John@Example.com&created_after=2024-07-19T10:58:34Z&created_before=2024-07-20T10:58:34%2B02:00&updated_since=2024-07-19T12:00:00Z")
+		assert.NoError(t, err)
+
+		userFilter, err := NewUserFilterFromQuery(values)
+		assert.NoError(t, err)
+		assert.Nil(t, userFilter.Country)
+		assert.Equal(t, []string{"UK", "IE", "FR"}, userFilter.Countries)
+		assert.Equal(t, "jo", *userFilter.NicknamePrefix)
+		assert.Equal(t, "John@Example.com", *userFilter.EmailIgnoringCase)
+		assert.True(t, time.Date(2024, 7, 19, 10, 58, 34, 0, time.UTC).Equal(*userFilter.CreatedAfter))
+		assert.True(t, time.Date(2024, 7, 20, 8, 58, 34, 0, time.UTC).Equal(*userFilter.CreatedBefore))
+		assert.True(t, time.Date(2024, 7, 19, 12, 0, 0, 0, time.UTC).Equal(*userFilter.UpdatedSince))
+	})
+
+	t.Run("Parse a single country as an exact match", func(t *testing.T) {
+		userFilter, err := NewUserFilterFromQuery(url.Values{"country": {"UK"}})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "UK", *userFilter.Country)
+		assert.Empty(t, userFilter.Countries)
+	})
+
+	t.Run("Parse the full-text search", func(t *testing.T) {
+		userFilter, err := NewUserFilterFromQuery(url.Values{"q": {"john smith"}})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "john smith", *userFilter.Search)
+
+		userFilter, err = NewUserFilterFromQuery(url.Values{})
+		assert.NoError(t, err)
+		assert.Nil(t, userFilter.Search)
+	})
+
+	t.Run("Parse the request of the total", func(t *testing.T) {
+		userFilter, err := NewUserFilterFromQuery(url.Values{"include_total": {"true"}})
+		assert.NoError(t, err)
+		assert.True(t, userFilter.IncludeTotal)
+
+		userFilter, err = NewUserFilterFromQuery(url.Values{})
+		assert.NoError(t, err)
+		assert.False(t, userFilter.IncludeTotal)
+
+		_, err = NewUserFilterFromQuery(url.Values{"include_total": {"maybe"}})
+		assert.Equal(t, domainerrors.KindInvalidArgument, domainerrors.KindOf(err))
+	})
+
+	t.Run("Return an invalid argument error for a malformed time", func(t *testing.T) {
+		_, err := NewUserFilterFromQuery(url.Values{"created_after": {"yesterday"}})
+
+		assert.Equal(t, domainerrors.KindInvalidArgument, domainerrors.KindOf(err))
+		assert.Equal(t, "created_after", domainerrors.ViolationsOf(err)[0].Field)
+	})
 }
@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+)
+
+func (h *WebhookHandler) GetWebhooksHandler(w http.ResponseWriter, req *http.Request) {
+	webhooks, err := h.WebhookService.GetWebhooks(req.Context())
+	if err != nil {
+		log.Print("Get failed, ", err)
+		writeError(w, req, err, "Failed to get webhooks")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, webhooks)
+}
@@ -1,22 +1,25 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
-
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
 type HealthCheckHandler struct {
-	mongoClient *mongo.Client
+	storage Pinger
 }
 
-func NewHealthCheckHandler(mongoClient *mongo.Client) *HealthCheckHandler {
-	return &HealthCheckHandler{mongoClient: mongoClient}
+func NewHealthCheckHandler(storage Pinger) *HealthCheckHandler {
+	return &HealthCheckHandler{storage: storage}
 }
 
 func (u *HealthCheckHandler) HealthCheckHandler(w http.ResponseWriter, req *http.Request) {
-	err := u.mongoClient.Ping(req.Context(), nil)
+	err := u.storage.Ping(req.Context())
 	if err != nil {
 		http.Error(w, "Failed to ping the database", http.StatusInternalServerError)
 		return
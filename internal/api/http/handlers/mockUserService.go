@@ -15,25 +15,35 @@ type MockUserService struct {
 
 func (m *MockUserService) NewUser(ctx context.Context, newUser *user.NewUser) (*user.User, error) {
 	args := m.Called()
-	return args.Get(0).(*user.User), nil
+	return args.Get(0).(*user.User), args.Error(1)
 }
 
 func (m *MockUserService) UpdateUser(ctx context.Context, updateUser *user.UpdateUser) (*user.User, error) {
 	args := m.Called()
-	return args.Get(0).(*user.User), nil
+	return args.Get(0).(*user.User), args.Error(1)
 }
 
-func (m *MockUserService) RemoveUser(ctx context.Context, id string) error {
-	m.Called()
-	return nil
+func (m *MockUserService) RemoveUser(ctx context.Context, id string, expectedVersion int64) error {
+	args := m.Called()
+	return args.Error(0)
 }
 
-func (m *MockUserService) GetUsers(ctx context.Context, filter *filter.UserFilter) ([]*user.User, error) {
+func (m *MockUserService) GetUser(ctx context.Context, id string) (*user.User, error) {
 	args := m.Called()
-	return args.Get(0).([]*user.User), nil
+	return args.Get(0).(*user.User), args.Error(1)
 }
 
-func (m *MockUserService) GetChangeChannel(clientId string) <-chan notifier.ChangeData {
+func (m *MockUserService) GetUsers(ctx context.Context, filter *filter.UserFilter) (*user.UsersPage, error) {
+	args := m.Called()
+	return args.Get(0).(*user.UsersPage), nil
+}
+
+func (m *MockUserService) GetChangesSince(ctx context.Context, sequence int64, limit int64) ([]notifier.ChangeData, error) {
+	args := m.Called(sequence)
+	return args.Get(0).([]notifier.ChangeData), args.Error(1)
+}
+
+func (m *MockUserService) GetChangeChannel(clientId string, changeFilter *notifier.ChangeFilter) <-chan notifier.ChangeData {
 	args := m.Called()
 	return args.Get(0).(<-chan notifier.ChangeData)
 }
@@ -42,3 +52,8 @@ func (m *MockUserService) RemoveChannel(clientId string) error {
 	m.Called()
 	return nil
 }
+
+func (m *MockUserService) ChangeChannelErr(clientId string) error {
+	args := m.Called()
+	return args.Error(0)
+}
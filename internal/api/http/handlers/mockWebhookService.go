@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/dlion/faceit_challenge/internal/domain/services/webhook"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockWebhookService struct {
+	mock.Mock
+}
+
+func (m *MockWebhookService) RegisterWebhook(ctx context.Context, newWebhook *webhook.NewWebhook) (*webhook.Webhook, error) {
+	args := m.Called(newWebhook)
+	return args.Get(0).(*webhook.Webhook), args.Error(1)
+}
+
+func (m *MockWebhookService) GetWebhooks(ctx context.Context) ([]*webhook.Webhook, error) {
+	args := m.Called()
+	return args.Get(0).([]*webhook.Webhook), args.Error(1)
+}
+
+func (m *MockWebhookService) RemoveWebhook(ctx context.Context, id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockWebhookService) GetDeliveries(ctx context.Context, webhookId string, status string) ([]*webhook.Delivery, error) {
+	args := m.Called(webhookId, status)
+	return args.Get(0).([]*webhook.Delivery), args.Error(1)
+}
+
+func (m *MockWebhookService) GetDeadLetters(ctx context.Context) ([]*webhook.Delivery, error) {
+	args := m.Called()
+	return args.Get(0).([]*webhook.Delivery), args.Error(1)
+}
+
+func (m *MockWebhookService) Redeliver(ctx context.Context, deliveryId string) (*webhook.Delivery, error) {
+	args := m.Called(deliveryId)
+	return args.Get(0).(*webhook.Delivery), args.Error(1)
+}
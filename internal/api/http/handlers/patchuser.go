@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/dlion/faceit_challenge/internal/domain/services/user"
+	"github.com/gorilla/mux"
+)
+
+// MERGE_PATCH_CONTENT_TYPE is the media type of the JSON Merge Patch documents described by RFC 7396.
+const MERGE_PATCH_CONTENT_TYPE = "application/merge-patch+json"
+
+// PatchUserHandler changes the user with a JSON Merge Patch: the fields of the document are set, those
+// which are null are cleared and the missing ones are left unchanged. An empty field is rejected.
+func (u *UserHandler) PatchUserHandler(w http.ResponseWriter, req *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != MERGE_PATCH_CONTENT_TYPE {
+		log.Print("Patch failed, unsupported content type ", req.Header.Get("Content-Type"))
+		w.Header().Set("Accept-Patch", MERGE_PATCH_CONTENT_TYPE)
+		writeProblem(w, req, &Problem{
+			Type:   "/problems/" + domainerrors.KindInvalidArgument.String(),
+			Title:  http.StatusText(http.StatusUnsupportedMediaType),
+			Status: http.StatusUnsupportedMediaType,
+			Detail: "The patch must be a " + MERGE_PATCH_CONTENT_TYPE + " document",
+		})
+		return
+	}
+
+	var patch map[string]*string
+	if err := json.NewDecoder(req.Body).Decode(&patch); err != nil || patch == nil {
+		log.Print("Patch failed, ", err)
+		writeBadRequest(w, req, "Invalid request payload")
+		return
+	}
+
+	vars := mux.Vars(req)
+	id, ok := vars["id"]
+	if !ok || id == "" {
+		log.Print("Patch failed, it has been provided a bad ID")
+		writeBadRequest(w, req, "ID parameter missing in URL")
+		return
+	}
+
+	updateUser, err := mergePatch(patch)
+	if err != nil {
+		log.Print("Patch failed, ", err)
+		writeError(w, req, err, "Failed to patch user")
+		return
+	}
+	updateUser.Id = id
+
+	updateUser.ExpectedVersion, err = expectedVersion(req, u.currentVersion(req.Context(), id))
+	if err != nil {
+		log.Print("Patch failed, ", err)
+		writeError(w, req, err, "Failed to patch user")
+		return
+	}
+
+	updatedUser, err := u.UserService.UpdateUser(req.Context(), updateUser)
+	if err != nil {
+		log.Print("Patch failed, ", err)
+		writeError(w, req, err, "Failed to patch user")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etagOf(updatedUser.Version))
+	if err := json.NewEncoder(w).Encode(updatedUser); err != nil {
+		log.Print(err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// mergePatch returns the update of the user described by the members of a merge patch,
+// the members clearing a field are null. An empty string is rejected, it would leave the field unchanged.
+func mergePatch(patch map[string]*string) (*user.UpdateUser, error) {
+	updateUser := &user.UpdateUser{}
+	fields := map[string]*string{
+		"first_name": &updateUser.FirstName,
+		"last_name":  &updateUser.LastName,
+		"nickname":   &updateUser.Nickname,
+		"email":      &updateUser.Email,
+		"password":   &updateUser.Password,
+		"country":    &updateUser.Country,
+	}
+
+	// The members are sorted so that the cleared fields and the violations are in a stable order.
+	names := make([]string, 0, len(patch))
+	for name := range patch {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var violations []domainerrors.FieldViolation
+	for _, name := range names {
+		value := patch[name]
+		field, ok := fields[name]
+		if !ok {
+			violations = append(violations, domainerrors.FieldViolation{Field: name, Description: "is not a field of the user that can be changed"})
+			continue
+		}
+
+		switch {
+		case value == nil:
+			updateUser.ClearedFields = append(updateUser.ClearedFields, name)
+		case *value == "":
+			violations = append(violations, domainerrors.FieldViolation{Field: name, Description: "must not be empty, null clears it"})
+		default:
+			*field = *value
+		}
+	}
+
+	if len(violations) > 0 {
+		return nil, domainerrors.NewInvalidArgument("invalid patch", nil, violations...)
+	}
+	return updateUser, nil
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/dlion/faceit_challenge/internal/domain/services/user"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchUserHandler(t *testing.T) {
+	newRouter := func(mockedUserService *MockUserService) *mux.Router {
+		userHandler := UserHandler{UserService: mockedUserService}
+		router := mux.NewRouter()
+		router.HandleFunc("/api/user/{id}", userHandler.PatchUserHandler).Methods("PATCH")
+		return router
+	}
+
+	t.Run("Patch an existing user", func(t *testing.T) {
+		req, err := http.NewRequest("PATCH", "/api/user/66981a71a4fd0f7ff33251b1", bytes.NewBufferString(`{"first_name": "Paco", "last_name": null}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", MERGE_PATCH_CONTENT_TYPE)
+		req.Header.Set("If-Match", `"1"`)
+
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		mockedUserService.On("UpdateUser").Return(&user.User{
+			Id:        "66981a71a4fd0f7ff33251b1",
+			FirstName: "Paco",
+			Email:     "john.doe@example.com",
+			Version:   2,
+		}, nil)
+
+		newRouter(mockedUserService).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+
+		var patchedUser user.User
+		err = json.NewDecoder(rr.Body).Decode(&patchedUser)
+		assert.NoError(t, err)
+		assert.Equal(t, "Paco", patchedUser.FirstName)
+		assert.Empty(t, patchedUser.LastName)
+	})
+
+	t.Run("Return 415 if the patch isn't a merge patch", func(t *testing.T) {
+		req, err := http.NewRequest("PATCH", "/api/user/66981a71a4fd0f7ff33251b1", bytes.NewBufferString(`{"first_name": "Paco"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		newRouter(mockedUserService).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+		assert.Equal(t, MERGE_PATCH_CONTENT_TYPE, rr.Header().Get("Accept-Patch"))
+		mockedUserService.AssertNotCalled(t, "UpdateUser")
+	})
+
+	t.Run("Return 400 if the patch has a member which isn't a field of the user", func(t *testing.T) {
+		req, err := http.NewRequest("PATCH", "/api/user/66981a71a4fd0f7ff33251b1", bytes.NewBufferString(`{"first_name": "Paco", "version": "3"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", MERGE_PATCH_CONTENT_TYPE)
+
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		newRouter(mockedUserService).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		var problem Problem
+		err = json.NewDecoder(rr.Body).Decode(&problem)
+		assert.NoError(t, err)
+		if assert.Len(t, problem.InvalidParams, 1) {
+			assert.Equal(t, "version", problem.InvalidParams[0].Field)
+		}
+		mockedUserService.AssertNotCalled(t, "UpdateUser")
+	})
+}
+
+func TestMergePatch(t *testing.T) {
+	t.Run("Set the string members and clear the null ones", func(t *testing.T) {
+		var patch map[string]*string
+		err := json.Unmarshal([]byte(`{"nickname": "paco", "last_name": null, "country": null}`), &patch)
+		assert.NoError(t, err)
+
+		updateUser, err := mergePatch(patch)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "paco", updateUser.Nickname)
+		assert.Empty(t, updateUser.FirstName)
+		assert.Equal(t, []string{"country", "last_name"}, updateUser.ClearedFields)
+	})
+
+	t.Run("Reject the empty string members instead of clearing them", func(t *testing.T) {
+		var patch map[string]*string
+		err := json.Unmarshal([]byte(`{"nickname": "paco", "country": ""}`), &patch)
+		assert.NoError(t, err)
+
+		_, err = mergePatch(patch)
+
+		assert.Equal(t, domainerrors.KindInvalidArgument, domainerrors.KindOf(err))
+		violations := domainerrors.ViolationsOf(err)
+		if assert.Len(t, violations, 1) {
+			assert.Equal(t, "country", violations[0].Field)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+)
+
+const PROBLEM_CONTENT_TYPE = "application/problem+json"
+
+// Problem is an error response as described by RFC 7807.
+type Problem struct {
+	Type          string                        `json:"type"`
+	Title         string                        `json:"title"`
+	Status        int                           `json:"status"`
+	Detail        string                        `json:"detail,omitempty"`
+	Instance      string                        `json:"instance,omitempty"`
+	InvalidParams []domainerrors.FieldViolation `json:"invalid-params,omitempty"`
+}
+
+// writeError writes the problem matching the kind of a domain error,
+// any other error is reported as an internal error hiding its details.
+func writeError(w http.ResponseWriter, req *http.Request, err error, fallbackDetail string) {
+	kind := domainerrors.KindOf(err)
+	status := statusOf(kind)
+
+	detail := domainerrors.MessageOf(err)
+	if status == http.StatusInternalServerError {
+		detail = fallbackDetail
+	}
+
+	writeProblem(w, req, &Problem{
+		Type:          "/problems/" + kind.String(),
+		Title:         http.StatusText(status),
+		Status:        status,
+		Detail:        detail,
+		InvalidParams: domainerrors.ViolationsOf(err),
+	})
+}
+
+// writeBadRequest writes a problem for a request that can't be understood by the handler.
+func writeBadRequest(w http.ResponseWriter, req *http.Request, detail string) {
+	writeProblem(w, req, &Problem{
+		Type:   "/problems/" + domainerrors.KindInvalidArgument.String(),
+		Title:  http.StatusText(http.StatusBadRequest),
+		Status: http.StatusBadRequest,
+		Detail: detail,
+	})
+}
+
+func writeProblem(w http.ResponseWriter, req *http.Request, problem *Problem) {
+	problem.Instance = req.URL.Path
+
+	w.Header().Set("Content-Type", PROBLEM_CONTENT_TYPE)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		log.Print(err)
+	}
+}
+
+func statusOf(kind domainerrors.Kind) int {
+	switch kind {
+	case domainerrors.KindNotFound:
+		return http.StatusNotFound
+	case domainerrors.KindConflict:
+		return http.StatusConflict
+	case domainerrors.KindInvalidArgument:
+		return http.StatusBadRequest
+	case domainerrors.KindValidation:
+		return http.StatusUnprocessableEntity
+	case domainerrors.KindOutOfRange:
+		return http.StatusGone
+	case domainerrors.KindFailedPrecondition:
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/dlion/faceit_challenge/internal/domain/services/user"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProblemResponses(t *testing.T) {
+	t.Run("Return 422 with the invalid fields if the new user is not valid", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "/api/user", bytes.NewBufferString(`{"email": "notAnEmail"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService}
+		violations := []domainerrors.FieldViolation{{Field: "email", Description: "must be a valid email address"}}
+		mockedUserService.On("NewUser").Return((*user.User)(nil), domainerrors.NewValidation("invalid user", violations))
+		handler := http.HandlerFunc(userHandler.AddUserHandler)
+
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+		assert.Equal(t, PROBLEM_CONTENT_TYPE, rr.Header().Get("Content-Type"))
+
+		var problem Problem
+		err = json.NewDecoder(rr.Body).Decode(&problem)
+		assert.NoError(t, err)
+		assert.Equal(t, "/problems/validation", problem.Type)
+		assert.Equal(t, http.StatusUnprocessableEntity, problem.Status)
+		assert.Equal(t, "invalid user", problem.Detail)
+		assert.Equal(t, "/api/user", problem.Instance)
+		assert.Equal(t, violations, problem.InvalidParams)
+	})
+
+	t.Run("Return 409 if the user already exists", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "/api/user", bytes.NewBufferString(`{"email": "john.doe@example.com"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService}
+		mockedUserService.On("NewUser").Return((*user.User)(nil), domainerrors.NewConflict("user already exists", nil))
+		handler := http.HandlerFunc(userHandler.AddUserHandler)
+
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	})
+
+	t.Run("Return 400 if the id is not valid", func(t *testing.T) {
+		req, err := http.NewRequest("DELETE", "/api/user/randomId", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService}
+		mockedUserService.On("RemoveUser").Return(domainerrors.NewInvalidArgument("invalid user id", nil))
+		router := mux.NewRouter()
+		router.HandleFunc("/api/user/{id}", userHandler.RemoveUserHandler).Methods("DELETE")
+
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Hide the details of internal errors", func(t *testing.T) {
+		req, err := http.NewRequest("DELETE", "/api/user/66981a71a4fd0f7ff33251b1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService}
+		mockedUserService.On("RemoveUser").Return(assert.AnError)
+		router := mux.NewRouter()
+		router.HandleFunc("/api/user/{id}", userHandler.RemoveUserHandler).Methods("DELETE")
+
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+
+		var problem Problem
+		err = json.NewDecoder(rr.Body).Decode(&problem)
+		assert.NoError(t, err)
+		assert.Equal(t, "Failed to delete user", problem.Detail)
+	})
+}
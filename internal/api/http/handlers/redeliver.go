@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (h *WebhookHandler) RedeliverHandler(w http.ResponseWriter, req *http.Request) {
+	id, ok := mux.Vars(req)["id"]
+	if !ok || id == "" {
+		log.Print("Redelivery failed, it has been provided a bad ID")
+		writeBadRequest(w, req, "ID parameter missing in URL")
+		return
+	}
+
+	delivery, err := h.WebhookService.Redeliver(req.Context(), id)
+	if err != nil {
+		log.Print("Redelivery failed, ", err)
+		writeError(w, req, err, "Failed to redeliver")
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, delivery)
+}
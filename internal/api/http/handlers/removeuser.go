@@ -12,14 +12,21 @@ func (u *UserHandler) RemoveUserHandler(w http.ResponseWriter, req *http.Request
 	id, ok := vars["id"]
 	if !ok || id == "" {
 		log.Print("Delete failed, it has been provided a bad ID")
-		http.Error(w, "ID parameter missing in URL", http.StatusBadRequest)
+		writeBadRequest(w, req, "ID parameter missing in URL")
 		return
 	}
 
-	err := u.UserService.RemoveUser(req.Context(), id)
+	version, err := expectedVersion(req, u.currentVersion(req.Context(), id))
 	if err != nil {
 		log.Print("Delete failed, ", err)
-		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+		writeError(w, req, err, "Failed to delete user")
+		return
+	}
+
+	err = u.UserService.RemoveUser(req.Context(), id, version)
+	if err != nil {
+		log.Print("Delete failed, ", err)
+		writeError(w, req, err, "Failed to delete user")
 		return
 	}
 }
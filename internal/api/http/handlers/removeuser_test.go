@@ -5,24 +5,110 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/dlion/faceit_challenge/internal/domain/services/user"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestRemoveUserHandler(t *testing.T) {
-	req, err := http.NewRequest("DELETE", "/api/user/66981a71a4fd0f7ff33251b1", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
+	t.Run("Remove an existing user", func(t *testing.T) {
+		req, err := http.NewRequest("DELETE", "/api/user/66981a71a4fd0f7ff33251b1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
 
-	rr := httptest.NewRecorder()
+		rr := httptest.NewRecorder()
 
-	mockedUserService := new(MockUserService)
-	userHandler := UserHandler{UserService: mockedUserService}
-	mockedUserService.On("RemoveUser").Return(nil)
-	router := mux.NewRouter()
-	router.HandleFunc("/api/user/{id}", userHandler.RemoveUserHandler).Methods("DELETE")
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService}
+		mockedUserService.On("RemoveUser").Return(nil)
+		router := mux.NewRouter()
+		router.HandleFunc("/api/user/{id}", userHandler.RemoveUserHandler).Methods("DELETE")
 
-	router.ServeHTTP(rr, req)
-	assert.Equal(t, http.StatusOK, rr.Code)
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("Return 412 if the user hasn't the version of the If-Match header", func(t *testing.T) {
+		req, err := http.NewRequest("DELETE", "/api/user/66981a71a4fd0f7ff33251b1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("If-Match", `"2"`)
+
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService}
+		mockedUserService.On("RemoveUser").Return(domainerrors.NewFailedPrecondition("the user has been modified", nil))
+		router := mux.NewRouter()
+		router.HandleFunc("/api/user/{id}", userHandler.RemoveUserHandler).Methods("DELETE")
+
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+		assert.Equal(t, PROBLEM_CONTENT_TYPE, rr.Header().Get("Content-Type"))
+	})
+
+	t.Run("Return 412 if the If-Match header has only weak or unknown entity tags", func(t *testing.T) {
+		for _, ifMatch := range []string{`W/"2"`, `"abc"`, `W/"1", "abc"`} {
+			req, err := http.NewRequest("DELETE", "/api/user/66981a71a4fd0f7ff33251b1", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("If-Match", ifMatch)
+
+			rr := httptest.NewRecorder()
+
+			mockedUserService := new(MockUserService)
+			userHandler := UserHandler{UserService: mockedUserService}
+			router := mux.NewRouter()
+			router.HandleFunc("/api/user/{id}", userHandler.RemoveUserHandler).Methods("DELETE")
+
+			router.ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusPreconditionFailed, rr.Code, ifMatch)
+			mockedUserService.AssertNotCalled(t, "RemoveUser")
+		}
+	})
+
+	t.Run("Remove the user if its version is in the If-Match list", func(t *testing.T) {
+		req, err := http.NewRequest("DELETE", "/api/user/66981a71a4fd0f7ff33251b1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("If-Match", `W/"3", "1", "2"`)
+
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService}
+		mockedUserService.On("GetUser").Return(&user.User{Id: "66981a71a4fd0f7ff33251b1", Version: 2}, nil)
+		mockedUserService.On("RemoveUser").Return(nil)
+		router := mux.NewRouter()
+		router.HandleFunc("/api/user/{id}", userHandler.RemoveUserHandler).Methods("DELETE")
+
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		mockedUserService.AssertCalled(t, "RemoveUser")
+	})
+
+	t.Run("Return 412 if the version of the user isn't in the If-Match list", func(t *testing.T) {
+		req, err := http.NewRequest("DELETE", "/api/user/66981a71a4fd0f7ff33251b1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("If-Match", `"1", "2"`)
+
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService}
+		mockedUserService.On("GetUser").Return(&user.User{Id: "66981a71a4fd0f7ff33251b1", Version: 3}, nil)
+		router := mux.NewRouter()
+		router.HandleFunc("/api/user/{id}", userHandler.RemoveUserHandler).Methods("DELETE")
+
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+		mockedUserService.AssertNotCalled(t, "RemoveUser")
+	})
 }
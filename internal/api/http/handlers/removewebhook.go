@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func (h *WebhookHandler) RemoveWebhookHandler(w http.ResponseWriter, req *http.Request) {
+	id, ok := mux.Vars(req)["id"]
+	if !ok || id == "" {
+		log.Print("Delete failed, it has been provided a bad ID")
+		writeBadRequest(w, req, "ID parameter missing in URL")
+		return
+	}
+
+	if err := h.WebhookService.RemoveWebhook(req.Context(), id); err != nil {
+		log.Print("Delete failed, ", err)
+		writeError(w, req, err, "Failed to delete webhook")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dlion/faceit_challenge/pkg/cloudevents"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Messages sent by the client.
+	MESSAGE_SUBSCRIBE   = "subscribe"
+	MESSAGE_UNSUBSCRIBE = "unsubscribe"
+	MESSAGE_PING        = "ping"
+
+	// Messages sent by the server.
+	MESSAGE_ACK    = "ack"
+	MESSAGE_CHANGE = "change"
+	MESSAGE_PONG   = "pong"
+	MESSAGE_ERROR  = "error"
+
+	// MAX_SUBSCRIPTIONS is how many subscriptions a connection can have at a time.
+	MAX_SUBSCRIPTIONS = 100
+	// MAX_MESSAGE_SIZE is the size in bytes of the largest message accepted from a client.
+	MAX_MESSAGE_SIZE = 64 << 10
+
+	// A connection not answering the pings of the server for PONG_WAIT is closed.
+	PING_INTERVAL = 30 * time.Second
+	PONG_WAIT     = 60 * time.Second
+	WRITE_WAIT    = 10 * time.Second
+)
+
+// SubscriptionRequest is a message sent by the client over the WebSocket.
+type SubscriptionRequest struct {
+	Type string `json:"type"`
+	// Id is chosen by the client, it identifies the subscription to subscribe or unsubscribe,
+	// and it's sent back in the reply.
+	Id string `json:"id,omitempty"`
+	// UserIds, Countries and OperationTypes select the changes of the subscription, all of them if empty.
+	UserIds        []string `json:"user_ids,omitempty"`
+	Countries      []string `json:"countries,omitempty"`
+	OperationTypes []string `json:"operation_types,omitempty"`
+}
+
+// SubscriptionMessage is a message sent by the server over the WebSocket.
+type SubscriptionMessage struct {
+	Type string `json:"type"`
+	// Id is the one of the request replied to.
+	Id string `json:"id,omitempty"`
+	// Subscriptions are the ids of the subscriptions selecting the change.
+	Subscriptions []string             `json:"subscriptions,omitempty"`
+	Change        *notifier.ChangeData `json:"change,omitempty"`
+	// Event is the change as a CloudEvent, sent instead of the change on the connections with
+	// the format=cloudevents query parameter.
+	Event *cloudevents.Event `json:"event,omitempty"`
+	Error string             `json:"error,omitempty"`
+}
+
+type SubscriptionHandler struct {
+	Notifier notifier.Notifier
+	// EventSource is the CloudEvents source of the changes sent as CloudEvents,
+	// cloudevents.DEFAULT_SOURCE if empty.
+	EventSource string
+	upgrader    websocket.Upgrader
+}
+
+// NewSubscriptionHandler accepts the connections from the allowed origins only, from the same
+// origin of the request if there are none.
+func NewSubscriptionHandler(notifier notifier.Notifier, allowedOrigins []string) *SubscriptionHandler {
+	handler := &SubscriptionHandler{Notifier: notifier}
+	if len(allowedOrigins) > 0 {
+		handler.upgrader.CheckOrigin = func(req *http.Request) bool {
+			origin := req.Header.Get("Origin")
+			return origin == "" || slices.Contains(allowedOrigins, origin)
+		}
+	}
+	return handler
+}
+
+// SubscriptionsHandler upgrades the request to a WebSocket, over which the client subscribes to
+// and unsubscribes from the changes of the users. Every change is sent once, together with the ids
+// of the subscriptions selecting it.
+func (h *SubscriptionHandler) SubscriptionsHandler(w http.ResponseWriter, req *http.Request) {
+	source, err := eventSource(req.URL.Query(), h.EventSource)
+	if err != nil {
+		log.Print("Invalid subscriptions query, ", err)
+		writeError(w, req, err, "Invalid query")
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// The upgrader has already replied with the error.
+		log.Print("Can't upgrade to a WebSocket, ", err)
+		return
+	}
+	defer conn.Close()
+
+	clientId := uuid.New().String()
+	log.Printf("WebSocket client %s connected", clientId)
+
+	connection := &subscriptionConnection{conn: conn, source: source, subscriptions: map[string]*subscription{}}
+	// The notifier selects the changes of any subscription, whose operation types are checked
+	// while sending them. No change is selected until the client subscribes.
+	changes := h.Notifier.AddSubscriber(clientId, &notifier.ChangeFilter{MatchesUser: connection.matchesUser})
+	defer h.Notifier.RemoveSubscriber(clientId)
+
+	replies := make(chan SubscriptionMessage)
+	stopped := make(chan struct{})
+	defer close(stopped)
+	disconnected := make(chan struct{})
+	go func() {
+		defer close(disconnected)
+		connection.read(replies, stopped)
+	}()
+
+	// Only this goroutine writes to the connection.
+	ping := time.NewTicker(PING_INTERVAL)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case <-disconnected:
+			log.Printf("WebSocket client %s disconnected", clientId)
+			return
+		case reply := <-replies:
+			err = connection.write(reply)
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(WRITE_WAIT))
+		case change, ok := <-changes:
+			if !ok {
+				connection.close(h.Notifier.Err(clientId))
+				return
+			}
+			if subscriptions := connection.selecting(change); len(subscriptions) > 0 {
+				err = connection.writeChange(change, subscriptions)
+			}
+		}
+
+		if err != nil {
+			log.Printf("WebSocket client %s disconnected, %v", clientId, err)
+			return
+		}
+	}
+}
+
+type subscription struct {
+	userIds []string
+	filter  *notifier.ChangeFilter
+}
+
+// matches reports whether the subscription selects the change. The changes without the user
+// are selected by their user id only, since their country is unknown.
+func (s *subscription) matches(change notifier.ChangeData) bool {
+	return (len(s.userIds) == 0 || slices.Contains(s.userIds, change.UserId)) && s.filter.Matches(change)
+}
+
+type subscriptionConnection struct {
+	conn *websocket.Conn
+	// source is the CloudEvents source of the changes, they're sent bare if it's empty.
+	source        string
+	mu            sync.RWMutex
+	subscriptions map[string]*subscription
+}
+
+// read handles the requests of the client until it disconnects, sending the replies to be written.
+func (c *subscriptionConnection) read(replies chan<- SubscriptionMessage, stopped <-chan struct{}) {
+	c.conn.SetReadLimit(MAX_MESSAGE_SIZE)
+	c.conn.SetReadDeadline(time.Now().Add(PONG_WAIT))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(PONG_WAIT))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Print("Can't read the WebSocket request, ", err)
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(PONG_WAIT))
+
+		var reply SubscriptionMessage
+		var request SubscriptionRequest
+		if err := json.Unmarshal(data, &request); err != nil {
+			reply = SubscriptionMessage{Type: MESSAGE_ERROR, Error: "invalid message: " + err.Error()}
+		} else {
+			reply = c.handle(request)
+		}
+
+		select {
+		case replies <- reply:
+		case <-stopped:
+			return
+		}
+	}
+}
+
+func (c *subscriptionConnection) handle(request SubscriptionRequest) SubscriptionMessage {
+	switch request.Type {
+	case MESSAGE_PING:
+		return SubscriptionMessage{Type: MESSAGE_PONG, Id: request.Id}
+	case MESSAGE_SUBSCRIBE:
+		if err := c.subscribe(request); err != nil {
+			return SubscriptionMessage{Type: MESSAGE_ERROR, Id: request.Id, Error: err.Error()}
+		}
+	case MESSAGE_UNSUBSCRIBE:
+		if err := c.unsubscribe(request.Id); err != nil {
+			return SubscriptionMessage{Type: MESSAGE_ERROR, Id: request.Id, Error: err.Error()}
+		}
+	default:
+		return SubscriptionMessage{Type: MESSAGE_ERROR, Id: request.Id, Error: fmt.Sprintf("unknown message type %q", request.Type)}
+	}
+	return SubscriptionMessage{Type: MESSAGE_ACK, Id: request.Id}
+}
+
+// subscribe adds the subscription, replacing the one with the same id.
+func (c *subscriptionConnection) subscribe(request SubscriptionRequest) error {
+	if request.Id == "" {
+		return errors.New("the subscription id is missing")
+	}
+	for _, operationType := range request.OperationTypes {
+		switch operationType {
+		case notifier.ChangeOperationInsert, notifier.ChangeOperationUpdate, notifier.ChangeOperationDelete:
+		default:
+			return fmt.Errorf("invalid operation type %q, it must be %s, %s or %s", operationType,
+				notifier.ChangeOperationInsert, notifier.ChangeOperationUpdate, notifier.ChangeOperationDelete)
+		}
+	}
+
+	countries := request.Countries
+	newSubscription := &subscription{
+		userIds: request.UserIds,
+		filter: &notifier.ChangeFilter{
+			OperationTypes: request.OperationTypes,
+			MatchesUser: func(user *notifier.UserSnapshot) bool {
+				return len(countries) == 0 || slices.Contains(countries, user.Country)
+			},
+		},
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.subscriptions[request.Id]; !ok && len(c.subscriptions) >= MAX_SUBSCRIPTIONS {
+		return fmt.Errorf("too many subscriptions, at most %d are allowed", MAX_SUBSCRIPTIONS)
+	}
+	c.subscriptions[request.Id] = newSubscription
+	return nil
+}
+
+func (c *subscriptionConnection) unsubscribe(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.subscriptions[id]; !ok {
+		return fmt.Errorf("unknown subscription %q", id)
+	}
+	delete(c.subscriptions, id)
+	return nil
+}
+
+// matchesUser selects the users of any subscription, it's called by the notifier.
+func (c *subscriptionConnection) matchesUser(user *notifier.UserSnapshot) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, subscription := range c.subscriptions {
+		if (len(subscription.userIds) == 0 || slices.Contains(subscription.userIds, user.Id)) && subscription.filter.MatchesUser(user) {
+			return true
+		}
+	}
+	return false
+}
+
+// selecting returns the ids of the subscriptions selecting the change, sorted.
+func (c *subscriptionConnection) selecting(change notifier.ChangeData) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var ids []string
+	for id, subscription := range c.subscriptions {
+		if subscription.matches(change) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func (c *subscriptionConnection) write(message SubscriptionMessage) error {
+	c.conn.SetWriteDeadline(time.Now().Add(WRITE_WAIT))
+	return c.conn.WriteJSON(message)
+}
+
+func (c *subscriptionConnection) writeChange(change notifier.ChangeData, subscriptions []string) error {
+	message := SubscriptionMessage{Type: MESSAGE_CHANGE, Subscriptions: subscriptions}
+	if c.source == "" {
+		message.Change = &change
+	} else {
+		event, err := cloudevents.NewEvent(c.source, change)
+		if err != nil {
+			return err
+		}
+		message.Event = event
+	}
+	return c.write(message)
+}
+
+// close tells the client why the server closed the connection: it didn't keep up with the changes,
+// or the notifier has been closed because the service is stopping.
+func (c *subscriptionConnection) close(err error) {
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseGoingAway, "the server is stopping")
+	if errors.Is(err, notifier.ErrSlowConsumer) {
+		log.Print("WebSocket client disconnected by the server, ", err)
+		closeMessage = websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "the client didn't keep up with the changes")
+	}
+	c.conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(WRITE_WAIT))
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dlion/faceit_challenge/pkg/cloudevents"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// removalNotifier records the subscribers removed from the notifier.
+type removalNotifier struct {
+	*notifier.NotifierImpl
+	mu      sync.Mutex
+	removed []string
+}
+
+func (n *removalNotifier) RemoveSubscriber(id string) {
+	n.NotifierImpl.RemoveSubscriber(id)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.removed = append(n.removed, id)
+}
+
+func (n *removalNotifier) removedCount() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.removed)
+}
+
+func TestSubscriptionsHandler(t *testing.T) {
+	connectWithQuery := func(t *testing.T, userChangeNotifier notifier.Notifier, query string) *websocket.Conn {
+		server := httptest.NewServer(http.HandlerFunc(NewSubscriptionHandler(userChangeNotifier, nil).SubscriptionsHandler))
+		t.Cleanup(server.Close)
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+query, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	connect := func(t *testing.T, userChangeNotifier notifier.Notifier) *websocket.Conn {
+		return connectWithQuery(t, userChangeNotifier, "")
+	}
+
+	request := func(t *testing.T, conn *websocket.Conn, request SubscriptionRequest) SubscriptionMessage {
+		require.NoError(t, conn.WriteJSON(request))
+		return read(t, conn)
+	}
+
+	changeOf := func(operationType, userId, country string) notifier.ChangeData {
+		return notifier.ChangeData{
+			EventId:       operationType + "-" + userId,
+			OperationType: operationType,
+			UserId:        userId,
+			After:         &notifier.UserSnapshot{Id: userId, Country: country},
+		}
+	}
+
+	t.Run("should send the changes selected by the subscriptions until they're removed", func(t *testing.T) {
+		userChangeNotifier := notifier.NewNotifier()
+		conn := connect(t, userChangeNotifier)
+
+		assert.Equal(t, SubscriptionMessage{Type: MESSAGE_ACK, Id: "uk"},
+			request(t, conn, SubscriptionRequest{Type: MESSAGE_SUBSCRIBE, Id: "uk", Countries: []string{"UK"}}))
+		assert.Equal(t, SubscriptionMessage{Type: MESSAGE_ACK, Id: "user-1"},
+			request(t, conn, SubscriptionRequest{Type: MESSAGE_SUBSCRIBE, Id: "user-1", UserIds: []string{"user-1"}, OperationTypes: []string{"update"}}))
+
+		userChangeNotifier.Broadcast(changeOf("insert", "user-2", "IT"))
+		userChangeNotifier.Broadcast(changeOf("insert", "user-1", "UK"))
+		userChangeNotifier.Broadcast(changeOf("update", "user-1", "UK"))
+
+		message := read(t, conn)
+		assert.Equal(t, MESSAGE_CHANGE, message.Type)
+		assert.Equal(t, []string{"uk"}, message.Subscriptions)
+		assert.Equal(t, "insert-user-1", message.Change.EventId)
+		message = read(t, conn)
+		assert.Equal(t, []string{"uk", "user-1"}, message.Subscriptions)
+		assert.Equal(t, "update-user-1", message.Change.EventId)
+
+		assert.Equal(t, SubscriptionMessage{Type: MESSAGE_ACK, Id: "uk"},
+			request(t, conn, SubscriptionRequest{Type: MESSAGE_UNSUBSCRIBE, Id: "uk"}))
+		userChangeNotifier.Broadcast(changeOf("insert", "user-3", "UK"))
+		userChangeNotifier.Broadcast(changeOf("update", "user-1", "IT"))
+
+		message = read(t, conn)
+		assert.Equal(t, []string{"user-1"}, message.Subscriptions)
+		assert.Equal(t, "IT", message.Change.After.Country)
+	})
+
+	t.Run("should send the changes as CloudEvents", func(t *testing.T) {
+		userChangeNotifier := notifier.NewNotifier()
+		conn := connectWithQuery(t, userChangeNotifier, "?format=cloudevents")
+		request(t, conn, SubscriptionRequest{Type: MESSAGE_SUBSCRIBE, Id: "all"})
+
+		userChangeNotifier.Broadcast(changeOf("delete", "user-1", "UK"))
+
+		message := read(t, conn)
+		assert.Equal(t, MESSAGE_CHANGE, message.Type)
+		assert.Nil(t, message.Change)
+		require.NotNil(t, message.Event)
+		assert.Equal(t, "delete-user-1", message.Event.Id)
+		assert.Equal(t, cloudevents.DEFAULT_SOURCE, message.Event.Source)
+		assert.Equal(t, cloudevents.TYPE_USER_DELETED, message.Event.Type)
+		assert.Equal(t, "user-1", message.Event.Subject)
+	})
+
+	t.Run("should reply to pings and report the invalid requests", func(t *testing.T) {
+		conn := connect(t, notifier.NewNotifier())
+
+		assert.Equal(t, SubscriptionMessage{Type: MESSAGE_PONG, Id: "1"}, request(t, conn, SubscriptionRequest{Type: MESSAGE_PING, Id: "1"}))
+
+		reply := request(t, conn, SubscriptionRequest{Type: MESSAGE_SUBSCRIBE, Id: "bad", OperationTypes: []string{"replace"}})
+		assert.Equal(t, MESSAGE_ERROR, reply.Type)
+		assert.Equal(t, "bad", reply.Id)
+		assert.Contains(t, reply.Error, "replace")
+
+		reply = request(t, conn, SubscriptionRequest{Type: MESSAGE_UNSUBSCRIBE, Id: "missing"})
+		assert.Equal(t, MESSAGE_ERROR, reply.Type)
+
+		reply = request(t, conn, SubscriptionRequest{Type: "publish"})
+		assert.Equal(t, MESSAGE_ERROR, reply.Type)
+
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("{")))
+		reply = read(t, conn)
+		assert.Equal(t, MESSAGE_ERROR, reply.Type)
+		assert.Contains(t, reply.Error, "invalid message")
+	})
+
+	t.Run("should remove the subscriber when the client disconnects", func(t *testing.T) {
+		userChangeNotifier := &removalNotifier{NotifierImpl: notifier.NewNotifier()}
+		conn := connect(t, userChangeNotifier)
+		request(t, conn, SubscriptionRequest{Type: MESSAGE_PING})
+
+		require.NoError(t, conn.Close())
+
+		assert.Eventually(t, func() bool { return userChangeNotifier.removedCount() == 1 }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("should close the connection of a slow consumer", func(t *testing.T) {
+		userChangeNotifier := notifier.NewNotifier(notifier.WithBufferSize(1), notifier.WithBackpressurePolicy(notifier.DisconnectSlowConsumer))
+		conn := connect(t, userChangeNotifier)
+		request(t, conn, SubscriptionRequest{Type: MESSAGE_SUBSCRIBE, Id: "all"})
+
+		for i := 0; i < 100; i++ {
+			userChangeNotifier.Broadcast(changeOf("insert", "user-1", "UK"))
+		}
+
+		var err error
+		for err == nil {
+			_, _, err = conn.ReadMessage()
+		}
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseTryAgainLater), err.Error())
+	})
+}
+
+func read(t *testing.T, conn *websocket.Conn) SubscriptionMessage {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	var message SubscriptionMessage
+	require.NoError(t, conn.ReadJSON(&message))
+	return message
+}
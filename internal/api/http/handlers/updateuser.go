@@ -13,7 +13,7 @@ func (u *UserHandler) UpdateUserHandler(w http.ResponseWriter, req *http.Request
 	var updateUser user.UpdateUser
 	if err := json.NewDecoder(req.Body).Decode(&updateUser); err != nil {
 		log.Print(err)
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		writeBadRequest(w, req, "Invalid request payload")
 		return
 	}
 
@@ -21,19 +21,28 @@ func (u *UserHandler) UpdateUserHandler(w http.ResponseWriter, req *http.Request
 	id, ok := vars["id"]
 	if !ok || id == "" {
 		log.Print("Update failed, it has been provided a bad ID")
-		http.Error(w, "ID parameter missing in URL", http.StatusBadRequest)
+		writeBadRequest(w, req, "ID parameter missing in URL")
 		return
 	}
 	updateUser.Id = id
 
+	version, err := expectedVersion(req, u.currentVersion(req.Context(), id))
+	if err != nil {
+		log.Print(err)
+		writeError(w, req, err, "Failed to update user")
+		return
+	}
+	updateUser.ExpectedVersion = version
+
 	updatedUser, err := u.UserService.UpdateUser(req.Context(), &updateUser)
 	if err != nil {
 		log.Print(err)
-		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		writeError(w, req, err, "Failed to update user")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etagOf(updatedUser.Version))
 	if err := json.NewEncoder(w).Encode(updatedUser); err != nil {
 		log.Print(err)
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
@@ -31,6 +31,7 @@ func TestUpdateUserHandler(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("If-Match", `"1"`)
 
 	rr := httptest.NewRecorder()
 
@@ -45,12 +46,14 @@ func TestUpdateUserHandler(t *testing.T) {
 		Country:   "USA",
 		CreatedAt: time.Now().String(),
 		UpdatedAt: time.Now().String(),
-	})
+		Version:   2,
+	}, nil)
 	router := mux.NewRouter()
 	router.HandleFunc("/api/user/{id}", userHandler.UpdateUserHandler).Methods("PUT")
 
 	router.ServeHTTP(rr, req)
 	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
 
 	var updatedUser user.User
 	err = json.NewDecoder(rr.Body).Decode(&updatedUser)
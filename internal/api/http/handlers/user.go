@@ -1,9 +1,18 @@
 package handlers
 
-import "github.com/dlion/faceit_challenge/internal/domain/services/user"
+import (
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/domain/services/user"
+)
 
 type UserHandler struct {
 	UserService user.UserService
+	// HeartbeatInterval is how often the idle event streams send a heartbeat, HEARTBEAT_INTERVAL if zero.
+	HeartbeatInterval time.Duration
+	// EventSource is the CloudEvents source of the changes streamed as CloudEvents,
+	// cloudevents.DEFAULT_SOURCE if empty.
+	EventSource string
 }
 
 func NewUserHandler(userService user.UserService) *UserHandler {
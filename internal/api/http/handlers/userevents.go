@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/dlion/faceit_challenge/internal/domain/services/user"
+	"github.com/dlion/faceit_challenge/pkg/cloudevents"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+)
+
+const (
+	// HEARTBEAT_INTERVAL is how often a comment is sent on an idle stream, so the proxies
+	// don't close it and the client notices a broken connection.
+	HEARTBEAT_INTERVAL = 15 * time.Second
+	// RETRY_INTERVAL is how long the browsers wait before reconnecting a closed stream.
+	RETRY_INTERVAL = 3 * time.Second
+
+	LAST_EVENT_ID_HEADER = "Last-Event-ID"
+)
+
+// UserEventsHandler streams the changes of the users as Server-Sent Events. Every event is
+// named after the operation, has the sequence of the change as id and the change as data.
+// A client reconnecting with the Last-Event-ID header, or the last_event_id query parameter,
+// gets the changes it missed from the change log before the live ones, like Watch.
+// With the format=cloudevents query parameter every event is named after the CloudEvents type
+// of the change and has the CloudEvent in the structured mode as data.
+func (u *UserHandler) UserEventsHandler(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	userFilter, err := NewUserFilterFromQuery(query)
+	if err != nil {
+		log.Print("Invalid events query, ", err)
+		writeError(w, req, err, "Invalid query")
+		return
+	}
+	changeFilter, err := user.NewChangeFilter(userFilter, splitList(query.Get("operation_types")))
+	if err != nil {
+		log.Print("Invalid events query, ", err)
+		writeError(w, req, err, "Invalid query")
+		return
+	}
+
+	source, err := eventSource(query, u.EventSource)
+	if err != nil {
+		log.Print("Invalid events query, ", err)
+		writeError(w, req, err, "Invalid query")
+		return
+	}
+
+	lastEventId := req.Header.Get(LAST_EVENT_ID_HEADER)
+	if lastEventId == "" {
+		lastEventId = query.Get("last_event_id")
+	}
+	var fromSequence *int64
+	if lastEventId != "" {
+		lastSequence, err := strconv.ParseInt(lastEventId, 10, 64)
+		if err != nil {
+			log.Print("Invalid last event id, ", err)
+			writeError(w, req, domainerrors.NewInvalidArgument("invalid last event id", err, domainerrors.FieldViolation{
+				Field:       LAST_EVENT_ID_HEADER,
+				Description: "must be the sequence of a change",
+			}), "Invalid last event id")
+			return
+		}
+		fromSequence = &lastSequence
+	}
+
+	changes := user.NewChangeStream(u.UserService, changeFilter, fromSequence)
+	defer changes.Close()
+
+	stream := newEventStream(w, source)
+
+	// The replay is read before the headers are written, so a sequence that can't be resumed
+	// is reported with an error response instead of an empty stream.
+	if err := changes.Replay(req.Context(), stream.send); err != nil {
+		log.Print("Can't replay the changes, ", err)
+		if !stream.started {
+			writeError(w, req, err, "Can't replay the changes")
+		}
+		return
+	}
+	if err := stream.start(); err != nil {
+		log.Print("Can't start the event stream, ", err)
+		return
+	}
+
+	heartbeatInterval := u.HeartbeatInterval
+	if heartbeatInterval <= 0 {
+		heartbeatInterval = HEARTBEAT_INTERVAL
+	}
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			log.Print("Events client disconnected")
+			return
+		case <-heartbeat.C:
+			if err := stream.heartbeat(); err != nil {
+				log.Print("Events client disconnected, ", err)
+				return
+			}
+		case change, ok := <-changes.Changes():
+			if !ok {
+				if err := changes.Err(); err != nil {
+					// The browsers reconnect with the id of the last event received, resuming from there.
+					log.Print("Events client disconnected by the server, ", err)
+				}
+				return
+			}
+
+			if err := changes.Send(req.Context(), change, stream.send); err != nil {
+				log.Print("Events client disconnected, ", err)
+				return
+			}
+		}
+	}
+}
+
+// eventStream writes the events of a text/event-stream response, flushing every one of them.
+type eventStream struct {
+	w          http.ResponseWriter
+	controller *http.ResponseController
+	// source is the CloudEvents source of the changes, they're sent bare if it's empty.
+	source  string
+	started bool
+}
+
+func newEventStream(w http.ResponseWriter, source string) *eventStream {
+	return &eventStream{w: w, controller: http.NewResponseController(w), source: source}
+}
+
+// start writes the headers of the stream, once. The write timeout of the server doesn't apply
+// to the stream, which lasts until the client disconnects.
+func (s *eventStream) start() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+
+	if err := s.controller.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+
+	s.w.Header().Set("Content-Type", "text/event-stream")
+	s.w.Header().Set("Cache-Control", "no-cache")
+	s.w.Header().Set("Connection", "keep-alive")
+	// Stops nginx from buffering the events.
+	s.w.Header().Set("X-Accel-Buffering", "no")
+	s.w.WriteHeader(http.StatusOK)
+
+	if _, err := fmt.Fprintf(s.w, "retry: %d\n\n", RETRY_INTERVAL.Milliseconds()); err != nil {
+		return err
+	}
+	return s.controller.Flush()
+}
+
+// send writes the change as an event, without an id if it has no sequence, so the client
+// keeps the id of the last change it can resume from.
+func (s *eventStream) send(change notifier.ChangeData) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+
+	name, data, err := s.encode(change)
+	if err != nil {
+		return err
+	}
+
+	if change.Sequence != 0 {
+		if _, err := fmt.Fprintf(s.w, "id: %d\n", change.Sequence); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return err
+	}
+	return s.controller.Flush()
+}
+
+// encode returns the name and the data of the event of the change.
+func (s *eventStream) encode(change notifier.ChangeData) (string, []byte, error) {
+	if s.source == "" {
+		data, err := json.Marshal(change)
+		return change.OperationType, data, err
+	}
+
+	event, err := cloudevents.NewEvent(s.source, change)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := json.Marshal(event)
+	return event.Type, data, err
+}
+
+func (s *eventStream) heartbeat() error {
+	if _, err := fmt.Fprint(s.w, ": heartbeat\n\n"); err != nil {
+		return err
+	}
+	return s.controller.Flush()
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserEventsHandler(t *testing.T) {
+	changesOf := func(changes ...notifier.ChangeData) <-chan notifier.ChangeData {
+		channel := make(chan notifier.ChangeData, len(changes))
+		for _, change := range changes {
+			channel <- change
+		}
+		close(channel)
+		return channel
+	}
+
+	t.Run("should stream the changes as events with their sequence as id", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/users/events", nil)
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService}
+		mockedUserService.On("GetChangeChannel").Return(changesOf(
+			notifier.ChangeData{EventId: "event-1", Sequence: 1, OperationType: notifier.ChangeOperationInsert, UserId: "user-1"},
+			notifier.ChangeData{EventId: "event-2", OperationType: notifier.ChangeOperationDelete, UserId: "user-1"},
+		))
+		mockedUserService.On("ChangeChannelErr").Return(nil)
+		mockedUserService.On("RemoveChannel").Return(nil)
+
+		userHandler.UserEventsHandler(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+		body := rr.Body.String()
+		assert.True(t, strings.HasPrefix(body, "retry: 3000\n\n"))
+		assert.Contains(t, body, "id: 1\nevent: insert\ndata: {\"eventId\":\"event-1\",\"sequence\":1,")
+		assert.Contains(t, body, "\n\nevent: delete\ndata: {\"eventId\":\"event-2\",")
+		mockedUserService.AssertCalled(t, "RemoveChannel")
+		mockedUserService.AssertNotCalled(t, "GetChangesSince", int64(0))
+	})
+
+	t.Run("should stream the changes as CloudEvents", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/users/events?format=cloudevents", nil)
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService, EventSource: "/test"}
+		mockedUserService.On("GetChangeChannel").Return(changesOf(
+			notifier.ChangeData{EventId: "event-1", Sequence: 1, OperationType: notifier.ChangeOperationInsert, UserId: "user-1"},
+		))
+		mockedUserService.On("ChangeChannelErr").Return(nil)
+		mockedUserService.On("RemoveChannel").Return(nil)
+
+		userHandler.UserEventsHandler(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), "id: 1\nevent: com.faceit.user.created\ndata: {\"specversion\":\"1.0\",\"id\":\"event-1\",\"source\":\"/test\",\"type\":\"com.faceit.user.created\",\"subject\":\"user-1\",")
+	})
+
+	t.Run("should reject an unknown format", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/users/events?format=xml", nil)
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService}
+
+		userHandler.UserEventsHandler(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		mockedUserService.AssertNotCalled(t, "GetChangeChannel")
+	})
+
+	t.Run("should replay the changes following the Last-Event-ID once", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/users/events", nil)
+		req.Header.Set(LAST_EVENT_ID_HEADER, "3")
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService}
+		mockedUserService.On("GetChangesSince", int64(3)).Return([]notifier.ChangeData{
+			{EventId: "event-4", Sequence: 4, OperationType: notifier.ChangeOperationUpdate},
+		}, nil)
+		mockedUserService.On("GetChangeChannel").Return(changesOf(
+			notifier.ChangeData{EventId: "event-4", Sequence: 4, OperationType: notifier.ChangeOperationUpdate},
+			notifier.ChangeData{EventId: "event-5", Sequence: 5, OperationType: notifier.ChangeOperationUpdate},
+		))
+		mockedUserService.On("ChangeChannelErr").Return(nil)
+		mockedUserService.On("RemoveChannel").Return(nil)
+
+		userHandler.UserEventsHandler(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		body := rr.Body.String()
+		assert.Equal(t, 1, strings.Count(body, "id: 4\n"))
+		assert.Less(t, strings.Index(body, "id: 4\n"), strings.Index(body, "id: 5\n"))
+	})
+
+	t.Run("should reject a Last-Event-ID that isn't a sequence", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/users/events?last_event_id=abc", nil)
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService}
+
+		userHandler.UserEventsHandler(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		mockedUserService.AssertNotCalled(t, "GetChangeChannel")
+	})
+
+	t.Run("should return gone when the changes to resume from have been purged", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/users/events", nil)
+		req.Header.Set(LAST_EVENT_ID_HEADER, "3")
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService}
+		mockedUserService.On("GetChangeChannel").Return(changesOf())
+		mockedUserService.On("GetChangesSince", int64(3)).Return([]notifier.ChangeData(nil),
+			domainerrors.NewOutOfRange("the changes following the sequence have been purged", nil))
+		mockedUserService.On("RemoveChannel").Return(nil)
+
+		userHandler.UserEventsHandler(rr, req)
+
+		assert.Equal(t, http.StatusGone, rr.Code)
+		mockedUserService.AssertCalled(t, "RemoveChannel")
+	})
+
+	t.Run("should send heartbeats until the client disconnects", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		req := httptest.NewRequest("GET", "/api/users/events", nil).WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		mockedUserService := new(MockUserService)
+		userHandler := UserHandler{UserService: mockedUserService, HeartbeatInterval: 10 * time.Millisecond}
+		mockedUserService.On("GetChangeChannel").Return((<-chan notifier.ChangeData)(make(chan notifier.ChangeData)))
+		mockedUserService.On("RemoveChannel").Return(nil)
+
+		userHandler.UserEventsHandler(rr, req)
+
+		assert.Contains(t, rr.Body.String(), ": heartbeat\n\n")
+		mockedUserService.AssertCalled(t, "RemoveChannel")
+	})
+}
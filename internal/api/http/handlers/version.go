@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+)
+
+// etagOf returns the strong entity tag of the version of a user.
+func etagOf(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// expectedVersion returns the version of the user required by the If-Match header of the request:
+// zero, matching any version, if the header is missing or it's *. The header lists the entity tags
+// the user may match, compared strongly, so the weak ones and the ones that aren't the ETag of a user
+// never match: without any other the user doesn't satisfy the header. With several ETags the version
+// of the user, read by currentVersion, is the expected one if it's listed.
+func expectedVersion(req *http.Request, currentVersion func() (int64, error)) (int64, error) {
+	ifMatch := strings.TrimSpace(req.Header.Get("If-Match"))
+	if ifMatch == "" {
+		return 0, nil
+	}
+
+	var versions []int64
+	for _, etag := range strings.Split(ifMatch, ",") {
+		etag = strings.TrimSpace(etag)
+		if etag == "*" {
+			return 0, nil
+		}
+		if version, ok := parseETag(etag); ok {
+			versions = append(versions, version)
+		}
+	}
+
+	switch len(versions) {
+	case 0:
+		return 0, versionMismatch(ifMatch)
+	case 1:
+		return versions[0], nil
+	}
+
+	version, err := currentVersion()
+	if err != nil {
+		return 0, err
+	}
+	if !slices.Contains(versions, version) {
+		return 0, versionMismatch(ifMatch)
+	}
+	return version, nil
+}
+
+// parseETag returns the version of a user whose ETag is the entity tag.
+func parseETag(etag string) (int64, bool) {
+	if len(etag) < 2 || !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(etag[1:len(etag)-1], 10, 64)
+	if err != nil || version < 1 {
+		return 0, false
+	}
+	return version, true
+}
+
+func versionMismatch(ifMatch string) error {
+	return domainerrors.NewFailedPrecondition("the user doesn't match the If-Match header", fmt.Errorf("no entity tag of %q matches the version of the user", ifMatch))
+}
+
+// currentVersion returns a function reading the version of the user.
+func (u *UserHandler) currentVersion(ctx context.Context, id string) func() (int64, error) {
+	return func() (int64, error) {
+		user, err := u.UserService.GetUser(ctx, id)
+		if err != nil {
+			return 0, err
+		}
+		return user.Version, nil
+	}
+}
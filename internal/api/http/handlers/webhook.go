@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/dlion/faceit_challenge/internal/domain/services/webhook"
+)
+
+type WebhookHandler struct {
+	WebhookService webhook.WebhookService
+}
+
+func NewWebhookHandler(webhookService webhook.WebhookService) *WebhookHandler {
+	return &WebhookHandler{WebhookService: webhookService}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/dlion/faceit_challenge/internal/domain/services/webhook"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	t.Run("should register a webhook and return it without its secret", func(t *testing.T) {
+		newWebhook := webhook.NewWebhook{Url: "https://example.com/hook", EventTypes: []string{"insert"}, Secret: "0123456789abcdef"}
+		jsonData, err := json.Marshal(newWebhook)
+		assert.NoError(t, err)
+		req := httptest.NewRequest("POST", "/api/webhooks", bytes.NewBuffer(jsonData))
+		rr := httptest.NewRecorder()
+
+		mockedWebhookService := new(MockWebhookService)
+		webhookHandler := NewWebhookHandler(mockedWebhookService)
+		mockedWebhookService.On("RegisterWebhook", &newWebhook).Return(&webhook.Webhook{
+			Id:         "66981a71a4fd0f7ff33251b1",
+			Url:        newWebhook.Url,
+			EventTypes: newWebhook.EventTypes,
+		}, nil)
+
+		http.HandlerFunc(webhookHandler.AddWebhookHandler).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		assert.NotContains(t, rr.Body.String(), newWebhook.Secret)
+		var registered webhook.Webhook
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&registered))
+		assert.Equal(t, "66981a71a4fd0f7ff33251b1", registered.Id)
+		assert.Equal(t, newWebhook.Url, registered.Url)
+	})
+
+	t.Run("should reject an invalid webhook", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/webhooks", bytes.NewBufferString(`{"url":"ftp://example.com"}`))
+		rr := httptest.NewRecorder()
+
+		mockedWebhookService := new(MockWebhookService)
+		webhookHandler := NewWebhookHandler(mockedWebhookService)
+		mockedWebhookService.On("RegisterWebhook", &webhook.NewWebhook{Url: "ftp://example.com"}).Return((*webhook.Webhook)(nil),
+			domainerrors.NewValidation("invalid webhook", []domainerrors.FieldViolation{{Field: "url", Description: "must be an absolute http or https URL"}}))
+
+		http.HandlerFunc(webhookHandler.AddWebhookHandler).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+		assert.Contains(t, rr.Body.String(), "url")
+	})
+
+	t.Run("should return the deliveries of a webhook with the status", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/webhooks/66981a71a4fd0f7ff33251b1/deliveries?status=dead", nil)
+		rr := httptest.NewRecorder()
+
+		mockedWebhookService := new(MockWebhookService)
+		webhookHandler := NewWebhookHandler(mockedWebhookService)
+		mockedWebhookService.On("GetDeliveries", "66981a71a4fd0f7ff33251b1", "dead").Return([]*webhook.Delivery{
+			{Id: "66981a71a4fd0f7ff33251b2", WebhookId: "66981a71a4fd0f7ff33251b1", Status: "dead"},
+		}, nil)
+		router := mux.NewRouter()
+		router.HandleFunc("/api/webhooks/{id}/deliveries", webhookHandler.GetDeliveriesHandler).Methods("GET")
+
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var deliveries []*webhook.Delivery
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&deliveries))
+		assert.Len(t, deliveries, 1)
+		assert.Equal(t, "66981a71a4fd0f7ff33251b2", deliveries[0].Id)
+	})
+
+	t.Run("should redeliver a delivery", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/webhooks/deliveries/66981a71a4fd0f7ff33251b2/redeliver", nil)
+		rr := httptest.NewRecorder()
+
+		mockedWebhookService := new(MockWebhookService)
+		webhookHandler := NewWebhookHandler(mockedWebhookService)
+		mockedWebhookService.On("Redeliver", "66981a71a4fd0f7ff33251b2").Return(&webhook.Delivery{
+			Id:     "66981a71a4fd0f7ff33251b2",
+			Status: "pending",
+		}, nil)
+		router := mux.NewRouter()
+		router.HandleFunc("/api/webhooks/deliveries/{id}/redeliver", webhookHandler.RedeliverHandler).Methods("POST")
+
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusAccepted, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"status":"pending"`)
+	})
+
+	t.Run("should return not found redelivering a missing delivery", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/webhooks/deliveries/66981a71a4fd0f7ff33251b2/redeliver", nil)
+		rr := httptest.NewRecorder()
+
+		mockedWebhookService := new(MockWebhookService)
+		webhookHandler := NewWebhookHandler(mockedWebhookService)
+		mockedWebhookService.On("Redeliver", "66981a71a4fd0f7ff33251b2").Return((*webhook.Delivery)(nil),
+			domainerrors.NewNotFound("delivery not found", nil))
+		router := mux.NewRouter()
+		router.HandleFunc("/api/webhooks/deliveries/{id}/redeliver", webhookHandler.RedeliverHandler).Methods("POST")
+
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
@@ -0,0 +1,115 @@
+// Package domainerrors defines the errors returned by the domain services,
+// classified by Kind so that every API can translate them to its own status codes.
+package domainerrors
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindNotFound
+	KindConflict
+	KindInvalidArgument
+	KindValidation
+	KindOutOfRange
+	KindFailedPrecondition
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNotFound:
+		return "not-found"
+	case KindConflict:
+		return "conflict"
+	case KindInvalidArgument:
+		return "invalid-argument"
+	case KindValidation:
+		return "validation"
+	case KindOutOfRange:
+		return "out-of-range"
+	case KindFailedPrecondition:
+		return "failed-precondition"
+	default:
+		return "internal"
+	}
+}
+
+type FieldViolation struct {
+	Field       string `json:"name"`
+	Description string `json:"reason"`
+}
+
+type Error struct {
+	Kind       Kind
+	Message    string
+	Violations []FieldViolation
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func NewNotFound(message string, err error) *Error {
+	return &Error{Kind: KindNotFound, Message: message, Err: err}
+}
+
+func NewConflict(message string, err error) *Error {
+	return &Error{Kind: KindConflict, Message: message, Err: err}
+}
+
+func NewInvalidArgument(message string, err error, violations ...FieldViolation) *Error {
+	return &Error{Kind: KindInvalidArgument, Message: message, Err: err, Violations: violations}
+}
+
+func NewValidation(message string, violations []FieldViolation) *Error {
+	return &Error{Kind: KindValidation, Message: message, Violations: violations}
+}
+
+func NewOutOfRange(message string, err error) *Error {
+	return &Error{Kind: KindOutOfRange, Message: message, Err: err}
+}
+
+func NewFailedPrecondition(message string, err error) *Error {
+	return &Error{Kind: KindFailedPrecondition, Message: message, Err: err}
+}
+
+// KindOf returns the kind of the first domain error in the chain of err,
+// KindInternal if there's none.
+func KindOf(err error) Kind {
+	var domainErr *Error
+	if errors.As(err, &domainErr) {
+		return domainErr.Kind
+	}
+	return KindInternal
+}
+
+// ViolationsOf returns the field violations of the first domain error in the chain of err.
+func ViolationsOf(err error) []FieldViolation {
+	var domainErr *Error
+	if errors.As(err, &domainErr) {
+		return domainErr.Violations
+	}
+	return nil
+}
+
+// MessageOf returns the message of the first domain error in the chain of err,
+// it never includes the underlying cause so it's safe to be shown to the clients.
+func MessageOf(err error) string {
+	var domainErr *Error
+	if errors.As(err, &domainErr) {
+		return domainErr.Message
+	}
+	return ""
+}
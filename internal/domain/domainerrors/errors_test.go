@@ -0,0 +1,37 @@
+package domainerrors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrors(t *testing.T) {
+	t.Run("Return the kind of a wrapped domain error", func(t *testing.T) {
+		cause := errors.New("the user doesn't exist in the db")
+		err := fmt.Errorf("getting user: %w", NewNotFound("user not found", cause))
+
+		assert.Equal(t, KindNotFound, KindOf(err))
+		assert.Equal(t, "user not found", MessageOf(err))
+		assert.ErrorIs(t, err, cause)
+	})
+
+	t.Run("Return KindInternal for any other error", func(t *testing.T) {
+		err := errors.New("connection refused")
+
+		assert.Equal(t, KindInternal, KindOf(err))
+		assert.Empty(t, MessageOf(err))
+		assert.Nil(t, ViolationsOf(err))
+	})
+
+	t.Run("Return the field violations of a validation error", func(t *testing.T) {
+		violations := []FieldViolation{{Field: "email", Description: "must be a valid email"}}
+		err := NewValidation("invalid user", violations)
+
+		assert.Equal(t, KindValidation, KindOf(err))
+		assert.Equal(t, violations, ViolationsOf(err))
+		assert.Equal(t, "invalid user", err.Error())
+	})
+}
@@ -0,0 +1,127 @@
+package user
+
+import (
+	"context"
+
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/google/uuid"
+)
+
+// REPLAY_BATCH_SIZE is how many logged changes are read at a time while replaying them.
+const REPLAY_BATCH_SIZE = 100
+
+// ChangeStream is the subscription of a client to the changes of the users.
+// Resuming clients get every change once and in order: the ones already sent are skipped
+// and the missed ones are read from the change log. The changes without a sequence,
+// written without the service and broadcast from the MongoDB change stream, aren't in the log
+// and are always sent.
+type ChangeStream struct {
+	service      UserService
+	clientId     string
+	changeFilter *notifier.ChangeFilter
+	channel      <-chan notifier.ChangeData
+	resuming     bool
+	lastSequence int64
+}
+
+// NewChangeStream subscribes to the changes selected by the filter, resuming after fromSequence
+// if it isn't nil. The stream must be closed when the client is gone.
+func NewChangeStream(service UserService, changeFilter *notifier.ChangeFilter, fromSequence *int64) *ChangeStream {
+	stream := &ChangeStream{service: service, clientId: uuid.New().String(), changeFilter: changeFilter}
+
+	if fromSequence != nil {
+		stream.resuming = true
+		stream.lastSequence = *fromSequence
+		// The changes not selected by the filter are received with their sequence only, so they don't
+		// leave gaps in the live sequence: only the missed ones are read from the log. The filter is
+		// copied, the caller's one is left as it is.
+		if changeFilter != nil {
+			resumedFilter := *changeFilter
+			resumedFilter.NotifySkipped = true
+			changeFilter = &resumedFilter
+		}
+	}
+
+	// The subscription starts before the replay, so no change is missed between the two.
+	stream.channel = service.GetChangeChannel(stream.clientId, changeFilter)
+	return stream
+}
+
+// Changes returns the channel of the live changes, it's closed when the client is disconnected
+// or the service is stopping.
+func (c *ChangeStream) Changes() <-chan notifier.ChangeData {
+	return c.channel
+}
+
+// Replay sends the logged changes selected by the filter following the last sequence sent,
+// if the client is resuming.
+func (c *ChangeStream) Replay(ctx context.Context, send func(notifier.ChangeData) error) error {
+	if !c.resuming {
+		return nil
+	}
+
+	for {
+		changes, err := c.service.GetChangesSince(ctx, c.lastSequence, REPLAY_BATCH_SIZE)
+		if err != nil {
+			return err
+		}
+
+		for _, change := range changes {
+			if c.changeFilter.Matches(change) {
+				if err := send(change); err != nil {
+					return err
+				}
+			}
+			c.lastSequence = change.Sequence
+		}
+
+		if len(changes) < REPLAY_BATCH_SIZE {
+			return nil
+		}
+	}
+}
+
+// Send sends a live change received from the channel, after the missed ones, unless it has
+// already been sent or it's skipped by the filter.
+func (c *ChangeStream) Send(ctx context.Context, change notifier.ChangeData, send func(notifier.ChangeData) error) error {
+	if c.resuming && change.Sequence != 0 {
+		if change.Sequence > c.lastSequence+1 {
+			if err := c.Replay(ctx, send); err != nil {
+				return err
+			}
+		}
+
+		if change.Sequence <= c.lastSequence {
+			return nil
+		}
+		if change.Skipped() {
+			c.lastSequence = change.Sequence
+			return nil
+		}
+	}
+
+	if err := send(change); err != nil {
+		return err
+	}
+	if change.Sequence != 0 {
+		c.lastSequence = change.Sequence
+	}
+	return nil
+}
+
+// Err returns why the channel of the changes has been closed, nil if the client hasn't been
+// disconnected, as when the service is stopping.
+func (c *ChangeStream) Err() error {
+	return c.service.ChangeChannelErr(c.clientId)
+}
+
+// LastSequence returns the sequence of the last change sent, or skipped, for the client to
+// resume from. It's false if the client didn't resume and no change with a sequence has been sent.
+func (c *ChangeStream) LastSequence() (int64, bool) {
+	return c.lastSequence, c.resuming || c.lastSequence != 0
+}
+
+// Close removes the subscription.
+func (c *ChangeStream) Close() {
+	c.service.RemoveChannel(c.clientId)
+}
@@ -0,0 +1,78 @@
+package user
+
+import (
+	"context"
+	"testing"
+
+	memoryRepositories "github.com/dlion/faceit_challenge/internal/repositories/memory"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeStream(t *testing.T) {
+	newUserService := func(t *testing.T, count int) *UserServiceImpl {
+		userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+		changeNotifier := notifier.NewNotifier()
+		t.Cleanup(changeNotifier.Close)
+		userService := NewUserService(userRepo, changeNotifier, WithChangeLog(userRepo))
+		for _, nickname := range []string{"Test1", "Test2", "Test3", "Test4"}[:count] {
+			_, err := userService.NewUser(context.TODO(), &NewUser{Email: nickname + "@test.com", Nickname: nickname, Password: "testPassword"})
+			require.NoError(t, err)
+		}
+		return userService
+	}
+
+	collect := func(sent *[]int64) func(notifier.ChangeData) error {
+		return func(change notifier.ChangeData) error {
+			*sent = append(*sent, change.Sequence)
+			return nil
+		}
+	}
+
+	t.Run("Replay the missed changes and skip the ones already sent", func(t *testing.T) {
+		userService := newUserService(t, 4)
+		fromSequence := int64(1)
+		stream := NewChangeStream(userService, nil, &fromSequence)
+		defer stream.Close()
+
+		var sent []int64
+		require.NoError(t, stream.Replay(context.TODO(), collect(&sent)))
+		require.NoError(t, stream.Send(context.TODO(), notifier.ChangeData{Sequence: 3, EventId: "event3"}, collect(&sent)))
+		require.NoError(t, stream.Send(context.TODO(), notifier.ChangeData{Sequence: 5, EventId: "event5"}, collect(&sent)))
+		require.NoError(t, stream.Send(context.TODO(), notifier.ChangeData{}, collect(&sent)))
+
+		assert.Equal(t, []int64{2, 3, 4, 5, 0}, sent)
+		lastSequence, hasSequence := stream.LastSequence()
+		assert.Equal(t, int64(5), lastSequence)
+		assert.True(t, hasSequence)
+	})
+
+	t.Run("Read the log only for the missed changes, not for the skipped ones", func(t *testing.T) {
+		userService := newUserService(t, 2)
+		fromSequence := int64(2)
+		changeFilter := &notifier.ChangeFilter{OperationTypes: []string{notifier.ChangeOperationDelete}}
+		stream := NewChangeStream(userService, changeFilter, &fromSequence)
+		defer stream.Close()
+
+		var sent []int64
+		require.NoError(t, stream.Replay(context.TODO(), collect(&sent)))
+		require.NoError(t, stream.Send(context.TODO(), notifier.ChangeData{Sequence: 3}, collect(&sent)))
+		require.NoError(t, stream.Send(context.TODO(), notifier.ChangeData{Sequence: 4, EventId: "event4", OperationType: notifier.ChangeOperationDelete}, collect(&sent)))
+
+		assert.False(t, changeFilter.NotifySkipped, "the filter of the caller must not be changed")
+		assert.Equal(t, []int64{4}, sent)
+	})
+
+	t.Run("Send every live change without resuming", func(t *testing.T) {
+		userService := newUserService(t, 2)
+		stream := NewChangeStream(userService, nil, nil)
+		defer stream.Close()
+
+		var sent []int64
+		require.NoError(t, stream.Replay(context.TODO(), collect(&sent)))
+		require.NoError(t, stream.Send(context.TODO(), notifier.ChangeData{Sequence: 5, EventId: "event5"}, collect(&sent)))
+
+		assert.Equal(t, []int64{5}, sent)
+	})
+}
@@ -26,6 +26,11 @@ type UpdateUser struct {
 	Email     string `json:"email"`
 	Password  string `json:"password"`
 	Country   string `json:"country"`
+	// ExpectedVersion is the version the user must have to be updated, it's updated whatever
+	// its version if it's zero.
+	ExpectedVersion int64 `json:"-"`
+	// ClearedFields are the names of the fields to empty, among repositories.CLEARABLE_FIELDS.
+	ClearedFields []string `json:"-"`
 }
 
 type User struct {
@@ -37,4 +42,19 @@ type User struct {
 	Country   string `json:"country"`
 	CreatedAt string `json:"created_at"`
 	UpdatedAt string `json:"updated_at"`
+	// Version is incremented by every update of the user.
+	Version int64 `json:"version"`
+}
+
+type UsersPage struct {
+	Users []*User
+	// NextPageToken selects the following page, it's empty if there are no more users.
+	NextPageToken string
+	// Total is the number of users matching the filter across all the pages,
+	// nil unless the filter includes it.
+	Total *int64
+	Limit int64
+	// Offset is the position of the first user of the page, also when it's selected by a page token.
+	Offset  int64
+	HasMore bool
 }
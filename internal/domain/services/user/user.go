@@ -2,39 +2,90 @@ package user
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"reflect"
+	"slices"
+	"strings"
 	"time"
 
 	filter "github.com/dlion/faceit_challenge/internal"
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/dlion/faceit_challenge/internal/outbox"
+	"github.com/dlion/faceit_challenge/internal/pagetoken"
 	"github.com/dlion/faceit_challenge/internal/repositories"
 	"github.com/dlion/faceit_challenge/pkg/notifier"
 	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type UserService interface {
 	NewUser(context.Context, *NewUser) (*User, error)
 	UpdateUser(context.Context, *UpdateUser) (*User, error)
-	RemoveUser(context.Context, string) error
-	GetUsers(context.Context, *filter.UserFilter) ([]*User, error)
-	GetChangeChannel(clientId string) <-chan notifier.ChangeData
+	// RemoveUser removes the user if its version is the expected one, whatever its version if that is zero.
+	RemoveUser(ctx context.Context, id string, expectedVersion int64) error
+	GetUser(context.Context, string) (*User, error)
+	GetUsers(context.Context, *filter.UserFilter) (*UsersPage, error)
+	GetChangesSince(ctx context.Context, sequence int64, limit int64) ([]notifier.ChangeData, error)
+	GetChangeChannel(clientId string, changeFilter *notifier.ChangeFilter) <-chan notifier.ChangeData
 	RemoveChannel(clientId string) error
+	ChangeChannelErr(clientId string) error
 }
 
+const DEFAULT_LIMIT = 10
+
 type UserServiceImpl struct {
-	repository repositories.UserRepository
-	notifier   notifier.Notifier
+	repository     repositories.UserRepository
+	notifier       notifier.Notifier
+	pageTokenCodec *pagetoken.Codec
+	outboxRelay    *outbox.Relay
+	changeLog      repositories.ChangeLog
+}
+
+type Option func(*UserServiceImpl)
+
+// WithPageTokenCodec sets the codec of the page tokens, by default they're signed with a random
+// secret so they can't be used across replicas or restarts.
+func WithPageTokenCodec(codec *pagetoken.Codec) Option {
+	return func(u *UserServiceImpl) {
+		u.pageTokenCodec = codec
+	}
 }
 
-func NewUserService(repository repositories.UserRepository, notifier notifier.Notifier) *UserServiceImpl {
-	return &UserServiceImpl{repository: repository, notifier: notifier}
+// WithOutboxRelay is used when the repository records the changes of the users in its outbox:
+// the service doesn't broadcast them but wakes up the relay, which broadcasts them from the outbox.
+func WithOutboxRelay(relay *outbox.Relay) Option {
+	return func(u *UserServiceImpl) {
+		u.outboxRelay = relay
+	}
+}
+
+// WithChangeLog sets the log the missed changes are read from, without it they can't be replayed.
+func WithChangeLog(changeLog repositories.ChangeLog) Option {
+	return func(u *UserServiceImpl) {
+		u.changeLog = changeLog
+	}
+}
+
+func NewUserService(repository repositories.UserRepository, notifier notifier.Notifier, options ...Option) *UserServiceImpl {
+	userService := &UserServiceImpl{repository: repository, notifier: notifier}
+	for _, option := range options {
+		option(userService)
+	}
+
+	if userService.pageTokenCodec == nil {
+		userService.pageTokenCodec = pagetoken.NewRandomCodec()
+	}
+
+	return userService
 }
 
 func (u *UserServiceImpl) NewUser(ctx context.Context, newUser *NewUser) (*User, error) {
 	log.Printf("Adding a new user: %s", newUser)
 
-	validate := validator.New(validator.WithRequiredStructEnabled())
-	err := validate.Struct(newUser)
+	err := validateStruct(newUser)
 	if err != nil {
 		return nil, err
 	}
@@ -42,78 +93,261 @@ func (u *UserServiceImpl) NewUser(ctx context.Context, newUser *NewUser) (*User,
 	repoUser := repositories.NewRepoUser(newUser.FirstName, newUser.LastName, newUser.Nickname, newUser.Password, newUser.Email, newUser.Country)
 	addedUser, err := u.repository.AddUser(ctx, repoUser)
 	if err != nil {
-		return nil, err
+		return nil, toDomainError(err)
 	}
 
-	outputUser := toUser(addedUser)
+	u.publish(notifier.ChangeOperationInsert, nil, addedUser)
 
-	u.notifier.Broadcast(notifier.ChangeData{
-		OperationType: notifier.ChangeOperationInsert,
-		UserId:        outputUser.Id,
-	})
-
-	return outputUser, nil
+	return toUser(addedUser), nil
 }
 
 func (u *UserServiceImpl) UpdateUser(ctx context.Context, updateUser *UpdateUser) (*User, error) {
 	log.Printf("Updating user %s", updateUser.Id)
 
-	hex, err := primitive.ObjectIDFromHex(updateUser.Id)
+	hex, err := parseUserId(updateUser.Id)
 	if err != nil {
 		return nil, err
 	}
+	if err := validateExpectedVersion(updateUser.ExpectedVersion); err != nil {
+		return nil, err
+	}
+	if err := validateClearedFields(updateUser); err != nil {
+		return nil, err
+	}
 
 	repoUser := repositories.NewRepoUser(updateUser.FirstName, updateUser.LastName, updateUser.Nickname, updateUser.Password, updateUser.Email, updateUser.Country)
 	repoUser.Id = hex
+	repoUser.Version = updateUser.ExpectedVersion
+	repoUser.ClearedFields = updateUser.ClearedFields
 
-	updatedUser, err := u.repository.UpdateUser(ctx, repoUser)
+	updatedUser, previousUser, err := u.repository.UpdateUser(ctx, repoUser)
 	if err != nil {
-		return nil, err
+		return nil, toDomainError(err)
 	}
 
-	outputUser := toUser(updatedUser)
-
-	u.notifier.Broadcast(notifier.ChangeData{
-		OperationType: notifier.ChangeOperationUpdate,
-		UserId:        outputUser.Id,
-	})
+	u.publish(notifier.ChangeOperationUpdate, previousUser, updatedUser)
 
-	return outputUser, nil
+	return toUser(updatedUser), nil
 }
 
-func (u *UserServiceImpl) RemoveUser(ctx context.Context, id string) error {
+func (u *UserServiceImpl) RemoveUser(ctx context.Context, id string, expectedVersion int64) error {
 	log.Printf("Removing user with id: %s", id)
 
-	err := u.repository.RemoveUser(ctx, id)
-	if err != nil {
+	if _, err := parseUserId(id); err != nil {
+		return err
+	}
+	if err := validateExpectedVersion(expectedVersion); err != nil {
 		return err
 	}
 
-	u.notifier.Broadcast(notifier.ChangeData{
-		OperationType: notifier.ChangeOperationDelete,
-		UserId:        id,
-	})
+	removedUser, err := u.repository.RemoveUser(ctx, id, expectedVersion)
+	if err != nil {
+		return toDomainError(err)
+	}
+
+	u.publish(notifier.ChangeOperationDelete, removedUser, nil)
 
 	return nil
 }
 
-func (u *UserServiceImpl) GetUsers(ctx context.Context, userFilter *filter.UserFilter) ([]*User, error) {
-	log.Printf("Getting users with query: %s", userFilter)
+// publish notifies the change of a user from its stored values before and after the change,
+// unless it's broadcast by the outbox relay.
+func (u *UserServiceImpl) publish(operationType string, before, after *repositories.User) {
+	if u.outboxRelay != nil {
+		u.outboxRelay.Wake()
+		return
+	}
 
-	users, err := u.repository.GetUsers(ctx, userFilter, userFilter.Limit, userFilter.Offset)
+	user := after
+	if user == nil {
+		user = before
+	}
+
+	change := notifier.ChangeData{
+		EventId:       uuid.New().String(),
+		Timestamp:     time.Now(),
+		OperationType: operationType,
+		UserId:        user.Id.Hex(),
+		ChangedFields: repositories.ChangedFields(before, after),
+		Before:        before.Snapshot(),
+		After:         after.Snapshot(),
+	}
+	u.notifier.Broadcast(change)
+}
+
+func (u *UserServiceImpl) GetUser(ctx context.Context, id string) (*User, error) {
+	log.Printf("Getting user with id: %s", id)
+
+	if _, err := parseUserId(id); err != nil {
+		return nil, err
+	}
+
+	user, err := u.repository.GetUser(ctx, id)
+	if err != nil {
+		return nil, toDomainError(err)
+	}
+
+	return toUser(user), nil
+}
+
+func (u *UserServiceImpl) GetUsers(ctx context.Context, requestFilter *filter.UserFilter) (*UsersPage, error) {
+	// The page token is decoded in a copy, the filter of the caller is left unchanged.
+	userFilter := *requestFilter
+
+	if err := filter.ValidateSort(userFilter.Sort); err != nil {
+		return nil, domainerrors.NewInvalidArgument("invalid sort", err, domainerrors.FieldViolation{
+			Field:       "sort",
+			Description: err.Error(),
+		})
+	}
+
+	if userFilter.Search != nil && len(userFilter.SearchWords()) == 0 {
+		return nil, domainerrors.NewInvalidArgument("invalid search", nil, domainerrors.FieldViolation{
+			Field:       "q",
+			Description: "must contain at least a word made of letters or digits",
+		})
+	}
+
+	if userFilter.PageToken != nil && *userFilter.PageToken != "" {
+		// The relevance of a user isn't stored, so the pages ranked by it are selected by offset.
+		if userFilter.RanksByRelevance() {
+			return nil, domainerrors.NewInvalidArgument("invalid page token", nil, domainerrors.FieldViolation{
+				Field:       "page_token",
+				Description: "can't be used with a search ranked by relevance, use the offset or a sort",
+			})
+		}
+
+		cursor, err := u.pageTokenCodec.Decode(*userFilter.PageToken)
+		if err != nil {
+			return nil, domainerrors.NewInvalidArgument("invalid page token", err, domainerrors.FieldViolation{
+				Field:       "page_token",
+				Description: "must be a next_page_token returned by a previous listing",
+			})
+		}
+
+		if filter.FormatSort(cursor.Sort) != filter.FormatSort(userFilter.SortOrder()) {
+			return nil, domainerrors.NewInvalidArgument("invalid page token", nil, domainerrors.FieldViolation{
+				Field:       "page_token",
+				Description: "must be used with the same sort of the listing returning it",
+			})
+		}
+
+		// The page token replaces the offset, they can't be combined.
+		userFilter.Cursor = cursor
+		userFilter.Offset = nil
+	}
+
+	log.Printf("Getting users with query: %s", &userFilter)
+
+	limit := int64(DEFAULT_LIMIT)
+	if userFilter.Limit != nil {
+		limit = *userFilter.Limit
+	}
+
+	// One more user than requested is fetched to know if another page follows,
+	// a zero limit means there's no limit.
+	fetchLimit := limit
+	if limit > 0 {
+		fetchLimit = limit + 1
+	}
+
+	users, err := u.repository.GetUsers(ctx, &userFilter, &fetchLimit, userFilter.Offset)
 	if err != nil {
 		return nil, err
 	}
 
-	respUsers := make([]*User, len(users))
+	page := &UsersPage{Limit: limit}
+	switch {
+	case userFilter.Cursor != nil:
+		page.Offset = userFilter.Cursor.Position
+	case userFilter.Offset != nil:
+		page.Offset = *userFilter.Offset
+	}
+	if limit > 0 && int64(len(users)) > limit {
+		users = users[:limit]
+		page.HasMore = true
+	}
+
+	page.Users = make([]*User, len(users))
 	for i, u := range users {
-		respUsers[i] = toUser(u)
+		page.Users[i] = toUser(u)
 	}
-	return respUsers, nil
+
+	if page.HasMore && !userFilter.RanksByRelevance() {
+		cursor := cursorOf(users[len(users)-1], userFilter.SortOrder())
+		cursor.Position = page.Offset + int64(len(users))
+		page.NextPageToken = u.pageTokenCodec.Encode(cursor)
+	}
+
+	// Counting all the matching users costs another query, so it's done only when requested.
+	if userFilter.IncludeTotal {
+		countFilter := userFilter
+		countFilter.Cursor = nil
+
+		total, err := u.repository.CountUsers(ctx, &countFilter)
+		if err != nil {
+			return nil, err
+		}
+		page.Total = &total
+	}
+
+	return page, nil
 }
 
-func (u *UserServiceImpl) GetChangeChannel(clientId string) <-chan notifier.ChangeData {
-	return u.notifier.AddSubscriber(clientId)
+// GetChangesSince returns at most limit logged changes following the sequence number, in order.
+func (u *UserServiceImpl) GetChangesSince(ctx context.Context, sequence int64, limit int64) ([]notifier.ChangeData, error) {
+	if sequence < 0 {
+		return nil, domainerrors.NewInvalidArgument("invalid sequence", nil, domainerrors.FieldViolation{
+			Field:       "from_sequence",
+			Description: "must not be negative",
+		})
+	}
+
+	if u.changeLog == nil {
+		return nil, domainerrors.NewOutOfRange("the changes aren't logged", nil)
+	}
+
+	entries, err := u.changeLog.ChangesSince(ctx, sequence, limit)
+	if err != nil {
+		return nil, toDomainError(err)
+	}
+
+	changes := make([]notifier.ChangeData, len(entries))
+	for i, entry := range entries {
+		changes[i] = entry.ChangeData()
+	}
+	return changes, nil
+}
+
+// GetChangeChannel returns the channel of the changes selected by the filter, nil selects all of them.
+func (u *UserServiceImpl) GetChangeChannel(clientId string, changeFilter *notifier.ChangeFilter) <-chan notifier.ChangeData {
+	return u.notifier.AddSubscriber(clientId, changeFilter)
+}
+
+// NewChangeFilter returns the filter selecting the changes of the given types of the users matching
+// the filter, its pagination and sort are ignored. Without conditions it returns nil, selecting every change.
+func NewChangeFilter(userFilter *filter.UserFilter, operationTypes []string) (*notifier.ChangeFilter, error) {
+	for _, operationType := range operationTypes {
+		switch operationType {
+		case notifier.ChangeOperationInsert, notifier.ChangeOperationUpdate, notifier.ChangeOperationDelete:
+		default:
+			return nil, domainerrors.NewInvalidArgument("invalid operation type", nil, domainerrors.FieldViolation{
+				Field: "operation_types",
+				Description: fmt.Sprintf("must be %s, %s or %s",
+					notifier.ChangeOperationInsert, notifier.ChangeOperationUpdate, notifier.ChangeOperationDelete),
+			})
+		}
+	}
+
+	if userFilter == nil && len(operationTypes) == 0 {
+		return nil, nil
+	}
+
+	changeFilter := &notifier.ChangeFilter{OperationTypes: operationTypes}
+	if userFilter != nil {
+		changeFilter.MatchesUser = userFilter.Matches
+	}
+	return changeFilter, nil
 }
 
 func (u *UserServiceImpl) RemoveChannel(clientId string) error {
@@ -121,6 +355,12 @@ func (u *UserServiceImpl) RemoveChannel(clientId string) error {
 	return nil
 }
 
+// ChangeChannelErr returns why the channel of the changes has been closed, nil if the client hasn't
+// been disconnected, as when the service is stopping.
+func (u *UserServiceImpl) ChangeChannelErr(clientId string) error {
+	return u.notifier.Err(clientId)
+}
+
 func toUser(user *repositories.User) *User {
 	return &User{
 		Id:        user.Id.Hex(),
@@ -131,5 +371,137 @@ func toUser(user *repositories.User) *User {
 		Country:   user.Country,
 		CreatedAt: user.CreatedAt.Format(time.RFC3339),
 		UpdatedAt: user.UpdatedAt.Format(time.RFC3339),
+		Version:   user.Version,
+	}
+}
+
+func parseUserId(id string) (primitive.ObjectID, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, domainerrors.NewInvalidArgument("invalid user id", err, domainerrors.FieldViolation{
+			Field:       "id",
+			Description: "must be a 24 characters hexadecimal string",
+		})
+	}
+	return objectId, nil
+}
+
+func validateExpectedVersion(expectedVersion int64) error {
+	if expectedVersion < 0 {
+		return domainerrors.NewInvalidArgument("invalid expected version", nil, domainerrors.FieldViolation{
+			Field:       "expected_version",
+			Description: "must not be negative",
+		})
+	}
+	return nil
+}
+
+// validateClearedFields checks that the cleared fields can be cleared and aren't set too.
+func validateClearedFields(updateUser *UpdateUser) error {
+	values := map[string]string{
+		"first_name": updateUser.FirstName,
+		"last_name":  updateUser.LastName,
+		"nickname":   updateUser.Nickname,
+		"email":      updateUser.Email,
+		"password":   updateUser.Password,
+		"country":    updateUser.Country,
+	}
+
+	var violations []domainerrors.FieldViolation
+	for _, field := range updateUser.ClearedFields {
+		switch {
+		case !slices.Contains(repositories.CLEARABLE_FIELDS, field):
+			violations = append(violations, domainerrors.FieldViolation{
+				Field:       field,
+				Description: "can't be cleared, only " + strings.Join(repositories.CLEARABLE_FIELDS, ", ") + " can",
+			})
+		case values[field] != "":
+			violations = append(violations, domainerrors.FieldViolation{
+				Field:       field,
+				Description: "can't be both set and cleared",
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return domainerrors.NewInvalidArgument("invalid cleared fields", nil, violations...)
+	}
+	return nil
+}
+
+func toDomainError(err error) error {
+	switch {
+	case errors.Is(err, repositories.ErrUserNotFound):
+		return domainerrors.NewNotFound("user not found", err)
+	case errors.Is(err, repositories.ErrUserAlreadyExist):
+		return domainerrors.NewConflict("user already exists", err)
+	case errors.Is(err, repositories.ErrNothingToUpdate):
+		return domainerrors.NewInvalidArgument("no field to update", err)
+	case errors.Is(err, repositories.ErrNotClearable):
+		return domainerrors.NewInvalidArgument("the field can't be cleared", err)
+	case errors.Is(err, repositories.ErrVersionMismatch):
+		return domainerrors.NewFailedPrecondition("the user has been modified, its version isn't the expected one", err)
+	case errors.Is(err, repositories.ErrSequenceCompacted):
+		return domainerrors.NewOutOfRange("the changes following the sequence have been purged", err)
+	default:
+		return err
+	}
+}
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+
+	// Violations are reported with the field names known by the clients.
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			return field.Name
+		}
+		return name
+	})
+
+	return v
+}
+
+func validateStruct(s interface{}) error {
+	err := validate.Struct(s)
+
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return err
+	}
+
+	violations := make([]domainerrors.FieldViolation, len(validationErrors))
+	for i, fieldErr := range validationErrors {
+		violations[i] = domainerrors.FieldViolation{
+			Field:       fieldErr.Field(),
+			Description: describeValidationError(fieldErr),
+		}
+	}
+
+	return domainerrors.NewValidation("invalid user", violations)
+}
+
+func describeValidationError(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		return fmt.Sprintf("must be at least %s characters long", fieldErr.Param())
+	default:
+		return fmt.Sprintf("failed the %s validation", fieldErr.Tag())
+	}
+}
+
+// cursorOf returns the position of the user in a listing with the given sort.
+func cursorOf(user *repositories.User, sort []filter.SortField) *filter.Cursor {
+	values := make([]interface{}, len(sort))
+	for i, field := range sort {
+		values[i] = user.SortValue(field.Field)
 	}
+	return &filter.Cursor{Sort: sort, Values: values, Id: user.Id}
 }
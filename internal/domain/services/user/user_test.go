@@ -2,11 +2,16 @@ package user
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
 	"time"
 
 	filter "github.com/dlion/faceit_challenge/internal"
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/dlion/faceit_challenge/internal/outbox"
+	"github.com/dlion/faceit_challenge/internal/pagetoken"
 	"github.com/dlion/faceit_challenge/internal/repositories"
+	memoryRepositories "github.com/dlion/faceit_challenge/internal/repositories/memory"
 	"github.com/dlion/faceit_challenge/pkg/notifier"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -51,6 +56,121 @@ func TestUserService(t *testing.T) {
 		assert.Equal(t, "emailTest@test.com", addedUser.Email)
 	})
 
+	t.Run("Broadcast the changes recorded in the outbox through the relay", func(t *testing.T) {
+		userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+		changeNotifier := notifier.NewNotifier()
+		defer changeNotifier.Close()
+		ch := changeNotifier.AddSubscriber("subscriber", nil)
+		relay := outbox.NewRelay(userRepo, changeNotifier, outbox.WithInterval(time.Hour))
+
+		userService := NewUserService(userRepo, changeNotifier, WithOutboxRelay(relay))
+		addedUser, err := userService.NewUser(context.TODO(), &NewUser{
+			Email:    "emailTest@test.com",
+			Nickname: "Test",
+			Password: "testPassword",
+		})
+		assert.NoError(t, err)
+		assert.Empty(t, ch, "the change must be broadcast only by the relay")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go relay.Run(ctx)
+
+		select {
+		case msg := <-ch:
+			assert.NotEmpty(t, msg.EventId)
+			assert.Equal(t, int64(1), msg.Sequence)
+			assert.Equal(t, notifier.ChangeOperationInsert, msg.OperationType)
+			assert.Equal(t, addedUser.Id, msg.UserId)
+			assert.Equal(t, []string{"nickname", "password", "email"}, msg.ChangedFields)
+			assert.Nil(t, msg.Before)
+			assert.Equal(t, "Test", msg.After.Nickname)
+		case <-time.After(time.Second):
+			t.Fatal("Expected to receive a message")
+		}
+	})
+
+	t.Run("Broadcast the user before and after the change without its password", func(t *testing.T) {
+		userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+		changeNotifier := notifier.NewNotifier()
+		defer changeNotifier.Close()
+		userService := NewUserService(userRepo, changeNotifier)
+		addedUser, err := userService.NewUser(context.TODO(), &NewUser{Email: "emailTest@test.com", Nickname: "Test", Password: "testPassword", Country: "UK"})
+		assert.NoError(t, err)
+		ch := changeNotifier.AddSubscriber("subscriber", nil)
+
+		_, err = userService.UpdateUser(context.TODO(), &UpdateUser{Id: addedUser.Id, Nickname: "Updated", Password: "updatedPassword"})
+		assert.NoError(t, err)
+
+		select {
+		case msg := <-ch:
+			assert.NotEmpty(t, msg.EventId)
+			assert.False(t, msg.Timestamp.IsZero())
+			assert.Equal(t, notifier.ChangeOperationUpdate, msg.OperationType)
+			assert.Equal(t, []string{"nickname", "password"}, msg.ChangedFields)
+			assert.Equal(t, "Test", msg.Before.Nickname)
+			assert.Equal(t, "Updated", msg.After.Nickname)
+			assert.Equal(t, "UK", msg.After.Country)
+			storedUser, err := userRepo.GetUser(context.TODO(), addedUser.Id)
+			assert.NoError(t, err)
+			encodedMsg, err := json.Marshal(msg)
+			assert.NoError(t, err)
+			assert.NotContains(t, string(encodedMsg), storedUser.Password)
+		case <-time.After(time.Second):
+			t.Fatal("Expected to receive a message")
+		}
+
+		assert.NoError(t, userService.RemoveUser(context.TODO(), addedUser.Id, 0))
+
+		select {
+		case msg := <-ch:
+			assert.Equal(t, notifier.ChangeOperationDelete, msg.OperationType)
+			assert.Equal(t, "Updated", msg.Before.Nickname)
+			assert.Nil(t, msg.After)
+		case <-time.After(time.Second):
+			t.Fatal("Expected to receive a message")
+		}
+	})
+
+	t.Run("Return the logged changes following a sequence number", func(t *testing.T) {
+		userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+		userService := NewUserService(userRepo, notifier.NewNotifier(), WithChangeLog(userRepo))
+		var addedUsers []*User
+		for _, nickname := range []string{"Test1", "Test2", "Test3"} {
+			addedUser, err := userService.NewUser(context.TODO(), &NewUser{Email: nickname + "@test.com", Nickname: nickname, Password: "testPassword"})
+			assert.NoError(t, err)
+			addedUsers = append(addedUsers, addedUser)
+		}
+
+		changes, err := userService.GetChangesSince(context.TODO(), 1, 10)
+
+		assert.NoError(t, err)
+		assert.Len(t, changes, 2)
+		for i, change := range changes {
+			assert.Equal(t, int64(i+2), change.Sequence)
+			assert.Equal(t, notifier.ChangeOperationInsert, change.OperationType)
+			assert.Equal(t, addedUsers[i+1].Id, change.UserId)
+			assert.Equal(t, addedUsers[i+1].Nickname, change.After.Nickname)
+		}
+	})
+
+	t.Run("Return an out of range error if the changes have been purged", func(t *testing.T) {
+		userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+		userService := NewUserService(userRepo, notifier.NewNotifier(), WithChangeLog(userRepo))
+		_, err := userService.NewUser(context.TODO(), &NewUser{Email: "emailTest@test.com", Nickname: "Test", Password: "testPassword"})
+		assert.NoError(t, err)
+		entries, err := userRepo.PendingEntries(context.TODO(), 10)
+		assert.NoError(t, err)
+		assert.NoError(t, userRepo.MarkDelivered(context.TODO(), entries[0].Id))
+		assert.NoError(t, userRepo.PurgeDelivered(context.TODO(), time.Now().Add(time.Hour)))
+
+		_, err = userService.GetChangesSince(context.TODO(), 0, 10)
+		assert.Equal(t, domainerrors.KindOutOfRange, domainerrors.KindOf(err))
+
+		_, err = NewUserService(userRepo, notifier.NewNotifier()).GetChangesSince(context.TODO(), 0, 10)
+		assert.Equal(t, domainerrors.KindOutOfRange, domainerrors.KindOf(err))
+	})
+
 	t.Run("Modify and existing user and return it", func(t *testing.T) {
 		mockedRepository := new(mockUserRepository)
 		mockedNotifier := new(mockUserNotifier)
@@ -67,6 +187,12 @@ func TestUserService(t *testing.T) {
 			Password:  "1234567",
 			CreatedAt: now,
 			UpdatedAt: later,
+		}, &repositories.User{
+			Id:        objectId,
+			FirstName: "OldFirstName",
+			Nickname:  "Test",
+			CreatedAt: now,
+			UpdatedAt: now,
 		}, nil)
 		mockedNotifier.On("Broadcast")
 
@@ -89,21 +215,52 @@ func TestUserService(t *testing.T) {
 		assert.Equal(t, "UK", updatedUser.Country)
 		assert.Equal(t, "emailTest@test.com", updatedUser.Email)
 		assert.Equal(t, later.Format(time.RFC3339), updatedUser.UpdatedAt)
+
+		// The user before the update is the one returned by the repository, it isn't read apart.
+		mockedRepository.AssertNotCalled(t, "GetUser")
 	})
 
 	t.Run("Remove an existing user", func(t *testing.T) {
 		mockedRepository := new(mockUserRepository)
 		mockedNotifier := new(mockUserNotifier)
-		mockedRepository.On("RemoveUser").Return(nil)
+		mockedRepository.On("RemoveUser").Return(&repositories.User{Id: primitive.NewObjectID()}, nil)
 		mockedNotifier.On("Broadcast")
 
 		userService := NewUserService(mockedRepository, mockedNotifier)
-		err := userService.RemoveUser(context.TODO(), "randomId")
+		err := userService.RemoveUser(context.TODO(), primitive.NewObjectID().Hex(), 0)
 
 		mockedRepository.AssertExpectations(t)
 		assert.NoError(t, err)
 	})
 
+	t.Run("Get an existing user", func(t *testing.T) {
+		mockedRepository := new(mockUserRepository)
+		mockedNotifier := new(mockUserNotifier)
+		now := time.Now()
+		objectId := primitive.NewObjectIDFromTimestamp(now)
+		mockedRepository.On("GetUser").Return(&repositories.User{
+			Id:        objectId,
+			FirstName: "TestFirstName",
+			LastName:  "TestLastName",
+			Country:   "UK",
+			Email:     "emailTest@test.com",
+			Nickname:  "Test",
+			Password:  "1234567",
+			CreatedAt: now,
+			UpdatedAt: now,
+		}, nil)
+
+		userService := NewUserService(mockedRepository, mockedNotifier)
+		user, err := userService.GetUser(context.TODO(), objectId.Hex())
+
+		mockedRepository.AssertExpectations(t)
+		assert.NoError(t, err)
+		assert.Equal(t, objectId.Hex(), user.Id)
+		assert.Equal(t, "TestFirstName", user.FirstName)
+		assert.Equal(t, "emailTest@test.com", user.Email)
+		assert.Equal(t, now.Format(time.RFC3339), user.CreatedAt)
+	})
+
 	t.Run("Get paginated list of users filtered by Conuntry", func(t *testing.T) {
 		mockedRepository := new(mockUserRepository)
 		mockedNotifier := new(mockUserNotifier)
@@ -135,17 +292,301 @@ func TestUserService(t *testing.T) {
 			UpdatedAt: later,
 		})
 		mockedRepository.On("GetUsers").Return(dbUsers, nil)
+		mockedRepository.On("CountUsers").Return(int64(2), nil)
 
 		userService := NewUserService(mockedRepository, mockedNotifier)
 		country := "UK"
-		users, err := userService.GetUsers(context.TODO(), &filter.UserFilter{Country: &country})
+		page, err := userService.GetUsers(context.TODO(), &filter.UserFilter{Country: &country, IncludeTotal: true})
 
 		mockedRepository.AssertExpectations(t)
 		assert.NoError(t, err)
-		assert.Len(t, users, 2)
+		assert.Len(t, page.Users, 2)
 		assert.Equal(t, "TestFirstName", dbUsers[0].FirstName)
+		assert.Empty(t, page.NextPageToken)
+		assert.False(t, page.HasMore)
+		assert.Equal(t, int64Ptr(2), page.Total)
+		assert.Equal(t, int64(DEFAULT_LIMIT), page.Limit)
+		assert.Zero(t, page.Offset)
+	})
+
+	t.Run("Return the pagination metadata of a page in the middle", func(t *testing.T) {
+		mockedRepository := new(mockUserRepository)
+		mockedNotifier := new(mockUserNotifier)
+		now := time.Now()
+		dbUsers := []*repositories.User{
+			{Id: primitive.NewObjectIDFromTimestamp(now), CreatedAt: now, UpdatedAt: now},
+			{Id: primitive.NewObjectIDFromTimestamp(now), CreatedAt: now, UpdatedAt: now},
+			{Id: primitive.NewObjectIDFromTimestamp(now), CreatedAt: now, UpdatedAt: now},
+		}
+		mockedRepository.On("GetUsers").Return(dbUsers, nil)
+		mockedRepository.On("CountUsers").Return(int64(40), nil)
+
+		userService := NewUserService(mockedRepository, mockedNotifier)
+		limit, offset := int64(2), int64(4)
+		page, err := userService.GetUsers(context.TODO(), &filter.UserFilter{Limit: &limit, Offset: &offset, IncludeTotal: true})
+
+		assert.NoError(t, err)
+		assert.Len(t, page.Users, 2)
+		assert.True(t, page.HasMore)
+		assert.Equal(t, int64Ptr(40), page.Total)
+		assert.Equal(t, int64(2), page.Limit)
+		assert.Equal(t, int64(4), page.Offset)
+	})
+
+	t.Run("Return a page token selecting the users following a full page", func(t *testing.T) {
+		mockedRepository := new(mockUserRepository)
+		mockedNotifier := new(mockUserNotifier)
+		now := time.Now()
+		lastUser := &repositories.User{Id: primitive.NewObjectIDFromTimestamp(now), CreatedAt: now, UpdatedAt: now}
+		followingUser := &repositories.User{Id: primitive.NewObjectIDFromTimestamp(now), CreatedAt: now, UpdatedAt: now}
+		mockedRepository.On("GetUsers").Return([]*repositories.User{lastUser, followingUser}, nil)
+		mockedRepository.On("CountUsers").Return(int64(2), nil)
+
+		codec := pagetoken.NewCodec([]byte("secret"))
+		userService := NewUserService(mockedRepository, mockedNotifier, WithPageTokenCodec(codec))
+		limit := int64(1)
+		page, err := userService.GetUsers(context.TODO(), &filter.UserFilter{Limit: &limit})
+
+		assert.NoError(t, err)
+		cursor, err := codec.Decode(page.NextPageToken)
+		assert.NoError(t, err)
+		assert.Equal(t, lastUser.Id, cursor.Id)
+		assert.Equal(t, filter.DEFAULT_SORT, cursor.Sort)
+		assert.True(t, lastUser.CreatedAt.Equal(cursor.Values[0].(time.Time)))
+
+		assert.Equal(t, int64(1), cursor.Position)
+
+		offset := int64(5)
+		nextFilter := &filter.UserFilter{Limit: &limit, Offset: &offset, PageToken: &page.NextPageToken}
+		nextPage, err := userService.GetUsers(context.TODO(), nextFilter)
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), nextPage.Offset, "the offset of a page selected by token is its position")
+		assert.Nil(t, nextFilter.Cursor, "the filter of the caller must not be changed")
+		assert.Equal(t, int64(5), *nextFilter.Offset)
+	})
+
+	t.Run("Count the users only if the total is included", func(t *testing.T) {
+		mockedRepository := new(mockUserRepository)
+		mockedNotifier := new(mockUserNotifier)
+		now := time.Now()
+		mockedRepository.On("GetUsers").Return([]*repositories.User{{Id: primitive.NewObjectIDFromTimestamp(now), CreatedAt: now, UpdatedAt: now}}, nil)
+
+		userService := NewUserService(mockedRepository, mockedNotifier)
+		page, err := userService.GetUsers(context.TODO(), &filter.UserFilter{})
+
+		assert.NoError(t, err)
+		assert.Nil(t, page.Total)
+		mockedRepository.AssertNotCalled(t, "CountUsers")
+	})
+
+	t.Run("Return a page token bound to the sort of the listing", func(t *testing.T) {
+		mockedRepository := new(mockUserRepository)
+		mockedNotifier := new(mockUserNotifier)
+		now := time.Now()
+		lastUser := &repositories.User{Id: primitive.NewObjectIDFromTimestamp(now), Nickname: "johnd", CreatedAt: now, UpdatedAt: now}
+		followingUser := &repositories.User{Id: primitive.NewObjectIDFromTimestamp(now), Nickname: "johne", CreatedAt: now, UpdatedAt: now}
+		mockedRepository.On("GetUsers").Return([]*repositories.User{lastUser, followingUser}, nil)
+		mockedRepository.On("CountUsers").Return(int64(2), nil)
+
+		codec := pagetoken.NewCodec([]byte("secret"))
+		userService := NewUserService(mockedRepository, mockedNotifier, WithPageTokenCodec(codec))
+		limit := int64(1)
+		sort := []filter.SortField{{Field: "nickname"}}
+		page, err := userService.GetUsers(context.TODO(), &filter.UserFilter{Limit: &limit, Sort: sort})
+
+		assert.NoError(t, err)
+		cursor, err := codec.Decode(page.NextPageToken)
+		assert.NoError(t, err)
+		assert.Equal(t, sort, cursor.Sort)
+		assert.Equal(t, []interface{}{"johnd"}, cursor.Values)
+
+		_, err = userService.GetUsers(context.TODO(), &filter.UserFilter{Limit: &limit, PageToken: &page.NextPageToken})
+
+		assert.Equal(t, domainerrors.KindInvalidArgument, domainerrors.KindOf(err))
+		assert.Equal(t, "page_token", domainerrors.ViolationsOf(err)[0].Field)
+	})
+
+	t.Run("Return an invalid argument error if the sort is not valid", func(t *testing.T) {
+		mockedRepository := new(mockUserRepository)
+		mockedNotifier := new(mockUserNotifier)
+
+		userService := NewUserService(mockedRepository, mockedNotifier)
+		_, err := userService.GetUsers(context.TODO(), &filter.UserFilter{Sort: []filter.SortField{{Field: "password"}}})
+
+		mockedRepository.AssertNotCalled(t, "GetUsers")
+		assert.Equal(t, domainerrors.KindInvalidArgument, domainerrors.KindOf(err))
+		assert.Equal(t, "sort", domainerrors.ViolationsOf(err)[0].Field)
+	})
+
+	t.Run("Return an invalid argument error if the page token is not valid", func(t *testing.T) {
+		mockedRepository := new(mockUserRepository)
+		mockedNotifier := new(mockUserNotifier)
+
+		userService := NewUserService(mockedRepository, mockedNotifier)
+		pageToken := "forgedToken"
+		_, err := userService.GetUsers(context.TODO(), &filter.UserFilter{PageToken: &pageToken})
+
+		mockedRepository.AssertNotCalled(t, "GetUsers")
+		assert.Equal(t, domainerrors.KindInvalidArgument, domainerrors.KindOf(err))
+	})
+
+	t.Run("Return the pages of a search ranked by relevance without a page token", func(t *testing.T) {
+		mockedRepository := new(mockUserRepository)
+		mockedNotifier := new(mockUserNotifier)
+		now := time.Now()
+		dbUsers := []*repositories.User{
+			{Id: primitive.NewObjectIDFromTimestamp(now), CreatedAt: now, UpdatedAt: now},
+			{Id: primitive.NewObjectIDFromTimestamp(now), CreatedAt: now, UpdatedAt: now},
+		}
+		mockedRepository.On("GetUsers").Return(dbUsers, nil)
+		mockedRepository.On("CountUsers").Return(int64(5), nil)
+
+		userService := NewUserService(mockedRepository, mockedNotifier)
+		limit, offset, search := int64(1), int64(2), "john"
+		page, err := userService.GetUsers(context.TODO(), &filter.UserFilter{Limit: &limit, Offset: &offset, Search: &search})
+
+		assert.NoError(t, err)
+		assert.True(t, page.HasMore)
+		assert.Empty(t, page.NextPageToken)
+		assert.Equal(t, int64(2), page.Offset)
+
+		pageToken := "token"
+		_, err = userService.GetUsers(context.TODO(), &filter.UserFilter{Search: &search, PageToken: &pageToken})
+		assert.Equal(t, domainerrors.KindInvalidArgument, domainerrors.KindOf(err))
+		assert.Equal(t, "page_token", domainerrors.ViolationsOf(err)[0].Field)
 	})
 
+	t.Run("Return an invalid argument error if the search has no words", func(t *testing.T) {
+		mockedRepository := new(mockUserRepository)
+		mockedNotifier := new(mockUserNotifier)
+
+		userService := NewUserService(mockedRepository, mockedNotifier)
+		search := " @. "
+		_, err := userService.GetUsers(context.TODO(), &filter.UserFilter{Search: &search})
+
+		mockedRepository.AssertNotCalled(t, "GetUsers")
+		assert.Equal(t, domainerrors.KindInvalidArgument, domainerrors.KindOf(err))
+		assert.Equal(t, "q", domainerrors.ViolationsOf(err)[0].Field)
+	})
+
+	t.Run("Return a validation error with the invalid fields of a new user", func(t *testing.T) {
+		mockedRepository := new(mockUserRepository)
+		mockedNotifier := new(mockUserNotifier)
+
+		userService := NewUserService(mockedRepository, mockedNotifier)
+		_, err := userService.NewUser(context.TODO(), &NewUser{
+			Email:    "notAnEmail",
+			Password: "short",
+		})
+
+		mockedRepository.AssertNotCalled(t, "AddUser")
+		assert.Equal(t, domainerrors.KindValidation, domainerrors.KindOf(err))
+		assert.ElementsMatch(t, []domainerrors.FieldViolation{
+			{Field: "email", Description: "must be a valid email address"},
+			{Field: "password", Description: "must be at least 8 characters long"},
+		}, domainerrors.ViolationsOf(err))
+	})
+
+	t.Run("Return a conflict error if the user already exists", func(t *testing.T) {
+		mockedRepository := new(mockUserRepository)
+		mockedNotifier := new(mockUserNotifier)
+		mockedRepository.On("AddUser").Return((*repositories.User)(nil), repositories.ErrUserAlreadyExist)
+
+		userService := NewUserService(mockedRepository, mockedNotifier)
+		_, err := userService.NewUser(context.TODO(), &NewUser{
+			Email:    "emailTest@test.com",
+			Password: "testPassword",
+		})
+
+		mockedNotifier.AssertNotCalled(t, "Broadcast")
+		assert.Equal(t, domainerrors.KindConflict, domainerrors.KindOf(err))
+		assert.ErrorIs(t, err, repositories.ErrUserAlreadyExist)
+	})
+
+	t.Run("Return an invalid argument error if the id is not valid", func(t *testing.T) {
+		mockedRepository := new(mockUserRepository)
+		mockedNotifier := new(mockUserNotifier)
+
+		userService := NewUserService(mockedRepository, mockedNotifier)
+		_, err := userService.UpdateUser(context.TODO(), &UpdateUser{Id: "randomId", FirstName: "TestFirstName"})
+
+		mockedRepository.AssertNotCalled(t, "UpdateUser")
+		assert.Equal(t, domainerrors.KindInvalidArgument, domainerrors.KindOf(err))
+		assert.Equal(t, "id", domainerrors.ViolationsOf(err)[0].Field)
+	})
+
+	t.Run("Return a not found error if the user doesn't exist", func(t *testing.T) {
+		mockedRepository := new(mockUserRepository)
+		mockedNotifier := new(mockUserNotifier)
+		mockedRepository.On("RemoveUser").Return((*repositories.User)(nil), repositories.ErrUserNotFound)
+
+		userService := NewUserService(mockedRepository, mockedNotifier)
+		err := userService.RemoveUser(context.TODO(), primitive.NewObjectID().Hex(), 0)
+
+		mockedNotifier.AssertNotCalled(t, "Broadcast")
+		assert.Equal(t, domainerrors.KindNotFound, domainerrors.KindOf(err))
+	})
+
+	t.Run("Return a failed precondition error if the user hasn't the expected version", func(t *testing.T) {
+		mockedRepository := new(mockUserRepository)
+		mockedNotifier := new(mockUserNotifier)
+		mockedRepository.On("RemoveUser").Return((*repositories.User)(nil), repositories.ErrVersionMismatch)
+
+		userService := NewUserService(mockedRepository, mockedNotifier)
+		err := userService.RemoveUser(context.TODO(), primitive.NewObjectID().Hex(), 2)
+
+		mockedNotifier.AssertNotCalled(t, "Broadcast")
+		assert.Equal(t, domainerrors.KindFailedPrecondition, domainerrors.KindOf(err))
+		assert.ErrorIs(t, err, repositories.ErrVersionMismatch)
+	})
+
+	t.Run("Return an invalid argument error if the expected version is negative", func(t *testing.T) {
+		mockedRepository := new(mockUserRepository)
+		mockedNotifier := new(mockUserNotifier)
+
+		userService := NewUserService(mockedRepository, mockedNotifier)
+		_, err := userService.UpdateUser(context.TODO(), &UpdateUser{Id: primitive.NewObjectID().Hex(), FirstName: "TestFirstName", ExpectedVersion: -1})
+
+		mockedRepository.AssertNotCalled(t, "UpdateUser")
+		assert.Equal(t, domainerrors.KindInvalidArgument, domainerrors.KindOf(err))
+		assert.Equal(t, "expected_version", domainerrors.ViolationsOf(err)[0].Field)
+	})
+
+	t.Run("Return an invalid argument error if a required field is cleared", func(t *testing.T) {
+		mockedRepository := new(mockUserRepository)
+		mockedNotifier := new(mockUserNotifier)
+
+		userService := NewUserService(mockedRepository, mockedNotifier)
+		_, err := userService.UpdateUser(context.TODO(), &UpdateUser{
+			Id:            primitive.NewObjectID().Hex(),
+			Country:       "UK",
+			ClearedFields: []string{"email", "country"},
+		})
+
+		mockedRepository.AssertNotCalled(t, "UpdateUser")
+		assert.Equal(t, domainerrors.KindInvalidArgument, domainerrors.KindOf(err))
+		violations := domainerrors.ViolationsOf(err)
+		if assert.Len(t, violations, 2) {
+			assert.Equal(t, "email", violations[0].Field)
+			assert.Equal(t, "country", violations[1].Field)
+			assert.Equal(t, "can't be both set and cleared", violations[1].Description)
+		}
+	})
+
+	t.Run("Clear the fields of an existing user", func(t *testing.T) {
+		userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+		userService := NewUserService(userRepo, notifier.NewNotifier())
+		addedUser, err := userService.NewUser(context.TODO(), &NewUser{LastName: "Doe", Email: "emailTest@test.com", Nickname: "Test", Password: "testPassword", Country: "UK"})
+		assert.NoError(t, err)
+
+		updatedUser, err := userService.UpdateUser(context.TODO(), &UpdateUser{Id: addedUser.Id, ClearedFields: []string{"last_name", "country"}})
+
+		assert.NoError(t, err)
+		assert.Empty(t, updatedUser.LastName)
+		assert.Empty(t, updatedUser.Country)
+		assert.Equal(t, "Test", updatedUser.Nickname)
+	})
 }
 
 type mockUserRepository struct {
@@ -154,29 +595,39 @@ type mockUserRepository struct {
 
 func (m *mockUserRepository) AddUser(ctx context.Context, user *repositories.User) (*repositories.User, error) {
 	args := m.Called()
-	return args.Get(0).(*repositories.User), nil
+	return args.Get(0).(*repositories.User), args.Error(1)
 }
 
-func (m *mockUserRepository) UpdateUser(ctx context.Context, user *repositories.User) (*repositories.User, error) {
+func (m *mockUserRepository) UpdateUser(ctx context.Context, user *repositories.User) (*repositories.User, *repositories.User, error) {
 	args := m.Called()
-	return args.Get(0).(*repositories.User), nil
+	return args.Get(0).(*repositories.User), args.Get(1).(*repositories.User), args.Error(2)
 }
 
-func (m *mockUserRepository) RemoveUser(ctx context.Context, id string) error {
-	m.Called()
-	return nil
+func (m *mockUserRepository) RemoveUser(ctx context.Context, id string, expectedVersion int64) (*repositories.User, error) {
+	args := m.Called()
+	return args.Get(0).(*repositories.User), args.Error(1)
+}
+
+func (m *mockUserRepository) GetUser(ctx context.Context, id string) (*repositories.User, error) {
+	args := m.Called()
+	return args.Get(0).(*repositories.User), args.Error(1)
 }
 
 func (m *mockUserRepository) GetUsers(ctx context.Context, filter *filter.UserFilter, limit *int64, offset *int64) ([]*repositories.User, error) {
 	args := m.Called()
-	return args.Get(0).([]*repositories.User), nil
+	return args.Get(0).([]*repositories.User), args.Error(1)
+}
+
+func (m *mockUserRepository) CountUsers(ctx context.Context, filter *filter.UserFilter) (int64, error) {
+	args := m.Called()
+	return args.Get(0).(int64), args.Error(1)
 }
 
 type mockUserNotifier struct {
 	mock.Mock
 }
 
-func (m *mockUserNotifier) AddSubscriber(id string) <-chan notifier.ChangeData {
+func (m *mockUserNotifier) AddSubscriber(id string, changeFilter *notifier.ChangeFilter, options ...notifier.SubscriberOption) <-chan notifier.ChangeData {
 	m.Called()
 	return nil
 }
@@ -185,6 +636,16 @@ func (m *mockUserNotifier) RemoveSubscriber(id string) {
 	m.Called()
 }
 
+func (m *mockUserNotifier) Err(id string) error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *mockUserNotifier) Dropped(id string) uint64 {
+	args := m.Called()
+	return args.Get(0).(uint64)
+}
+
 func (m *mockUserNotifier) Broadcast(msg notifier.ChangeData) {
 	m.Called()
 }
@@ -192,3 +653,7 @@ func (m *mockUserNotifier) Broadcast(msg notifier.ChangeData) {
 func (m *mockUserNotifier) Close() {
 	m.Called()
 }
+
+func int64Ptr(value int64) *int64 {
+	return &value
+}
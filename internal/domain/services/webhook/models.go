@@ -0,0 +1,36 @@
+package webhook
+
+import "github.com/dlion/faceit_challenge/pkg/notifier"
+
+type NewWebhook struct {
+	Url string `json:"url"`
+	// EventTypes are the operation types of the changes posted, insert, update or delete, all of them if empty.
+	EventTypes []string `json:"event_types"`
+	// Secret signs the requests, it's never returned.
+	Secret string `json:"secret"`
+}
+
+type Webhook struct {
+	Id         string   `json:"id"`
+	Url        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+type Delivery struct {
+	Id            string              `json:"id"`
+	WebhookId     string              `json:"webhook_id"`
+	EventId       string              `json:"event_id"`
+	Change        notifier.ChangeData `json:"change"`
+	Status        string              `json:"status"`
+	Attempts      []Attempt           `json:"attempts"`
+	NextAttemptAt string              `json:"next_attempt_at,omitempty"`
+	CreatedAt     string              `json:"created_at"`
+}
+
+type Attempt struct {
+	AttemptedAt string `json:"attempted_at"`
+	// StatusCode is the HTTP status of the response, zero if none was received.
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error,omitempty"`
+}
@@ -0,0 +1,299 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	dispatcher "github.com/dlion/faceit_challenge/internal/webhook"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type WebhookService interface {
+	RegisterWebhook(context.Context, *NewWebhook) (*Webhook, error)
+	GetWebhooks(context.Context) ([]*Webhook, error)
+	RemoveWebhook(context.Context, string) error
+	// GetDeliveries returns the most recent deliveries to the webhook with the status, of any status if it's empty.
+	GetDeliveries(ctx context.Context, webhookId string, status string) ([]*Delivery, error)
+	// GetDeadLetters returns the most recent deliveries that failed all their attempts, to any webhook.
+	GetDeadLetters(context.Context) ([]*Delivery, error)
+	// Redeliver makes a delivery pending again, it's posted right away.
+	Redeliver(ctx context.Context, deliveryId string) (*Delivery, error)
+}
+
+const (
+	DELIVERIES_LIMIT  = 100
+	MIN_SECRET_LENGTH = 16
+)
+
+type WebhookServiceImpl struct {
+	store      repositories.WebhookStore
+	dispatcher *dispatcher.Dispatcher
+	resolver   Resolver
+}
+
+// Resolver looks up the addresses of the host of a webhook, net.DefaultResolver implements it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+type Option func(*WebhookServiceImpl)
+
+// WithDispatcher sets the dispatcher woken up when a delivery is redelivered, without it the
+// delivery is posted at its next poll.
+func WithDispatcher(d *dispatcher.Dispatcher) Option {
+	return func(w *WebhookServiceImpl) {
+		w.dispatcher = d
+	}
+}
+
+// WithResolver sets the resolver checking the hosts of the webhooks, net.DefaultResolver by default.
+func WithResolver(resolver Resolver) Option {
+	return func(w *WebhookServiceImpl) {
+		w.resolver = resolver
+	}
+}
+
+func NewWebhookService(store repositories.WebhookStore, options ...Option) *WebhookServiceImpl {
+	webhookService := &WebhookServiceImpl{store: store, resolver: net.DefaultResolver}
+	for _, option := range options {
+		option(webhookService)
+	}
+	return webhookService
+}
+
+func (w *WebhookServiceImpl) RegisterWebhook(ctx context.Context, newWebhook *NewWebhook) (*Webhook, error) {
+	log.Printf("Registering a webhook: %s", newWebhook.Url)
+
+	if err := w.validateWebhook(ctx, newWebhook); err != nil {
+		return nil, err
+	}
+
+	addedWebhook, err := w.store.AddWebhook(ctx, repositories.NewWebhook(newWebhook.Url, newWebhook.EventTypes, newWebhook.Secret))
+	if err != nil {
+		return nil, err
+	}
+
+	return toWebhook(addedWebhook), nil
+}
+
+func (w *WebhookServiceImpl) GetWebhooks(ctx context.Context) ([]*Webhook, error) {
+	storedWebhooks, err := w.store.GetWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]*Webhook, len(storedWebhooks))
+	for i, webhook := range storedWebhooks {
+		webhooks[i] = toWebhook(webhook)
+	}
+	return webhooks, nil
+}
+
+func (w *WebhookServiceImpl) RemoveWebhook(ctx context.Context, id string) error {
+	log.Printf("Removing webhook %s", id)
+
+	if _, err := parseId("id", id); err != nil {
+		return err
+	}
+
+	return toDomainError(w.store.RemoveWebhook(ctx, id))
+}
+
+func (w *WebhookServiceImpl) GetDeliveries(ctx context.Context, webhookId string, status string) ([]*Delivery, error) {
+	objectId, err := parseId("id", webhookId)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case "", repositories.DeliveryPending, repositories.DeliverySucceeded, repositories.DeliveryDead:
+	default:
+		return nil, domainerrors.NewInvalidArgument("invalid delivery status", nil, domainerrors.FieldViolation{
+			Field: "status",
+			Description: fmt.Sprintf("must be %s, %s or %s",
+				repositories.DeliveryPending, repositories.DeliverySucceeded, repositories.DeliveryDead),
+		})
+	}
+
+	if _, err := w.store.GetWebhook(ctx, webhookId); err != nil {
+		return nil, toDomainError(err)
+	}
+
+	return w.getDeliveries(ctx, repositories.DeliveryQuery{WebhookId: objectId, Status: status, Limit: DELIVERIES_LIMIT})
+}
+
+func (w *WebhookServiceImpl) GetDeadLetters(ctx context.Context) ([]*Delivery, error) {
+	return w.getDeliveries(ctx, repositories.DeliveryQuery{Status: repositories.DeliveryDead, Limit: DELIVERIES_LIMIT})
+}
+
+func (w *WebhookServiceImpl) getDeliveries(ctx context.Context, query repositories.DeliveryQuery) ([]*Delivery, error) {
+	storedDeliveries, err := w.store.GetDeliveries(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]*Delivery, len(storedDeliveries))
+	for i, delivery := range storedDeliveries {
+		deliveries[i] = toDelivery(delivery)
+	}
+	return deliveries, nil
+}
+
+func (w *WebhookServiceImpl) Redeliver(ctx context.Context, deliveryId string) (*Delivery, error) {
+	log.Printf("Redelivering %s", deliveryId)
+
+	objectId, err := parseId("id", deliveryId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.store.RetryDelivery(ctx, objectId, time.Now()); err != nil {
+		return nil, toDomainError(err)
+	}
+	if w.dispatcher != nil {
+		w.dispatcher.Wake()
+	}
+
+	delivery, err := w.store.GetDelivery(ctx, deliveryId)
+	if err != nil {
+		return nil, toDomainError(err)
+	}
+	return toDelivery(delivery), nil
+}
+
+func (w *WebhookServiceImpl) validateWebhook(ctx context.Context, newWebhook *NewWebhook) error {
+	var violations []domainerrors.FieldViolation
+
+	endpoint, err := url.Parse(newWebhook.Url)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Hostname() == "" {
+		violations = append(violations, domainerrors.FieldViolation{Field: "url", Description: "must be an absolute http or https URL"})
+	} else if description := w.checkHost(ctx, endpoint.Hostname()); description != "" {
+		violations = append(violations, domainerrors.FieldViolation{Field: "url", Description: description})
+	}
+
+	for _, eventType := range newWebhook.EventTypes {
+		switch eventType {
+		case notifier.ChangeOperationInsert, notifier.ChangeOperationUpdate, notifier.ChangeOperationDelete:
+		default:
+			violations = append(violations, domainerrors.FieldViolation{
+				Field: "event_types",
+				Description: fmt.Sprintf("must be %s, %s or %s",
+					notifier.ChangeOperationInsert, notifier.ChangeOperationUpdate, notifier.ChangeOperationDelete),
+			})
+		}
+	}
+
+	if len(newWebhook.Secret) < MIN_SECRET_LENGTH {
+		violations = append(violations, domainerrors.FieldViolation{
+			Field:       "secret",
+			Description: fmt.Sprintf("must be at least %d characters long", MIN_SECRET_LENGTH),
+		})
+	}
+
+	if len(violations) > 0 {
+		return domainerrors.NewValidation("invalid webhook", violations)
+	}
+	return nil
+}
+
+// checkHost returns why the changes can't be posted to the host, empty if they can: the host and all
+// its addresses must be public, so the service can't be made to post to itself or its internal network.
+func (w *WebhookServiceImpl) checkHost(ctx context.Context, host string) string {
+	const notPublic = "must not be a loopback, link-local or private address"
+
+	if ip := net.ParseIP(host); ip != nil {
+		if !isPublicIP(ip) {
+			return notPublic
+		}
+		return ""
+	}
+	if host = strings.ToLower(host); host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return notPublic
+	}
+
+	addresses, err := w.resolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addresses) == 0 {
+		return "must be a host that resolves"
+	}
+	for _, address := range addresses {
+		if !isPublicIP(address.IP) {
+			return notPublic
+		}
+	}
+	return ""
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsUnspecified() && !ip.IsMulticast()
+}
+
+func parseId(field, id string) (primitive.ObjectID, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, domainerrors.NewInvalidArgument("invalid "+field, err, domainerrors.FieldViolation{
+			Field:       field,
+			Description: "must be a 24 characters hexadecimal string",
+		})
+	}
+	return objectId, nil
+}
+
+func toDomainError(err error) error {
+	switch {
+	case errors.Is(err, repositories.ErrWebhookNotFound):
+		return domainerrors.NewNotFound("webhook not found", err)
+	case errors.Is(err, repositories.ErrDeliveryNotFound):
+		return domainerrors.NewNotFound("delivery not found", err)
+	default:
+		return err
+	}
+}
+
+func toWebhook(webhook *repositories.Webhook) *Webhook {
+	eventTypes := webhook.EventTypes
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+
+	return &Webhook{
+		Id:         webhook.Id.Hex(),
+		Url:        webhook.Url,
+		EventTypes: eventTypes,
+		CreatedAt:  webhook.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func toDelivery(delivery *repositories.WebhookDelivery) *Delivery {
+	attempts := make([]Attempt, len(delivery.Attempts))
+	for i, attempt := range delivery.Attempts {
+		attempts[i] = Attempt{
+			AttemptedAt: attempt.AttemptedAt.Format(time.RFC3339),
+			StatusCode:  attempt.StatusCode,
+			Error:       attempt.Error,
+		}
+	}
+
+	result := &Delivery{
+		Id:        delivery.Id.Hex(),
+		WebhookId: delivery.WebhookId.Hex(),
+		EventId:   delivery.EventId,
+		Change:    delivery.Change,
+		Status:    delivery.Status,
+		Attempts:  attempts,
+		CreatedAt: delivery.CreatedAt.Format(time.RFC3339),
+	}
+	if delivery.Status == repositories.DeliveryPending {
+		result.NextAttemptAt = delivery.NextAttemptAt.Format(time.RFC3339)
+	}
+	return result
+}
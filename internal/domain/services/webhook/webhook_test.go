@@ -0,0 +1,137 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/domain/domainerrors"
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	memoryRepositories "github.com/dlion/faceit_challenge/internal/repositories/memory"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// staticResolver resolves the hosts to the given addresses, failing for the other ones.
+type staticResolver map[string]string
+
+func (s staticResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	address, found := s[host]
+	if !found {
+		return nil, errors.New("no such host")
+	}
+	return []net.IPAddr{{IP: net.ParseIP(address)}}, nil
+}
+
+func TestWebhookService(t *testing.T) {
+	ctx := context.Background()
+	resolver := staticResolver{"partner.com": "203.0.113.10", "internal.partner.com": "10.1.2.3"}
+
+	newWebhook := &NewWebhook{
+		Url:        "https://partner.com/hooks/users",
+		EventTypes: []string{notifier.ChangeOperationDelete},
+		Secret:     "aVeryLongTestSecret",
+	}
+
+	t.Run("Register a webhook and list it without its secret", func(t *testing.T) {
+		webhookService := NewWebhookService(memoryRepositories.NewUserRepositoryMemoryImpl(), WithResolver(resolver))
+
+		registeredWebhook, err := webhookService.RegisterWebhook(ctx, newWebhook)
+		require.NoError(t, err)
+		assert.NotEmpty(t, registeredWebhook.Id)
+		assert.Equal(t, "https://partner.com/hooks/users", registeredWebhook.Url)
+		assert.Equal(t, []string{notifier.ChangeOperationDelete}, registeredWebhook.EventTypes)
+
+		webhooks, err := webhookService.GetWebhooks(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []*Webhook{registeredWebhook}, webhooks)
+	})
+
+	t.Run("Return a validation error for an invalid webhook", func(t *testing.T) {
+		webhookService := NewWebhookService(memoryRepositories.NewUserRepositoryMemoryImpl())
+
+		_, err := webhookService.RegisterWebhook(ctx, &NewWebhook{Url: "ftp://partner.com", EventTypes: []string{"truncate"}, Secret: "short"})
+
+		assert.Equal(t, domainerrors.KindValidation, domainerrors.KindOf(err))
+		var fields []string
+		for _, violation := range domainerrors.ViolationsOf(err) {
+			fields = append(fields, violation.Field)
+		}
+		assert.Equal(t, []string{"url", "event_types", "secret"}, fields)
+	})
+
+	t.Run("Reject the webhooks posting to the service itself or its internal network", func(t *testing.T) {
+		webhookService := NewWebhookService(memoryRepositories.NewUserRepositoryMemoryImpl(), WithResolver(resolver))
+
+		for _, url := range []string{
+			"http://127.0.0.1/hook", "http://localhost:8080/hook", "http://api.localhost/hook", "http://[::1]/hook",
+			"http://169.254.169.254/latest/meta-data", "http://[fe80::1]/hook", "http://10.0.0.5/hook",
+			"http://192.168.1.1/hook", "http://0.0.0.0/hook", "http://internal.partner.com/hook", "http://unknown.partner.com/hook",
+		} {
+			_, err := webhookService.RegisterWebhook(ctx, &NewWebhook{Url: url, Secret: "aVeryLongTestSecret"})
+
+			assert.Equal(t, domainerrors.KindValidation, domainerrors.KindOf(err), url)
+			violations := domainerrors.ViolationsOf(err)
+			require.Len(t, violations, 1, url)
+			assert.Equal(t, "url", violations[0].Field, url)
+		}
+	})
+
+	t.Run("Return not found for a missing webhook", func(t *testing.T) {
+		webhookService := NewWebhookService(memoryRepositories.NewUserRepositoryMemoryImpl())
+
+		err := webhookService.RemoveWebhook(ctx, primitive.NewObjectID().Hex())
+		assert.Equal(t, domainerrors.KindNotFound, domainerrors.KindOf(err))
+
+		_, err = webhookService.GetDeliveries(ctx, primitive.NewObjectID().Hex(), "")
+		assert.Equal(t, domainerrors.KindNotFound, domainerrors.KindOf(err))
+
+		err = webhookService.RemoveWebhook(ctx, "invalid")
+		assert.Equal(t, domainerrors.KindInvalidArgument, domainerrors.KindOf(err))
+	})
+
+	t.Run("List the dead letters and redeliver them", func(t *testing.T) {
+		store := memoryRepositories.NewUserRepositoryMemoryImpl()
+		webhookService := NewWebhookService(store, WithResolver(resolver))
+		registeredWebhook, err := webhookService.RegisterWebhook(ctx, newWebhook)
+		require.NoError(t, err)
+		webhookId, err := primitive.ObjectIDFromHex(registeredWebhook.Id)
+		require.NoError(t, err)
+
+		delivery := repositories.NewWebhookDelivery(webhookId, notifier.ChangeData{EventId: "event1", OperationType: notifier.ChangeOperationDelete})
+		require.NoError(t, store.AddDelivery(ctx, delivery))
+		require.NoError(t, store.RecordAttempt(ctx, delivery.Id,
+			repositories.WebhookAttempt{AttemptedAt: time.Now(), StatusCode: 503, Error: "unexpected status 503"},
+			repositories.DeliveryDead, time.Now()))
+
+		deadLetters, err := webhookService.GetDeadLetters(ctx)
+		require.NoError(t, err)
+		require.Len(t, deadLetters, 1)
+		assert.Equal(t, delivery.Id.Hex(), deadLetters[0].Id)
+		assert.Equal(t, "event1", deadLetters[0].Change.EventId)
+		require.Len(t, deadLetters[0].Attempts, 1)
+		assert.Equal(t, 503, deadLetters[0].Attempts[0].StatusCode)
+
+		redelivered, err := webhookService.Redeliver(ctx, delivery.Id.Hex())
+		require.NoError(t, err)
+		assert.Equal(t, repositories.DeliveryPending, redelivered.Status)
+		assert.NotEmpty(t, redelivered.NextAttemptAt)
+
+		deadLetters, err = webhookService.GetDeadLetters(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, deadLetters)
+
+		pending, err := webhookService.GetDeliveries(ctx, registeredWebhook.Id, repositories.DeliveryPending)
+		require.NoError(t, err)
+		assert.Len(t, pending, 1)
+
+		_, err = webhookService.GetDeliveries(ctx, registeredWebhook.Id, "lost")
+		assert.Equal(t, domainerrors.KindInvalidArgument, domainerrors.KindOf(err))
+		_, err = webhookService.Redeliver(ctx, primitive.NewObjectID().Hex())
+		assert.Equal(t, domainerrors.KindNotFound, domainerrors.KindOf(err))
+	})
+}
@@ -0,0 +1,352 @@
+// Package outbox publishes the changes of the users recorded by the repository in its outbox.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DEFAULT_INTERVAL   = time.Second
+	DEFAULT_BATCH_SIZE = 100
+	DEFAULT_RETENTION  = 24 * time.Hour
+)
+
+// Store is the outbox relayed, with the change log the publishers read from their cursors.
+type Store interface {
+	repositories.Outbox
+	repositories.ChangeLog
+	repositories.PublishCursors
+}
+
+// Relay broadcasts the pending entries of the outbox to the notifier and marks them as delivered.
+// An entry broadcast right before a crash, and not marked yet, is broadcast again after the restart,
+// so the changes are delivered at least once. Every publisher publishes the change log on its own,
+// from the last change it published, so one that can't publish only holds back its own backlog.
+type Relay struct {
+	outbox     Store
+	notifier   notifier.Notifier
+	publishers []*publisher
+	interval   time.Duration
+	batchSize  int64
+	retention  time.Duration
+	broadcast  bool
+	wake       chan struct{}
+	mu         sync.Mutex
+	// cursorsMu guards cursorsReady, set once every publisher has a cursor.
+	cursorsMu    sync.Mutex
+	cursorsReady bool
+}
+
+// publisher publishes the changes following the cursor recorded with its name.
+type publisher struct {
+	name      string
+	publisher notifier.Publisher
+	wake      chan struct{}
+	mu        sync.Mutex
+}
+
+type Option func(*Relay)
+
+// WithInterval sets how often the outbox is polled when the relay isn't woken up.
+func WithInterval(interval time.Duration) Option {
+	return func(r *Relay) {
+		r.interval = interval
+	}
+}
+
+// WithBatchSize sets how many entries are read from the outbox at a time.
+func WithBatchSize(batchSize int64) Option {
+	return func(r *Relay) {
+		r.batchSize = batchSize
+	}
+}
+
+// WithRetention sets how long the delivered entries are kept before being purged.
+func WithRetention(retention time.Duration) Option {
+	return func(r *Relay) {
+		r.retention = retention
+	}
+}
+
+// WithoutBroadcast makes the relay only mark the entries as delivered and purge them, keeping the
+// change log, when the changes are broadcast from another source, as the MongoDB change stream.
+func WithoutBroadcast() Option {
+	return func(r *Relay) {
+		r.broadcast = false
+	}
+}
+
+// WithPublisher makes the relay publish every change to the publisher, in order, from the last one
+// it published, recorded under its name. A change that can't be published is published again at a
+// later poll, with the following ones, so the changes are published at least once and in order.
+// A new publisher starts from the changes not relayed yet.
+func WithPublisher(name string, publisher notifier.Publisher) Option {
+	return func(r *Relay) {
+		r.publishers = append(r.publishers, newPublisher(name, publisher))
+	}
+}
+
+func newPublisher(name string, notifierPublisher notifier.Publisher) *publisher {
+	return &publisher{name: name, publisher: notifierPublisher, wake: make(chan struct{}, 1)}
+}
+
+func NewRelay(outbox Store, notifier notifier.Notifier, options ...Option) *Relay {
+	relay := &Relay{
+		outbox:    outbox,
+		notifier:  notifier,
+		interval:  DEFAULT_INTERVAL,
+		batchSize: DEFAULT_BATCH_SIZE,
+		retention: DEFAULT_RETENTION,
+		broadcast: true,
+		wake:      make(chan struct{}, 1),
+	}
+	for _, option := range options {
+		option(relay)
+	}
+	return relay
+}
+
+// Wake makes the relay broadcast and publish the pending entries right away, instead of at the next
+// poll. It never blocks, the wake ups requested while the relay is busy are coalesced.
+func (r *Relay) Wake() {
+	wake(r.wake)
+	for _, publisher := range r.publishers {
+		wake(publisher.wake)
+	}
+}
+
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Run relays the pending entries, and publishes the changes to every publisher, whenever it's woken
+// up or the interval elapses, until the context is done.
+func (r *Relay) Run(ctx context.Context) {
+	log.Printf("Starting the outbox relay")
+
+	for _, publisher := range r.publishers {
+		go r.runPublisher(ctx, publisher)
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	lastPurge := time.Now()
+	for {
+		if _, err := r.RelayPending(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Can't relay the outbox entries, %v", err)
+		}
+
+		if time.Since(lastPurge) > r.retention/10 {
+			if err := r.purge(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Can't purge the delivered outbox entries, %v", err)
+			}
+			lastPurge = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping the outbox relay")
+			return
+		case <-r.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayPending broadcasts all the pending entries, the oldest first, returning how many were broadcast.
+func (r *Relay) RelayPending(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The new publishers start from the entries still pending, before they're marked as delivered.
+	if err := r.startCursors(ctx); err != nil {
+		return 0, err
+	}
+
+	relayed := 0
+	for {
+		entries, err := r.outbox.PendingEntries(ctx, r.batchSize)
+		if err != nil {
+			return relayed, err
+		}
+
+		ids := make([]primitive.ObjectID, len(entries))
+		for i, entry := range entries {
+			if r.broadcast {
+				r.notifier.Broadcast(entry.ChangeData())
+			}
+			ids[i] = entry.Id
+		}
+
+		if err := r.outbox.MarkDelivered(ctx, ids...); err != nil {
+			return relayed, err
+		}
+		relayed += len(ids)
+
+		if r.batchSize <= 0 || int64(len(entries)) < r.batchSize {
+			return relayed, nil
+		}
+	}
+}
+
+// PublishPending publishes to every publisher the changes following the last one it published.
+func (r *Relay) PublishPending(ctx context.Context) error {
+	var errs []error
+	for _, publisher := range r.publishers {
+		if err := r.publishPending(ctx, publisher); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runPublisher publishes the changes to the publisher whenever it's woken up or the interval elapses,
+// until the context is done.
+func (r *Relay) runPublisher(ctx context.Context, publisher *publisher) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.publishPending(ctx, publisher); err != nil && ctx.Err() == nil {
+			log.Printf("Can't publish the changes to %s, %v", publisher.name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-publisher.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishPending publishes the changes following the cursor of the publisher, moving it past every
+// change published, up to the first one failing.
+func (r *Relay) publishPending(ctx context.Context, publisher *publisher) error {
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+
+	if err := r.startCursors(ctx); err != nil {
+		return err
+	}
+	sequence, _, err := r.outbox.PublishedSequence(ctx, publisher.name)
+	if err != nil {
+		return err
+	}
+
+	for {
+		entries, err := r.outbox.ChangesSince(ctx, sequence, r.batchSize)
+		if errors.Is(err, repositories.ErrSequenceCompacted) {
+			// The purge keeps the changes not published by the publishers relayed, this one
+			// was left behind while it wasn't, it starts again from the pending entries.
+			log.Printf("The changes following %d not published to %s have been purged, skipping them", sequence, publisher.name)
+			if sequence, err = r.outbox.DeliveredSequence(ctx); err != nil {
+				return err
+			}
+			if err := r.outbox.SetPublishedSequence(ctx, publisher.name, sequence); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		published := sequence
+		var publishErr error
+		for _, entry := range entries {
+			change := entry.ChangeData()
+			if err := publisher.publisher.Publish(ctx, change); err != nil {
+				publishErr = fmt.Errorf("can't publish the change %s: %w", change.EventId, err)
+				break
+			}
+			published = entry.Sequence
+		}
+
+		if published != sequence {
+			if err := r.outbox.SetPublishedSequence(ctx, publisher.name, published); err != nil {
+				return err
+			}
+			sequence = published
+		}
+		if publishErr != nil {
+			return publishErr
+		}
+
+		if r.batchSize <= 0 || int64(len(entries)) < r.batchSize {
+			return nil
+		}
+	}
+}
+
+// startCursors records the cursor of the publishers without one, at the entries still pending.
+func (r *Relay) startCursors(ctx context.Context) error {
+	r.cursorsMu.Lock()
+	defer r.cursorsMu.Unlock()
+
+	if r.cursorsReady {
+		return nil
+	}
+
+	for _, publisher := range r.publishers {
+		_, found, err := r.outbox.PublishedSequence(ctx, publisher.name)
+		if err != nil {
+			return err
+		}
+		if found {
+			continue
+		}
+
+		sequence, err := r.outbox.DeliveredSequence(ctx)
+		if err != nil {
+			return err
+		}
+		if err := r.outbox.SetPublishedSequence(ctx, publisher.name, sequence); err != nil {
+			return err
+		}
+	}
+
+	r.cursorsReady = true
+	return nil
+}
+
+// purge deletes the entries delivered before the retention, except the ones some publisher
+// hasn't published yet.
+func (r *Relay) purge(ctx context.Context) error {
+	before := time.Now().Add(-r.retention)
+	for _, publisher := range r.publishers {
+		sequence, found, err := r.outbox.PublishedSequence(ctx, publisher.name)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+
+		entries, err := r.outbox.ChangesSince(ctx, sequence, 1)
+		if errors.Is(err, repositories.ErrSequenceCompacted) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 && entries[0].DeliveredAt != nil && entries[0].DeliveredAt.Before(before) {
+			before = *entries[0].DeliveredAt
+		}
+	}
+
+	return r.outbox.PurgeDelivered(ctx, before)
+}
@@ -0,0 +1,239 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	memoryRepositories "github.com/dlion/faceit_challenge/internal/repositories/memory"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// failingOutbox fails to mark the entries as delivered, as if the relay crashed after broadcasting them.
+type failingOutbox struct {
+	Store
+}
+
+func (f *failingOutbox) MarkDelivered(ctx context.Context, ids ...primitive.ObjectID) error {
+	return errors.New("connection refused")
+}
+
+// recordingPublisher records the changes published, failing while it's down.
+type recordingPublisher struct {
+	down      bool
+	published []notifier.ChangeData
+}
+
+func (r *recordingPublisher) Publish(ctx context.Context, change notifier.ChangeData) error {
+	if r.down {
+		return errors.New("no responders")
+	}
+	r.published = append(r.published, change)
+	return nil
+}
+
+func (r *recordingPublisher) Close() error {
+	return nil
+}
+
+func TestRelay(t *testing.T) {
+	ctx := context.Background()
+
+	addUsers := func(t *testing.T, userRepo repositories.UserRepository, count int) []*repositories.User {
+		var users []*repositories.User
+		for i := 0; i < count; i++ {
+			user, err := userRepo.AddUser(ctx, repositories.NewRepoUser("testName", "testLastName", fmt.Sprintf("testNickname%d", i), "testPassword", fmt.Sprintf("testEmail%d@email.com", i), "UK"))
+			require.NoError(t, err)
+			users = append(users, user)
+		}
+		return users
+	}
+
+	receive := func(t *testing.T, ch <-chan notifier.ChangeData) notifier.ChangeData {
+		select {
+		case msg := <-ch:
+			return msg
+		case <-time.After(time.Second):
+			t.Fatal("Expected to receive a message")
+			return notifier.ChangeData{}
+		}
+	}
+
+	t.Run("Broadcast the pending entries in order and mark them as delivered", func(t *testing.T) {
+		userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+		changeNotifier := notifier.NewNotifier()
+		defer changeNotifier.Close()
+		ch := changeNotifier.AddSubscriber("subscriber", nil)
+		users := addUsers(t, userRepo, 3)
+
+		relayed, err := NewRelay(userRepo, changeNotifier, WithBatchSize(2)).RelayPending(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, 3, relayed)
+		for i, user := range users {
+			change := receive(t, ch)
+			assert.Equal(t, int64(i+1), change.Sequence)
+			assert.Equal(t, notifier.ChangeOperationInsert, change.OperationType)
+			assert.Equal(t, user.Id.Hex(), change.UserId)
+			assert.Equal(t, user.Snapshot(), change.After)
+		}
+		pendingEntries, err := userRepo.PendingEntries(ctx, 10)
+		require.NoError(t, err)
+		assert.Empty(t, pendingEntries)
+	})
+
+	t.Run("Broadcast again the entries that couldn't be marked as delivered", func(t *testing.T) {
+		userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+		changeNotifier := notifier.NewNotifier()
+		defer changeNotifier.Close()
+		ch := changeNotifier.AddSubscriber("subscriber", nil)
+		users := addUsers(t, userRepo, 1)
+
+		_, err := NewRelay(&failingOutbox{Store: userRepo}, changeNotifier).RelayPending(ctx)
+		require.Error(t, err)
+		relayed, err := NewRelay(userRepo, changeNotifier).RelayPending(ctx)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, relayed)
+		assert.Equal(t, users[0].Id.Hex(), receive(t, ch).UserId)
+		assert.Equal(t, users[0].Id.Hex(), receive(t, ch).UserId)
+	})
+
+	t.Run("Broadcast the entries as soon as the relay is woken up", func(t *testing.T) {
+		userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+		changeNotifier := notifier.NewNotifier()
+		defer changeNotifier.Close()
+		ch := changeNotifier.AddSubscriber("subscriber", nil)
+
+		relay := NewRelay(userRepo, changeNotifier, WithInterval(time.Hour))
+		runCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go relay.Run(runCtx)
+
+		users := addUsers(t, userRepo, 1)
+		relay.Wake()
+
+		assert.Equal(t, users[0].Id.Hex(), receive(t, ch).UserId)
+	})
+
+	t.Run("Only mark the entries as delivered without broadcasting them", func(t *testing.T) {
+		userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+		changeNotifier := notifier.NewNotifier()
+		defer changeNotifier.Close()
+		ch := changeNotifier.AddSubscriber("subscriber", nil)
+		addUsers(t, userRepo, 2)
+
+		relayed, err := NewRelay(userRepo, changeNotifier, WithoutBroadcast()).RelayPending(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, relayed)
+		assert.Empty(t, ch)
+		pendingEntries, err := userRepo.PendingEntries(ctx, 10)
+		require.NoError(t, err)
+		assert.Empty(t, pendingEntries)
+		changes, err := userRepo.ChangesSince(ctx, 0, 10)
+		require.NoError(t, err)
+		assert.Len(t, changes, 2)
+	})
+
+	t.Run("Broadcast the entries while a publisher is down, publishing them once it's back", func(t *testing.T) {
+		userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+		changeNotifier := notifier.NewNotifier()
+		defer changeNotifier.Close()
+		ch := changeNotifier.AddSubscriber("subscriber", nil)
+		users := addUsers(t, userRepo, 2)
+		publisher := &recordingPublisher{down: true}
+		relay := NewRelay(userRepo, changeNotifier, WithPublisher("broker", publisher))
+
+		relayed, err := relay.RelayPending(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 2, relayed)
+		require.Error(t, relay.PublishPending(ctx))
+		require.Error(t, relay.PublishPending(ctx))
+		for _, user := range users {
+			assert.Equal(t, user.Id.Hex(), receive(t, ch).UserId)
+		}
+		pendingEntries, err := userRepo.PendingEntries(ctx, 10)
+		require.NoError(t, err)
+		assert.Empty(t, pendingEntries)
+
+		publisher.down = false
+		require.NoError(t, relay.PublishPending(ctx))
+		require.NoError(t, relay.PublishPending(ctx))
+
+		require.Len(t, publisher.published, 2)
+		for i, user := range users {
+			assert.Equal(t, user.Id.Hex(), publisher.published[i].UserId)
+		}
+		assert.Empty(t, ch)
+	})
+
+	t.Run("Publish to every publisher whatever the state of the others", func(t *testing.T) {
+		userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+		changeNotifier := notifier.NewNotifier()
+		defer changeNotifier.Close()
+		downPublisher := &recordingPublisher{down: true}
+		upPublisher := &recordingPublisher{}
+		relay := NewRelay(userRepo, changeNotifier, WithPublisher("down", downPublisher), WithPublisher("up", upPublisher))
+		addUsers(t, userRepo, 2)
+
+		require.Error(t, relay.PublishPending(ctx))
+
+		assert.Len(t, upPublisher.published, 2)
+		downSequence, _, err := userRepo.PublishedSequence(ctx, "down")
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), downSequence)
+		upSequence, _, err := userRepo.PublishedSequence(ctx, "up")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), upSequence)
+	})
+
+	t.Run("Start a new publisher from the entries not relayed yet", func(t *testing.T) {
+		userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+		changeNotifier := notifier.NewNotifier()
+		defer changeNotifier.Close()
+		addUsers(t, userRepo, 1)
+		_, err := NewRelay(userRepo, changeNotifier).RelayPending(ctx)
+		require.NoError(t, err)
+		_, err = userRepo.AddUser(ctx, repositories.NewRepoUser("testName", "testLastName", "pendingNickname", "testPassword", "pendingEmail@email.com", "UK"))
+		require.NoError(t, err)
+		publisher := &recordingPublisher{}
+		relay := NewRelay(userRepo, changeNotifier, WithPublisher("broker", publisher))
+
+		_, err = relay.RelayPending(ctx)
+		require.NoError(t, err)
+		require.NoError(t, relay.PublishPending(ctx))
+
+		require.Len(t, publisher.published, 1)
+		assert.Equal(t, int64(2), publisher.published[0].Sequence)
+	})
+
+	t.Run("Keep the entries delivered but not published past the retention", func(t *testing.T) {
+		userRepo := memoryRepositories.NewUserRepositoryMemoryImpl()
+		changeNotifier := notifier.NewNotifier()
+		defer changeNotifier.Close()
+		publisher := &recordingPublisher{down: true}
+		relay := NewRelay(userRepo, changeNotifier, WithPublisher("broker", publisher), WithRetention(-time.Hour))
+		addUsers(t, userRepo, 2)
+		_, err := relay.RelayPending(ctx)
+		require.NoError(t, err)
+
+		require.NoError(t, relay.purge(ctx))
+
+		publisher.down = false
+		require.NoError(t, relay.PublishPending(ctx))
+		assert.Len(t, publisher.published, 2)
+		require.NoError(t, relay.purge(ctx))
+		changes, err := userRepo.ChangesSince(ctx, 2, 10)
+		require.NoError(t, err)
+		assert.Empty(t, changes)
+		_, err = userRepo.ChangesSince(ctx, 0, 10)
+		assert.ErrorIs(t, err, repositories.ErrSequenceCompacted)
+	})
+}
@@ -0,0 +1,136 @@
+// Package pagetoken encodes the cursors of the user listings as opaque page tokens,
+// signed with HMAC-SHA256 so that the clients can't forge or tamper with them.
+package pagetoken
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	filter "github.com/dlion/faceit_challenge/internal"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrInvalidPageToken = errors.New("the page token is not valid")
+
+// payload is the signed content of a token, the times are stored as Unix nanoseconds.
+type payload struct {
+	Sort   string            `json:"s"`
+	Values []json.RawMessage `json:"v"`
+	Id     string            `json:"i"`
+	// Position is missing in the tokens issued before it was introduced.
+	Position int64 `json:"o,omitempty"`
+}
+
+type Codec struct {
+	secret []byte
+}
+
+func NewCodec(secret []byte) *Codec {
+	return &Codec{secret: secret}
+}
+
+// NewRandomCodec returns a codec signing with a random secret,
+// its tokens can't be verified by other processes or after a restart.
+func NewRandomCodec() *Codec {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return NewCodec(secret)
+}
+
+func (c *Codec) Encode(cursor *filter.Cursor) string {
+	p := payload{Sort: filter.FormatSort(cursor.Sort), Values: make([]json.RawMessage, len(cursor.Values)), Id: cursor.Id.Hex(), Position: cursor.Position}
+	for i, value := range cursor.Values {
+		if t, ok := value.(time.Time); ok {
+			value = t.UnixNano()
+		}
+
+		encodedValue, err := json.Marshal(value)
+		if err != nil {
+			panic(err)
+		}
+		p.Values[i] = encodedValue
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		panic(err)
+	}
+
+	encodedData := base64.RawURLEncoding.EncodeToString(data)
+	return encodedData + "." + base64.RawURLEncoding.EncodeToString(c.sign(encodedData))
+}
+
+func (c *Codec) Decode(token string) (*filter.Cursor, error) {
+	encodedData, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return nil, ErrInvalidPageToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, c.sign(encodedData)) {
+		return nil, ErrInvalidPageToken
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(encodedData)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+
+	var p payload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, ErrInvalidPageToken
+	}
+
+	id, err := primitive.ObjectIDFromHex(p.Id)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+
+	sort, err := filter.ParseSort(p.Sort)
+	if err != nil || len(sort) == 0 || len(sort) != len(p.Values) {
+		return nil, ErrInvalidPageToken
+	}
+
+	values := make([]interface{}, len(sort))
+	for i, field := range sort {
+		if values[i], err = decodeValue(field.Field, p.Values[i]); err != nil {
+			return nil, ErrInvalidPageToken
+		}
+	}
+
+	if p.Position < 0 {
+		return nil, ErrInvalidPageToken
+	}
+
+	return &filter.Cursor{Sort: sort, Values: values, Id: id, Position: p.Position}, nil
+}
+
+func decodeValue(field string, encodedValue json.RawMessage) (interface{}, error) {
+	if filter.IsTimeField(field) {
+		var nanoseconds int64
+		if err := json.Unmarshal(encodedValue, &nanoseconds); err != nil {
+			return nil, err
+		}
+		return time.Unix(0, nanoseconds).UTC(), nil
+	}
+
+	var value string
+	if err := json.Unmarshal(encodedValue, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (c *Codec) sign(encodedData string) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(encodedData))
+	return mac.Sum(nil)
+}
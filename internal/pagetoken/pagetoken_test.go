@@ -0,0 +1,56 @@
+package pagetoken
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	filter "github.com/dlion/faceit_challenge/internal"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCodec(t *testing.T) {
+	codec := NewCodec([]byte("secret"))
+	createdAt := time.Date(2024, 7, 19, 12, 25, 25, 123456789, time.UTC)
+	cursor := &filter.Cursor{Sort: filter.DEFAULT_SORT, Values: []interface{}{createdAt}, Id: primitive.NewObjectID()}
+
+	t.Run("Decode an encoded cursor", func(t *testing.T) {
+		decodedCursor, err := codec.Decode(codec.Encode(cursor))
+
+		assert.NoError(t, err)
+		assert.Equal(t, cursor, decodedCursor)
+	})
+
+	t.Run("Decode an encoded cursor of a listing sorted by more fields", func(t *testing.T) {
+		sort := []filter.SortField{{Field: "nickname"}, {Field: "updated_at", Descending: true}}
+		sortedCursor := &filter.Cursor{Sort: sort, Values: []interface{}{"johnd", createdAt}, Id: primitive.NewObjectID()}
+
+		decodedCursor, err := codec.Decode(codec.Encode(sortedCursor))
+
+		assert.NoError(t, err)
+		assert.Equal(t, sortedCursor, decodedCursor)
+	})
+
+	t.Run("Reject a token signed with another secret", func(t *testing.T) {
+		token := NewCodec([]byte("otherSecret")).Encode(cursor)
+
+		_, err := codec.Decode(token)
+		assert.ErrorIs(t, err, ErrInvalidPageToken)
+	})
+
+	t.Run("Reject a tampered token", func(t *testing.T) {
+		token := codec.Encode(cursor)
+		otherToken := codec.Encode(&filter.Cursor{Sort: filter.DEFAULT_SORT, Values: []interface{}{time.Now()}, Id: primitive.NewObjectID()})
+		data, _, _ := strings.Cut(token, ".")
+		_, signature, _ := strings.Cut(otherToken, ".")
+
+		_, err := codec.Decode(data + "." + signature)
+		assert.ErrorIs(t, err, ErrInvalidPageToken)
+	})
+
+	t.Run("Reject a malformed token", func(t *testing.T) {
+		_, err := codec.Decode("randomToken")
+		assert.ErrorIs(t, err, ErrInvalidPageToken)
+	})
+}
@@ -0,0 +1,445 @@
+package repositories
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	filter "github.com/dlion/faceit_challenge/internal"
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type UserRepositoryMemoryImpl struct {
+	users map[primitive.ObjectID]*repositories.User
+	// outbox is sorted by sequence, the entries are appended while holding the lock of the change.
+	outbox       []*repositories.OutboxEntry
+	lastSequence int64
+	// publishCursors are the sequence numbers of the last changes published, by publisher.
+	publishCursors map[string]int64
+	// webhooks and deliveries are sorted by creation, the oldest first.
+	webhooks   []*repositories.Webhook
+	deliveries []*repositories.WebhookDelivery
+	mu         sync.RWMutex
+}
+
+func NewUserRepositoryMemoryImpl() *UserRepositoryMemoryImpl {
+	return &UserRepositoryMemoryImpl{
+		users:          map[primitive.ObjectID]*repositories.User{},
+		publishCursors: map[string]int64{},
+	}
+}
+
+func (u *UserRepositoryMemoryImpl) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (u *UserRepositoryMemoryImpl) AddUser(ctx context.Context, user *repositories.User) (*repositories.User, error) {
+	log.Printf("Adding a user to the memory storage")
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.userAlreadyExists(user.Nickname, user.Email, primitive.NilObjectID) {
+		return nil, repositories.ErrUserAlreadyExist
+	}
+
+	hashedPassword, err := repositories.HashPassword(user.Password)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = hashedPassword
+
+	now := time.Now()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	user.Id = primitive.NewObjectID()
+	user.Version = 1
+
+	storedUser := *user
+	u.users[user.Id] = &storedUser
+	u.recordChange(notifier.ChangeOperationInsert, nil, &storedUser)
+
+	return user, nil
+}
+
+func (u *UserRepositoryMemoryImpl) UpdateUser(ctx context.Context, user *repositories.User) (*repositories.User, *repositories.User, error) {
+	log.Printf("Updating user (%s) in the memory storage", user.Id.Hex())
+
+	if !hasFieldsToUpdate(user) {
+		return nil, nil, repositories.ErrNothingToUpdate
+	}
+	if err := user.ValidateClearedFields(); err != nil {
+		return nil, nil, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	storedUser, exists := u.users[user.Id]
+	if !exists {
+		return nil, nil, repositories.ErrUserNotFound
+	}
+	if user.Version != 0 && user.Version != storedUser.Version {
+		return nil, nil, repositories.ErrVersionMismatch
+	}
+
+	updatedUser := *storedUser
+	if err := applyUpdatedFields(&updatedUser, user); err != nil {
+		return nil, nil, err
+	}
+	if u.userAlreadyExists(updatedUser.Nickname, updatedUser.Email, updatedUser.Id) {
+		return nil, nil, repositories.ErrUserAlreadyExist
+	}
+	updatedUser.Version++
+	u.users[user.Id] = &updatedUser
+	u.recordChange(notifier.ChangeOperationUpdate, storedUser, &updatedUser)
+
+	result, previous := updatedUser, *storedUser
+	return &result, &previous, nil
+}
+
+func (u *UserRepositoryMemoryImpl) RemoveUser(ctx context.Context, id string, expectedVersion int64) (*repositories.User, error) {
+	log.Printf("Removing user (%s) from the memory storage", id)
+
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	storedUser, exists := u.users[objectId]
+	if !exists {
+		return nil, repositories.ErrUserNotFound
+	}
+	if expectedVersion != 0 && expectedVersion != storedUser.Version {
+		return nil, repositories.ErrVersionMismatch
+	}
+
+	delete(u.users, objectId)
+	u.recordChange(notifier.ChangeOperationDelete, storedUser, nil)
+
+	removedUser := *storedUser
+	return &removedUser, nil
+}
+
+func (u *UserRepositoryMemoryImpl) GetUser(ctx context.Context, id string) (*repositories.User, error) {
+	log.Printf("Getting user (%s) from the memory storage", id)
+
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	storedUser, exists := u.users[objectId]
+	if !exists {
+		return nil, repositories.ErrUserNotFound
+	}
+
+	result := *storedUser
+	return &result, nil
+}
+
+func (u *UserRepositoryMemoryImpl) GetUsers(ctx context.Context, userFilter *filter.UserFilter, limit, offset *int64) ([]*repositories.User, error) {
+	log.Printf("Getting users from the memory storage with filters: %s", userFilter)
+
+	if limit == nil {
+		limit = int64Ptr(10)
+	}
+
+	if offset == nil {
+		offset = int64Ptr(0)
+	}
+
+	u.mu.RLock()
+	matchingUsers := make([]*repositories.User, 0, len(u.users))
+	for _, user := range u.users {
+		if matchesFilter(user, userFilter) {
+			result := *user
+			matchingUsers = append(matchingUsers, &result)
+		}
+	}
+	u.mu.RUnlock()
+
+	sortOrder := userFilter.SortOrder()
+	rankByRelevance := userFilter.RanksByRelevance()
+	sort.Slice(matchingUsers, func(i, j int) bool {
+		if rankByRelevance {
+			iMatches := countSearchMatches(matchingUsers[i], userFilter.SearchWords())
+			jMatches := countSearchMatches(matchingUsers[j], userFilter.SearchWords())
+			if iMatches != jMatches {
+				return iMatches > jMatches
+			}
+		}
+		return compareUsers(matchingUsers[i], matchingUsers[j], sortOrder) < 0
+	})
+
+	return paginate(matchingUsers, *limit, *offset), nil
+}
+
+func (u *UserRepositoryMemoryImpl) CountUsers(ctx context.Context, userFilter *filter.UserFilter) (int64, error) {
+	log.Printf("Counting users in the memory storage with filters: %s", userFilter)
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	var count int64
+	for _, user := range u.users {
+		if matchesFilter(user, userFilter) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// recordChange appends a change to the outbox, the lock must be held.
+func (u *UserRepositoryMemoryImpl) recordChange(operationType string, before, after *repositories.User) {
+	u.lastSequence++
+	u.outbox = append(u.outbox, repositories.NewOutboxEntry(u.lastSequence, operationType, before, after))
+}
+
+func (u *UserRepositoryMemoryImpl) ChangesSince(ctx context.Context, sequence int64, limit int64) ([]*repositories.OutboxEntry, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	var entries []*repositories.OutboxEntry
+	for _, entry := range u.outbox {
+		if limit > 0 && int64(len(entries)) == limit {
+			break
+		}
+		if entry.Sequence > sequence {
+			result := *entry
+			entries = append(entries, &result)
+		}
+	}
+
+	if err := repositories.CheckNotCompacted(sequence, u.lastSequence, entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (u *UserRepositoryMemoryImpl) PendingEntries(ctx context.Context, limit int64) ([]*repositories.OutboxEntry, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	var entries []*repositories.OutboxEntry
+	for _, entry := range u.outbox {
+		if limit > 0 && int64(len(entries)) == limit {
+			break
+		}
+		if entry.DeliveredAt == nil {
+			result := *entry
+			entries = append(entries, &result)
+		}
+	}
+
+	return entries, nil
+}
+
+func (u *UserRepositoryMemoryImpl) MarkDelivered(ctx context.Context, ids ...primitive.ObjectID) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	for _, entry := range u.outbox {
+		if entry.DeliveredAt == nil && slices.Contains(ids, entry.Id) {
+			entry.DeliveredAt = &now
+		}
+	}
+
+	return nil
+}
+
+func (u *UserRepositoryMemoryImpl) PurgeDelivered(ctx context.Context, before time.Time) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.outbox = slices.DeleteFunc(u.outbox, func(entry *repositories.OutboxEntry) bool {
+		return entry.DeliveredAt != nil && entry.DeliveredAt.Before(before)
+	})
+
+	return nil
+}
+
+func (u *UserRepositoryMemoryImpl) DeliveredSequence(ctx context.Context) (int64, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	for _, entry := range u.outbox {
+		if entry.DeliveredAt == nil {
+			return entry.Sequence - 1, nil
+		}
+	}
+
+	return u.lastSequence, nil
+}
+
+func (u *UserRepositoryMemoryImpl) PublishedSequence(ctx context.Context, publisher string) (int64, bool, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	sequence, found := u.publishCursors[publisher]
+	return sequence, found, nil
+}
+
+func (u *UserRepositoryMemoryImpl) SetPublishedSequence(ctx context.Context, publisher string, sequence int64) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.publishCursors[publisher] = sequence
+	return nil
+}
+
+// userAlreadyExists reports whether a user other than the excluded one has the email, regardless of
+// its case, or the nickname, the users without a nickname don't share it.
+func (u *UserRepositoryMemoryImpl) userAlreadyExists(nickname, email string, excludedId primitive.ObjectID) bool {
+	for _, user := range u.users {
+		if user.Id == excludedId {
+			continue
+		}
+		if filter.FoldCase(user.Email) == filter.FoldCase(email) || (nickname != "" && user.Nickname == nickname) {
+			return true
+		}
+	}
+	return false
+}
+
+func int64Ptr(value int64) *int64 {
+	return &value
+}
+
+func hasFieldsToUpdate(user *repositories.User) bool {
+	return user.FirstName != "" || user.LastName != "" || user.Nickname != "" ||
+		user.Password != "" || user.Email != "" || user.Country != "" || len(user.ClearedFields) > 0
+}
+
+func applyUpdatedFields(storedUser, user *repositories.User) error {
+	if user.FirstName != "" {
+		storedUser.FirstName = user.FirstName
+	}
+
+	if user.LastName != "" {
+		storedUser.LastName = user.LastName
+	}
+
+	if user.Nickname != "" {
+		storedUser.Nickname = user.Nickname
+	}
+
+	if user.Password != "" {
+		hashedPassword, err := repositories.HashPassword(user.Password)
+		if err != nil {
+			return err
+		}
+		storedUser.Password = hashedPassword
+	}
+
+	if user.Email != "" {
+		storedUser.Email = user.Email
+	}
+
+	if user.Country != "" {
+		storedUser.Country = user.Country
+	}
+
+	for _, field := range user.ClearedFields {
+		switch field {
+		case "first_name":
+			storedUser.FirstName = ""
+		case "last_name":
+			storedUser.LastName = ""
+		case "nickname":
+			storedUser.Nickname = ""
+		case "country":
+			storedUser.Country = ""
+		}
+	}
+
+	storedUser.UpdatedAt = time.Now()
+
+	return nil
+}
+
+func matchesFilter(user *repositories.User, userFilter *filter.UserFilter) bool {
+	if userFilter == nil {
+		return true
+	}
+
+	return userFilter.Matches(user.Snapshot()) && followsCursor(user, userFilter.Cursor)
+}
+
+// countSearchMatches returns how many words of the search are among the search terms of the user.
+func countSearchMatches(user *repositories.User, words []string) int {
+	return filter.CountSearchMatches(user.SearchTerms(), words)
+}
+
+func followsCursor(user *repositories.User, cursor *filter.Cursor) bool {
+	if cursor == nil {
+		return true
+	}
+
+	for i, field := range cursor.Sort {
+		if result := compareValues(user.SortValue(field.Field), cursor.Values[i], field.Descending); result != 0 {
+			return result > 0
+		}
+	}
+	return compareValues(user.Id.Hex(), cursor.Id.Hex(), filter.IdDescending(cursor.Sort)) > 0
+}
+
+// compareUsers returns a negative number if the first user comes before the second one
+// in the sort order, a positive one if it comes after, the ties are broken by id.
+func compareUsers(first, second *repositories.User, sortOrder []filter.SortField) int {
+	for _, field := range sortOrder {
+		if result := compareValues(first.SortValue(field.Field), second.SortValue(field.Field), field.Descending); result != 0 {
+			return result
+		}
+	}
+	return compareValues(first.Id.Hex(), second.Id.Hex(), filter.IdDescending(sortOrder))
+}
+
+// compareValues compares two values of a sort field, either strings or times.
+func compareValues(first, second interface{}, descending bool) int {
+	var result int
+	switch firstValue := first.(type) {
+	case time.Time:
+		result = firstValue.Compare(second.(time.Time))
+	case string:
+		result = strings.Compare(firstValue, second.(string))
+	}
+
+	if descending {
+		return -result
+	}
+	return result
+}
+
+func paginate(users []*repositories.User, limit, offset int64) []*repositories.User {
+	if offset < 0 {
+		offset = 0
+	}
+
+	if offset >= int64(len(users)) {
+		return []*repositories.User{}
+	}
+	users = users[offset:]
+
+	// A zero limit means no limit, as it does for MongoDB.
+	if limit > 0 && limit < int64(len(users)) {
+		users = users[:limit]
+	}
+
+	return users
+}
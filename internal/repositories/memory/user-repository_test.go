@@ -0,0 +1,14 @@
+package repositories
+
+import (
+	"testing"
+
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	"github.com/dlion/faceit_challenge/internal/repositories/repotest"
+)
+
+func TestRepository(t *testing.T) {
+	repotest.RunUserRepositorySuite(t, func(t *testing.T) repositories.UserRepository {
+		return NewUserRepositoryMemoryImpl()
+	})
+}
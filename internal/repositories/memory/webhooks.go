@@ -0,0 +1,212 @@
+package repositories
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (u *UserRepositoryMemoryImpl) AddWebhook(ctx context.Context, webhook *repositories.Webhook) (*repositories.Webhook, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	storedWebhook := *webhook
+	u.webhooks = append(u.webhooks, &storedWebhook)
+
+	return webhook, nil
+}
+
+func (u *UserRepositoryMemoryImpl) GetWebhook(ctx context.Context, id string) (*repositories.Webhook, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	for _, webhook := range u.webhooks {
+		if webhook.Id == objectId {
+			result := *webhook
+			return &result, nil
+		}
+	}
+
+	return nil, repositories.ErrWebhookNotFound
+}
+
+func (u *UserRepositoryMemoryImpl) GetWebhooks(ctx context.Context) ([]*repositories.Webhook, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	webhooks := make([]*repositories.Webhook, len(u.webhooks))
+	for i, webhook := range u.webhooks {
+		result := *webhook
+		webhooks[i] = &result
+	}
+
+	return webhooks, nil
+}
+
+func (u *UserRepositoryMemoryImpl) RemoveWebhook(ctx context.Context, id string) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	index := slices.IndexFunc(u.webhooks, func(webhook *repositories.Webhook) bool {
+		return webhook.Id == objectId
+	})
+	if index < 0 {
+		return repositories.ErrWebhookNotFound
+	}
+
+	u.webhooks = slices.Delete(u.webhooks, index, index+1)
+	u.deliveries = slices.DeleteFunc(u.deliveries, func(delivery *repositories.WebhookDelivery) bool {
+		return delivery.WebhookId == objectId
+	})
+
+	return nil
+}
+
+func (u *UserRepositoryMemoryImpl) AddDelivery(ctx context.Context, delivery *repositories.WebhookDelivery) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, storedDelivery := range u.deliveries {
+		if storedDelivery.WebhookId == delivery.WebhookId && storedDelivery.EventId == delivery.EventId {
+			return nil
+		}
+	}
+
+	storedDelivery := *delivery
+	storedDelivery.Attempts = slices.Clone(delivery.Attempts)
+	u.deliveries = append(u.deliveries, &storedDelivery)
+
+	return nil
+}
+
+func (u *UserRepositoryMemoryImpl) GetDelivery(ctx context.Context, id string) (*repositories.WebhookDelivery, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	for _, delivery := range u.deliveries {
+		if delivery.Id == objectId {
+			return copyDelivery(delivery), nil
+		}
+	}
+
+	return nil, repositories.ErrDeliveryNotFound
+}
+
+func (u *UserRepositoryMemoryImpl) GetDeliveries(ctx context.Context, query repositories.DeliveryQuery) ([]*repositories.WebhookDelivery, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	var deliveries []*repositories.WebhookDelivery
+	for i := len(u.deliveries) - 1; i >= 0; i-- {
+		if query.Limit > 0 && int64(len(deliveries)) == query.Limit {
+			break
+		}
+
+		delivery := u.deliveries[i]
+		if (query.WebhookId.IsZero() || delivery.WebhookId == query.WebhookId) &&
+			(query.Status == "" || delivery.Status == query.Status) {
+			deliveries = append(deliveries, copyDelivery(delivery))
+		}
+	}
+
+	return deliveries, nil
+}
+
+func (u *UserRepositoryMemoryImpl) DueDeliveries(ctx context.Context, now time.Time, limit int64) ([]*repositories.WebhookDelivery, error) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	var deliveries []*repositories.WebhookDelivery
+	for _, delivery := range u.deliveries {
+		if delivery.Status == repositories.DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			deliveries = append(deliveries, copyDelivery(delivery))
+		}
+	}
+
+	slices.SortStableFunc(deliveries, func(first, second *repositories.WebhookDelivery) int {
+		return first.NextAttemptAt.Compare(second.NextAttemptAt)
+	})
+	if limit > 0 && int64(len(deliveries)) > limit {
+		deliveries = deliveries[:limit]
+	}
+
+	return deliveries, nil
+}
+
+func (u *UserRepositoryMemoryImpl) RecordAttempt(ctx context.Context, id primitive.ObjectID, attempt repositories.WebhookAttempt, status string, nextAttemptAt time.Time) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	delivery := u.findDelivery(id)
+	if delivery == nil {
+		return repositories.ErrDeliveryNotFound
+	}
+
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	delivery.Status = status
+	delivery.NextAttemptAt = nextAttemptAt
+	delivery.UpdatedAt = time.Now()
+
+	return nil
+}
+
+func (u *UserRepositoryMemoryImpl) RetryDelivery(ctx context.Context, id primitive.ObjectID, nextAttemptAt time.Time) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	delivery := u.findDelivery(id)
+	if delivery == nil {
+		return repositories.ErrDeliveryNotFound
+	}
+
+	delivery.Status = repositories.DeliveryPending
+	delivery.NextAttemptAt = nextAttemptAt
+	delivery.UpdatedAt = time.Now()
+
+	return nil
+}
+
+func (u *UserRepositoryMemoryImpl) PurgeDeliveries(ctx context.Context, before time.Time) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.deliveries = slices.DeleteFunc(u.deliveries, func(delivery *repositories.WebhookDelivery) bool {
+		return delivery.Status != repositories.DeliveryPending && delivery.UpdatedAt.Before(before)
+	})
+
+	return nil
+}
+
+// findDelivery returns the stored delivery, the lock must be held.
+func (u *UserRepositoryMemoryImpl) findDelivery(id primitive.ObjectID) *repositories.WebhookDelivery {
+	for _, delivery := range u.deliveries {
+		if delivery.Id == id {
+			return delivery
+		}
+	}
+	return nil
+}
+
+func copyDelivery(delivery *repositories.WebhookDelivery) *repositories.WebhookDelivery {
+	result := *delivery
+	result.Attempts = slices.Clone(delivery.Attempts)
+	return &result
+}
@@ -0,0 +1,320 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	RESUME_TOKENS_COLLECTION_NAME = "resume_tokens"
+
+	DEFAULT_RETRY_INTERVAL = 5 * time.Second
+
+	// changeStreamHistoryLost is the error code of a resume token older than the oplog.
+	changeStreamHistoryLost = 286
+)
+
+// changeEvent is the part of a change stream event needed to notify the change of a user.
+type changeEvent struct {
+	// Id is the resume token of the event, it's the same every time the event is read.
+	Id struct {
+		Data string `bson:"_data"`
+	} `bson:"_id"`
+	OperationType string              `bson:"operationType"`
+	ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+	WallTime      time.Time           `bson:"wallTime"`
+	DocumentKey   struct {
+		Id primitive.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	UpdateDescription struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+	// FullDocument is the user after the change, if it still exists when the event is read.
+	FullDocument *repositories.User `bson:"fullDocument"`
+	// FullDocumentBeforeChange is the user before the change, if its pre-image was recorded.
+	FullDocumentBeforeChange *repositories.User `bson:"fullDocumentBeforeChange"`
+}
+
+// outboxEvent is the insert of an entry in the outbox, the change of a user made by the repository.
+type outboxEvent struct {
+	FullDocument *repositories.OutboxEntry `bson:"fullDocument"`
+}
+
+// ChangeStreamWatcher broadcasts every change of the users collection, including the ones written
+// without the service, reading them from a MongoDB change stream. The stream follows the oplog, so
+// every replica of the service watching it broadcasts the same changes in the same order.
+// The changes made by the repository are read from the entries it inserts in the outbox, so they
+// have the sequence and the event id of the change log, while the ones written without a transaction,
+// outside the service, are read from the users collection and have neither.
+// The resume token of the last change broadcast is stored, so after a restart the watcher
+// continues from where it stopped.
+type ChangeStreamWatcher struct {
+	database      *mongo.Database
+	collection    *mongo.Collection
+	resumeTokens  *mongo.Collection
+	notifier      notifier.Notifier
+	name          string
+	retryInterval time.Duration
+}
+
+type ChangeStreamOption func(*ChangeStreamWatcher)
+
+// WithRetryInterval sets how long the watcher waits before opening the stream again after an error.
+func WithRetryInterval(retryInterval time.Duration) ChangeStreamOption {
+	return func(w *ChangeStreamWatcher) {
+		w.retryInterval = retryInterval
+	}
+}
+
+// NewChangeStreamWatcher returns a watcher storing its resume token by name,
+// every replica of the service must have its own.
+func NewChangeStreamWatcher(client *mongo.Client, notifier notifier.Notifier, name string, options ...ChangeStreamOption) *ChangeStreamWatcher {
+	database := client.Database(DATABASE_NAME)
+	watcher := &ChangeStreamWatcher{
+		database:      database,
+		collection:    database.Collection(COLLECTION_NAME),
+		resumeTokens:  database.Collection(RESUME_TOKENS_COLLECTION_NAME),
+		notifier:      notifier,
+		name:          name,
+		retryInterval: DEFAULT_RETRY_INTERVAL,
+	}
+	for _, option := range options {
+		option(watcher)
+	}
+	return watcher
+}
+
+// Run broadcasts the changes until the context is done, opening the stream again after every error.
+func (w *ChangeStreamWatcher) Run(ctx context.Context) {
+	log.Printf("Starting the change stream watcher (%s)", w.name)
+
+	if err := w.enablePreImages(ctx); err != nil {
+		log.Printf("Can't record the users before their changes, the changes won't have them, %v", err)
+	}
+
+	for {
+		err := w.watch(ctx)
+		if ctx.Err() != nil {
+			log.Printf("Stopping the change stream watcher (%s)", w.name)
+			return
+		}
+		if err != nil {
+			log.Printf("The change stream of the users stopped, %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping the change stream watcher (%s)", w.name)
+			return
+		case <-time.After(w.retryInterval):
+		}
+	}
+}
+
+// watch broadcasts the changes following the stored resume token, until the stream ends.
+func (w *ChangeStreamWatcher) watch(ctx context.Context) error {
+	resumeToken, err := w.loadResumeToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	stream, err := w.open(ctx, resumeToken)
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(changeStreamHistoryLost) {
+		log.Printf("The changes following the resume token are no longer in the oplog, some changes weren't broadcast")
+		stream, err = w.open(ctx, nil)
+	}
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		change, ok, err := decodeChange(stream.Current)
+		if err != nil {
+			return err
+		}
+		if ok {
+			w.notifier.Broadcast(change)
+		}
+
+		if err := w.saveResumeToken(ctx, stream.ResumeToken()); err != nil {
+			return err
+		}
+	}
+
+	// The stream ends after an invalidate event, as the drop of the collection, and is opened again after it.
+	if err := stream.Err(); err != nil {
+		return err
+	}
+	return w.saveResumeToken(ctx, stream.ResumeToken())
+}
+
+// enablePreImages makes MongoDB record the users before every change, for the events to include them.
+func (w *ChangeStreamWatcher) enablePreImages(ctx context.Context) error {
+	database := w.collection.Database()
+	names, err := database.ListCollectionNames(ctx, bson.M{"name": COLLECTION_NAME})
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		if err := database.CreateCollection(ctx, COLLECTION_NAME); err != nil {
+			return err
+		}
+	}
+
+	return database.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: COLLECTION_NAME},
+		{Key: "changeStreamPreAndPostImages", Value: bson.M{"enabled": true}},
+	}).Err()
+}
+
+// open watches the inserts in the outbox and the changes of the users outside a transaction: the repository
+// changes the users in a transaction together with the outbox, its changes are read from the outbox alone.
+func (w *ChangeStreamWatcher) open(ctx context.Context, resumeToken bson.Raw) (*mongo.ChangeStream, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"ns.coll": OUTBOX_COLLECTION_NAME, "operationType": "insert"},
+			bson.M{
+				"ns.coll":       COLLECTION_NAME,
+				"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}},
+				"txnNumber":     bson.M{"$exists": false},
+			},
+		}}}},
+	}
+
+	streamOptions := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetFullDocumentBeforeChange(options.WhenAvailable)
+	if resumeToken != nil {
+		streamOptions.SetStartAfter(resumeToken)
+	}
+
+	return w.database.Watch(ctx, pipeline, streamOptions)
+}
+
+func (w *ChangeStreamWatcher) loadResumeToken(ctx context.Context) (bson.Raw, error) {
+	var document struct {
+		Token bson.Raw `bson:"token"`
+	}
+	err := w.resumeTokens.FindOne(ctx, bson.M{"_id": w.name}).Decode(&document)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return document.Token, nil
+}
+
+func (w *ChangeStreamWatcher) saveResumeToken(ctx context.Context, resumeToken bson.Raw) error {
+	if resumeToken == nil {
+		return nil
+	}
+
+	_, err := w.resumeTokens.UpdateOne(ctx,
+		bson.M{"_id": w.name},
+		bson.M{"$set": bson.M{"token": resumeToken, "updated_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// decodeChange converts an event of the stream to the change of a user, it returns false for the events
+// that don't change a user.
+func decodeChange(current bson.Raw) (notifier.ChangeData, bool, error) {
+	// The events that aren't about a collection, as an invalidate, have no namespace.
+	if collection, _ := current.Lookup("ns", "coll").StringValueOK(); collection != OUTBOX_COLLECTION_NAME {
+		var event changeEvent
+		if err := bson.Unmarshal(current, &event); err != nil {
+			return notifier.ChangeData{}, false, err
+		}
+		change, ok := toChangeData(event)
+		return change, ok, nil
+	}
+
+	var insertedEntry outboxEvent
+	if err := bson.Unmarshal(current, &insertedEntry); err != nil {
+		return notifier.ChangeData{}, false, err
+	}
+	if insertedEntry.FullDocument == nil {
+		return notifier.ChangeData{}, false, nil
+	}
+	return insertedEntry.FullDocument.ChangeData(), true, nil
+}
+
+// toChangeData converts the event to the change of a user, it returns false for the events that
+// don't change the user, as the backfill of its search terms or of its version, and the migrations.
+func toChangeData(event changeEvent) (notifier.ChangeData, bool) {
+	change := notifier.ChangeData{
+		EventId:   event.Id.Data,
+		Timestamp: event.WallTime,
+		UserId:    event.DocumentKey.Id.Hex(),
+		Before:    event.FullDocumentBeforeChange.Snapshot(),
+		After:     event.FullDocument.Snapshot(),
+	}
+	// The wall time is only recorded since MongoDB 6.0.
+	if change.Timestamp.IsZero() && event.ClusterTime.T != 0 {
+		change.Timestamp = time.Unix(int64(event.ClusterTime.T), 0)
+	}
+
+	switch event.OperationType {
+	case "insert":
+		change.OperationType = notifier.ChangeOperationInsert
+		change.ChangedFields = repositories.ChangedFields(nil, event.FullDocument)
+	case "update":
+		if _, migrated := event.UpdateDescription.UpdatedFields[MIGRATED_AT_FIELD]; migrated {
+			return notifier.ChangeData{}, false
+		}
+		change.ChangedFields = updatedFields(event)
+		if len(change.ChangedFields) == 0 {
+			return notifier.ChangeData{}, false
+		}
+		change.OperationType = notifier.ChangeOperationUpdate
+	case "replace":
+		change.OperationType = notifier.ChangeOperationUpdate
+		if event.FullDocumentBeforeChange != nil && event.FullDocument != nil {
+			change.ChangedFields = repositories.ChangedFields(event.FullDocumentBeforeChange, event.FullDocument)
+		}
+	case "delete":
+		change.OperationType = notifier.ChangeOperationDelete
+		if event.FullDocumentBeforeChange != nil {
+			change.ChangedFields = repositories.ChangedFields(event.FullDocumentBeforeChange, nil)
+		}
+	default:
+		return notifier.ChangeData{}, false
+	}
+
+	return change, true
+}
+
+// updatedFields returns the sorted names of the fields updated or removed by the event,
+// but the ones maintained by the repository.
+func updatedFields(event changeEvent) []string {
+	var fields []string
+	for field := range event.UpdateDescription.UpdatedFields {
+		fields = append(fields, field)
+	}
+	fields = append(fields, event.UpdateDescription.RemovedFields...)
+
+	var changedFields []string
+	for _, field := range fields {
+		if field != "search_terms" && field != "email_folded" && field != "updated_at" && field != "version" {
+			changedFields = append(changedFields, field)
+		}
+	}
+	sort.Strings(changedFields)
+	return changedFields
+}
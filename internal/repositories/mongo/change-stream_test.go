@@ -0,0 +1,286 @@
+package repositories
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestChangeStreamWatcher(t *testing.T) {
+	ctx := context.Background()
+
+	mongodbContainer, err := mongodb.Run(ctx, "mongo:7", mongodb.WithReplicaSet("rs"))
+	require.NoError(t, err, "failed to start container: %s", err)
+
+	defer func() {
+		err := mongodbContainer.Terminate(ctx)
+		assert.NoError(t, err, "failed to terminate container: %s", err)
+	}()
+
+	endpoint, err := mongodbContainer.ConnectionString(ctx)
+	require.NoError(t, err, "failed to get connection string: %s", err)
+
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint+"/?directConnection=true"))
+	require.NoError(t, err, "failed to connect to MongoDB: %s", err)
+
+	users := mongoClient.Database(DATABASE_NAME).Collection(COLLECTION_NAME)
+
+	receive := func(t *testing.T, ch <-chan notifier.ChangeData) notifier.ChangeData {
+		select {
+		case msg := <-ch:
+			return msg
+		case <-time.After(10 * time.Second):
+			t.Fatal("Expected to receive a message")
+			return notifier.ChangeData{}
+		}
+	}
+
+	// waitForStream writes a marker until the watcher notifies the last one written, so no change
+	// of the test is written before the stream is open.
+	waitForStream := func(t *testing.T, ch <-chan notifier.ChangeData) {
+		writeMarker := func() primitive.ObjectID {
+			marker := primitive.NewObjectID()
+			_, err := users.InsertOne(ctx, bson.M{"_id": marker})
+			require.NoError(t, err)
+			return marker
+		}
+
+		deadline := time.After(10 * time.Second)
+		marker := writeMarker()
+		for {
+			select {
+			case <-deadline:
+				t.Fatal("Expected the change stream to be opened")
+			case msg := <-ch:
+				if msg.UserId == marker.Hex() {
+					return
+				}
+			case <-time.After(200 * time.Millisecond):
+				marker = writeMarker()
+			}
+		}
+	}
+
+	// startWatcher runs the watcher until the test ends, waiting for its stream to be opened.
+	startWatcher := func(t *testing.T, name string) <-chan notifier.ChangeData {
+		changeNotifier := notifier.NewNotifier()
+		ch := changeNotifier.AddSubscriber("subscriber", nil)
+		runCtx, cancel := context.WithCancel(ctx)
+		t.Cleanup(func() {
+			cancel()
+			changeNotifier.Close()
+		})
+		go NewChangeStreamWatcher(mongoClient, changeNotifier, name, WithRetryInterval(100*time.Millisecond)).Run(runCtx)
+		waitForStream(t, ch)
+
+		return ch
+	}
+
+	t.Run("Broadcast the changes written directly to the collection", func(t *testing.T) {
+		ch := startWatcher(t, "direct")
+
+		id := primitive.NewObjectID()
+		_, err := users.InsertOne(ctx, bson.M{"_id": id, "nickname": "testNickname"})
+		require.NoError(t, err)
+		_, err = users.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"search_terms": bson.A{"test"}}})
+		require.NoError(t, err)
+		_, err = users.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"nickname": "updatedNickname"}})
+		require.NoError(t, err)
+		_, err = users.DeleteOne(ctx, bson.M{"_id": id})
+		require.NoError(t, err)
+
+		inserted := receive(t, ch)
+		assert.Equal(t, notifier.ChangeOperationInsert, inserted.OperationType)
+		assert.Equal(t, id.Hex(), inserted.UserId)
+		assert.Equal(t, "testNickname", inserted.After.Nickname)
+
+		updated := receive(t, ch)
+		assert.Equal(t, notifier.ChangeOperationUpdate, updated.OperationType)
+		assert.Equal(t, []string{"nickname"}, updated.ChangedFields)
+		require.NotNil(t, updated.Before)
+		assert.Equal(t, "testNickname", updated.Before.Nickname)
+		assert.Equal(t, "updatedNickname", updated.After.Nickname)
+
+		deleted := receive(t, ch)
+		assert.Equal(t, notifier.ChangeOperationDelete, deleted.OperationType)
+		assert.Equal(t, id.Hex(), deleted.UserId)
+		assert.NotEqual(t, inserted.EventId, deleted.EventId)
+		assert.Nil(t, deleted.After)
+	})
+
+	t.Run("Broadcast once the changes made by the repository", func(t *testing.T) {
+		ch := startWatcher(t, "repository")
+
+		userRepo := NewUserRepositoryMongoImpl(mongoClient)
+		user, err := userRepo.AddUser(ctx, repositories.NewRepoUser("testName", "testLastName", "repositoryNickname", "testPassword", "repository@email.com", "UK"))
+		require.NoError(t, err)
+		_, _, err = userRepo.UpdateUser(ctx, &repositories.User{Id: user.Id, FirstName: "updatedName"})
+		require.NoError(t, err)
+		_, err = userRepo.RemoveUser(ctx, user.Id.Hex(), 0)
+		require.NoError(t, err)
+
+		changes, err := userRepo.ChangesSince(ctx, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, changes, 3)
+
+		for i, operationType := range []string{notifier.ChangeOperationInsert, notifier.ChangeOperationUpdate, notifier.ChangeOperationDelete} {
+			change := receive(t, ch)
+			assert.Equal(t, operationType, change.OperationType)
+			assert.Equal(t, changes[i].ChangeData(), change, "the change must be the one of the change log")
+		}
+	})
+
+	t.Run("Skip the backfills of the repository", func(t *testing.T) {
+		ch := startWatcher(t, "backfill")
+
+		id := primitive.NewObjectID()
+		_, err := users.InsertOne(ctx, bson.M{"_id": id, "nickname": "backfilledNickname", "first_name": ""})
+		require.NoError(t, err)
+		assert.Equal(t, id.Hex(), receive(t, ch).UserId)
+
+		userRepo := NewUserRepositoryMongoImpl(mongoClient)
+		require.NoError(t, userRepo.BackfillVersions(ctx))
+		require.NoError(t, userRepo.BackfillSearchTerms(ctx))
+		require.NoError(t, userRepo.BackfillEmptyFields(ctx))
+		_, err = users.DeleteOne(ctx, bson.M{"_id": id})
+		require.NoError(t, err)
+
+		assert.Equal(t, notifier.ChangeOperationDelete, receive(t, ch).OperationType)
+	})
+
+	t.Run("Resume after the last change broadcast", func(t *testing.T) {
+		runCtx, cancel := context.WithCancel(ctx)
+		changeNotifier := notifier.NewNotifier()
+		ch := changeNotifier.AddSubscriber("subscriber", nil)
+		go NewChangeStreamWatcher(mongoClient, changeNotifier, "resumed").Run(runCtx)
+
+		waitForStream(t, ch)
+		cancel()
+		changeNotifier.Close()
+
+		// Written while the watcher is stopped.
+		missed := primitive.NewObjectID()
+		_, err := users.InsertOne(ctx, bson.M{"_id": missed})
+		require.NoError(t, err)
+
+		changeNotifier = notifier.NewNotifier()
+		defer changeNotifier.Close()
+		ch = changeNotifier.AddSubscriber("subscriber", nil)
+		runCtx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		go NewChangeStreamWatcher(mongoClient, changeNotifier, "resumed").Run(runCtx)
+
+		assert.Equal(t, missed.Hex(), receive(t, ch).UserId)
+	})
+}
+
+func TestToChangeData(t *testing.T) {
+	id := primitive.NewObjectID()
+
+	newEvent := func(operationType string, updatedFields bson.M, removedFields ...string) changeEvent {
+		event := changeEvent{OperationType: operationType}
+		event.Id.Data = "resumeToken"
+		event.DocumentKey.Id = id
+		event.UpdateDescription.UpdatedFields = updatedFields
+		event.UpdateDescription.RemovedFields = removedFields
+		return event
+	}
+
+	t.Run("Convert the changes of the users", func(t *testing.T) {
+		for operationType, expected := range map[string]string{
+			"insert":  notifier.ChangeOperationInsert,
+			"update":  notifier.ChangeOperationUpdate,
+			"replace": notifier.ChangeOperationUpdate,
+			"delete":  notifier.ChangeOperationDelete,
+		} {
+			change, ok := toChangeData(newEvent(operationType, bson.M{"nickname": "updatedNickname"}))
+
+			assert.True(t, ok, operationType)
+			assert.Equal(t, expected, change.OperationType, operationType)
+			assert.Equal(t, id.Hex(), change.UserId, operationType)
+			assert.Equal(t, "resumeToken", change.EventId, operationType)
+		}
+	})
+
+	t.Run("Return the user before and after the change", func(t *testing.T) {
+		event := newEvent("update", bson.M{"nickname": "updatedNickname", "updated_at": time.Now()}, "country")
+		event.WallTime = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+		event.FullDocumentBeforeChange = &repositories.User{Id: id, Nickname: "testNickname", Country: "UK", Password: "hash"}
+		event.FullDocument = &repositories.User{Id: id, Nickname: "updatedNickname", Password: "hash"}
+
+		change, ok := toChangeData(event)
+
+		assert.True(t, ok)
+		assert.Equal(t, event.WallTime, change.Timestamp)
+		assert.Equal(t, []string{"country", "nickname"}, change.ChangedFields)
+		assert.Equal(t, event.FullDocumentBeforeChange.Snapshot(), change.Before)
+		assert.Equal(t, event.FullDocument.Snapshot(), change.After)
+	})
+
+	t.Run("Return all the fields of an inserted user as changed", func(t *testing.T) {
+		event := newEvent("insert", nil)
+		event.FullDocument = &repositories.User{Id: id, Nickname: "testNickname", Email: "testEmail@email.com", Password: "hash"}
+
+		change, ok := toChangeData(event)
+
+		assert.True(t, ok)
+		assert.Equal(t, []string{"nickname", "password", "email"}, change.ChangedFields)
+		assert.Nil(t, change.Before)
+	})
+
+	t.Run("Skip the updates of the search terms only", func(t *testing.T) {
+		_, ok := toChangeData(newEvent("update", bson.M{"search_terms": bson.A{"test"}}))
+		assert.False(t, ok)
+
+		_, ok = toChangeData(newEvent("update", bson.M{"search_terms": bson.A{"test"}, "email_folded": "TEST@TEST.COM"}))
+		assert.False(t, ok)
+
+		_, ok = toChangeData(newEvent("update", bson.M{"search_terms": bson.A{"test"}}, "country"))
+		assert.True(t, ok)
+	})
+
+	t.Run("Skip the backfills of the versions", func(t *testing.T) {
+		_, ok := toChangeData(newEvent("update", bson.M{"version": int64(1)}))
+		assert.False(t, ok)
+	})
+
+	t.Run("Skip the writes of the migrations", func(t *testing.T) {
+		_, ok := toChangeData(newEvent("update", bson.M{MIGRATED_AT_FIELD: time.Now()}, "first_name"))
+		assert.False(t, ok)
+	})
+
+	t.Run("Return the change of the outbox entry inserted by the repository", func(t *testing.T) {
+		entry := repositories.NewOutboxEntry(7, notifier.ChangeOperationInsert, nil, &repositories.User{Id: id, Nickname: "testNickname"})
+		entry.CreatedAt = entry.CreatedAt.Truncate(time.Millisecond)
+		event, err := bson.Marshal(bson.M{
+			"_id":           bson.M{"_data": "resumeToken"},
+			"operationType": "insert",
+			"ns":            bson.M{"db": DATABASE_NAME, "coll": OUTBOX_COLLECTION_NAME},
+			"fullDocument":  entry,
+		})
+		require.NoError(t, err)
+
+		change, ok, err := decodeChange(event)
+
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, int64(7), change.Sequence)
+		assert.Equal(t, entry.Id.Hex(), change.EventId)
+		assert.Equal(t, id.Hex(), change.UserId)
+	})
+
+	t.Run("Skip the events that don't change a user", func(t *testing.T) {
+		_, ok := toChangeData(newEvent("invalidate", nil))
+		assert.False(t, ok)
+	})
+}
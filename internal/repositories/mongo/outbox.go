@@ -0,0 +1,144 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// CHANGE_SEQUENCE_ID is the document of the counters collection numbering the outbox entries.
+	CHANGE_SEQUENCE_ID = "changes"
+	// PUBLISH_CURSOR_PREFIX prefixes the name of a publisher in the id of the document of the
+	// counters collection recording the last change it published.
+	PUBLISH_CURSOR_PREFIX = "published:"
+)
+
+// addOutboxEntry records a change of a user, it's run in the transaction of the change.
+// The concurrent transactions incrementing the sequence conflict, so the numbers follow the commit order.
+func (u *UserRepositoryMongoImpl) addOutboxEntry(ctx mongo.SessionContext, operationType string, before, after *repositories.User) error {
+	var counter struct {
+		Value int64 `bson:"value"`
+	}
+	err := u.counters.FindOneAndUpdate(ctx,
+		bson.M{"_id": CHANGE_SEQUENCE_ID},
+		bson.M{"$inc": bson.M{"value": int64(1)}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.outbox.InsertOne(ctx, repositories.NewOutboxEntry(counter.Value, operationType, before, after))
+	return err
+}
+
+func (u *UserRepositoryMongoImpl) PendingEntries(ctx context.Context, limit int64) ([]*repositories.OutboxEntry, error) {
+	return u.findOutbox(ctx, bson.M{"delivered_at": nil}, limit)
+}
+
+func (u *UserRepositoryMongoImpl) ChangesSince(ctx context.Context, sequence int64, limit int64) ([]*repositories.OutboxEntry, error) {
+	// The last sequence is read first: the entries read afterwards include at least all the ones up to it.
+	lastSequence, _, err := u.counterValue(ctx, CHANGE_SEQUENCE_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := u.findOutbox(ctx, bson.M{"sequence": bson.M{"$gt": sequence}}, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := repositories.CheckNotCompacted(sequence, lastSequence, entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// findOutbox returns at most limit entries matching the filter, in sequence order.
+func (u *UserRepositoryMongoImpl) findOutbox(ctx context.Context, filter bson.M, limit int64) ([]*repositories.OutboxEntry, error) {
+	cursor, err := u.outbox.Find(ctx, filter, &options.FindOptions{
+		Limit: &limit,
+		Sort:  bson.D{{Key: "sequence", Value: 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*repositories.OutboxEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (u *UserRepositoryMongoImpl) MarkDelivered(ctx context.Context, ids ...primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := u.outbox.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "delivered_at": nil},
+		bson.M{"$set": bson.M{"delivered_at": time.Now()}},
+	)
+	return err
+}
+
+func (u *UserRepositoryMongoImpl) PurgeDelivered(ctx context.Context, before time.Time) error {
+	_, err := u.outbox.DeleteMany(ctx, bson.M{"delivered_at": bson.M{"$lt": before}})
+	return err
+}
+
+func (u *UserRepositoryMongoImpl) DeliveredSequence(ctx context.Context) (int64, error) {
+	// The last sequence is read first, a change committed afterwards is pending.
+	lastSequence, _, err := u.counterValue(ctx, CHANGE_SEQUENCE_ID)
+	if err != nil {
+		return 0, err
+	}
+
+	pendingEntries, err := u.findOutbox(ctx, bson.M{"delivered_at": nil}, 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(pendingEntries) > 0 && pendingEntries[0].Sequence <= lastSequence {
+		return pendingEntries[0].Sequence - 1, nil
+	}
+
+	return lastSequence, nil
+}
+
+func (u *UserRepositoryMongoImpl) PublishedSequence(ctx context.Context, publisher string) (int64, bool, error) {
+	return u.counterValue(ctx, PUBLISH_CURSOR_PREFIX+publisher)
+}
+
+func (u *UserRepositoryMongoImpl) SetPublishedSequence(ctx context.Context, publisher string, sequence int64) error {
+	_, err := u.counters.UpdateOne(ctx,
+		bson.M{"_id": PUBLISH_CURSOR_PREFIX + publisher},
+		bson.M{"$set": bson.M{"value": sequence}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// counterValue returns the value of a document of the counters collection, and false if there's none.
+func (u *UserRepositoryMongoImpl) counterValue(ctx context.Context, id string) (int64, bool, error) {
+	var counter struct {
+		Value int64 `bson:"value"`
+	}
+	err := u.counters.FindOne(ctx, bson.M{"_id": id}).Decode(&counter)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return counter.Value, true, nil
+}
@@ -8,110 +8,384 @@ import (
 
 	filter "github.com/dlion/faceit_challenge/internal"
 	"github.com/dlion/faceit_challenge/internal/repositories"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const (
-	DATABASE_NAME   = "faceit"
-	COLLECTION_NAME = "users"
+	DATABASE_NAME            = "faceit"
+	COLLECTION_NAME          = "users"
+	OUTBOX_COLLECTION_NAME   = "outbox"
+	COUNTERS_COLLECTION_NAME = "counters"
+
+	WEBHOOKS_COLLECTION_NAME   = "webhooks"
+	DELIVERIES_COLLECTION_NAME = "webhook_deliveries"
+
+	// MIGRATED_AT_FIELD is set by the migrations to the time of their writes, which don't change the
+	// users, so the change stream watcher tells them apart and doesn't broadcast them.
+	MIGRATED_AT_FIELD = "migrated_at"
+	// MIGRATION_BATCH_SIZE is how many users a migration writes at a time.
+	MIGRATION_BATCH_SIZE = 500
 )
 
 var (
-	ErrUserAlreadyExist = errors.New("the user already exist in the db")
-	ErrUserNotFound     = errors.New("the user doesn't exist in the db")
-	ErrNothingToUpdate  = errors.New("there's anything to be update")
+	ErrUserAlreadyExist = repositories.ErrUserAlreadyExist
+	ErrUserNotFound     = repositories.ErrUserNotFound
+	ErrNothingToUpdate  = repositories.ErrNothingToUpdate
+	ErrVersionMismatch  = repositories.ErrVersionMismatch
+	ErrNotClearable     = repositories.ErrNotClearable
 )
 
+// userDocument is the stored user together with the terms it's found by with a full-text search
+// and its email folded by filter.FoldCase, matched by the email_ignore_case filter.
+type userDocument struct {
+	*repositories.User `bson:",inline"`
+	SearchTerms        []string `bson:"search_terms"`
+	EmailFolded        string   `bson:"email_folded"`
+}
+
+func newUserDocument(user *repositories.User) userDocument {
+	return userDocument{User: user, SearchTerms: user.SearchTerms(), EmailFolded: filter.FoldCase(user.Email)}
+}
+
+// UserRepositoryMongoImpl changes the users in transactions together with the outbox,
+// so MongoDB must be deployed as a replica set, even of a single member.
 type UserRepositoryMongoImpl struct {
 	collection *mongo.Collection
+	outbox     *mongo.Collection
+	counters   *mongo.Collection
+	webhooks   *mongo.Collection
+	deliveries *mongo.Collection
 }
 
 func NewUserRepositoryMongoImpl(client *mongo.Client) *UserRepositoryMongoImpl {
-	return &UserRepositoryMongoImpl{collection: client.Database(DATABASE_NAME).Collection(COLLECTION_NAME)}
+	database := client.Database(DATABASE_NAME)
+	return &UserRepositoryMongoImpl{
+		collection: database.Collection(COLLECTION_NAME),
+		outbox:     database.Collection(OUTBOX_COLLECTION_NAME),
+		counters:   database.Collection(COUNTERS_COLLECTION_NAME),
+		webhooks:   database.Collection(WEBHOOKS_COLLECTION_NAME),
+		deliveries: database.Collection(DELIVERIES_COLLECTION_NAME),
+	}
 }
 
-func (u *UserRepositoryMongoImpl) AddUser(ctx context.Context, user *repositories.User) (*repositories.User, error) {
-	log.Printf("Adding a user to the database")
+// CreateIndexes creates the indexes of the users collection, it can be run at every startup.
+// Every sortable field is indexed together with the _id breaking its ties, the indexes are
+// traversed backwards for the descending sorts. The folded email and the nickname are unique,
+// it fails if the stored users already share them.
+func (u *UserRepositoryMongoImpl) CreateIndexes(ctx context.Context) error {
+	log.Printf("Creating the indexes of the users collection")
 
-	if err := userAlreadyExists(ctx, u.collection, user.Nickname, user.Email); err != nil {
-		return nil, err
+	var indexes []mongo.IndexModel
+	for _, field := range filter.SORTABLE_FIELDS {
+		indexes = append(indexes, mongo.IndexModel{Keys: bson.D{{Key: field, Value: 1}, {Key: "_id", Value: 1}}})
+	}
+
+	// The users stored before the folded email don't have it until it's computed at startup,
+	// and the users without a nickname don't share it.
+	indexes = append(indexes,
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "email_folded", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"email_folded": bson.M{"$exists": true}}),
+		},
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "nickname", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"nickname": bson.M{"$gt": ""}}),
+		},
+	)
+
+	// The search terms are already normalized, no stemming nor stop words must be applied to them.
+	indexes = append(indexes, mongo.IndexModel{
+		Keys:    bson.D{{Key: "search_terms", Value: "text"}},
+		Options: options.Index().SetDefaultLanguage("none"),
+	})
+
+	if _, err := u.collection.Indexes().CreateMany(ctx, indexes); err != nil {
+		return err
+	}
+
+	_, err := u.outbox.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "sequence", Value: 1}}},
+		{Keys: bson.D{{Key: "delivered_at", Value: 1}, {Key: "sequence", Value: 1}}},
+	})
+	if err != nil {
+		return err
 	}
 
+	// A webhook gets a single delivery of every change, even if it's broadcast more times.
+	_, err = u.deliveries.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "event_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "updated_at", Value: 1}}},
+	})
+	return err
+}
+
+// Client returns the client the repository is connected with.
+func (u *UserRepositoryMongoImpl) Client() *mongo.Client {
+	return u.collection.Database().Client()
+}
+
+func (u *UserRepositoryMongoImpl) Ping(ctx context.Context) error {
+	return u.Client().Ping(ctx, nil)
+}
+
+func (u *UserRepositoryMongoImpl) AddUser(ctx context.Context, user *repositories.User) (*repositories.User, error) {
+	log.Printf("Adding a user to the database")
+
 	if err := addHashedPassword(user); err != nil {
 		return nil, err
 	}
 
 	setCreationTime(user)
+	user.Id = primitive.NewObjectID()
+	user.Version = 1
 
-	insertedUserID, err := u.collection.InsertOne(ctx, user)
-	if err != nil {
-		return nil, err
-	}
+	err := u.withTransaction(ctx, func(ctx mongo.SessionContext) error {
+		if err := userAlreadyExists(ctx, u.collection, user.Nickname, user.Email, user.Id); err != nil {
+			return err
+		}
 
-	insertedObjectID, ok := insertedUserID.InsertedID.(primitive.ObjectID)
-	if !ok {
-		return nil, errors.New("failed to convert the insertedID to an ObjectID")
-	}
+		if _, err := u.collection.InsertOne(ctx, newUserDocument(user)); err != nil {
+			return err
+		}
 
-	user.Id = insertedObjectID
+		return u.addOutboxEntry(ctx, notifier.ChangeOperationInsert, nil, user)
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
 
 	return user, nil
 }
 
-func (u *UserRepositoryMongoImpl) UpdateUser(ctx context.Context, user *repositories.User) (*repositories.User, error) {
+func (u *UserRepositoryMongoImpl) UpdateUser(ctx context.Context, user *repositories.User) (*repositories.User, *repositories.User, error) {
 	log.Printf("Updating user (%s) in the database", user.Id.Hex())
 
 	updatedFields, err := createUpdatedUser(user)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	updatedResult, err := u.collection.UpdateOne(ctx, bson.M{"_id": user.Id}, updatedFields)
+	updatedUserResult := &repositories.User{}
+	storedUser := &repositories.User{}
+	err = u.withTransaction(ctx, func(ctx mongo.SessionContext) error {
+		// The user is returned as it was right before the update, the users are decoded again if the transaction is retried.
+		*storedUser, *updatedUserResult = repositories.User{}, repositories.User{}
+		err := u.collection.FindOneAndUpdate(ctx,
+			versionFilter(user.Id, user.Version),
+			updatedFields,
+			options.FindOneAndUpdate().SetReturnDocument(options.Before),
+		).Decode(storedUser)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return u.notFoundOrMismatch(ctx, user.Id)
+		}
+		if err != nil {
+			return err
+		}
+
+		updatedUser := u.collection.FindOne(ctx, bson.M{"_id": user.Id})
+		if updatedUser.Err() != nil {
+			return updatedUser.Err()
+		}
+		if err := updatedUser.Decode(updatedUserResult); err != nil {
+			return err
+		}
+		// Aborting the transaction the user isn't updated.
+		if err := userAlreadyExists(ctx, u.collection, updatedUserResult.Nickname, updatedUserResult.Email, user.Id); err != nil {
+			return err
+		}
+
+		// The search terms depend on the stored values of the fields that haven't been updated.
+		_, err = u.collection.UpdateOne(ctx, bson.M{"_id": user.Id}, searchFieldsUpdate(updatedUserResult))
+		if err != nil {
+			return err
+		}
+
+		return u.addOutboxEntry(ctx, notifier.ChangeOperationUpdate, storedUser, updatedUserResult)
+	})
 	if err != nil {
-		return nil, err
+		return nil, nil, translateError(err)
 	}
 
-	if updatedResult.MatchedCount == 0 {
-		return nil, ErrUserNotFound
-	}
+	return updatedUserResult, storedUser, nil
+}
 
-	updatedUser := u.collection.FindOne(ctx, bson.M{"_id": user.Id})
-	if updatedUser.Err() != nil {
-		return nil, updatedUser.Err()
+// BackfillSearchTerms computes the search terms and the folded email of the users stored before
+// they were introduced, it can be run at every startup.
+func (u *UserRepositoryMongoImpl) BackfillSearchTerms(ctx context.Context) error {
+	missing := bson.A{
+		bson.M{"search_terms": bson.M{"$exists": false}},
+		bson.M{"email_folded": bson.M{"$exists": false}},
 	}
-	updatedUserResult := &repositories.User{}
-	err = updatedUser.Decode(updatedUserResult)
+	cursor, err := u.collection.Find(ctx, bson.M{"$or": missing})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return updatedUserResult, nil
+	var users []*repositories.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return err
+	}
+
+	if len(users) > 0 {
+		log.Printf("Computing the search terms of %d users in the database", len(users))
+	}
+
+	for _, user := range users {
+		_, err := u.collection.UpdateOne(ctx, bson.M{"_id": user.Id, "$or": missing}, searchFieldsUpdate(user))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (u *UserRepositoryMongoImpl) RemoveUser(ctx context.Context, id string) error {
+func (u *UserRepositoryMongoImpl) RemoveUser(ctx context.Context, id string, expectedVersion int64) (*repositories.User, error) {
 	log.Printf("Removing user (%s) from the database", id)
 
 	objectId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	storedUser := &repositories.User{}
+	err = u.withTransaction(ctx, func(ctx mongo.SessionContext) error {
+		*storedUser = repositories.User{}
+		err := u.collection.FindOneAndDelete(ctx, versionFilter(objectId, expectedVersion)).Decode(storedUser)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return u.notFoundOrMismatch(ctx, objectId)
+		}
+		if err != nil {
+			return err
+		}
+
+		return u.addOutboxEntry(ctx, notifier.ChangeOperationDelete, storedUser, nil)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	deletedResult, err := u.collection.DeleteOne(ctx, bson.M{"_id": objectId})
+	return storedUser, nil
+}
+
+// BackfillVersions sets the version of the users stored before they were introduced,
+// it can be run at every startup.
+func (u *UserRepositoryMongoImpl) BackfillVersions(ctx context.Context) error {
+	result, err := u.collection.UpdateMany(ctx,
+		bson.M{"version": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"version": 1}},
+	)
 	if err != nil {
 		return err
 	}
 
-	if deletedResult.DeletedCount == 0 {
-		return ErrUserNotFound
+	if result.ModifiedCount > 0 {
+		log.Printf("Set the version of %d users in the database", result.ModifiedCount)
+	}
+
+	return nil
+}
+
+// BackfillEmptyFields unsets the clearable fields of the users stored empty before they were left out
+// of the documents, so that the empty fields are sorted alike, it can be run at every startup. The users
+// are migrated MIGRATION_BATCH_SIZE at a time, and tagged with MIGRATED_AT_FIELD since they aren't changed.
+func (u *UserRepositoryMongoImpl) BackfillEmptyFields(ctx context.Context) error {
+	emptyFields := bson.A{}
+	for _, field := range repositories.CLEARABLE_FIELDS {
+		emptyFields = append(emptyFields, bson.M{field: ""})
+	}
+
+	var unset int64
+	for {
+		cursor, err := u.collection.Find(ctx, bson.M{"$or": emptyFields},
+			options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(MIGRATION_BATCH_SIZE))
+		if err != nil {
+			return err
+		}
+		var batch []struct {
+			Id primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.All(ctx, &batch); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		ids := make(bson.A, len(batch))
+		for i, user := range batch {
+			ids[i] = user.Id
+		}
+		for _, field := range repositories.CLEARABLE_FIELDS {
+			result, err := u.collection.UpdateMany(ctx,
+				bson.M{"_id": bson.M{"$in": ids}, field: ""},
+				bson.M{"$unset": bson.M{field: ""}, "$set": bson.M{MIGRATED_AT_FIELD: time.Now()}},
+			)
+			if err != nil {
+				return err
+			}
+			unset += result.ModifiedCount
+		}
+	}
+
+	if unset > 0 {
+		log.Printf("Unset %d empty fields of the users in the database", unset)
 	}
 
 	return nil
 }
 
+// versionFilter selects the user with the id, only if it has the expected version when that isn't zero.
+func versionFilter(id primitive.ObjectID, expectedVersion int64) bson.M {
+	userFilter := bson.M{"_id": id}
+	if expectedVersion != 0 {
+		userFilter["version"] = expectedVersion
+	}
+	return userFilter
+}
+
+// notFoundOrMismatch tells why the user with the id wasn't selected by its versionFilter.
+func (u *UserRepositoryMongoImpl) notFoundOrMismatch(ctx context.Context, id primitive.ObjectID) error {
+	count, err := u.collection.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrUserNotFound
+	}
+	return ErrVersionMismatch
+}
+
+func (u *UserRepositoryMongoImpl) GetUser(ctx context.Context, id string) (*repositories.User, error) {
+	log.Printf("Getting user (%s) from the database", id)
+
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	result := u.collection.FindOne(ctx, bson.M{"_id": objectId})
+	if errors.Is(result.Err(), mongo.ErrNoDocuments) {
+		return nil, ErrUserNotFound
+	}
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	user := &repositories.User{}
+	err = result.Decode(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
 func (u *UserRepositoryMongoImpl) GetUsers(ctx context.Context, userFilter *filter.UserFilter, limit, offset *int64) ([]*repositories.User, error) {
 
 	log.Printf("Getting users from the database with filters: %+v", userFilter.ToBSON())
@@ -124,11 +398,29 @@ func (u *UserRepositoryMongoImpl) GetUsers(ctx context.Context, userFilter *filt
 		offset = int64Ptr(0)
 	}
 
-	cursor, err := u.collection.Find(ctx, userFilter.ToBSON(), &options.FindOptions{
-		Limit: limit,
-		Skip:  offset,
-		Sort:  bson.D{{Key: "created_at", Value: -1}},
-	})
+	var cursor *mongo.Cursor
+	var err error
+	if userFilter.RanksByRelevance() {
+		// The text score depends on how many terms the users have, they're ranked by the words they match
+		// instead, as on the other backends.
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: userFilter.ToBSON()}},
+			{{Key: "$addFields", Value: bson.M{filter.SEARCH_SCORE_FIELD: userFilter.SearchScoreBSON()}}},
+			{{Key: "$sort", Value: userFilter.SortBSON()}},
+			{{Key: "$skip", Value: *offset}},
+		}
+		// As with Find, a zero limit returns all the users.
+		if *limit > 0 {
+			pipeline = append(pipeline, bson.D{{Key: "$limit", Value: *limit}})
+		}
+		cursor, err = u.collection.Aggregate(ctx, pipeline)
+	} else {
+		cursor, err = u.collection.Find(ctx, userFilter.ToBSON(), &options.FindOptions{
+			Limit: limit,
+			Skip:  offset,
+			Sort:  userFilter.SortBSON(),
+		})
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -142,6 +434,26 @@ func (u *UserRepositoryMongoImpl) GetUsers(ctx context.Context, userFilter *filt
 	return users, nil
 }
 
+func (u *UserRepositoryMongoImpl) CountUsers(ctx context.Context, userFilter *filter.UserFilter) (int64, error) {
+	log.Printf("Counting users in the database with filters: %+v", userFilter.ToBSON())
+
+	return u.collection.CountDocuments(ctx, userFilter.ToBSON())
+}
+
+// withTransaction runs the function in a transaction, which is retried on transient errors.
+func (u *UserRepositoryMongoImpl) withTransaction(ctx context.Context, fn func(ctx mongo.SessionContext) error) error {
+	session, err := u.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
+
 func int64Ptr(value int64) *int64 {
 	return &value
 }
@@ -162,7 +474,7 @@ func createUpdatedUser(user *repositories.User) (bson.M, error) {
 	}
 
 	if user.Password != "" {
-		hashedPassword, err := hashPassword(user.Password)
+		hashedPassword, err := repositories.HashPassword(user.Password)
 		if err != nil {
 			return nil, err
 		}
@@ -177,17 +489,43 @@ func createUpdatedUser(user *repositories.User) (bson.M, error) {
 		updateFields["country"] = user.Country
 	}
 
-	if len(updateFields) == 0 {
+	if err := user.ValidateClearedFields(); err != nil {
+		return nil, err
+	}
+	clearedFields := bson.M{}
+	for _, field := range user.ClearedFields {
+		clearedFields[field] = ""
+	}
+
+	if len(updateFields) == 0 && len(clearedFields) == 0 {
 		return nil, ErrNothingToUpdate
 	}
 
 	updateFields["updated_at"] = time.Now()
 
-	return bson.M{"$set": updateFields}, nil
+	update := bson.M{"$set": updateFields, "$inc": bson.M{"version": 1}}
+	if len(clearedFields) > 0 {
+		update["$unset"] = clearedFields
+	}
+	return update, nil
 }
 
-func userAlreadyExists(ctx context.Context, collection *mongo.Collection, nickname, email string) error {
-	count, err := collection.CountDocuments(ctx, bson.M{"nickname": nickname, "email": email})
+// searchFieldsUpdate sets the fields of the stored user derived from its values.
+func searchFieldsUpdate(user *repositories.User) bson.M {
+	document := newUserDocument(user)
+	return bson.M{"$set": bson.M{"search_terms": document.SearchTerms, "email_folded": document.EmailFolded}}
+}
+
+// userAlreadyExists returns ErrUserAlreadyExist if a user other than the excluded one has the email,
+// regardless of its case, or the nickname, the users without a nickname don't share it. The unique
+// indexes reject the duplicates written concurrently, it only spares the failing writes.
+func userAlreadyExists(ctx context.Context, collection *mongo.Collection, nickname, email string, excludedId primitive.ObjectID) error {
+	taken := bson.A{bson.M{"email_folded": filter.FoldCase(email)}}
+	if nickname != "" {
+		taken = append(taken, bson.M{"nickname": nickname})
+	}
+
+	count, err := collection.CountDocuments(ctx, bson.M{"$or": taken, "_id": bson.M{"$ne": excludedId}})
 	if err != nil {
 		return err
 	}
@@ -199,8 +537,16 @@ func userAlreadyExists(ctx context.Context, collection *mongo.Collection, nickna
 	return nil
 }
 
+// translateError reports the writes rejected by the unique indexes of the users as ErrUserAlreadyExist.
+func translateError(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrUserAlreadyExist
+	}
+	return err
+}
+
 func addHashedPassword(user *repositories.User) error {
-	hashedPassword, err := hashPassword(user.Password)
+	hashedPassword, err := repositories.HashPassword(user.Password)
 	if err != nil {
 		return err
 	}
@@ -209,14 +555,6 @@ func addHashedPassword(user *repositories.User) error {
 	return nil
 }
 
-func hashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	return string(hashedPassword), nil
-}
-
 func setCreationTime(user *repositories.User) {
 	now := time.Now()
 	user.CreatedAt = now
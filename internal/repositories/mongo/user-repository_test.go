@@ -2,12 +2,15 @@ package repositories
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
 	filter "github.com/dlion/faceit_challenge/internal"
 	"github.com/dlion/faceit_challenge/internal/repositories"
+	"github.com/dlion/faceit_challenge/internal/repositories/repotest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go/modules/mongodb"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -16,12 +19,122 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+func TestRepositoryConformance(t *testing.T) {
+	ctx := context.Background()
+
+	mongodbContainer, err := mongodb.Run(ctx, "mongo:7", mongodb.WithReplicaSet("rs"))
+	require.NoError(t, err, "failed to start container: %s", err)
+
+	defer func() {
+		err := mongodbContainer.Terminate(ctx)
+		assert.NoError(t, err, "failed to terminate container: %s", err)
+	}()
+
+	endpoint, err := mongodbContainer.ConnectionString(ctx)
+	require.NoError(t, err, "failed to get connection string: %s", err)
+
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint+"/?directConnection=true"))
+	require.NoError(t, err, "failed to connect to MongoDB: %s", err)
+
+	repotest.RunUserRepositorySuite(t, func(t *testing.T) repositories.UserRepository {
+		for _, collection := range []string{COLLECTION_NAME, OUTBOX_COLLECTION_NAME, COUNTERS_COLLECTION_NAME, WEBHOOKS_COLLECTION_NAME, DELIVERIES_COLLECTION_NAME} {
+			err := mongoClient.Database(DATABASE_NAME).Collection(collection).Drop(ctx)
+			assert.NoError(t, err, "failed to drop the collection: %s", err)
+		}
+
+		userRepo := NewUserRepositoryMongoImpl(mongoClient)
+		err := userRepo.CreateIndexes(ctx)
+		assert.NoError(t, err, "failed to create the indexes: %s", err)
+
+		return userRepo
+	})
+
+	t.Run("Sort the users with an index", func(t *testing.T) {
+		userRepo := NewUserRepositoryMongoImpl(mongoClient)
+		require.NoError(t, userRepo.CreateIndexes(ctx))
+
+		for _, sort := range []string{"-updated_at", "nickname"} {
+			sortFields, err := filter.ParseSort(sort)
+			require.NoError(t, err)
+			userFilter := filter.NewFilterBuilder().SortBy(sortFields...).Build()
+
+			var explanation bson.M
+			err = mongoClient.Database(DATABASE_NAME).RunCommand(ctx, bson.D{
+				{Key: "explain", Value: bson.D{
+					{Key: "find", Value: COLLECTION_NAME},
+					{Key: "filter", Value: userFilter.ToBSON()},
+					{Key: "sort", Value: userFilter.SortBSON()},
+				}},
+			}).Decode(&explanation)
+			require.NoError(t, err)
+
+			winningPlan := fmt.Sprint(explanation["queryPlanner"].(bson.M)["winningPlan"])
+			assert.Contains(t, winningPlan, "IXSCAN", sort)
+			assert.NotContains(t, winningPlan, "COLLSCAN", sort)
+		}
+	})
+
+	t.Run("Match the email ignoring the case of the users stored before the folded email", func(t *testing.T) {
+		users := mongoClient.Database(DATABASE_NAME).Collection(COLLECTION_NAME)
+		require.NoError(t, users.Drop(ctx))
+		userRepo := NewUserRepositoryMongoImpl(mongoClient)
+		require.NoError(t, userRepo.CreateIndexes(ctx))
+
+		id := primitive.NewObjectID()
+		_, err := users.InsertOne(ctx, bson.M{"_id": id, "nickname": "testNickname", "email": "Örjan@example.com"})
+		require.NoError(t, err)
+		require.NoError(t, userRepo.BackfillSearchTerms(ctx))
+
+		email := "öRJAN@EXAMPLE.COM"
+		userFilter := filter.NewFilterBuilder().ByEmailIgnoringCase(&email).Build()
+		storedUsers, err := userRepo.GetUsers(ctx, userFilter, nil, nil)
+		require.NoError(t, err)
+		require.Len(t, storedUsers, 1)
+		assert.Equal(t, id, storedUsers[0].Id)
+
+		var explanation bson.M
+		err = mongoClient.Database(DATABASE_NAME).RunCommand(ctx, bson.D{
+			{Key: "explain", Value: bson.D{
+				{Key: "find", Value: COLLECTION_NAME},
+				{Key: "filter", Value: userFilter.ToBSON()},
+			}},
+		}).Decode(&explanation)
+		require.NoError(t, err)
+		assert.Contains(t, fmt.Sprint(explanation["queryPlanner"].(bson.M)["winningPlan"]), "email_folded")
+	})
+
+	t.Run("Add only one of the users sharing the email concurrently", func(t *testing.T) {
+		require.NoError(t, mongoClient.Database(DATABASE_NAME).Collection(COLLECTION_NAME).Drop(ctx))
+		userRepo := NewUserRepositoryMongoImpl(mongoClient)
+		require.NoError(t, userRepo.CreateIndexes(ctx))
+
+		const concurrentUsers = 5
+		errs := make(chan error, concurrentUsers)
+		for i := 0; i < concurrentUsers; i++ {
+			go func(i int) {
+				_, err := userRepo.AddUser(ctx, repositories.NewRepoUser("", "", fmt.Sprintf("nickname%d", i), "testPassword", "testEmail@email.com", ""))
+				errs <- err
+			}(i)
+		}
+
+		var added int
+		for i := 0; i < concurrentUsers; i++ {
+			if err := <-errs; err == nil {
+				added++
+			} else {
+				assert.ErrorIs(t, err, ErrUserAlreadyExist)
+			}
+		}
+		assert.Equal(t, 1, added)
+	})
+}
+
 func TestRepository(t *testing.T) {
 	t.Run("AddUser", func(t *testing.T) {
 		t.Run("Add a new user", func(t *testing.T) {
 			ctx := context.Background()
 
-			mongodbContainer, err := mongodb.Run(ctx, "mongo:7")
+			mongodbContainer, err := mongodb.Run(ctx, "mongo:7", mongodb.WithReplicaSet("rs"))
 			assert.NoError(t, err, "failed to terminate container: %s", err)
 
 			defer func() {
@@ -32,7 +145,7 @@ func TestRepository(t *testing.T) {
 			endpoint, err := mongodbContainer.ConnectionString(ctx)
 			assert.NoError(t, err, "failed to get connection string: %s", err)
 
-			mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint))
+			mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint+"/?directConnection=true"))
 			assert.NoError(t, err, "failed to connect to MongoDB: %s", err)
 
 			err = mongoClient.Ping(ctx, nil)
@@ -73,7 +186,7 @@ func TestRepository(t *testing.T) {
 		t.Run("Return an error if the user already exist", func(t *testing.T) {
 			ctx := context.Background()
 
-			mongodbContainer, err := mongodb.Run(ctx, "mongo:7")
+			mongodbContainer, err := mongodb.Run(ctx, "mongo:7", mongodb.WithReplicaSet("rs"))
 			assert.NoError(t, err, "failed to terminate container: %s", err)
 
 			defer func() {
@@ -84,7 +197,7 @@ func TestRepository(t *testing.T) {
 			endpoint, err := mongodbContainer.ConnectionString(ctx)
 			assert.NoError(t, err, "failed to get connection string: %s", err)
 
-			mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint))
+			mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint+"/?directConnection=true"))
 			assert.NoError(t, err, "failed to ping MongoDB: %s", err)
 
 			err = mongoClient.Ping(ctx, nil)
@@ -122,7 +235,7 @@ func TestRepository(t *testing.T) {
 		t.Run("Modify an existing user", func(t *testing.T) {
 			ctx := context.Background()
 
-			mongodbContainer, err := mongodb.Run(ctx, "mongo:7")
+			mongodbContainer, err := mongodb.Run(ctx, "mongo:7", mongodb.WithReplicaSet("rs"))
 			assert.NoError(t, err, "failed to terminate container: %s", err)
 
 			defer func() {
@@ -133,7 +246,7 @@ func TestRepository(t *testing.T) {
 			endpoint, err := mongodbContainer.ConnectionString(ctx)
 			assert.NoError(t, err, "failed to get connection string: %s", err)
 
-			mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint))
+			mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint+"/?directConnection=true"))
 			assert.NoError(t, err, "failed to ping MongoDB: %s", err)
 
 			err = mongoClient.Ping(ctx, nil)
@@ -156,7 +269,7 @@ func TestRepository(t *testing.T) {
 			assert.NoError(t, err)
 
 			userRepo := NewUserRepositoryMongoImpl(mongoClient)
-			_, err = userRepo.UpdateUser(ctx, &repositories.User{
+			_, _, err = userRepo.UpdateUser(ctx, &repositories.User{
 				Id:        elementInserted.InsertedID.(primitive.ObjectID),
 				FirstName: "updatedFirstName",
 				LastName:  "testLastName",
@@ -187,7 +300,7 @@ func TestRepository(t *testing.T) {
 		t.Run("Return an error if the user doesn't exist", func(t *testing.T) {
 			ctx := context.Background()
 
-			mongodbContainer, err := mongodb.Run(ctx, "mongo:7")
+			mongodbContainer, err := mongodb.Run(ctx, "mongo:7", mongodb.WithReplicaSet("rs"))
 			assert.NoError(t, err, "failed to terminate container: %s", err)
 
 			defer func() {
@@ -198,14 +311,14 @@ func TestRepository(t *testing.T) {
 			endpoint, err := mongodbContainer.ConnectionString(ctx)
 			assert.NoError(t, err, "failed to get connection string: %s", err)
 
-			mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint))
+			mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint+"/?directConnection=true"))
 			assert.NoError(t, err, "failed to ping MongoDB: %s", err)
 
 			err = mongoClient.Ping(ctx, nil)
 			assert.NoError(t, err, "failed to ping MongoDB: %s", err)
 
 			userRepo := NewUserRepositoryMongoImpl(mongoClient)
-			_, err = userRepo.UpdateUser(ctx, &repositories.User{
+			_, _, err = userRepo.UpdateUser(ctx, &repositories.User{
 				Id:        primitive.NewObjectIDFromTimestamp(time.Now()),
 				FirstName: "updatedFirstName",
 				LastName:  "testLastName",
@@ -222,7 +335,7 @@ func TestRepository(t *testing.T) {
 		t.Run("Remove an existing user", func(t *testing.T) {
 			ctx := context.Background()
 
-			mongodbContainer, err := mongodb.Run(ctx, "mongo:7")
+			mongodbContainer, err := mongodb.Run(ctx, "mongo:7", mongodb.WithReplicaSet("rs"))
 			assert.NoError(t, err, "failed to terminate container: %s", err)
 
 			defer func() {
@@ -233,7 +346,7 @@ func TestRepository(t *testing.T) {
 			endpoint, err := mongodbContainer.ConnectionString(ctx)
 			assert.NoError(t, err, "failed to get connection string: %s", err)
 
-			mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint))
+			mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint+"/?directConnection=true"))
 			assert.NoError(t, err, "failed to ping MongoDB: %s", err)
 
 			err = mongoClient.Ping(ctx, nil)
@@ -258,7 +371,7 @@ func TestRepository(t *testing.T) {
 			assert.NoError(t, err)
 
 			userRepo := NewUserRepositoryMongoImpl(mongoClient)
-			err = userRepo.RemoveUser(ctx, objectID.Hex())
+			_, err = userRepo.RemoveUser(ctx, objectID.Hex(), 0)
 
 			assert.NoError(t, err)
 			result := mongoClient.
@@ -274,7 +387,7 @@ func TestRepository(t *testing.T) {
 		t.Run("Return an error if the user doesn't exist", func(t *testing.T) {
 			ctx := context.Background()
 
-			mongodbContainer, err := mongodb.Run(ctx, "mongo:7")
+			mongodbContainer, err := mongodb.Run(ctx, "mongo:7", mongodb.WithReplicaSet("rs"))
 			assert.NoError(t, err, "failed to terminate container: %s", err)
 
 			defer func() {
@@ -285,14 +398,14 @@ func TestRepository(t *testing.T) {
 			endpoint, err := mongodbContainer.ConnectionString(ctx)
 			assert.NoError(t, err, "failed to get connection string: %s", err)
 
-			mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint))
+			mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint+"/?directConnection=true"))
 			assert.NoError(t, err, "failed to ping MongoDB: %s", err)
 
 			err = mongoClient.Ping(ctx, nil)
 			assert.NoError(t, err, "failed to ping MongoDB: %s", err)
 
 			userRepo := NewUserRepositoryMongoImpl(mongoClient)
-			err = userRepo.RemoveUser(ctx, "randomId")
+			_, err = userRepo.RemoveUser(ctx, "randomId", 0)
 
 			assert.Error(t, err)
 		})
@@ -302,7 +415,7 @@ func TestRepository(t *testing.T) {
 		t.Run("Just a paginated list of users filtered by country", func(t *testing.T) {
 			ctx := context.Background()
 
-			mongodbContainer, err := mongodb.Run(ctx, "mongo:7")
+			mongodbContainer, err := mongodb.Run(ctx, "mongo:7", mongodb.WithReplicaSet("rs"))
 			assert.NoError(t, err, "failed to terminate container: %s", err)
 
 			defer func() {
@@ -313,7 +426,7 @@ func TestRepository(t *testing.T) {
 			endpoint, err := mongodbContainer.ConnectionString(ctx)
 			assert.NoError(t, err, "failed to get connection string: %s", err)
 
-			mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint))
+			mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint+"/?directConnection=true"))
 			assert.NoError(t, err, "failed to ping MongoDB: %s", err)
 
 			err = mongoClient.Ping(ctx, nil)
@@ -385,7 +498,7 @@ func TestRepository(t *testing.T) {
 		t.Run("Just a paginated list of users filtered by country and with an offset", func(t *testing.T) {
 			ctx := context.Background()
 
-			mongodbContainer, err := mongodb.Run(ctx, "mongo:7")
+			mongodbContainer, err := mongodb.Run(ctx, "mongo:7", mongodb.WithReplicaSet("rs"))
 			assert.NoError(t, err, "failed to terminate container: %s", err)
 
 			defer func() {
@@ -396,7 +509,7 @@ func TestRepository(t *testing.T) {
 			endpoint, err := mongodbContainer.ConnectionString(ctx)
 			assert.NoError(t, err, "failed to get connection string: %s", err)
 
-			mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint))
+			mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint+"/?directConnection=true"))
 			assert.NoError(t, err, "failed to ping MongoDB: %s", err)
 
 			err = mongoClient.Ping(ctx, nil)
@@ -0,0 +1,162 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (u *UserRepositoryMongoImpl) AddWebhook(ctx context.Context, webhook *repositories.Webhook) (*repositories.Webhook, error) {
+	if _, err := u.webhooks.InsertOne(ctx, webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (u *UserRepositoryMongoImpl) GetWebhook(ctx context.Context, id string) (*repositories.Webhook, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook := &repositories.Webhook{}
+	err = u.webhooks.FindOne(ctx, bson.M{"_id": objectId}).Decode(webhook)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, repositories.ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+func (u *UserRepositoryMongoImpl) GetWebhooks(ctx context.Context) ([]*repositories.Webhook, error) {
+	cursor, err := u.webhooks.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := []*repositories.Webhook{}
+	if err := cursor.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (u *UserRepositoryMongoImpl) RemoveWebhook(ctx context.Context, id string) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := u.webhooks.DeleteOne(ctx, bson.M{"_id": objectId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return repositories.ErrWebhookNotFound
+	}
+
+	_, err = u.deliveries.DeleteMany(ctx, bson.M{"webhook_id": objectId})
+	return err
+}
+
+func (u *UserRepositoryMongoImpl) AddDelivery(ctx context.Context, delivery *repositories.WebhookDelivery) error {
+	_, err := u.deliveries.InsertOne(ctx, delivery)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+func (u *UserRepositoryMongoImpl) GetDelivery(ctx context.Context, id string) (*repositories.WebhookDelivery, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := &repositories.WebhookDelivery{}
+	err = u.deliveries.FindOne(ctx, bson.M{"_id": objectId}).Decode(delivery)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, repositories.ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+func (u *UserRepositoryMongoImpl) GetDeliveries(ctx context.Context, query repositories.DeliveryQuery) ([]*repositories.WebhookDelivery, error) {
+	filter := bson.M{}
+	if !query.WebhookId.IsZero() {
+		filter["webhook_id"] = query.WebhookId
+	}
+	if query.Status != "" {
+		filter["status"] = query.Status
+	}
+
+	return u.findDeliveries(ctx, filter, bson.D{{Key: "_id", Value: -1}}, query.Limit)
+}
+
+func (u *UserRepositoryMongoImpl) DueDeliveries(ctx context.Context, now time.Time, limit int64) ([]*repositories.WebhookDelivery, error) {
+	return u.findDeliveries(ctx,
+		bson.M{"status": repositories.DeliveryPending, "next_attempt_at": bson.M{"$lte": now}},
+		bson.D{{Key: "next_attempt_at", Value: 1}, {Key: "_id", Value: 1}},
+		limit,
+	)
+}
+
+func (u *UserRepositoryMongoImpl) findDeliveries(ctx context.Context, filter bson.M, sort bson.D, limit int64) ([]*repositories.WebhookDelivery, error) {
+	cursor, err := u.deliveries.Find(ctx, filter, &options.FindOptions{Limit: &limit, Sort: sort})
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []*repositories.WebhookDelivery
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (u *UserRepositoryMongoImpl) RecordAttempt(ctx context.Context, id primitive.ObjectID, attempt repositories.WebhookAttempt, status string, nextAttemptAt time.Time) error {
+	return u.updateDelivery(ctx, id, bson.M{
+		"$push": bson.M{"attempts": attempt},
+		"$set":  bson.M{"status": status, "next_attempt_at": nextAttemptAt, "updated_at": time.Now()},
+	})
+}
+
+func (u *UserRepositoryMongoImpl) RetryDelivery(ctx context.Context, id primitive.ObjectID, nextAttemptAt time.Time) error {
+	return u.updateDelivery(ctx, id, bson.M{
+		"$set": bson.M{"status": repositories.DeliveryPending, "next_attempt_at": nextAttemptAt, "updated_at": time.Now()},
+	})
+}
+
+func (u *UserRepositoryMongoImpl) PurgeDeliveries(ctx context.Context, before time.Time) error {
+	_, err := u.deliveries.DeleteMany(ctx, bson.M{
+		"status":     bson.M{"$in": bson.A{repositories.DeliverySucceeded, repositories.DeliveryDead}},
+		"updated_at": bson.M{"$lt": before},
+	})
+	return err
+}
+
+func (u *UserRepositoryMongoImpl) updateDelivery(ctx context.Context, id primitive.ObjectID, update bson.M) error {
+	result, err := u.deliveries.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return repositories.ErrDeliveryNotFound
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrSequenceCompacted is returned when the changes following a sequence number have been purged.
+var ErrSequenceCompacted = errors.New("the changes following the sequence number have been purged")
+
+// OutboxEntry is a change of a user, recorded in the same transaction of the change
+// and waiting to be published until it's marked as delivered.
+type OutboxEntry struct {
+	Id primitive.ObjectID `bson:"_id"`
+	// Sequence numbers the changes from 1 without gaps, in the order they're committed.
+	Sequence int64 `bson:"sequence"`
+	// OperationType is one of the notifier.ChangeOperation constants.
+	OperationType string   `bson:"operation_type"`
+	UserId        string   `bson:"user_id"`
+	ChangedFields []string `bson:"changed_fields"`
+	// Before and After are the user as it was stored before and after the change, without its password.
+	Before      *notifier.UserSnapshot `bson:"before"`
+	After       *notifier.UserSnapshot `bson:"after"`
+	CreatedAt   time.Time              `bson:"created_at"`
+	DeliveredAt *time.Time             `bson:"delivered_at"`
+}
+
+// NewOutboxEntry records the change of a user from its stored values before and after the change,
+// before is nil for an inserted user and after for a deleted one.
+func NewOutboxEntry(sequence int64, operationType string, before, after *User) *OutboxEntry {
+	user := after
+	if user == nil {
+		user = before
+	}
+
+	return &OutboxEntry{
+		Id:            primitive.NewObjectID(),
+		Sequence:      sequence,
+		OperationType: operationType,
+		UserId:        user.Id.Hex(),
+		ChangedFields: ChangedFields(before, after),
+		Before:        before.Snapshot(),
+		After:         after.Snapshot(),
+		CreatedAt:     time.Now(),
+	}
+}
+
+// ChangeData returns the notification of the change, identified by the id of the entry.
+func (e *OutboxEntry) ChangeData() notifier.ChangeData {
+	return notifier.ChangeData{
+		EventId:       e.Id.Hex(),
+		Sequence:      e.Sequence,
+		Timestamp:     e.CreatedAt,
+		OperationType: e.OperationType,
+		UserId:        e.UserId,
+		ChangedFields: e.ChangedFields,
+		Before:        e.Before,
+		After:         e.After,
+	}
+}
+
+// Outbox is the list of the changes recorded by a UserRepository: every user added,
+// updated or removed gets an entry atomically with the change itself.
+type Outbox interface {
+	// PendingEntries returns at most limit entries not delivered yet, in sequence order.
+	PendingEntries(ctx context.Context, limit int64) ([]*OutboxEntry, error)
+	// MarkDelivered marks the entries as delivered, they're no longer returned as pending.
+	MarkDelivered(ctx context.Context, ids ...primitive.ObjectID) error
+	// PurgeDelivered deletes the entries delivered before the given time.
+	PurgeDelivered(ctx context.Context, before time.Time) error
+}
+
+// ChangeLog is the history of the changes recorded in the Outbox, the delivered entries
+// are kept until they're purged.
+type ChangeLog interface {
+	// ChangesSince returns at most limit entries following the sequence number, in sequence order,
+	// or ErrSequenceCompacted if the one right after it has been purged.
+	ChangesSince(ctx context.Context, sequence int64, limit int64) ([]*OutboxEntry, error)
+}
+
+// PublishCursors records how far every publisher of the changes got in the ChangeLog, so each one
+// publishes its own backlog whatever the state of the others.
+type PublishCursors interface {
+	// DeliveredSequence returns the sequence number preceding the first pending entry of the Outbox,
+	// or the last one assigned if none is pending: the position of a publisher seeing the changes
+	// from the next relay on.
+	DeliveredSequence(ctx context.Context) (int64, error)
+	// PublishedSequence returns the sequence number of the last change published by the publisher,
+	// and false if none has been recorded for it.
+	PublishedSequence(ctx context.Context, publisher string) (int64, bool, error)
+	// SetPublishedSequence records the sequence number of the last change published by the publisher.
+	SetPublishedSequence(ctx context.Context, publisher string, sequence int64) error
+}
+
+// CheckNotCompacted returns ErrSequenceCompacted if the entries following the sequence number,
+// read from a change log whose last assigned sequence number is lastSequence, miss the first one.
+func CheckNotCompacted(sequence, lastSequence int64, entries []*OutboxEntry) error {
+	if sequence >= lastSequence {
+		return nil
+	}
+	if len(entries) == 0 || entries[0].Sequence != sequence+1 {
+		return ErrSequenceCompacted
+	}
+	return nil
+}
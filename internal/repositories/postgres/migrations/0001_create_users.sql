@@ -0,0 +1,20 @@
+CREATE TABLE users (
+    id         CHAR(24)    PRIMARY KEY,
+    first_name TEXT        NOT NULL DEFAULT '',
+    last_name  TEXT        NOT NULL DEFAULT '',
+    nickname   TEXT        NOT NULL DEFAULT '',
+    password   TEXT        NOT NULL,
+    email      TEXT        NOT NULL,
+    country    TEXT        NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX users_email_key ON users (email);
+
+-- The nickname is optional, only the users having one must not share it.
+CREATE UNIQUE INDEX users_nickname_key ON users (nickname) WHERE nickname <> '';
+
+CREATE INDEX users_created_at_idx ON users (created_at DESC);
+
+CREATE INDEX users_country_idx ON users (country);
@@ -0,0 +1,20 @@
+-- Strings are sorted byte by byte, as MongoDB does, regardless of the locale of the database.
+ALTER TABLE users
+    ALTER COLUMN first_name TYPE TEXT COLLATE "C",
+    ALTER COLUMN last_name TYPE TEXT COLLATE "C",
+    ALTER COLUMN nickname TYPE TEXT COLLATE "C",
+    ALTER COLUMN email TYPE TEXT COLLATE "C",
+    ALTER COLUMN country TYPE TEXT COLLATE "C";
+
+-- Every sortable field is indexed together with the id breaking its ties,
+-- the indexes are scanned backwards for the descending sorts.
+DROP INDEX users_created_at_idx;
+DROP INDEX users_country_idx;
+
+CREATE INDEX users_first_name_sort_idx ON users (first_name, id);
+CREATE INDEX users_last_name_sort_idx ON users (last_name, id);
+CREATE INDEX users_nickname_sort_idx ON users (nickname, id);
+CREATE INDEX users_email_sort_idx ON users (email, id);
+CREATE INDEX users_country_sort_idx ON users (country, id);
+CREATE INDEX users_created_at_sort_idx ON users (created_at, id);
+CREATE INDEX users_updated_at_sort_idx ON users (updated_at, id);
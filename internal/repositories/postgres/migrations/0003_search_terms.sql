@@ -0,0 +1,3 @@
+-- The terms the users are found by with a full-text search, computed by the repository
+-- from their names, nickname and email as a space separated list surrounded by spaces.
+ALTER TABLE users ADD COLUMN search_terms TEXT NOT NULL DEFAULT '';
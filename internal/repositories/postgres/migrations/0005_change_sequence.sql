@@ -0,0 +1,19 @@
+-- The outbox entries are numbered by a single counter, incremented in the transaction of every
+-- change: the concurrent changes wait for each other, so the numbers follow the commit order.
+CREATE TABLE change_sequence (
+    value BIGINT NOT NULL
+);
+
+ALTER TABLE outbox ADD COLUMN sequence BIGINT NOT NULL DEFAULT 0;
+
+UPDATE outbox SET sequence = numbered.sequence
+FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY created_at, id) AS sequence FROM outbox) AS numbered
+WHERE outbox.id = numbered.id;
+
+INSERT INTO change_sequence (value) SELECT COALESCE(MAX(sequence), 0) FROM outbox;
+
+CREATE UNIQUE INDEX outbox_sequence_key ON outbox (sequence);
+
+DROP INDEX outbox_pending_idx;
+
+CREATE INDEX outbox_pending_idx ON outbox (sequence) WHERE delivered_at IS NULL;
@@ -0,0 +1,5 @@
+-- The details of the changes: the names of the changed fields, separated by commas, and the user
+-- before and after the change as JSON, without its password. The changes recorded before are kept without them.
+ALTER TABLE outbox ADD COLUMN changed_fields TEXT NOT NULL DEFAULT '';
+ALTER TABLE outbox ADD COLUMN before_snapshot TEXT;
+ALTER TABLE outbox ADD COLUMN after_snapshot TEXT;
@@ -0,0 +1,27 @@
+-- The endpoints the changes of the users are posted to. The event types are separated by commas,
+-- all the changes are posted if they're empty.
+CREATE TABLE webhooks (
+    id          CHAR(24)    PRIMARY KEY,
+    url         TEXT        NOT NULL,
+    event_types TEXT        NOT NULL DEFAULT '',
+    secret      TEXT        NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL
+);
+
+-- The changes to be posted to every webhook, with the attempts made so far as JSON.
+CREATE TABLE webhook_deliveries (
+    id              CHAR(24)    PRIMARY KEY,
+    webhook_id      CHAR(24)    NOT NULL,
+    event_id        TEXT        NOT NULL,
+    change_data     TEXT        NOT NULL,
+    status          TEXT        NOT NULL,
+    attempts        TEXT        NOT NULL DEFAULT '[]',
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL,
+    updated_at      TIMESTAMPTZ NOT NULL
+);
+
+-- A webhook gets a single delivery of every change, even if it's broadcast more times.
+CREATE UNIQUE INDEX webhook_deliveries_event_key ON webhook_deliveries (webhook_id, event_id);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
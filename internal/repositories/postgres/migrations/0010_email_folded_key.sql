@@ -0,0 +1,5 @@
+-- The emails are unique regardless of the case of the letters, as on MongoDB.
+-- The users stored before the folded email have it empty until it's computed at startup.
+DROP INDEX users_email_folded_idx;
+
+CREATE UNIQUE INDEX users_email_folded_key ON users (email_folded) WHERE email_folded <> '';
@@ -0,0 +1,62 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	"github.com/dlion/faceit_challenge/internal/repositories/sqlstore"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const uniqueViolationCode = "23505"
+
+var (
+	ErrUserAlreadyExist = repositories.ErrUserAlreadyExist
+	ErrUserNotFound     = repositories.ErrUserNotFound
+	ErrNothingToUpdate  = repositories.ErrNothingToUpdate
+	ErrVersionMismatch  = repositories.ErrVersionMismatch
+	ErrNotClearable     = repositories.ErrNotClearable
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var Dialect = sqlstore.Dialect{
+	Name: "PostgreSQL",
+	Placeholder: func(n int) string {
+		return fmt.Sprintf("$%d", n)
+	},
+	IsUniqueViolation: func(err error) bool {
+		var pgErr *pgconn.PgError
+		return errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode
+	},
+	// Serialises concurrent replicas migrating the same database.
+	LockMigrations: "LOCK TABLE schema_migrations IN EXCLUSIVE MODE",
+	LockForUpdate:  "FOR UPDATE",
+}
+
+type UserRepositoryPostgresImpl struct {
+	*sqlstore.UserRepositorySQLImpl
+}
+
+func NewUserRepositoryPostgresImpl(db *sql.DB) *UserRepositoryPostgresImpl {
+	return &UserRepositoryPostgresImpl{UserRepositorySQLImpl: sqlstore.NewUserRepositorySQLImpl(db, Dialect)}
+}
+
+// Migrate creates or upgrades the schema using the migrations embedded from the migrations directory.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	return sqlstore.Migrate(ctx, db, Dialect, migrations())
+}
+
+func migrations() fs.FS {
+	migrations, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		panic(err)
+	}
+	return migrations
+}
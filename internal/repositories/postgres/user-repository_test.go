@@ -0,0 +1,72 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	"github.com/dlion/faceit_challenge/internal/repositories/repotest"
+	"github.com/dlion/faceit_challenge/internal/repositories/sqlstore"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+)
+
+func TestRepository(t *testing.T) {
+	ctx := context.Background()
+
+	postgresContainer, err := postgres.Run(ctx, "postgres:16-alpine",
+		postgres.WithDatabase("faceit"),
+		postgres.WithUsername("postgres"),
+		postgres.WithPassword("postgres"),
+		postgres.BasicWaitStrategies(),
+	)
+	require.NoError(t, err, "failed to start container: %s", err)
+
+	defer func() {
+		err := postgresContainer.Terminate(ctx)
+		assert.NoError(t, err, "failed to terminate container: %s", err)
+	}()
+
+	dsn, err := postgresContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err, "failed to get connection string: %s", err)
+
+	db, err := sql.Open("pgx", dsn)
+	require.NoError(t, err, "failed to open the database: %s", err)
+	defer db.Close()
+
+	t.Run("Apply the migrations only once", func(t *testing.T) {
+		require.NoError(t, Migrate(ctx, db))
+		require.NoError(t, Migrate(ctx, db))
+
+		migrations, err := sqlstore.LoadMigrations(migrations())
+		require.NoError(t, err)
+
+		var appliedCount int
+		err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations").Scan(&appliedCount)
+		assert.NoError(t, err)
+		assert.Equal(t, len(migrations), appliedCount)
+	})
+
+	t.Run("Return ErrUserAlreadyExist if the email is already used", func(t *testing.T) {
+		_, err := db.ExecContext(ctx, "TRUNCATE "+sqlstore.USERS_TABLE)
+		require.NoError(t, err)
+
+		userRepo := NewUserRepositoryPostgresImpl(db)
+		_, err = userRepo.AddUser(ctx, repositories.NewRepoUser("", "", "nickname", "testPassword", "testEmail@email.com", ""))
+		require.NoError(t, err)
+
+		_, err = userRepo.AddUser(ctx, repositories.NewRepoUser("", "", "otherNickname", "testPassword", "testEmail@email.com", ""))
+		assert.ErrorIs(t, err, ErrUserAlreadyExist)
+	})
+
+	repotest.RunUserRepositorySuite(t, func(t *testing.T) repositories.UserRepository {
+		_, err := db.ExecContext(ctx, "TRUNCATE "+strings.Join([]string{sqlstore.USERS_TABLE, sqlstore.OUTBOX_TABLE, sqlstore.PUBLISH_CURSORS_TABLE, sqlstore.WEBHOOKS_TABLE, sqlstore.DELIVERIES_TABLE}, ", "))
+		require.NoError(t, err, "failed to truncate the users: %s", err)
+
+		return NewUserRepositoryPostgresImpl(db)
+	})
+}
@@ -2,13 +2,33 @@ package repositories
 
 import (
 	"context"
+	"errors"
 
 	filter "github.com/dlion/faceit_challenge/internal"
 )
 
+var (
+	ErrUserAlreadyExist = errors.New("the user already exist in the db")
+	ErrUserNotFound     = errors.New("the user doesn't exist in the db")
+	ErrNothingToUpdate  = errors.New("there's anything to be update")
+	ErrVersionMismatch  = errors.New("the version of the user doesn't match the expected one")
+	ErrNotClearable     = errors.New("the field can't be cleared")
+)
+
+// UserRepository stores the users. A user is a duplicate of another one if it has the same email, regardless
+// of its case, or the same nickname, the users without a nickname aren't duplicates: adding or updating a
+// user as a duplicate fails with ErrUserAlreadyExist.
 type UserRepository interface {
 	AddUser(context.Context, *User) (*User, error)
-	UpdateUser(context.Context, *User) (*User, error)
-	RemoveUser(context.Context, string) error
+	// UpdateUser sets the non empty fields of the user and empties its ClearedFields, if its Version
+	// is the stored one or zero, and increments the version. It returns the user after the update
+	// and as it was right before it, read in the same atomic operation.
+	UpdateUser(context.Context, *User) (updated *User, previous *User, err error)
+	// RemoveUser removes the user with the id, if its version is the expected one or that is zero,
+	// and returns it as it was stored.
+	RemoveUser(ctx context.Context, id string, expectedVersion int64) (*User, error)
+	GetUser(context.Context, string) (*User, error)
 	GetUsers(context.Context, *filter.UserFilter, *int64, *int64) ([]*User, error)
+	// CountUsers returns how many users match the filter, regardless of any pagination.
+	CountUsers(context.Context, *filter.UserFilter) (int64, error)
 }
@@ -0,0 +1,1302 @@
+// Package repotest contains the behaviour contract every repositories.UserRepository
+// implementation has to satisfy, runnable from the tests of each backend.
+package repotest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	filter "github.com/dlion/faceit_challenge/internal"
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// RepositoryFactory returns an empty repository, it is called once for every test of the suite.
+type RepositoryFactory func(t *testing.T) repositories.UserRepository
+
+// Timestamps are compared with this tolerance because some backends don't store nanoseconds.
+const timestampPrecision = time.Millisecond
+
+func RunUserRepositorySuite(t *testing.T, newRepository RepositoryFactory) {
+	t.Run("AddUser", func(t *testing.T) {
+		t.Run("Add a new user", func(t *testing.T) {
+			ctx := context.Background()
+			userRepo := newRepository(t)
+
+			addedUser, err := userRepo.AddUser(ctx, newTestUser("testNickname", "testEmail@email.com", "UK"))
+			require.NoError(t, err)
+
+			assert.False(t, addedUser.Id.IsZero())
+			assert.Equal(t, "testName", addedUser.FirstName)
+			assert.Equal(t, "testLastName", addedUser.LastName)
+			assert.Equal(t, "testNickname", addedUser.Nickname)
+			assert.Equal(t, "testEmail@email.com", addedUser.Email)
+			assert.Equal(t, "UK", addedUser.Country)
+			assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(addedUser.Password), []byte("testPassword")))
+			assert.False(t, addedUser.CreatedAt.IsZero())
+			assert.Equal(t, addedUser.CreatedAt, addedUser.UpdatedAt)
+			assert.Equal(t, int64(1), addedUser.Version)
+
+			users, err := userRepo.GetUsers(ctx, filter.NewFilterBuilder().Build(), nil, nil)
+			require.NoError(t, err)
+			require.Len(t, users, 1)
+			assert.Equal(t, addedUser.Id, users[0].Id)
+			assert.Equal(t, addedUser.Password, users[0].Password)
+			assert.WithinDuration(t, addedUser.CreatedAt, users[0].CreatedAt, timestampPrecision)
+		})
+
+		t.Run("Return ErrUserAlreadyExist if the user already exist", func(t *testing.T) {
+			ctx := context.Background()
+			userRepo := newRepository(t)
+
+			_, err := userRepo.AddUser(ctx, newTestUser("testNickname", "testEmail@email.com", "UK"))
+			require.NoError(t, err)
+
+			_, err = userRepo.AddUser(ctx, newTestUser("testNickname", "testEmail@email.com", "UK"))
+			assert.ErrorIs(t, err, repositories.ErrUserAlreadyExist)
+		})
+
+		t.Run("Return ErrUserAlreadyExist if the email or the nickname is already used", func(t *testing.T) {
+			ctx := context.Background()
+			userRepo := newRepository(t)
+
+			_, err := userRepo.AddUser(ctx, newTestUser("testNickname", "testEmail@email.com", "UK"))
+			require.NoError(t, err)
+
+			_, err = userRepo.AddUser(ctx, newTestUser("otherNickname", "testEmail@email.com", "UK"))
+			assert.ErrorIs(t, err, repositories.ErrUserAlreadyExist)
+			_, err = userRepo.AddUser(ctx, newTestUser("otherNickname", "TESTEMAIL@email.com", "UK"))
+			assert.ErrorIs(t, err, repositories.ErrUserAlreadyExist)
+			_, err = userRepo.AddUser(ctx, newTestUser("testNickname", "otherEmail@email.com", "UK"))
+			assert.ErrorIs(t, err, repositories.ErrUserAlreadyExist)
+		})
+
+		t.Run("Add the users without a nickname", func(t *testing.T) {
+			ctx := context.Background()
+			userRepo := newRepository(t)
+
+			_, err := userRepo.AddUser(ctx, newTestUser("", "testEmail@email.com", "UK"))
+			require.NoError(t, err)
+
+			_, err = userRepo.AddUser(ctx, newTestUser("", "otherEmail@email.com", "UK"))
+			assert.NoError(t, err)
+		})
+	})
+
+	t.Run("UpdateUser", func(t *testing.T) {
+		t.Run("Modify only the given fields of an existing user", func(t *testing.T) {
+			ctx := context.Background()
+			userRepo := newRepository(t)
+
+			addedUser, err := userRepo.AddUser(ctx, newTestUser("testNickname", "testEmail@email.com", "UK"))
+			require.NoError(t, err)
+			createdAt := addedUser.CreatedAt
+
+			updatedUser, previousUser, err := userRepo.UpdateUser(ctx, &repositories.User{
+				Id:        addedUser.Id,
+				FirstName: "updatedFirstName",
+				Password:  "updatedPassword",
+			})
+			require.NoError(t, err)
+
+			require.NotNil(t, previousUser)
+			assert.Equal(t, "testName", previousUser.FirstName)
+			assert.Equal(t, addedUser.Password, previousUser.Password)
+			assert.Equal(t, int64(1), previousUser.Version)
+
+			assert.Equal(t, addedUser.Id, updatedUser.Id)
+			assert.Equal(t, "updatedFirstName", updatedUser.FirstName)
+			assert.Equal(t, "testLastName", updatedUser.LastName)
+			assert.Equal(t, "testNickname", updatedUser.Nickname)
+			assert.Equal(t, "testEmail@email.com", updatedUser.Email)
+			assert.Equal(t, "UK", updatedUser.Country)
+			assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(updatedUser.Password), []byte("updatedPassword")))
+			assert.WithinDuration(t, createdAt, updatedUser.CreatedAt, timestampPrecision)
+			assert.True(t, updatedUser.UpdatedAt.After(updatedUser.CreatedAt))
+			assert.Equal(t, int64(2), updatedUser.Version)
+		})
+
+		t.Run("Update the user only if it has the expected version", func(t *testing.T) {
+			ctx := context.Background()
+			userRepo := newRepository(t)
+
+			addedUser, err := userRepo.AddUser(ctx, newTestUser("testNickname", "testEmail@email.com", "UK"))
+			require.NoError(t, err)
+
+			updatedUser, _, err := userRepo.UpdateUser(ctx, &repositories.User{Id: addedUser.Id, FirstName: "first", Version: 1})
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), updatedUser.Version)
+
+			_, _, err = userRepo.UpdateUser(ctx, &repositories.User{Id: addedUser.Id, FirstName: "second", Version: 1})
+			assert.ErrorIs(t, err, repositories.ErrVersionMismatch)
+
+			storedUser, err := userRepo.GetUser(ctx, addedUser.Id.Hex())
+			require.NoError(t, err)
+			assert.Equal(t, "first", storedUser.FirstName)
+			assert.Equal(t, int64(2), storedUser.Version)
+		})
+
+		t.Run("Clear the fields of the user", func(t *testing.T) {
+			ctx := context.Background()
+			userRepo := newRepository(t)
+
+			addedUser, err := userRepo.AddUser(ctx, newTestUser("testNickname", "testEmail@email.com", "UK"))
+			require.NoError(t, err)
+
+			updatedUser, _, err := userRepo.UpdateUser(ctx, &repositories.User{
+				Id:            addedUser.Id,
+				FirstName:     "updatedFirstName",
+				ClearedFields: []string{"last_name", "country"},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, "updatedFirstName", updatedUser.FirstName)
+			assert.Empty(t, updatedUser.LastName)
+			assert.Empty(t, updatedUser.Country)
+			assert.Equal(t, "testNickname", updatedUser.Nickname)
+
+			storedUser, err := userRepo.GetUser(ctx, addedUser.Id.Hex())
+			require.NoError(t, err)
+			assert.Empty(t, storedUser.LastName)
+			assert.Empty(t, storedUser.Country)
+		})
+
+		t.Run("Return ErrNotClearable if a required field is cleared", func(t *testing.T) {
+			ctx := context.Background()
+			userRepo := newRepository(t)
+
+			addedUser, err := userRepo.AddUser(ctx, newTestUser("testNickname", "testEmail@email.com", "UK"))
+			require.NoError(t, err)
+
+			_, _, err = userRepo.UpdateUser(ctx, &repositories.User{Id: addedUser.Id, ClearedFields: []string{"email"}})
+			assert.ErrorIs(t, err, repositories.ErrNotClearable)
+
+			storedUser, err := userRepo.GetUser(ctx, addedUser.Id.Hex())
+			require.NoError(t, err)
+			assert.Equal(t, "testEmail@email.com", storedUser.Email)
+		})
+
+		t.Run("Return ErrUserAlreadyExist if the user would have the email or the nickname of another one", func(t *testing.T) {
+			ctx := context.Background()
+			userRepo := newRepository(t)
+
+			_, err := userRepo.AddUser(ctx, newTestUser("testNickname", "testEmail@email.com", "UK"))
+			require.NoError(t, err)
+			addedUser, err := userRepo.AddUser(ctx, newTestUser("otherNickname", "otherEmail@email.com", "UK"))
+			require.NoError(t, err)
+
+			_, _, err = userRepo.UpdateUser(ctx, &repositories.User{Id: addedUser.Id, Nickname: "testNickname"})
+			assert.ErrorIs(t, err, repositories.ErrUserAlreadyExist)
+			_, _, err = userRepo.UpdateUser(ctx, &repositories.User{Id: addedUser.Id, Email: "testEmail@email.com"})
+			assert.ErrorIs(t, err, repositories.ErrUserAlreadyExist)
+
+			storedUser, err := userRepo.GetUser(ctx, addedUser.Id.Hex())
+			require.NoError(t, err)
+			assert.Equal(t, "otherNickname", storedUser.Nickname)
+			assert.Equal(t, "otherEmail@email.com", storedUser.Email)
+			assert.Equal(t, int64(1), storedUser.Version)
+
+			updatedUser, _, err := userRepo.UpdateUser(ctx, &repositories.User{Id: addedUser.Id, Nickname: "updatedNickname"})
+			require.NoError(t, err)
+			assert.Equal(t, "updatedNickname", updatedUser.Nickname)
+		})
+
+		t.Run("Return ErrUserNotFound if the user doesn't exist", func(t *testing.T) {
+			userRepo := newRepository(t)
+
+			_, _, err := userRepo.UpdateUser(context.Background(), &repositories.User{
+				Id:        primitive.NewObjectID(),
+				FirstName: "updatedFirstName",
+			})
+			assert.ErrorIs(t, err, repositories.ErrUserNotFound)
+		})
+
+		t.Run("Return ErrNothingToUpdate if no field is given", func(t *testing.T) {
+			ctx := context.Background()
+			userRepo := newRepository(t)
+
+			addedUser, err := userRepo.AddUser(ctx, newTestUser("testNickname", "testEmail@email.com", "UK"))
+			require.NoError(t, err)
+
+			_, _, err = userRepo.UpdateUser(ctx, &repositories.User{Id: addedUser.Id})
+			assert.ErrorIs(t, err, repositories.ErrNothingToUpdate)
+		})
+	})
+
+	t.Run("RemoveUser", func(t *testing.T) {
+		t.Run("Remove an existing user", func(t *testing.T) {
+			ctx := context.Background()
+			userRepo := newRepository(t)
+
+			addedUser, err := userRepo.AddUser(ctx, newTestUser("testNickname", "testEmail@email.com", "UK"))
+			require.NoError(t, err)
+
+			removedUser, err := userRepo.RemoveUser(ctx, addedUser.Id.Hex(), 0)
+			require.NoError(t, err)
+			assert.Equal(t, addedUser.Id, removedUser.Id)
+			assert.Equal(t, "testNickname", removedUser.Nickname)
+			assert.Equal(t, int64(1), removedUser.Version)
+
+			users, err := userRepo.GetUsers(ctx, filter.NewFilterBuilder().Build(), nil, nil)
+			require.NoError(t, err)
+			assert.Empty(t, users)
+
+			_, err = userRepo.RemoveUser(ctx, addedUser.Id.Hex(), 0)
+			assert.ErrorIs(t, err, repositories.ErrUserNotFound)
+		})
+
+		t.Run("Remove the user only if it has the expected version", func(t *testing.T) {
+			ctx := context.Background()
+			userRepo := newRepository(t)
+
+			addedUser, err := userRepo.AddUser(ctx, newTestUser("testNickname", "testEmail@email.com", "UK"))
+			require.NoError(t, err)
+
+			_, err = userRepo.RemoveUser(ctx, addedUser.Id.Hex(), 2)
+			assert.ErrorIs(t, err, repositories.ErrVersionMismatch)
+
+			_, err = userRepo.RemoveUser(ctx, addedUser.Id.Hex(), 1)
+			require.NoError(t, err)
+
+			_, err = userRepo.RemoveUser(ctx, addedUser.Id.Hex(), 1)
+			assert.ErrorIs(t, err, repositories.ErrUserNotFound)
+		})
+
+		t.Run("Return ErrUserNotFound if the user doesn't exist", func(t *testing.T) {
+			userRepo := newRepository(t)
+
+			_, err := userRepo.RemoveUser(context.Background(), primitive.NewObjectID().Hex(), 0)
+			assert.ErrorIs(t, err, repositories.ErrUserNotFound)
+		})
+
+		t.Run("Return an error if the id is not valid", func(t *testing.T) {
+			userRepo := newRepository(t)
+
+			_, err := userRepo.RemoveUser(context.Background(), "randomId", 0)
+			assert.Error(t, err)
+		})
+	})
+
+	t.Run("GetUser", func(t *testing.T) {
+		t.Run("Return an existing user", func(t *testing.T) {
+			ctx := context.Background()
+			userRepo := newRepository(t)
+
+			addedUser, err := userRepo.AddUser(ctx, newTestUser("testNickname", "testEmail@email.com", "UK"))
+			require.NoError(t, err)
+			_, err = userRepo.AddUser(ctx, newTestUser("otherNickname", "otherEmail@email.com", "UK"))
+			require.NoError(t, err)
+
+			user, err := userRepo.GetUser(ctx, addedUser.Id.Hex())
+			require.NoError(t, err)
+
+			assert.Equal(t, addedUser.Id, user.Id)
+			assert.Equal(t, "testName", user.FirstName)
+			assert.Equal(t, "testLastName", user.LastName)
+			assert.Equal(t, "testNickname", user.Nickname)
+			assert.Equal(t, "testEmail@email.com", user.Email)
+			assert.Equal(t, "UK", user.Country)
+			assert.Equal(t, addedUser.Password, user.Password)
+			assert.WithinDuration(t, addedUser.CreatedAt, user.CreatedAt, timestampPrecision)
+			assert.WithinDuration(t, addedUser.UpdatedAt, user.UpdatedAt, timestampPrecision)
+		})
+
+		t.Run("Return ErrUserNotFound if the user doesn't exist", func(t *testing.T) {
+			userRepo := newRepository(t)
+
+			_, err := userRepo.GetUser(context.Background(), primitive.NewObjectID().Hex())
+			assert.ErrorIs(t, err, repositories.ErrUserNotFound)
+		})
+
+		t.Run("Return an error if the id is not valid", func(t *testing.T) {
+			userRepo := newRepository(t)
+
+			_, err := userRepo.GetUser(context.Background(), "randomId")
+			assert.Error(t, err)
+		})
+	})
+
+	t.Run("GetUsers", func(t *testing.T) {
+		ctx := context.Background()
+		userRepo := newRepository(t)
+
+		// Users are returned from the most recent one, so the expected order is reversed.
+		var ukUsers, allUsers []*repositories.User
+		for i := 0; i < 12; i++ {
+			country := "UK"
+			if i%3 == 0 {
+				country = "ITA"
+			}
+			addedUser, err := userRepo.AddUser(ctx, newTestUser(fmt.Sprintf("testNickname%d", i), fmt.Sprintf("testEmail%d@email.com", i), country))
+			require.NoError(t, err)
+
+			allUsers = append([]*repositories.User{addedUser}, allUsers...)
+			if country == "UK" {
+				ukUsers = append([]*repositories.User{addedUser}, ukUsers...)
+			}
+		}
+
+		t.Run("Return the users sorted by creation time, most recent first", func(t *testing.T) {
+			users, err := userRepo.GetUsers(ctx, filter.NewFilterBuilder().Build(), int64Ptr(12), int64Ptr(0))
+			require.NoError(t, err)
+
+			assert.Equal(t, idsOf(allUsers), idsOf(users))
+		})
+
+		t.Run("Return at most 10 users if no limit is given", func(t *testing.T) {
+			users, err := userRepo.GetUsers(ctx, filter.NewFilterBuilder().Build(), nil, nil)
+			require.NoError(t, err)
+
+			assert.Equal(t, idsOf(allUsers[:10]), idsOf(users))
+		})
+
+		t.Run("Return the users filtered by country", func(t *testing.T) {
+			country := "UK"
+			userFilter := filter.NewFilterBuilder().ByCountry(&country).Build()
+			users, err := userRepo.GetUsers(ctx, userFilter, int64Ptr(10), int64Ptr(0))
+			require.NoError(t, err)
+
+			assert.Equal(t, idsOf(ukUsers), idsOf(users))
+		})
+
+		t.Run("Return the users filtered by more fields", func(t *testing.T) {
+			country := "UK"
+			nickname := "testNickname4"
+			email := "testEmail4@email.com"
+			userFilter := filter.NewFilterBuilder().ByCountry(&country).ByNickname(&nickname).ByEmail(&email).Build()
+			users, err := userRepo.GetUsers(ctx, userFilter, nil, nil)
+			require.NoError(t, err)
+
+			require.Len(t, users, 1)
+			assert.Equal(t, "testNickname4", users[0].Nickname)
+		})
+
+		t.Run("Skip the first users with an offset", func(t *testing.T) {
+			country := "UK"
+			userFilter := filter.NewFilterBuilder().ByCountry(&country).Build()
+			users, err := userRepo.GetUsers(ctx, userFilter, int64Ptr(2), int64Ptr(1))
+			require.NoError(t, err)
+
+			assert.Equal(t, idsOf(ukUsers[1:3]), idsOf(users))
+		})
+
+		t.Run("Return no users if the offset is beyond the last one", func(t *testing.T) {
+			users, err := userRepo.GetUsers(ctx, filter.NewFilterBuilder().Build(), int64Ptr(10), int64Ptr(12))
+			require.NoError(t, err)
+
+			assert.Empty(t, users)
+		})
+
+		t.Run("Return the users following a cursor", func(t *testing.T) {
+			var pagedUsers []*repositories.User
+			var cursor *filter.Cursor
+			for {
+				users, err := userRepo.GetUsers(ctx, filter.NewFilterBuilder().After(cursor).Build(), int64Ptr(5), nil)
+				require.NoError(t, err)
+
+				pagedUsers = append(pagedUsers, users...)
+				if len(users) < 5 {
+					break
+				}
+				cursor = cursorOf(users[len(users)-1])
+			}
+
+			assert.Equal(t, idsOf(allUsers), idsOf(pagedUsers))
+		})
+
+		t.Run("Return the users filtered by country following a cursor", func(t *testing.T) {
+			country := "UK"
+			// The cursor is taken from the stored users since some backends truncate their timestamps.
+			firstPage, err := userRepo.GetUsers(ctx, filter.NewFilterBuilder().ByCountry(&country).Build(), int64Ptr(3), nil)
+			require.NoError(t, err)
+			require.Len(t, firstPage, 3)
+
+			userFilter := filter.NewFilterBuilder().ByCountry(&country).After(cursorOf(firstPage[2])).Build()
+			users, err := userRepo.GetUsers(ctx, userFilter, int64Ptr(2), nil)
+			require.NoError(t, err)
+
+			assert.Equal(t, idsOf(ukUsers[3:5]), idsOf(users))
+		})
+
+		t.Run("Return no users if nothing matches the filter", func(t *testing.T) {
+			country := "FR"
+			userFilter := filter.NewFilterBuilder().ByCountry(&country).Build()
+			users, err := userRepo.GetUsers(ctx, userFilter, nil, nil)
+			require.NoError(t, err)
+
+			assert.Empty(t, users)
+		})
+	})
+
+	t.Run("CountUsers", func(t *testing.T) {
+		ctx := context.Background()
+		userRepo := newRepository(t)
+
+		t.Run("Return zero if there are no users", func(t *testing.T) {
+			count, err := userRepo.CountUsers(ctx, filter.NewFilterBuilder().Build())
+			require.NoError(t, err)
+
+			assert.Zero(t, count)
+		})
+
+		var addedUsers []*repositories.User
+		for i := 0; i < 12; i++ {
+			country := "UK"
+			if i%3 == 0 {
+				country = "ITA"
+			}
+			addedUser, err := userRepo.AddUser(ctx, newTestUser(fmt.Sprintf("testNickname%d", i), fmt.Sprintf("testEmail%d@email.com", i), country))
+			require.NoError(t, err)
+			addedUsers = append(addedUsers, addedUser)
+		}
+
+		t.Run("Count all the users ignoring limit and offset", func(t *testing.T) {
+			count, err := userRepo.CountUsers(ctx, filter.NewFilterBuilder().WithLimit(int64Ptr(2)).WithOffset(int64Ptr(5)).Build())
+			require.NoError(t, err)
+
+			assert.Equal(t, int64(12), count)
+		})
+
+		t.Run("Count the users matching the filter", func(t *testing.T) {
+			country := "ITA"
+			count, err := userRepo.CountUsers(ctx, filter.NewFilterBuilder().ByCountry(&country).Build())
+			require.NoError(t, err)
+
+			assert.Equal(t, int64(4), count)
+		})
+
+		t.Run("Count the users following a cursor", func(t *testing.T) {
+			storedUser, err := userRepo.GetUser(ctx, addedUsers[4].Id.Hex())
+			require.NoError(t, err)
+
+			count, err := userRepo.CountUsers(ctx, filter.NewFilterBuilder().After(cursorOf(storedUser)).Build())
+			require.NoError(t, err)
+
+			assert.Equal(t, int64(4), count)
+		})
+	})
+
+	t.Run("GetUsers with filter operators", func(t *testing.T) {
+		ctx := context.Background()
+		userRepo := newRepository(t)
+
+		addUser := func(nickname, email, country string) *repositories.User {
+			addedUser, err := userRepo.AddUser(ctx, newTestUser(nickname, email, country))
+			require.NoError(t, err)
+			return addedUser
+		}
+
+		// The users are added in two groups apart in time, to filter them by creation time.
+		john := addUser("john", "John.Doe@Example.com", "UK")
+		johnny := addUser("johnny", "johnny@example.com", "IE")
+		time.Sleep(5 * timestampPrecision)
+		between := time.Now()
+		time.Sleep(5 * timestampPrecision)
+		jane := addUser("jane", "Jane.Ørsted@example.com", "FR")
+		regexUser := addUser("jo.n+(", "regex@example.com", "ITA")
+
+		listUsers := func(t *testing.T, userFilter *filter.UserFilter) []string {
+			users, err := userRepo.GetUsers(ctx, userFilter, int64Ptr(10), nil)
+			require.NoError(t, err)
+
+			count, err := userRepo.CountUsers(ctx, userFilter)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(users)), count)
+
+			return idsOf(users)
+		}
+
+		t.Run("Return the users whose nickname starts with a prefix", func(t *testing.T) {
+			prefix := "john"
+			users := listUsers(t, filter.NewFilterBuilder().ByNicknamePrefix(&prefix).Build())
+
+			assert.Equal(t, idsOf([]*repositories.User{johnny, john}), users)
+		})
+
+		t.Run("Match the prefix literally", func(t *testing.T) {
+			prefix := "jo.n+("
+			users := listUsers(t, filter.NewFilterBuilder().ByNicknamePrefix(&prefix).Build())
+			assert.Equal(t, idsOf([]*repositories.User{regexUser}), users)
+
+			prefix = "jo."
+			users = listUsers(t, filter.NewFilterBuilder().ByNicknamePrefix(&prefix).Build())
+			assert.Equal(t, idsOf([]*repositories.User{regexUser}), users)
+		})
+
+		t.Run("Return the users with an email regardless of its case", func(t *testing.T) {
+			email := "john.doe@example.COM"
+			users := listUsers(t, filter.NewFilterBuilder().ByEmailIgnoringCase(&email).Build())
+			assert.Equal(t, idsOf([]*repositories.User{john}), users)
+
+			email = "john.doe@example"
+			users = listUsers(t, filter.NewFilterBuilder().ByEmailIgnoringCase(&email).Build())
+			assert.Empty(t, users)
+		})
+
+		t.Run("Match the email regardless of the case of the letters beyond ASCII", func(t *testing.T) {
+			email := "JANE.øRSTED@EXAMPLE.com"
+			users := listUsers(t, filter.NewFilterBuilder().ByEmailIgnoringCase(&email).Build())
+			assert.Equal(t, idsOf([]*repositories.User{jane}), users)
+		})
+
+		t.Run("Return the users of any of the countries", func(t *testing.T) {
+			users := listUsers(t, filter.NewFilterBuilder().ByCountries("UK", "IE", "ES").Build())
+
+			assert.Equal(t, idsOf([]*repositories.User{johnny, john}), users)
+		})
+
+		t.Run("Return the users created after or before a time", func(t *testing.T) {
+			users := listUsers(t, filter.NewFilterBuilder().CreatedAfter(&between).Build())
+			assert.Equal(t, idsOf([]*repositories.User{regexUser, jane}), users)
+
+			users = listUsers(t, filter.NewFilterBuilder().CreatedBefore(&between).Build())
+			assert.Equal(t, idsOf([]*repositories.User{johnny, john}), users)
+		})
+
+		t.Run("Combine the operators with the other filters", func(t *testing.T) {
+			prefix := "j"
+			country := "IE"
+			users := listUsers(t, filter.NewFilterBuilder().ByNicknamePrefix(&prefix).ByCountry(&country).ByCountries("IE", "FR").CreatedBefore(&between).Build())
+
+			assert.Equal(t, idsOf([]*repositories.User{johnny}), users)
+		})
+
+		t.Run("Return the users updated since a time", func(t *testing.T) {
+			time.Sleep(5 * timestampPrecision)
+			updatedSince := time.Now()
+			time.Sleep(5 * timestampPrecision)
+			_, _, err := userRepo.UpdateUser(ctx, &repositories.User{Id: johnny.Id, FirstName: "updatedName"})
+			require.NoError(t, err)
+
+			users := listUsers(t, filter.NewFilterBuilder().UpdatedSince(&updatedSince).Build())
+
+			assert.Equal(t, idsOf([]*repositories.User{johnny}), users)
+		})
+	})
+
+	t.Run("GetUsers with a full-text search", func(t *testing.T) {
+		ctx := context.Background()
+		userRepo := newRepository(t)
+
+		addUser := func(firstName, lastName, nickname, email string) *repositories.User {
+			addedUser, err := userRepo.AddUser(ctx, repositories.NewRepoUser(firstName, lastName, nickname, "testPassword", email, "UK"))
+			require.NoError(t, err)
+			return addedUser
+		}
+
+		johnDoe := addUser("John", "Doe", "jdoe", "john.doe@example.com")
+		janeSmith := addUser("Jane", "Smith", "janes", "jane@example.com")
+		johnnySmith := addUser("Johnny", "Smith", "johnny99", "js@example.com")
+		aliceBrown := addUser("Alice", "Brown", "ali", "alice@example.org")
+
+		search := func(t *testing.T, text string, sort ...filter.SortField) []string {
+			userFilter := filter.NewFilterBuilder().Search(&text).SortBy(sort...).Build()
+			users, err := userRepo.GetUsers(ctx, userFilter, int64Ptr(10), nil)
+			require.NoError(t, err)
+
+			count, err := userRepo.CountUsers(ctx, userFilter)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(users)), count)
+
+			return idsOf(users)
+		}
+
+		t.Run("Return the users having a word starting with the search in any field", func(t *testing.T) {
+			assert.ElementsMatch(t, idsOf([]*repositories.User{johnDoe, johnnySmith}), search(t, "joh"))
+			assert.ElementsMatch(t, idsOf([]*repositories.User{johnnySmith}), search(t, "johnny9"))
+			assert.ElementsMatch(t, idsOf([]*repositories.User{janeSmith, johnnySmith}), search(t, "smi"))
+			assert.ElementsMatch(t, idsOf([]*repositories.User{johnDoe}), search(t, "doe@"))
+		})
+
+		t.Run("Match the words regardless of their case", func(t *testing.T) {
+			assert.ElementsMatch(t, idsOf([]*repositories.User{janeSmith}), search(t, "JANE"))
+		})
+
+		t.Run("Return the users matching any of the words, those matching more of them first", func(t *testing.T) {
+			users := search(t, "john smith")
+
+			assert.Equal(t, idsOf([]*repositories.User{johnnySmith, janeSmith, johnDoe}), users)
+		})
+
+		t.Run("Break the ties of relevance with the most recently created users first", func(t *testing.T) {
+			// Jane Smith has fewer terms than Johnny Smith, that mustn't make it more relevant.
+			assert.Equal(t, idsOf([]*repositories.User{johnnySmith, janeSmith}), search(t, "smith"))
+			assert.Equal(t, idsOf([]*repositories.User{aliceBrown, johnnySmith, janeSmith, johnDoe}), search(t, "example"))
+		})
+
+		t.Run("Match the long words by their first letters", func(t *testing.T) {
+			longWord := addUser("Maximilianusfrederikson", "Pneumonoultramicroscopicsilicovolcanoconiosis", "maxi", "max@example.net")
+
+			assert.Equal(t, idsOf([]*repositories.User{longWord}), search(t, "pneumonoultramicroscopicsilico"))
+			assert.Equal(t, idsOf([]*repositories.User{longWord}), search(t, "Maximilianusfrederikson"))
+			assert.Equal(t, idsOf([]*repositories.User{longWord}), search(t, "pneumonoultramicrosc"))
+		})
+
+		t.Run("Sort the matching users as given instead of by relevance", func(t *testing.T) {
+			users := search(t, "john smith", filter.SortField{Field: "nickname"})
+
+			assert.Equal(t, idsOf([]*repositories.User{janeSmith, johnDoe, johnnySmith}), users)
+		})
+
+		t.Run("Return no users if nothing matches the search", func(t *testing.T) {
+			assert.Empty(t, search(t, "bob"))
+			assert.Empty(t, search(t, "ohn"))
+		})
+
+		t.Run("Find the users by their updated fields", func(t *testing.T) {
+			_, _, err := userRepo.UpdateUser(ctx, &repositories.User{Id: janeSmith.Id, LastName: "Doe"})
+			require.NoError(t, err)
+
+			assert.ElementsMatch(t, idsOf([]*repositories.User{johnDoe, janeSmith}), search(t, "doe"))
+			assert.ElementsMatch(t, idsOf([]*repositories.User{janeSmith}), search(t, "jane"))
+			assert.ElementsMatch(t, idsOf([]*repositories.User{johnnySmith}), search(t, "smith"))
+		})
+	})
+
+	t.Run("GetUsers sorted", func(t *testing.T) {
+		ctx := context.Background()
+		userRepo := newRepository(t)
+
+		// Users are added by nickname, every other one from ITA.
+		var addedUsers []*repositories.User
+		for i := 0; i < 6; i++ {
+			country := "UK"
+			if i%2 == 0 {
+				country = "ITA"
+			}
+			addedUser, err := userRepo.AddUser(ctx, newTestUser(fmt.Sprintf("testNickname%d", i), fmt.Sprintf("testEmail%d@email.com", i), country))
+			require.NoError(t, err)
+			addedUsers = append(addedUsers, addedUser)
+		}
+
+		idsByIndex := func(indexes ...int) []string {
+			ids := make([]string, len(indexes))
+			for i, index := range indexes {
+				ids[i] = addedUsers[index].Id.Hex()
+			}
+			return ids
+		}
+
+		listSorted := func(t *testing.T, sort string) []*repositories.User {
+			sortFields, err := filter.ParseSort(sort)
+			require.NoError(t, err)
+
+			users, err := userRepo.GetUsers(ctx, filter.NewFilterBuilder().SortBy(sortFields...).Build(), int64Ptr(10), nil)
+			require.NoError(t, err)
+			return users
+		}
+
+		t.Run("Return the users sorted by a field in ascending order", func(t *testing.T) {
+			assert.Equal(t, idsByIndex(0, 1, 2, 3, 4, 5), idsOf(listSorted(t, "nickname")))
+		})
+
+		t.Run("Return the users sorted by a field in descending order", func(t *testing.T) {
+			assert.Equal(t, idsByIndex(5, 4, 3, 2, 1, 0), idsOf(listSorted(t, "-nickname")))
+		})
+
+		t.Run("Return the users sorted by more fields", func(t *testing.T) {
+			assert.Equal(t, idsByIndex(1, 3, 5, 0, 2, 4), idsOf(listSorted(t, "-country,nickname")))
+		})
+
+		t.Run("Break the ties by id in the direction of the last field", func(t *testing.T) {
+			assert.Equal(t, idsByIndex(0, 2, 4, 1, 3, 5), idsOf(listSorted(t, "country")))
+			assert.Equal(t, idsByIndex(5, 3, 1, 4, 2, 0), idsOf(listSorted(t, "-country")))
+		})
+
+		t.Run("Return the users following a cursor in the sort order", func(t *testing.T) {
+			sort := []filter.SortField{{Field: "country", Descending: true}, {Field: "nickname"}}
+
+			var pagedUsers []*repositories.User
+			var cursor *filter.Cursor
+			for {
+				userFilter := filter.NewFilterBuilder().SortBy(sort...).After(cursor).Build()
+				users, err := userRepo.GetUsers(ctx, userFilter, int64Ptr(4), nil)
+				require.NoError(t, err)
+
+				pagedUsers = append(pagedUsers, users...)
+				if len(users) < 4 {
+					break
+				}
+				cursor = cursorOf(users[len(users)-1], sort...)
+			}
+
+			assert.Equal(t, idsByIndex(1, 3, 5, 0, 2, 4), idsOf(pagedUsers))
+		})
+
+		t.Run("Return the most recently updated users first", func(t *testing.T) {
+			for _, index := range []int{1, 4} {
+				time.Sleep(2 * timestampPrecision)
+				_, _, err := userRepo.UpdateUser(ctx, &repositories.User{Id: addedUsers[index].Id, FirstName: "updatedName"})
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, idsByIndex(4, 1, 5, 3, 2, 0), idsOf(listSorted(t, "-updated_at")))
+		})
+	})
+
+	t.Run("GetUsers with a cursor doesn't skip or duplicate users added between pages", func(t *testing.T) {
+		ctx := context.Background()
+		userRepo := newRepository(t)
+
+		var addedUsers []*repositories.User
+		for i := 0; i < 4; i++ {
+			addedUser, err := userRepo.AddUser(ctx, newTestUser(fmt.Sprintf("testNickname%d", i), fmt.Sprintf("testEmail%d@email.com", i), "UK"))
+			require.NoError(t, err)
+			addedUsers = append(addedUsers, addedUser)
+		}
+
+		firstPage, err := userRepo.GetUsers(ctx, filter.NewFilterBuilder().Build(), int64Ptr(2), nil)
+		require.NoError(t, err)
+		require.Len(t, firstPage, 2)
+
+		_, err = userRepo.AddUser(ctx, newTestUser("newNickname", "newEmail@email.com", "UK"))
+		require.NoError(t, err)
+
+		secondPage, err := userRepo.GetUsers(ctx, filter.NewFilterBuilder().After(cursorOf(firstPage[1])).Build(), int64Ptr(2), nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{addedUsers[1].Id.Hex(), addedUsers[0].Id.Hex()}, idsOf(secondPage))
+	})
+
+	t.Run("GetUsers matches and sorts the cleared fields as the empty ones", func(t *testing.T) {
+		ctx := context.Background()
+		userRepo := newRepository(t)
+
+		// The second user is added without a country, the third one has it cleared.
+		var addedUsers []*repositories.User
+		for i, country := range []string{"UK", "", "ITA", "FRA"} {
+			addedUser, err := userRepo.AddUser(ctx, newTestUser(fmt.Sprintf("testNickname%d", i), fmt.Sprintf("testEmail%d@email.com", i), country))
+			require.NoError(t, err)
+			addedUsers = append(addedUsers, addedUser)
+		}
+		_, _, err := userRepo.UpdateUser(ctx, &repositories.User{Id: addedUsers[2].Id, ClearedFields: []string{"country"}})
+		require.NoError(t, err)
+
+		idsByIndex := func(indexes ...int) []string {
+			ids := make([]string, len(indexes))
+			for i, index := range indexes {
+				ids[i] = addedUsers[index].Id.Hex()
+			}
+			return ids
+		}
+
+		empty := ""
+		users, err := userRepo.GetUsers(ctx, filter.NewFilterBuilder().ByCountry(&empty).Build(), nil, nil)
+		require.NoError(t, err)
+		assert.Equal(t, idsByIndex(2, 1), idsOf(users))
+
+		users, err = userRepo.GetUsers(ctx, filter.NewFilterBuilder().ByCountries("", "FRA").Build(), nil, nil)
+		require.NoError(t, err)
+		assert.Equal(t, idsByIndex(3, 2, 1), idsOf(users))
+
+		for sort, expectedIds := range map[string][]string{
+			"country":  idsByIndex(1, 2, 3, 0),
+			"-country": idsByIndex(0, 3, 2, 1),
+		} {
+			sortFields, err := filter.ParseSort(sort)
+			require.NoError(t, err)
+
+			var pagedUsers []*repositories.User
+			var cursor *filter.Cursor
+			for {
+				users, err := userRepo.GetUsers(ctx, filter.NewFilterBuilder().SortBy(sortFields...).After(cursor).Build(), int64Ptr(1), nil)
+				require.NoError(t, err)
+				if len(users) == 0 {
+					break
+				}
+				pagedUsers = append(pagedUsers, users...)
+				cursor = cursorOf(users[0], sortFields...)
+			}
+
+			assert.Equal(t, expectedIds, idsOf(pagedUsers), sort)
+		}
+	})
+
+	t.Run("Outbox", func(t *testing.T) {
+		ctx := context.Background()
+
+		newOutboxRepository := func(t *testing.T) (repositories.UserRepository, repositories.Outbox) {
+			userRepo := newRepository(t)
+			outbox, ok := userRepo.(repositories.Outbox)
+			require.True(t, ok, "the repository doesn't implement repositories.Outbox")
+			return userRepo, outbox
+		}
+
+		t.Run("Record an entry for every change of the users, the oldest first", func(t *testing.T) {
+			userRepo, outbox := newOutboxRepository(t)
+
+			addedUser, err := userRepo.AddUser(ctx, newTestUser("testNickname", "testEmail@email.com", "UK"))
+			require.NoError(t, err)
+			_, _, err = userRepo.UpdateUser(ctx, &repositories.User{Id: addedUser.Id, Country: "ITA"})
+			require.NoError(t, err)
+			_, err = userRepo.RemoveUser(ctx, addedUser.Id.Hex(), 0)
+			require.NoError(t, err)
+
+			entries, err := outbox.PendingEntries(ctx, 10)
+			require.NoError(t, err)
+
+			require.Len(t, entries, 3)
+			for i, operationType := range []string{notifier.ChangeOperationInsert, notifier.ChangeOperationUpdate, notifier.ChangeOperationDelete} {
+				assert.Equal(t, operationType, entries[i].OperationType)
+				assert.Equal(t, addedUser.Id.Hex(), entries[i].UserId)
+				assert.False(t, entries[i].Id.IsZero())
+				assert.False(t, entries[i].CreatedAt.IsZero())
+				assert.Nil(t, entries[i].DeliveredAt)
+			}
+		})
+
+		t.Run("Record the user before and after every change", func(t *testing.T) {
+			userRepo, outbox := newOutboxRepository(t)
+
+			addedUser, err := userRepo.AddUser(ctx, newTestUser("testNickname", "testEmail@email.com", "UK"))
+			require.NoError(t, err)
+			_, _, err = userRepo.UpdateUser(ctx, &repositories.User{Id: addedUser.Id, Nickname: "updatedNickname", Country: "UK"})
+			require.NoError(t, err)
+			_, err = userRepo.RemoveUser(ctx, addedUser.Id.Hex(), 0)
+			require.NoError(t, err)
+
+			entries, err := outbox.PendingEntries(ctx, 10)
+			require.NoError(t, err)
+			require.Len(t, entries, 3)
+
+			assert.Equal(t, []string{"first_name", "last_name", "nickname", "password", "email", "country"}, entries[0].ChangedFields)
+			assert.Nil(t, entries[0].Before)
+			require.NotNil(t, entries[0].After)
+			assert.Equal(t, addedUser.Id.Hex(), entries[0].After.Id)
+			assert.Equal(t, "testNickname", entries[0].After.Nickname)
+			assert.WithinDuration(t, addedUser.CreatedAt, entries[0].After.CreatedAt, timestampPrecision)
+
+			assert.Equal(t, []string{"nickname"}, entries[1].ChangedFields)
+			require.NotNil(t, entries[1].Before)
+			require.NotNil(t, entries[1].After)
+			assert.Equal(t, "testNickname", entries[1].Before.Nickname)
+			assert.Equal(t, "updatedNickname", entries[1].After.Nickname)
+			assert.Equal(t, "UK", entries[1].After.Country)
+
+			assert.Equal(t, []string{"first_name", "last_name", "nickname", "password", "email", "country"}, entries[2].ChangedFields)
+			require.NotNil(t, entries[2].Before)
+			assert.Equal(t, "updatedNickname", entries[2].Before.Nickname)
+			assert.Nil(t, entries[2].After)
+		})
+
+		t.Run("Don't record the failed changes", func(t *testing.T) {
+			userRepo, outbox := newOutboxRepository(t)
+
+			_, err := userRepo.AddUser(ctx, newTestUser("testNickname", "testEmail@email.com", "UK"))
+			require.NoError(t, err)
+			_, err = userRepo.AddUser(ctx, newTestUser("testNickname", "testEmail@email.com", "UK"))
+			require.ErrorIs(t, err, repositories.ErrUserAlreadyExist)
+			_, _, err = userRepo.UpdateUser(ctx, &repositories.User{Id: primitive.NewObjectID(), Country: "ITA"})
+			require.ErrorIs(t, err, repositories.ErrUserNotFound)
+			_, err = userRepo.RemoveUser(ctx, primitive.NewObjectID().Hex(), 0)
+			require.ErrorIs(t, err, repositories.ErrUserNotFound)
+
+			entries, err := outbox.PendingEntries(ctx, 10)
+			require.NoError(t, err)
+			assert.Len(t, entries, 1)
+		})
+
+		t.Run("Return at most the given number of pending entries", func(t *testing.T) {
+			userRepo, outbox := newOutboxRepository(t)
+			for i := 0; i < 3; i++ {
+				_, err := userRepo.AddUser(ctx, newTestUser(fmt.Sprintf("testNickname%d", i), fmt.Sprintf("testEmail%d@email.com", i), "UK"))
+				require.NoError(t, err)
+			}
+
+			entries, err := outbox.PendingEntries(ctx, 2)
+			require.NoError(t, err)
+			assert.Len(t, entries, 2)
+		})
+
+		t.Run("Don't return the delivered entries as pending", func(t *testing.T) {
+			userRepo, outbox := newOutboxRepository(t)
+			for i := 0; i < 3; i++ {
+				_, err := userRepo.AddUser(ctx, newTestUser(fmt.Sprintf("testNickname%d", i), fmt.Sprintf("testEmail%d@email.com", i), "UK"))
+				require.NoError(t, err)
+			}
+			entries, err := outbox.PendingEntries(ctx, 10)
+			require.NoError(t, err)
+			require.Len(t, entries, 3)
+
+			require.NoError(t, outbox.MarkDelivered(ctx, entries[0].Id, entries[2].Id))
+
+			pendingEntries, err := outbox.PendingEntries(ctx, 10)
+			require.NoError(t, err)
+			require.Len(t, pendingEntries, 1)
+			assert.Equal(t, entries[1].Id, pendingEntries[0].Id)
+		})
+
+		t.Run("Purge the entries delivered before a time", func(t *testing.T) {
+			userRepo, outbox := newOutboxRepository(t)
+			for i := 0; i < 2; i++ {
+				_, err := userRepo.AddUser(ctx, newTestUser(fmt.Sprintf("testNickname%d", i), fmt.Sprintf("testEmail%d@email.com", i), "UK"))
+				require.NoError(t, err)
+			}
+			entries, err := outbox.PendingEntries(ctx, 10)
+			require.NoError(t, err)
+			require.NoError(t, outbox.MarkDelivered(ctx, entries[0].Id))
+
+			require.NoError(t, outbox.PurgeDelivered(ctx, time.Now().Add(time.Hour)))
+
+			pendingEntries, err := outbox.PendingEntries(ctx, 10)
+			require.NoError(t, err)
+			require.Len(t, pendingEntries, 1)
+			assert.Equal(t, entries[1].Id, pendingEntries[0].Id)
+		})
+	})
+
+	t.Run("ChangeLog", func(t *testing.T) {
+		ctx := context.Background()
+
+		// newChangeLog returns an empty repository with count changes, and the sequence number preceding them.
+		newChangeLog := func(t *testing.T, count int) (repositories.ChangeLog, repositories.Outbox, int64) {
+			userRepo := newRepository(t)
+			changeLog, ok := userRepo.(repositories.ChangeLog)
+			require.True(t, ok, "the repository doesn't implement repositories.ChangeLog")
+			outbox := userRepo.(repositories.Outbox)
+
+			for i := 0; i < count; i++ {
+				_, err := userRepo.AddUser(ctx, newTestUser(fmt.Sprintf("testNickname%d", i), fmt.Sprintf("testEmail%d@email.com", i), "UK"))
+				require.NoError(t, err)
+			}
+
+			entries, err := outbox.PendingEntries(ctx, 1)
+			require.NoError(t, err)
+			require.NotEmpty(t, entries)
+			return changeLog, outbox, entries[0].Sequence - 1
+		}
+
+		sequencesOf := func(entries []*repositories.OutboxEntry) []int64 {
+			sequences := make([]int64, len(entries))
+			for i, entry := range entries {
+				sequences[i] = entry.Sequence
+			}
+			return sequences
+		}
+
+		t.Run("Number the changes consecutively", func(t *testing.T) {
+			changeLog, _, start := newChangeLog(t, 3)
+
+			entries, err := changeLog.ChangesSince(ctx, start, 10)
+			require.NoError(t, err)
+
+			assert.Equal(t, []int64{start + 1, start + 2, start + 3}, sequencesOf(entries))
+		})
+
+		t.Run("Return at most the given number of changes following a sequence number", func(t *testing.T) {
+			changeLog, _, start := newChangeLog(t, 4)
+
+			entries, err := changeLog.ChangesSince(ctx, start+1, 2)
+			require.NoError(t, err)
+
+			assert.Equal(t, []int64{start + 2, start + 3}, sequencesOf(entries))
+		})
+
+		t.Run("Return the delivered changes", func(t *testing.T) {
+			changeLog, outbox, start := newChangeLog(t, 2)
+			pendingEntries, err := outbox.PendingEntries(ctx, 10)
+			require.NoError(t, err)
+			require.NoError(t, outbox.MarkDelivered(ctx, pendingEntries[0].Id, pendingEntries[1].Id))
+
+			entries, err := changeLog.ChangesSince(ctx, start, 10)
+			require.NoError(t, err)
+
+			assert.Equal(t, []int64{start + 1, start + 2}, sequencesOf(entries))
+		})
+
+		t.Run("Return no changes following the last sequence number", func(t *testing.T) {
+			changeLog, _, start := newChangeLog(t, 2)
+
+			entries, err := changeLog.ChangesSince(ctx, start+2, 10)
+			require.NoError(t, err)
+
+			assert.Empty(t, entries)
+		})
+
+		t.Run("Return ErrSequenceCompacted if the following changes have been purged", func(t *testing.T) {
+			changeLog, outbox, start := newChangeLog(t, 2)
+			pendingEntries, err := outbox.PendingEntries(ctx, 10)
+			require.NoError(t, err)
+			require.NoError(t, outbox.MarkDelivered(ctx, pendingEntries[0].Id))
+			require.NoError(t, outbox.PurgeDelivered(ctx, time.Now().Add(time.Hour)))
+
+			_, err = changeLog.ChangesSince(ctx, start, 10)
+			assert.ErrorIs(t, err, repositories.ErrSequenceCompacted)
+
+			entries, err := changeLog.ChangesSince(ctx, start+1, 10)
+			require.NoError(t, err)
+			assert.Equal(t, []int64{start + 2}, sequencesOf(entries))
+		})
+	})
+
+	t.Run("PublishCursors", func(t *testing.T) {
+		ctx := context.Background()
+
+		// newPublishCursors returns an empty repository with count changes, and the sequence number preceding them.
+		newPublishCursors := func(t *testing.T, count int) (repositories.PublishCursors, repositories.Outbox, int64) {
+			userRepo := newRepository(t)
+			publishCursors, ok := userRepo.(repositories.PublishCursors)
+			require.True(t, ok, "the repository doesn't implement repositories.PublishCursors")
+			outbox := userRepo.(repositories.Outbox)
+
+			start, err := publishCursors.DeliveredSequence(ctx)
+			require.NoError(t, err)
+			for i := 0; i < count; i++ {
+				_, err := userRepo.AddUser(ctx, newTestUser(fmt.Sprintf("testNickname%d", i), fmt.Sprintf("testEmail%d@email.com", i), "UK"))
+				require.NoError(t, err)
+			}
+			return publishCursors, outbox, start
+		}
+
+		t.Run("Return the sequence number preceding the first pending entry", func(t *testing.T) {
+			publishCursors, outbox, start := newPublishCursors(t, 3)
+			pendingEntries, err := outbox.PendingEntries(ctx, 10)
+			require.NoError(t, err)
+			require.NoError(t, outbox.MarkDelivered(ctx, pendingEntries[0].Id))
+
+			sequence, err := publishCursors.DeliveredSequence(ctx)
+			require.NoError(t, err)
+
+			assert.Equal(t, start+1, sequence)
+		})
+
+		t.Run("Return the last sequence number if no entry is pending", func(t *testing.T) {
+			publishCursors, outbox, start := newPublishCursors(t, 2)
+			pendingEntries, err := outbox.PendingEntries(ctx, 10)
+			require.NoError(t, err)
+			require.NoError(t, outbox.MarkDelivered(ctx, pendingEntries[0].Id, pendingEntries[1].Id))
+
+			sequence, err := publishCursors.DeliveredSequence(ctx)
+			require.NoError(t, err)
+
+			assert.Equal(t, start+2, sequence)
+		})
+
+		t.Run("Record the last sequence number published by every publisher", func(t *testing.T) {
+			publishCursors, _, _ := newPublishCursors(t, 0)
+
+			_, found, err := publishCursors.PublishedSequence(ctx, "first")
+			require.NoError(t, err)
+			assert.False(t, found)
+
+			require.NoError(t, publishCursors.SetPublishedSequence(ctx, "first", 3))
+			require.NoError(t, publishCursors.SetPublishedSequence(ctx, "second", 1))
+			require.NoError(t, publishCursors.SetPublishedSequence(ctx, "first", 5))
+
+			sequence, found, err := publishCursors.PublishedSequence(ctx, "first")
+			require.NoError(t, err)
+			assert.True(t, found)
+			assert.Equal(t, int64(5), sequence)
+
+			sequence, found, err = publishCursors.PublishedSequence(ctx, "second")
+			require.NoError(t, err)
+			assert.True(t, found)
+			assert.Equal(t, int64(1), sequence)
+		})
+	})
+
+	t.Run("WebhookStore", func(t *testing.T) {
+		ctx := context.Background()
+
+		newWebhookStore := func(t *testing.T) repositories.WebhookStore {
+			webhookStore, ok := newRepository(t).(repositories.WebhookStore)
+			require.True(t, ok, "the repository doesn't implement repositories.WebhookStore")
+			return webhookStore
+		}
+
+		newChange := func(eventId string) notifier.ChangeData {
+			return notifier.ChangeData{
+				EventId:       eventId,
+				Sequence:      1,
+				Timestamp:     time.Now().UTC().Truncate(time.Millisecond),
+				OperationType: notifier.ChangeOperationUpdate,
+				UserId:        primitive.NewObjectID().Hex(),
+				ChangedFields: []string{"nickname"},
+				After:         &notifier.UserSnapshot{Nickname: "testNickname"},
+			}
+		}
+
+		t.Run("Add, list and remove the webhooks", func(t *testing.T) {
+			webhookStore := newWebhookStore(t)
+
+			first, err := webhookStore.AddWebhook(ctx, repositories.NewWebhook("https://first.com/hook", []string{notifier.ChangeOperationDelete}, "firstSecret"))
+			require.NoError(t, err)
+			second, err := webhookStore.AddWebhook(ctx, repositories.NewWebhook("https://second.com/hook", nil, "secondSecret"))
+			require.NoError(t, err)
+
+			webhooks, err := webhookStore.GetWebhooks(ctx)
+			require.NoError(t, err)
+			require.Len(t, webhooks, 2)
+			assert.Equal(t, first.Id, webhooks[0].Id)
+			assert.Equal(t, "https://first.com/hook", webhooks[0].Url)
+			assert.Equal(t, []string{notifier.ChangeOperationDelete}, webhooks[0].EventTypes)
+			assert.Equal(t, "firstSecret", webhooks[0].Secret)
+			assert.Empty(t, webhooks[1].EventTypes)
+
+			storedWebhook, err := webhookStore.GetWebhook(ctx, second.Id.Hex())
+			require.NoError(t, err)
+			assert.Equal(t, "https://second.com/hook", storedWebhook.Url)
+
+			require.NoError(t, webhookStore.RemoveWebhook(ctx, first.Id.Hex()))
+			_, err = webhookStore.GetWebhook(ctx, first.Id.Hex())
+			assert.ErrorIs(t, err, repositories.ErrWebhookNotFound)
+			assert.ErrorIs(t, webhookStore.RemoveWebhook(ctx, first.Id.Hex()), repositories.ErrWebhookNotFound)
+		})
+
+		t.Run("Record a single delivery of every change", func(t *testing.T) {
+			webhookStore := newWebhookStore(t)
+			webhook, err := webhookStore.AddWebhook(ctx, repositories.NewWebhook("https://test.com/hook", nil, "testSecret"))
+			require.NoError(t, err)
+
+			change := newChange("event1")
+			delivery := repositories.NewWebhookDelivery(webhook.Id, change)
+			require.NoError(t, webhookStore.AddDelivery(ctx, delivery))
+			require.NoError(t, webhookStore.AddDelivery(ctx, repositories.NewWebhookDelivery(webhook.Id, change)))
+
+			deliveries, err := webhookStore.GetDeliveries(ctx, repositories.DeliveryQuery{WebhookId: webhook.Id})
+			require.NoError(t, err)
+			require.Len(t, deliveries, 1)
+			assert.Equal(t, delivery.Id, deliveries[0].Id)
+			assert.Equal(t, "event1", deliveries[0].EventId)
+			assert.Equal(t, repositories.DeliveryPending, deliveries[0].Status)
+			assert.Empty(t, deliveries[0].Attempts)
+			assert.Equal(t, change.UserId, deliveries[0].Change.UserId)
+			assert.Equal(t, change.ChangedFields, deliveries[0].Change.ChangedFields)
+			assert.Equal(t, "testNickname", deliveries[0].Change.After.Nickname)
+			assert.WithinDuration(t, change.Timestamp, deliveries[0].Change.Timestamp, timestampPrecision)
+		})
+
+		t.Run("Return the due deliveries, the longest due first", func(t *testing.T) {
+			webhookStore := newWebhookStore(t)
+			webhook, err := webhookStore.AddWebhook(ctx, repositories.NewWebhook("https://test.com/hook", nil, "testSecret"))
+			require.NoError(t, err)
+
+			now := time.Now()
+			var deliveries []*repositories.WebhookDelivery
+			for i, nextAttemptAt := range []time.Time{now.Add(-time.Minute), now.Add(-time.Hour), now.Add(time.Hour)} {
+				delivery := repositories.NewWebhookDelivery(webhook.Id, newChange(fmt.Sprintf("event%d", i)))
+				delivery.NextAttemptAt = nextAttemptAt
+				require.NoError(t, webhookStore.AddDelivery(ctx, delivery))
+				deliveries = append(deliveries, delivery)
+			}
+
+			dueDeliveries, err := webhookStore.DueDeliveries(ctx, now, 10)
+			require.NoError(t, err)
+			require.Len(t, dueDeliveries, 2)
+			assert.Equal(t, deliveries[1].Id, dueDeliveries[0].Id)
+			assert.Equal(t, deliveries[0].Id, dueDeliveries[1].Id)
+
+			dueDeliveries, err = webhookStore.DueDeliveries(ctx, now, 1)
+			require.NoError(t, err)
+			assert.Len(t, dueDeliveries, 1)
+		})
+
+		t.Run("Record the attempts and retry the dead deliveries", func(t *testing.T) {
+			webhookStore := newWebhookStore(t)
+			webhook, err := webhookStore.AddWebhook(ctx, repositories.NewWebhook("https://test.com/hook", nil, "testSecret"))
+			require.NoError(t, err)
+			delivery := repositories.NewWebhookDelivery(webhook.Id, newChange("event1"))
+			require.NoError(t, webhookStore.AddDelivery(ctx, delivery))
+
+			now := time.Now()
+			require.NoError(t, webhookStore.RecordAttempt(ctx, delivery.Id,
+				repositories.WebhookAttempt{AttemptedAt: now, StatusCode: 500, Error: "unexpected status"},
+				repositories.DeliveryPending, now.Add(time.Minute)))
+			require.NoError(t, webhookStore.RecordAttempt(ctx, delivery.Id,
+				repositories.WebhookAttempt{AttemptedAt: now, Error: "connection refused"},
+				repositories.DeliveryDead, now.Add(time.Minute)))
+
+			storedDelivery, err := webhookStore.GetDelivery(ctx, delivery.Id.Hex())
+			require.NoError(t, err)
+			assert.Equal(t, repositories.DeliveryDead, storedDelivery.Status)
+			require.Len(t, storedDelivery.Attempts, 2)
+			assert.Equal(t, 500, storedDelivery.Attempts[0].StatusCode)
+			assert.Equal(t, "connection refused", storedDelivery.Attempts[1].Error)
+
+			deadDeliveries, err := webhookStore.GetDeliveries(ctx, repositories.DeliveryQuery{Status: repositories.DeliveryDead})
+			require.NoError(t, err)
+			require.Len(t, deadDeliveries, 1)
+
+			require.NoError(t, webhookStore.RetryDelivery(ctx, delivery.Id, now))
+			dueDeliveries, err := webhookStore.DueDeliveries(ctx, now, 10)
+			require.NoError(t, err)
+			require.Len(t, dueDeliveries, 1)
+			assert.Len(t, dueDeliveries[0].Attempts, 2)
+
+			assert.ErrorIs(t, webhookStore.RetryDelivery(ctx, primitive.NewObjectID(), now), repositories.ErrDeliveryNotFound)
+			_, err = webhookStore.GetDelivery(ctx, primitive.NewObjectID().Hex())
+			assert.ErrorIs(t, err, repositories.ErrDeliveryNotFound)
+		})
+
+		t.Run("Purge the succeeded and the dead deliveries updated before a time", func(t *testing.T) {
+			webhookStore := newWebhookStore(t)
+			webhook, err := webhookStore.AddWebhook(ctx, repositories.NewWebhook("https://test.com/hook", nil, "testSecret"))
+			require.NoError(t, err)
+			deliveries := make([]*repositories.WebhookDelivery, 3)
+			for i, status := range []string{repositories.DeliverySucceeded, repositories.DeliveryDead, repositories.DeliveryPending} {
+				deliveries[i] = repositories.NewWebhookDelivery(webhook.Id, newChange(fmt.Sprintf("event%d", i)))
+				require.NoError(t, webhookStore.AddDelivery(ctx, deliveries[i]))
+				require.NoError(t, webhookStore.RecordAttempt(ctx, deliveries[i].Id,
+					repositories.WebhookAttempt{AttemptedAt: time.Now()}, status, time.Now()))
+			}
+
+			require.NoError(t, webhookStore.PurgeDeliveries(ctx, time.Now().Add(-time.Hour)))
+			storedDeliveries, err := webhookStore.GetDeliveries(ctx, repositories.DeliveryQuery{})
+			require.NoError(t, err)
+			assert.Len(t, storedDeliveries, 3)
+
+			require.NoError(t, webhookStore.PurgeDeliveries(ctx, time.Now().Add(time.Hour)))
+			storedDeliveries, err = webhookStore.GetDeliveries(ctx, repositories.DeliveryQuery{})
+			require.NoError(t, err)
+			require.Len(t, storedDeliveries, 1)
+			assert.Equal(t, deliveries[2].Id, storedDeliveries[0].Id)
+		})
+
+		t.Run("Remove the deliveries of a removed webhook", func(t *testing.T) {
+			webhookStore := newWebhookStore(t)
+			webhook, err := webhookStore.AddWebhook(ctx, repositories.NewWebhook("https://test.com/hook", nil, "testSecret"))
+			require.NoError(t, err)
+			require.NoError(t, webhookStore.AddDelivery(ctx, repositories.NewWebhookDelivery(webhook.Id, newChange("event1"))))
+
+			require.NoError(t, webhookStore.RemoveWebhook(ctx, webhook.Id.Hex()))
+
+			deliveries, err := webhookStore.GetDeliveries(ctx, repositories.DeliveryQuery{})
+			require.NoError(t, err)
+			assert.Empty(t, deliveries)
+		})
+	})
+}
+
+// cursorOf returns the cursor of the user in a listing sorted as given, by default with filter.DEFAULT_SORT.
+func cursorOf(user *repositories.User, sort ...filter.SortField) *filter.Cursor {
+	if len(sort) == 0 {
+		sort = filter.DEFAULT_SORT
+	}
+
+	values := make([]interface{}, len(sort))
+	for i, field := range sort {
+		values[i] = user.SortValue(field.Field)
+	}
+	return &filter.Cursor{Sort: sort, Values: values, Id: user.Id}
+}
+
+func newTestUser(nickname, email, country string) *repositories.User {
+	return repositories.NewRepoUser("testName", "testLastName", nickname, "testPassword", email, country)
+}
+
+func idsOf(users []*repositories.User) []string {
+	ids := make([]string, len(users))
+	for i, user := range users {
+		ids[i] = user.Id.Hex()
+	}
+	return ids
+}
+
+func int64Ptr(value int64) *int64 {
+	return &value
+}
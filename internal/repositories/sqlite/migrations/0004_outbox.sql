@@ -0,0 +1,10 @@
+-- The changes of the users waiting to be published, written in the same transaction of the change.
+CREATE TABLE outbox (
+    id             TEXT     PRIMARY KEY,
+    operation_type TEXT     NOT NULL,
+    user_id        TEXT     NOT NULL,
+    created_at     DATETIME NOT NULL,
+    delivered_at   DATETIME
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (created_at, id) WHERE delivered_at IS NULL;
@@ -0,0 +1,3 @@
+-- The version of the users, incremented by every update to detect the concurrent ones.
+-- The users stored before are at their first version.
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,6 @@
+-- The email folded by the repository regardless of the case of the letters, as filter.FoldCase does,
+-- matched by the email_ignore_case filter in the same way by every database.
+-- It's computed at startup for the users stored before.
+ALTER TABLE users ADD COLUMN email_folded TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX users_email_folded_idx ON users (email_folded);
@@ -0,0 +1,6 @@
+-- The sequence number of the last change published by every publisher of the outbox relay,
+-- each one publishes its own backlog from there.
+CREATE TABLE publish_cursors (
+    publisher TEXT    PRIMARY KEY,
+    sequence  INTEGER NOT NULL
+);
@@ -0,0 +1,2 @@
+-- The succeeded and the dead deliveries are purged after their retention.
+CREATE INDEX webhook_deliveries_finished_idx ON webhook_deliveries (status, updated_at);
@@ -0,0 +1,80 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"io/fs"
+	"net/url"
+
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	"github.com/dlion/faceit_challenge/internal/repositories/sqlstore"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var (
+	ErrUserAlreadyExist = repositories.ErrUserAlreadyExist
+	ErrUserNotFound     = repositories.ErrUserNotFound
+	ErrNothingToUpdate  = repositories.ErrNothingToUpdate
+	ErrVersionMismatch  = repositories.ErrVersionMismatch
+	ErrNotClearable     = repositories.ErrNotClearable
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var Dialect = sqlstore.Dialect{
+	Name: "SQLite",
+	Placeholder: func(n int) string {
+		return "?"
+	},
+	IsUniqueViolation: func(err error) bool {
+		var sqliteErr *sqlite.Error
+		return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	},
+}
+
+type UserRepositorySQLiteImpl struct {
+	*sqlstore.UserRepositorySQLImpl
+}
+
+func NewUserRepositorySQLiteImpl(db *sql.DB) *UserRepositorySQLiteImpl {
+	return &UserRepositorySQLiteImpl{UserRepositorySQLImpl: sqlstore.NewUserRepositorySQLImpl(db, Dialect)}
+}
+
+// Open opens (creating it if needed) the SQLite database stored in the given file.
+func Open(path string) (*sql.DB, error) {
+	dsn := (&url.URL{
+		Scheme: "file",
+		Opaque: path,
+		RawQuery: url.Values{
+			"_pragma":      {"busy_timeout(5000)", "journal_mode(WAL)"},
+			"_time_format": {"sqlite"},
+		}.Encode(),
+	}).String()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, sharing one connection avoids "database is locked" errors.
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+// Migrate creates or upgrades the schema using the migrations embedded from the migrations directory.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	return sqlstore.Migrate(ctx, db, Dialect, migrations())
+}
+
+func migrations() fs.FS {
+	migrations, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		panic(err)
+	}
+	return migrations
+}
@@ -0,0 +1,55 @@
+package repositories
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	"github.com/dlion/faceit_challenge/internal/repositories/repotest"
+	"github.com/dlion/faceit_challenge/internal/repositories/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepository(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := Open(filepath.Join(t.TempDir(), "users.db"))
+	require.NoError(t, err, "failed to open the database: %s", err)
+	defer db.Close()
+
+	t.Run("Apply the migrations only once", func(t *testing.T) {
+		require.NoError(t, Migrate(ctx, db))
+		require.NoError(t, Migrate(ctx, db))
+
+		migrations, err := sqlstore.LoadMigrations(migrations())
+		require.NoError(t, err)
+
+		var appliedCount int
+		err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations").Scan(&appliedCount)
+		assert.NoError(t, err)
+		assert.Equal(t, len(migrations), appliedCount)
+	})
+
+	t.Run("Return ErrUserAlreadyExist if the email is already used", func(t *testing.T) {
+		_, err := db.ExecContext(ctx, "DELETE FROM "+sqlstore.USERS_TABLE)
+		require.NoError(t, err)
+
+		userRepo := NewUserRepositorySQLiteImpl(db)
+		_, err = userRepo.AddUser(ctx, repositories.NewRepoUser("", "", "nickname", "testPassword", "testEmail@email.com", ""))
+		require.NoError(t, err)
+
+		_, err = userRepo.AddUser(ctx, repositories.NewRepoUser("", "", "otherNickname", "testPassword", "testEmail@email.com", ""))
+		assert.ErrorIs(t, err, ErrUserAlreadyExist)
+	})
+
+	repotest.RunUserRepositorySuite(t, func(t *testing.T) repositories.UserRepository {
+		for _, table := range []string{sqlstore.USERS_TABLE, sqlstore.OUTBOX_TABLE, sqlstore.PUBLISH_CURSORS_TABLE, sqlstore.WEBHOOKS_TABLE, sqlstore.DELIVERIES_TABLE} {
+			_, err := db.ExecContext(ctx, "DELETE FROM "+table)
+			require.NoError(t, err, "failed to delete the %s: %s", table, err)
+		}
+
+		return NewUserRepositorySQLiteImpl(db)
+	})
+}
@@ -0,0 +1,17 @@
+// Package sqlstore implements repositories.UserRepository on top of database/sql,
+// the differences between the SQL databases are described by a Dialect.
+package sqlstore
+
+type Dialect struct {
+	Name string
+	// Placeholder returns the parameter marker for the n-th argument of a query.
+	Placeholder func(n int) string
+	// IsUniqueViolation reports whether the error is caused by a unique constraint.
+	IsUniqueViolation func(err error) bool
+	// LockMigrations is run at the beginning of every migration transaction
+	// to serialise concurrent migrations, it's skipped if empty.
+	LockMigrations string
+	// LockForUpdate is appended to the query reading a row that's updated afterwards
+	// in the same transaction, it's empty if the transactions are already serialised.
+	LockForUpdate string
+}
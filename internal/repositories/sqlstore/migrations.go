@@ -0,0 +1,113 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Query   string
+}
+
+// Migrate applies, in order and each one in its own transaction, the migrations of the
+// migrationFiles root directory not yet recorded in the schema_migrations table.
+// Every migration file name starts with its version, e.g. 0001_create_users.sql.
+func Migrate(ctx context.Context, db *sql.DB, dialect Dialect, migrationFiles fs.FS) error {
+	log.Printf("Migrating the %s schema", dialect.Name)
+
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER   PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return err
+	}
+
+	migrations, err := LoadMigrations(migrationFiles)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if err := applyMigration(ctx, db, dialect, m); err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// LoadMigrations returns the migrations of the migrationFiles root directory sorted by version.
+func LoadMigrations(migrationFiles fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		prefix, _, found := strings.Cut(entry.Name(), "_")
+		if !found {
+			return nil, fmt.Errorf("migration %s doesn't start with a version", entry.Name())
+		}
+
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s doesn't start with a version: %w", entry.Name(), err)
+		}
+
+		query, err := fs.ReadFile(migrationFiles, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{Version: version, Name: entry.Name(), Query: string(query)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, dialect Dialect, m Migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if dialect.LockMigrations != "" {
+		if _, err := tx.ExecContext(ctx, dialect.LockMigrations); err != nil {
+			return err
+		}
+	}
+
+	var appliedCount int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations WHERE version = "+dialect.Placeholder(1), m.Version).Scan(&appliedCount)
+	if err != nil {
+		return err
+	}
+
+	if appliedCount > 0 {
+		return nil
+	}
+
+	log.Printf("Applying migration %s", m.Name)
+
+	if _, err := tx.ExecContext(ctx, m.Query); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ("+dialect.Placeholder(1)+")", m.Version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,210 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	OUTBOX_TABLE          = "outbox"
+	CHANGE_SEQUENCE_TABLE = "change_sequence"
+	PUBLISH_CURSORS_TABLE = "publish_cursors"
+
+	outboxColumns = "id, sequence, operation_type, user_id, changed_fields, before_snapshot, after_snapshot, created_at, delivered_at"
+)
+
+// addOutboxEntry records a change of a user, it's run in the transaction of the change.
+// Incrementing the sequence locks it until the transaction ends.
+func (u *UserRepositorySQLImpl) addOutboxEntry(ctx context.Context, tx *sql.Tx, operationType string, before, after *repositories.User) error {
+	var sequence int64
+	err := tx.QueryRowContext(ctx, fmt.Sprintf("UPDATE %s SET value = value + 1 RETURNING value", CHANGE_SEQUENCE_TABLE)).Scan(&sequence)
+	if err != nil {
+		return err
+	}
+
+	entry := repositories.NewOutboxEntry(sequence, operationType, before, after)
+
+	beforeSnapshot, err := marshalSnapshot(entry.Before)
+	if err != nil {
+		return err
+	}
+	afterSnapshot, err := marshalSnapshot(entry.After)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", OUTBOX_TABLE, outboxColumns, u.placeholders(9)),
+		entry.Id.Hex(), entry.Sequence, entry.OperationType, entry.UserId, strings.Join(entry.ChangedFields, ","),
+		beforeSnapshot, afterSnapshot, entry.CreatedAt.UTC(), nil,
+	)
+	return err
+}
+
+// marshalSnapshot stores a snapshot as JSON, nil if there's none.
+func marshalSnapshot(snapshot *notifier.UserSnapshot) (*string, error) {
+	if snapshot == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	value := string(data)
+	return &value, nil
+}
+
+func unmarshalSnapshot(value sql.NullString) (*notifier.UserSnapshot, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+
+	snapshot := &notifier.UserSnapshot{}
+	if err := json.Unmarshal([]byte(value.String), snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (u *UserRepositorySQLImpl) PendingEntries(ctx context.Context, limit int64) ([]*repositories.OutboxEntry, error) {
+	return u.queryOutbox(ctx, "delivered_at IS NULL", nil, limit)
+}
+
+func (u *UserRepositorySQLImpl) ChangesSince(ctx context.Context, sequence int64, limit int64) ([]*repositories.OutboxEntry, error) {
+	// The last sequence is read first: the entries read afterwards include at least all the ones up to it.
+	var lastSequence int64
+	err := u.db.QueryRowContext(ctx, fmt.Sprintf("SELECT value FROM %s", CHANGE_SEQUENCE_TABLE)).Scan(&lastSequence)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := u.queryOutbox(ctx, "sequence > "+u.dialect.Placeholder(1), []interface{}{sequence}, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := repositories.CheckNotCompacted(sequence, lastSequence, entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// queryOutbox returns at most limit entries matching the condition, in sequence order.
+func (u *UserRepositorySQLImpl) queryOutbox(ctx context.Context, condition string, args []interface{}, limit int64) ([]*repositories.OutboxEntry, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY sequence", outboxColumns, OUTBOX_TABLE, condition)
+	if limit > 0 {
+		args = append(args, limit)
+		query += " LIMIT " + u.dialect.Placeholder(len(args))
+	}
+
+	rows, err := u.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*repositories.OutboxEntry
+	for rows.Next() {
+		var id, changedFields string
+		var beforeSnapshot, afterSnapshot sql.NullString
+		var deliveredAt sql.NullTime
+		entry := &repositories.OutboxEntry{}
+		err := rows.Scan(&id, &entry.Sequence, &entry.OperationType, &entry.UserId, &changedFields,
+			&beforeSnapshot, &afterSnapshot, &entry.CreatedAt, &deliveredAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry.Id, err = primitive.ObjectIDFromHex(id); err != nil {
+			return nil, err
+		}
+		if changedFields != "" {
+			entry.ChangedFields = strings.Split(changedFields, ",")
+		}
+		if entry.Before, err = unmarshalSnapshot(beforeSnapshot); err != nil {
+			return nil, err
+		}
+		if entry.After, err = unmarshalSnapshot(afterSnapshot); err != nil {
+			return nil, err
+		}
+		if deliveredAt.Valid {
+			entry.DeliveredAt = &deliveredAt.Time
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (u *UserRepositorySQLImpl) MarkDelivered(ctx context.Context, ids ...primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := []interface{}{time.Now().UTC()}
+	placeholders := make([]string, len(ids))
+	for i, id := range ids {
+		args = append(args, id.Hex())
+		placeholders[i] = u.dialect.Placeholder(len(args))
+	}
+
+	_, err := u.db.ExecContext(ctx,
+		fmt.Sprintf("UPDATE %s SET delivered_at = %s WHERE delivered_at IS NULL AND id IN (%s)",
+			OUTBOX_TABLE, u.dialect.Placeholder(1), strings.Join(placeholders, ", ")),
+		args...,
+	)
+	return err
+}
+
+func (u *UserRepositorySQLImpl) PurgeDelivered(ctx context.Context, before time.Time) error {
+	_, err := u.db.ExecContext(ctx,
+		fmt.Sprintf("DELETE FROM %s WHERE delivered_at < %s", OUTBOX_TABLE, u.dialect.Placeholder(1)),
+		before.UTC(),
+	)
+	return err
+}
+
+func (u *UserRepositorySQLImpl) DeliveredSequence(ctx context.Context) (int64, error) {
+	var sequence int64
+	err := u.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT COALESCE((SELECT MIN(sequence) - 1 FROM %s WHERE delivered_at IS NULL), value) FROM %s",
+			OUTBOX_TABLE, CHANGE_SEQUENCE_TABLE),
+	).Scan(&sequence)
+	return sequence, err
+}
+
+func (u *UserRepositorySQLImpl) PublishedSequence(ctx context.Context, publisher string) (int64, bool, error) {
+	var sequence int64
+	err := u.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT sequence FROM %s WHERE publisher = %s", PUBLISH_CURSORS_TABLE, u.dialect.Placeholder(1)),
+		publisher,
+	).Scan(&sequence)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return sequence, true, nil
+}
+
+func (u *UserRepositorySQLImpl) SetPublishedSequence(ctx context.Context, publisher string, sequence int64) error {
+	_, err := u.db.ExecContext(ctx,
+		fmt.Sprintf("INSERT INTO %s (publisher, sequence) VALUES (%s) ON CONFLICT (publisher) DO UPDATE SET sequence = excluded.sequence",
+			PUBLISH_CURSORS_TABLE, u.placeholders(2)),
+		publisher, sequence,
+	)
+	return err
+}
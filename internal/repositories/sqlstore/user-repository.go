@@ -0,0 +1,426 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	filter "github.com/dlion/faceit_challenge/internal"
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	USERS_TABLE = "users"
+
+	userColumns = "id, first_name, last_name, nickname, password, email, country, created_at, updated_at, version"
+)
+
+type UserRepositorySQLImpl struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+func NewUserRepositorySQLImpl(db *sql.DB, dialect Dialect) *UserRepositorySQLImpl {
+	return &UserRepositorySQLImpl{db: db, dialect: dialect}
+}
+
+func (u *UserRepositorySQLImpl) Ping(ctx context.Context) error {
+	return u.db.PingContext(ctx)
+}
+
+func (u *UserRepositorySQLImpl) AddUser(ctx context.Context, user *repositories.User) (*repositories.User, error) {
+	log.Printf("Adding a user to the %s database", u.dialect.Name)
+
+	hashedPassword, err := repositories.HashPassword(user.Password)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = hashedPassword
+
+	now := time.Now().UTC()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	user.Id = primitive.NewObjectID()
+	user.Version = 1
+
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		fmt.Sprintf("INSERT INTO %s (%s, search_terms, email_folded) VALUES (%s)", USERS_TABLE, userColumns, u.placeholders(12)),
+		user.Id.Hex(), user.FirstName, user.LastName, user.Nickname, user.Password, user.Email, user.Country, user.CreatedAt, user.UpdatedAt, user.Version,
+		filter.JoinSearchTerms(user.SearchTerms()), filter.FoldCase(user.Email),
+	)
+	if err != nil {
+		return nil, u.translateError(err)
+	}
+
+	if err := u.addOutboxEntry(ctx, tx, notifier.ChangeOperationInsert, nil, user); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (u *UserRepositorySQLImpl) UpdateUser(ctx context.Context, user *repositories.User) (*repositories.User, *repositories.User, error) {
+	log.Printf("Updating user (%s) in the %s database", user.Id.Hex(), u.dialect.Name)
+
+	assignments, args, err := u.createUpdatedUser(user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	storedUser, err := scanUser(tx.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT %s FROM %s WHERE id = %s %s", userColumns, USERS_TABLE, u.dialect.Placeholder(1), u.dialect.LockForUpdate),
+		user.Id.Hex(),
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, repositories.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// The stored user is locked until the end of the transaction, so neither its version nor the user
+	// returned as it was before the update can change meanwhile.
+	if user.Version != 0 && user.Version != storedUser.Version {
+		return nil, nil, repositories.ErrVersionMismatch
+	}
+
+	args = append(args, user.Id.Hex())
+	row := tx.QueryRowContext(ctx,
+		fmt.Sprintf("UPDATE %s SET %s WHERE id = %s RETURNING %s", USERS_TABLE, assignments, u.dialect.Placeholder(len(args)), userColumns),
+		args...,
+	)
+
+	updatedUser, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, repositories.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, nil, u.translateError(err)
+	}
+
+	// The search columns depend on the stored values of the fields that haven't been updated.
+	if err := u.updateSearchColumns(ctx, tx, updatedUser); err != nil {
+		return nil, nil, u.translateError(err)
+	}
+
+	if err := u.addOutboxEntry(ctx, tx, notifier.ChangeOperationUpdate, storedUser, updatedUser); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return updatedUser, storedUser, nil
+}
+
+// BackfillSearchTerms computes the search terms and the folded email of the users stored before
+// they were introduced, it's run at startup after the migrations.
+func (u *UserRepositorySQLImpl) BackfillSearchTerms(ctx context.Context) error {
+	rows, err := u.db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE search_terms = '' OR email_folded = ''", userColumns, USERS_TABLE))
+	if err != nil {
+		return err
+	}
+
+	var users []*repositories.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		users = append(users, user)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(users) > 0 {
+		log.Printf("Computing the search terms of %d users in the %s database", len(users), u.dialect.Name)
+	}
+
+	for _, user := range users {
+		if err := u.updateSearchColumns(ctx, u.db, user); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// updateSearchColumns updates the columns the user is found by, computed from its stored values.
+func (u *UserRepositorySQLImpl) updateSearchColumns(ctx context.Context, db execer, user *repositories.User) error {
+	_, err := db.ExecContext(ctx,
+		fmt.Sprintf("UPDATE %s SET search_terms = %s, email_folded = %s WHERE id = %s", USERS_TABLE, u.dialect.Placeholder(1), u.dialect.Placeholder(2), u.dialect.Placeholder(3)),
+		filter.JoinSearchTerms(user.SearchTerms()), filter.FoldCase(user.Email), user.Id.Hex(),
+	)
+	return err
+}
+
+func (u *UserRepositorySQLImpl) RemoveUser(ctx context.Context, id string, expectedVersion int64) (*repositories.User, error) {
+	log.Printf("Removing user (%s) from the %s database", id, u.dialect.Name)
+
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = %s", USERS_TABLE, u.dialect.Placeholder(1))
+	args := []interface{}{objectId.Hex()}
+	if expectedVersion != 0 {
+		args = append(args, expectedVersion)
+		query += " AND version = " + u.dialect.Placeholder(2)
+	}
+
+	deletedUser, err := scanUser(tx.QueryRowContext(ctx, query+" RETURNING "+userColumns, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, u.notFoundOrMismatch(ctx, tx, objectId.Hex())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.addOutboxEntry(ctx, tx, notifier.ChangeOperationDelete, deletedUser, nil); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return deletedUser, nil
+}
+
+// notFoundOrMismatch tells why the user with the id wasn't deleted.
+func (u *UserRepositorySQLImpl) notFoundOrMismatch(ctx context.Context, tx *sql.Tx, id string) error {
+	var count int
+	err := tx.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE id = %s", USERS_TABLE, u.dialect.Placeholder(1)),
+		id,
+	).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return repositories.ErrUserNotFound
+	}
+	return repositories.ErrVersionMismatch
+}
+
+func (u *UserRepositorySQLImpl) GetUser(ctx context.Context, id string) (*repositories.User, error) {
+	log.Printf("Getting user (%s) from the %s database", id, u.dialect.Name)
+
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	row := u.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT %s FROM %s WHERE id = %s", userColumns, USERS_TABLE, u.dialect.Placeholder(1)),
+		objectId.Hex(),
+	)
+
+	user, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repositories.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (u *UserRepositorySQLImpl) GetUsers(ctx context.Context, userFilter *filter.UserFilter, limit, offset *int64) ([]*repositories.User, error) {
+	condition, args := userFilter.ToSQL(u.dialect.Placeholder)
+
+	log.Printf("Getting users from the %s database with filters: %s %v", u.dialect.Name, condition, args)
+
+	if limit == nil {
+		limit = int64Ptr(10)
+	}
+
+	if offset == nil {
+		offset = int64Ptr(0)
+	}
+
+	query := "SELECT " + userColumns + " FROM " + USERS_TABLE
+	if condition != "" {
+		query += " WHERE " + condition
+	}
+	var orderBy string
+	orderBy, args = userFilter.OrderBySQL(u.dialect.Placeholder, args)
+	query += " ORDER BY " + orderBy
+
+	// A zero limit means no limit, as it does for MongoDB.
+	// SQLite doesn't accept an OFFSET without a LIMIT, so the largest one is used.
+	if *limit <= 0 {
+		limit = int64Ptr(math.MaxInt64)
+	}
+	args = append(args, *limit)
+	query += " LIMIT " + u.dialect.Placeholder(len(args))
+	args = append(args, *offset)
+	query += " OFFSET " + u.dialect.Placeholder(len(args))
+
+	rows, err := u.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*repositories.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func (u *UserRepositorySQLImpl) CountUsers(ctx context.Context, userFilter *filter.UserFilter) (int64, error) {
+	condition, args := userFilter.ToSQL(u.dialect.Placeholder)
+
+	log.Printf("Counting users in the %s database with filters: %s %v", u.dialect.Name, condition, args)
+
+	query := "SELECT COUNT(*) FROM " + USERS_TABLE
+	if condition != "" {
+		query += " WHERE " + condition
+	}
+
+	var count int64
+	if err := u.db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (*repositories.User, error) {
+	var id string
+	user := &repositories.User{}
+
+	err := row.Scan(&id, &user.FirstName, &user.LastName, &user.Nickname, &user.Password, &user.Email, &user.Country, &user.CreatedAt, &user.UpdatedAt, &user.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Id, err = primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (u *UserRepositorySQLImpl) createUpdatedUser(user *repositories.User) (string, []interface{}, error) {
+	var assignments []string
+	var args []interface{}
+
+	setField := func(column string, value interface{}) {
+		args = append(args, value)
+		assignments = append(assignments, fmt.Sprintf("%s = %s", column, u.dialect.Placeholder(len(args))))
+	}
+
+	if user.FirstName != "" {
+		setField("first_name", user.FirstName)
+	}
+
+	if user.LastName != "" {
+		setField("last_name", user.LastName)
+	}
+
+	if user.Nickname != "" {
+		setField("nickname", user.Nickname)
+	}
+
+	if user.Password != "" {
+		hashedPassword, err := repositories.HashPassword(user.Password)
+		if err != nil {
+			return "", nil, err
+		}
+		setField("password", hashedPassword)
+	}
+
+	if user.Email != "" {
+		setField("email", user.Email)
+	}
+
+	if user.Country != "" {
+		setField("country", user.Country)
+	}
+
+	if err := user.ValidateClearedFields(); err != nil {
+		return "", nil, err
+	}
+	for _, field := range user.ClearedFields {
+		setField(field, "")
+	}
+
+	if len(assignments) == 0 {
+		return "", nil, repositories.ErrNothingToUpdate
+	}
+
+	setField("updated_at", time.Now().UTC())
+	assignments = append(assignments, "version = version + 1")
+
+	return strings.Join(assignments, ", "), args, nil
+}
+
+func (u *UserRepositorySQLImpl) translateError(err error) error {
+	if u.dialect.IsUniqueViolation(err) {
+		return repositories.ErrUserAlreadyExist
+	}
+	return err
+}
+
+func (u *UserRepositorySQLImpl) placeholders(count int) string {
+	markers := make([]string, count)
+	for i := range markers {
+		markers[i] = u.dialect.Placeholder(i + 1)
+	}
+	return strings.Join(markers, ", ")
+}
+
+func int64Ptr(value int64) *int64 {
+	return &value
+}
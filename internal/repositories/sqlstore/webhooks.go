@@ -0,0 +1,296 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	WEBHOOKS_TABLE   = "webhooks"
+	DELIVERIES_TABLE = "webhook_deliveries"
+
+	webhookColumns  = "id, url, event_types, secret, created_at"
+	deliveryColumns = "id, webhook_id, event_id, change_data, status, attempts, next_attempt_at, created_at, updated_at"
+)
+
+func (u *UserRepositorySQLImpl) AddWebhook(ctx context.Context, webhook *repositories.Webhook) (*repositories.Webhook, error) {
+	_, err := u.db.ExecContext(ctx,
+		fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", WEBHOOKS_TABLE, webhookColumns, u.placeholders(5)),
+		webhook.Id.Hex(), webhook.Url, strings.Join(webhook.EventTypes, ","), webhook.Secret, webhook.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (u *UserRepositorySQLImpl) GetWebhook(ctx context.Context, id string) (*repositories.Webhook, error) {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+
+	webhooks, err := u.queryWebhooks(ctx, "WHERE id = "+u.dialect.Placeholder(1), id)
+	if err != nil {
+		return nil, err
+	}
+	if len(webhooks) == 0 {
+		return nil, repositories.ErrWebhookNotFound
+	}
+
+	return webhooks[0], nil
+}
+
+func (u *UserRepositorySQLImpl) GetWebhooks(ctx context.Context) ([]*repositories.Webhook, error) {
+	return u.queryWebhooks(ctx, "")
+}
+
+func (u *UserRepositorySQLImpl) queryWebhooks(ctx context.Context, condition string, args ...interface{}) ([]*repositories.Webhook, error) {
+	rows, err := u.db.QueryContext(ctx,
+		fmt.Sprintf("SELECT %s FROM %s %s ORDER BY created_at, id", webhookColumns, WEBHOOKS_TABLE, condition),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*repositories.Webhook{}
+	for rows.Next() {
+		var id, eventTypes string
+		webhook := &repositories.Webhook{}
+		if err := rows.Scan(&id, &webhook.Url, &eventTypes, &webhook.Secret, &webhook.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		if webhook.Id, err = primitive.ObjectIDFromHex(id); err != nil {
+			return nil, err
+		}
+		if eventTypes != "" {
+			webhook.EventTypes = strings.Split(eventTypes, ",")
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+func (u *UserRepositorySQLImpl) RemoveWebhook(ctx context.Context, id string) error {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return err
+	}
+
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = %s", WEBHOOKS_TABLE, u.dialect.Placeholder(1)), id)
+	if err != nil {
+		return err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return repositories.ErrWebhookNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE webhook_id = %s", DELIVERIES_TABLE, u.dialect.Placeholder(1)), id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (u *UserRepositorySQLImpl) AddDelivery(ctx context.Context, delivery *repositories.WebhookDelivery) error {
+	changeData, err := json.Marshal(delivery.Change)
+	if err != nil {
+		return err
+	}
+	attempts, err := marshalAttempts(delivery.Attempts)
+	if err != nil {
+		return err
+	}
+
+	_, err = u.db.ExecContext(ctx,
+		fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (webhook_id, event_id) DO NOTHING",
+			DELIVERIES_TABLE, deliveryColumns, u.placeholders(9)),
+		delivery.Id.Hex(), delivery.WebhookId.Hex(), delivery.EventId, string(changeData), delivery.Status, attempts,
+		delivery.NextAttemptAt.UTC(), delivery.CreatedAt.UTC(), delivery.UpdatedAt.UTC(),
+	)
+	return err
+}
+
+func (u *UserRepositorySQLImpl) GetDelivery(ctx context.Context, id string) (*repositories.WebhookDelivery, error) {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+
+	deliveries, err := u.queryDeliveries(ctx, "id = "+u.dialect.Placeholder(1), "id", 0, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return nil, repositories.ErrDeliveryNotFound
+	}
+
+	return deliveries[0], nil
+}
+
+func (u *UserRepositorySQLImpl) GetDeliveries(ctx context.Context, query repositories.DeliveryQuery) ([]*repositories.WebhookDelivery, error) {
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+	if !query.WebhookId.IsZero() {
+		args = append(args, query.WebhookId.Hex())
+		conditions = append(conditions, "webhook_id = "+u.dialect.Placeholder(len(args)))
+	}
+	if query.Status != "" {
+		args = append(args, query.Status)
+		conditions = append(conditions, "status = "+u.dialect.Placeholder(len(args)))
+	}
+
+	return u.queryDeliveries(ctx, strings.Join(conditions, " AND "), "created_at DESC, id DESC", query.Limit, args...)
+}
+
+func (u *UserRepositorySQLImpl) DueDeliveries(ctx context.Context, now time.Time, limit int64) ([]*repositories.WebhookDelivery, error) {
+	return u.queryDeliveries(ctx,
+		fmt.Sprintf("status = %s AND next_attempt_at <= %s", u.dialect.Placeholder(1), u.dialect.Placeholder(2)),
+		"next_attempt_at, id", limit, repositories.DeliveryPending, now.UTC(),
+	)
+}
+
+// queryDeliveries returns at most limit deliveries matching the condition, in the given order.
+func (u *UserRepositorySQLImpl) queryDeliveries(ctx context.Context, condition, orderBy string, limit int64, args ...interface{}) ([]*repositories.WebhookDelivery, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s", deliveryColumns, DELIVERIES_TABLE, condition, orderBy)
+	if limit > 0 {
+		args = append(args, limit)
+		query += " LIMIT " + u.dialect.Placeholder(len(args))
+	}
+
+	rows, err := u.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*repositories.WebhookDelivery
+	for rows.Next() {
+		var id, webhookId, changeData, attempts string
+		delivery := &repositories.WebhookDelivery{}
+		err := rows.Scan(&id, &webhookId, &delivery.EventId, &changeData, &delivery.Status, &attempts,
+			&delivery.NextAttemptAt, &delivery.CreatedAt, &delivery.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if delivery.Id, err = primitive.ObjectIDFromHex(id); err != nil {
+			return nil, err
+		}
+		if delivery.WebhookId, err = primitive.ObjectIDFromHex(webhookId); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(changeData), &delivery.Change); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(attempts), &delivery.Attempts); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (u *UserRepositorySQLImpl) RecordAttempt(ctx context.Context, id primitive.ObjectID, attempt repositories.WebhookAttempt, status string, nextAttemptAt time.Time) error {
+	tx, err := u.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var storedAttempts string
+	err = tx.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT attempts FROM %s WHERE id = %s %s", DELIVERIES_TABLE, u.dialect.Placeholder(1), u.dialect.LockForUpdate),
+		id.Hex(),
+	).Scan(&storedAttempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.ErrDeliveryNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var attempts []repositories.WebhookAttempt
+	if err := json.Unmarshal([]byte(storedAttempts), &attempts); err != nil {
+		return err
+	}
+	updatedAttempts, err := marshalAttempts(append(attempts, attempt))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		fmt.Sprintf("UPDATE %s SET attempts = %s, status = %s, next_attempt_at = %s, updated_at = %s WHERE id = %s",
+			DELIVERIES_TABLE, u.dialect.Placeholder(1), u.dialect.Placeholder(2), u.dialect.Placeholder(3),
+			u.dialect.Placeholder(4), u.dialect.Placeholder(5)),
+		updatedAttempts, status, nextAttemptAt.UTC(), time.Now().UTC(), id.Hex(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (u *UserRepositorySQLImpl) RetryDelivery(ctx context.Context, id primitive.ObjectID, nextAttemptAt time.Time) error {
+	result, err := u.db.ExecContext(ctx,
+		fmt.Sprintf("UPDATE %s SET status = %s, next_attempt_at = %s, updated_at = %s WHERE id = %s",
+			DELIVERIES_TABLE, u.dialect.Placeholder(1), u.dialect.Placeholder(2), u.dialect.Placeholder(3), u.dialect.Placeholder(4)),
+		repositories.DeliveryPending, nextAttemptAt.UTC(), time.Now().UTC(), id.Hex(),
+	)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return repositories.ErrDeliveryNotFound
+	}
+	return nil
+}
+
+func (u *UserRepositorySQLImpl) PurgeDeliveries(ctx context.Context, before time.Time) error {
+	_, err := u.db.ExecContext(ctx,
+		fmt.Sprintf("DELETE FROM %s WHERE status IN (%s, %s) AND updated_at < %s",
+			DELIVERIES_TABLE, u.dialect.Placeholder(1), u.dialect.Placeholder(2), u.dialect.Placeholder(3)),
+		repositories.DeliverySucceeded, repositories.DeliveryDead, before.UTC(),
+	)
+	return err
+}
+
+// marshalAttempts stores the attempts as a JSON array, empty if there are none.
+func marshalAttempts(attempts []repositories.WebhookAttempt) (string, error) {
+	if attempts == nil {
+		attempts = []repositories.WebhookAttempt{}
+	}
+
+	data, err := json.Marshal(attempts)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
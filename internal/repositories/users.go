@@ -1,21 +1,47 @@
 package repositories
 
 import (
+	"fmt"
+	"slices"
 	"time"
 
+	filter "github.com/dlion/faceit_challenge/internal"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// User is a user of the service. The empty CLEARABLE_FIELDS are left out of the MongoDB documents,
+// as the cleared ones.
 type User struct {
 	Id        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	FirstName string             `json:"first_name" bson:"first_name"`
-	LastName  string             `json:"last_name" bson:"last_name"`
-	Nickname  string             `json:"nickname" bson:"nickname"`
+	FirstName string             `json:"first_name" bson:"first_name,omitempty"`
+	LastName  string             `json:"last_name" bson:"last_name,omitempty"`
+	Nickname  string             `json:"nickname" bson:"nickname,omitempty"`
 	Password  string             `json:"password" bson:"password"`
 	Email     string             `json:"email" bson:"email"`
-	Country   string             `json:"country" bson:"country"`
+	Country   string             `json:"country" bson:"country,omitempty"`
 	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
 	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+	// Version is 1 for an added user and incremented by every update. When updating a user it's the
+	// version the stored user must have, the update fails with ErrVersionMismatch otherwise; zero
+	// updates the user whatever its version.
+	Version int64 `json:"version" bson:"version"`
+	// ClearedFields are the names of the fields emptied when updating the user, among CLEARABLE_FIELDS.
+	ClearedFields []string `json:"-" bson:"-"`
+}
+
+// CLEARABLE_FIELDS are the fields an update can clear, the email and the password are always required.
+// They're the names of both the MongoDB fields and the SQL columns.
+var CLEARABLE_FIELDS = []string{"first_name", "last_name", "nickname", "country"}
+
+// ValidateClearedFields returns ErrNotClearable if any of the ClearedFields isn't clearable.
+func (u *User) ValidateClearedFields() error {
+	for _, field := range u.ClearedFields {
+		if !slices.Contains(CLEARABLE_FIELDS, field) {
+			return fmt.Errorf("%w: %s", ErrNotClearable, field)
+		}
+	}
+	return nil
 }
 
 func NewRepoUser(firstName, lastName, nickname, password, email, country string) *User {
@@ -28,3 +54,81 @@ func NewRepoUser(firstName, lastName, nickname, password, email, country string)
 		Country:   country,
 	}
 }
+
+// SortValue returns the value of one of the filter.SORTABLE_FIELDS, nil for any other field.
+func (u *User) SortValue(field string) interface{} {
+	switch field {
+	case "first_name":
+		return u.FirstName
+	case "last_name":
+		return u.LastName
+	case "nickname":
+		return u.Nickname
+	case "email":
+		return u.Email
+	case "country":
+		return u.Country
+	case "created_at":
+		return u.CreatedAt
+	case "updated_at":
+		return u.UpdatedAt
+	default:
+		return nil
+	}
+}
+
+// SearchTerms returns the terms the user is found by with a full-text search.
+func (u *User) SearchTerms() []string {
+	return filter.SearchTerms(u.FirstName, u.LastName, u.Nickname, u.Email)
+}
+
+// Snapshot returns the user without its password, nil if there's no user.
+func (u *User) Snapshot() *notifier.UserSnapshot {
+	if u == nil {
+		return nil
+	}
+
+	return &notifier.UserSnapshot{
+		Id:        u.Id.Hex(),
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Nickname:  u.Nickname,
+		Email:     u.Email,
+		Country:   u.Country,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+		Version:   u.Version,
+	}
+}
+
+// ChangedFields returns the names of the fields whose value differs between the user before and after
+// a change. A nil user has no value, so for an inserted or removed user every field with a value is
+// listed. The timestamps of the change aren't listed.
+func ChangedFields(before, after *User) []string {
+	if before == nil {
+		before = &User{}
+	}
+	if after == nil {
+		after = &User{}
+	}
+
+	fields := []struct {
+		name          string
+		before, after string
+	}{
+		{"first_name", before.FirstName, after.FirstName},
+		{"last_name", before.LastName, after.LastName},
+		{"nickname", before.Nickname, after.Nickname},
+		{"password", before.Password, after.Password},
+		{"email", before.Email, after.Email},
+		{"country", before.Country, after.Country},
+	}
+
+	var changedFields []string
+	for _, field := range fields {
+		if field.before != field.after {
+			changedFields = append(changedFields, field.name)
+		}
+	}
+	return changedFields
+}
@@ -0,0 +1,126 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrWebhookNotFound  = errors.New("the webhook doesn't exist in the db")
+	ErrDeliveryNotFound = errors.New("the webhook delivery doesn't exist in the db")
+)
+
+const (
+	// DeliveryPending is the status of a delivery waiting for its next attempt.
+	DeliveryPending = "pending"
+	// DeliverySucceeded is the status of a delivery accepted by the endpoint.
+	DeliverySucceeded = "succeeded"
+	// DeliveryDead is the status of a delivery that failed all its attempts, it's retried only on request.
+	DeliveryDead = "dead"
+)
+
+// Webhook is an endpoint the changes of the users are posted to.
+type Webhook struct {
+	Id  primitive.ObjectID `bson:"_id"`
+	Url string             `bson:"url"`
+	// EventTypes are the notifier.ChangeOperation constants of the changes posted, all of them if empty.
+	EventTypes []string `bson:"event_types"`
+	// Secret signs the body of the requests, so the endpoint can verify they're sent by the service.
+	Secret    string    `bson:"secret"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+func NewWebhook(url string, eventTypes []string, secret string) *Webhook {
+	return &Webhook{
+		Id:         primitive.NewObjectID(),
+		Url:        url,
+		EventTypes: eventTypes,
+		Secret:     secret,
+		CreatedAt:  time.Now(),
+	}
+}
+
+// Accepts reports whether the change is posted to the webhook.
+func (w *Webhook) Accepts(change notifier.ChangeData) bool {
+	return len(w.EventTypes) == 0 || slices.Contains(w.EventTypes, change.OperationType)
+}
+
+// WebhookAttempt is the outcome of a request posting a change to a webhook.
+type WebhookAttempt struct {
+	AttemptedAt time.Time `bson:"attempted_at" json:"attempted_at"`
+	// StatusCode is the HTTP status of the response, zero if none was received.
+	StatusCode int `bson:"status_code" json:"status_code"`
+	// Error describes why the attempt failed, empty if it succeeded.
+	Error string `bson:"error" json:"error,omitempty"`
+}
+
+// WebhookDelivery is a change to be posted to a webhook, with the attempts made so far.
+type WebhookDelivery struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	WebhookId primitive.ObjectID `bson:"webhook_id"`
+	// EventId is the id of the change, a webhook gets a single delivery for every change.
+	EventId       string              `bson:"event_id"`
+	Change        notifier.ChangeData `bson:"change"`
+	Status        string              `bson:"status"`
+	Attempts      []WebhookAttempt    `bson:"attempts"`
+	NextAttemptAt time.Time           `bson:"next_attempt_at"`
+	CreatedAt     time.Time           `bson:"created_at"`
+	UpdatedAt     time.Time           `bson:"updated_at"`
+}
+
+// NewWebhookDelivery returns a pending delivery of the change to the webhook, due right away.
+func NewWebhookDelivery(webhookId primitive.ObjectID, change notifier.ChangeData) *WebhookDelivery {
+	now := time.Now()
+	return &WebhookDelivery{
+		Id:            primitive.NewObjectID(),
+		WebhookId:     webhookId,
+		EventId:       change.EventId,
+		Change:        change,
+		Status:        DeliveryPending,
+		Attempts:      []WebhookAttempt{},
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+// DeliveryQuery selects the deliveries returned by GetDeliveries.
+type DeliveryQuery struct {
+	// WebhookId selects the deliveries of a webhook, all of them if it's zero.
+	WebhookId primitive.ObjectID
+	// Status selects the deliveries with the status, all of them if it's empty.
+	Status string
+	Limit  int64
+}
+
+// WebhookStore keeps the registered webhooks and the deliveries of the changes to them.
+type WebhookStore interface {
+	AddWebhook(ctx context.Context, webhook *Webhook) (*Webhook, error)
+	// GetWebhook returns ErrWebhookNotFound if the webhook doesn't exist.
+	GetWebhook(ctx context.Context, id string) (*Webhook, error)
+	// GetWebhooks returns all the webhooks, the oldest first.
+	GetWebhooks(ctx context.Context) ([]*Webhook, error)
+	// RemoveWebhook removes the webhook together with its deliveries.
+	RemoveWebhook(ctx context.Context, id string) error
+
+	// AddDelivery records the delivery, unless the webhook already has one of the same change.
+	AddDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	// GetDelivery returns ErrDeliveryNotFound if the delivery doesn't exist.
+	GetDelivery(ctx context.Context, id string) (*WebhookDelivery, error)
+	// GetDeliveries returns at most query.Limit deliveries selected by the query, the most recent first.
+	GetDeliveries(ctx context.Context, query DeliveryQuery) ([]*WebhookDelivery, error)
+	// DueDeliveries returns at most limit pending deliveries due by the given time, the longest due first.
+	DueDeliveries(ctx context.Context, now time.Time, limit int64) ([]*WebhookDelivery, error)
+	// RecordAttempt appends the attempt to the delivery and sets its status and next attempt time.
+	RecordAttempt(ctx context.Context, id primitive.ObjectID, attempt WebhookAttempt, status string, nextAttemptAt time.Time) error
+	// RetryDelivery makes the delivery pending again and due at the given time, keeping its attempts.
+	// It returns ErrDeliveryNotFound if the delivery doesn't exist.
+	RetryDelivery(ctx context.Context, id primitive.ObjectID, nextAttemptAt time.Time) error
+	// PurgeDeliveries deletes the succeeded and the dead deliveries last updated before the given time.
+	PurgeDeliveries(ctx context.Context, before time.Time) error
+}
@@ -2,8 +2,14 @@ package filter
 
 import (
 	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Filter interface {
@@ -16,15 +22,66 @@ type UserFilter struct {
 	Nickname  *string
 	Country   *string
 	Email     *string
-	Offset    *int64
-	Limit     *int64
+	// NicknamePrefix matches the nicknames starting with it.
+	NicknamePrefix *string
+	// EmailIgnoringCase matches the email regardless of the case of the letters.
+	EmailIgnoringCase *string
+	// Countries matches any of the countries.
+	Countries []string
+	// CreatedAfter and CreatedBefore are exclusive, UpdatedSince is inclusive.
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedSince  *time.Time
+	// Search is a full-text search, matching the users having at least one of its words
+	// as prefix of a word of their names, nickname or email.
+	Search *string
+	Offset *int64
+	Limit  *int64
+	// Sort is the order of the listing, DEFAULT_SORT if empty.
+	Sort []SortField
+	// PageToken is the opaque token received by the clients, once verified it's decoded to the Cursor.
+	PageToken *string
+	Cursor    *Cursor
+	// IncludeTotal requests the number of users matching the filter across all the pages.
+	IncludeTotal bool
+}
+
+// Cursor is the position of a user in a sorted listing, it selects the users following it.
+type Cursor struct {
+	// Sort is the order of the listing the cursor belongs to.
+	Sort []SortField
+	// Values holds the value of each sort field for the user, a string or a time.Time.
+	Values []interface{}
+	Id     primitive.ObjectID
+	// Position is the number of users preceding the first one following the cursor,
+	// the offset of the page it selects.
+	Position int64
+}
+
+func (c *Cursor) String() string {
+	if c == nil {
+		return "empty"
+	}
+
+	values := make([]string, len(c.Values))
+	for i, value := range c.Values {
+		if t, ok := value.(time.Time); ok {
+			values[i] = t.Format(time.RFC3339Nano)
+		} else {
+			values[i] = fmt.Sprint(value)
+		}
+	}
+	return fmt.Sprintf("%s:%s/%s", FormatSort(c.Sort), strings.Join(values, "/"), c.Id.Hex())
 }
 
 func (uf *UserFilter) String() string {
 	return fmt.Sprintf(
-		"FirstName:%v, LastName:%v, Nickname:%v, Country:%v, Email:%v, Offset:%v, Limit:%v",
+		"FirstName:%v, LastName:%v, Nickname:%v, Country:%v, Email:%v, NicknamePrefix:%v, EmailIgnoringCase:%v, "+
+			"Countries:%v, CreatedAfter:%v, CreatedBefore:%v, UpdatedSince:%v, Search:%v, Offset:%v, Limit:%v, Sort:%v, Cursor:%v",
 		stringValue(uf.FirstName), stringValue(uf.LastName), stringValue(uf.Nickname),
-		stringValue(uf.Country), stringValue(uf.Email), int64Value(uf.Offset), int64Value(uf.Limit),
+		stringValue(uf.Country), stringValue(uf.Email), stringValue(uf.NicknamePrefix), stringValue(uf.EmailIgnoringCase),
+		uf.Countries, timeValue(uf.CreatedAfter), timeValue(uf.CreatedBefore), timeValue(uf.UpdatedSince),
+		stringValue(uf.Search), int64Value(uf.Offset), int64Value(uf.Limit), FormatSort(uf.SortOrder()), uf.Cursor,
 	)
 }
 
@@ -32,28 +89,273 @@ func (u *UserFilter) ToBSON() bson.M {
 	query := bson.M{}
 
 	if u.FirstName != nil {
-		query["first_name"] = *u.FirstName
+		query["first_name"] = EqualBSON(*u.FirstName)
 	}
 
 	if u.LastName != nil {
-		query["last_name"] = u.LastName
+		query["last_name"] = EqualBSON(*u.LastName)
 	}
 
 	if u.Nickname != nil {
-		query["nickname"] = *u.Nickname
+		query["nickname"] = EqualBSON(*u.Nickname)
 	}
 
 	if u.Country != nil {
-		query["country"] = *u.Country
+		query["country"] = EqualBSON(*u.Country)
 	}
 
 	if u.Email != nil {
-		query["email"] = *u.Email
+		query["email"] = EqualBSON(*u.Email)
+	}
+
+	// The operators of a field already matched by equality are merged with it.
+	addOperator := func(field, operator string, value interface{}) {
+		operators, ok := query[field].(bson.M)
+		if !ok {
+			operators = bson.M{}
+			if equalValue, exists := query[field]; exists {
+				operators["$eq"] = equalValue
+			}
+			query[field] = operators
+		}
+		if _, exists := operators[operator]; exists {
+			// As the $in of the countries and of the empty country, the operator is a further condition.
+			conditions, _ := query["$and"].(bson.A)
+			query["$and"] = append(conditions, bson.M{field: bson.M{operator: value}})
+			return
+		}
+		operators[operator] = value
+	}
+
+	// The user input is quoted so that it's matched literally, the prefix regex can use the index.
+	if u.NicknamePrefix != nil {
+		addOperator("nickname", "$regex", primitive.Regex{Pattern: "^" + regexp.QuoteMeta(*u.NicknamePrefix)})
+	}
+
+	// The email folded by the repository is matched by equality, as by the SQL backends.
+	if u.EmailIgnoringCase != nil {
+		query["email_folded"] = FoldCase(*u.EmailIgnoringCase)
+	}
+
+	if len(u.Countries) > 0 {
+		var countries interface{} = u.Countries
+		if slices.Contains(u.Countries, "") {
+			countries = append(bson.A{nil}, toBSONArray(u.Countries)...)
+		}
+		addOperator("country", "$in", countries)
+	}
+
+	if u.CreatedAfter != nil {
+		addOperator("created_at", "$gt", *u.CreatedAfter)
+	}
+
+	if u.CreatedBefore != nil {
+		addOperator("created_at", "$lt", *u.CreatedBefore)
+	}
+
+	if u.UpdatedSince != nil {
+		addOperator("updated_at", "$gte", *u.UpdatedSince)
+	}
+
+	// The text index covers the search terms of the users, the words are matched as whole terms.
+	if words := u.SearchWords(); len(words) > 0 {
+		query["$text"] = bson.M{"$search": strings.Join(words, " "), "$language": "none"}
+	}
+
+	if u.Cursor != nil {
+		query["$or"] = u.Cursor.toBSON()
 	}
 
 	return query
 }
 
+// EqualBSON matches the value of a string field. The empty value matches the missing field too,
+// as the fields cleared or never set are left out of the documents.
+func EqualBSON(value string) interface{} {
+	if value == "" {
+		return bson.M{"$in": bson.A{"", nil}}
+	}
+	return value
+}
+
+// toBSON selects the users following the cursor: those having a following value in one of the sort
+// fields and the same values in the previous ones, or the same values in all of them and a following id.
+func (c *Cursor) toBSON() bson.A {
+	var conditions bson.A
+	for i := 0; i <= len(c.Sort); i++ {
+		condition := bson.M{}
+		for j := 0; j < i; j++ {
+			condition[c.Sort[j].Field] = equalValueBSON(c.Values[j])
+		}
+
+		if i < len(c.Sort) {
+			condition[c.Sort[i].Field] = followingBSON(c.Values[i], c.Sort[i].Descending)
+		} else {
+			condition["_id"] = bson.M{followingOperator(IdDescending(c.Sort)): c.Id}
+		}
+
+		conditions = append(conditions, condition)
+	}
+	return conditions
+}
+
+func toBSONArray(values []string) bson.A {
+	array := make(bson.A, len(values))
+	for i, value := range values {
+		array[i] = value
+	}
+	return array
+}
+
+func equalValueBSON(value interface{}) interface{} {
+	if text, ok := value.(string); ok {
+		return EqualBSON(text)
+	}
+	return value
+}
+
+// followingBSON selects the values following the one in the sort order. The missing fields, the
+// empty ones, are sorted by MongoDB as null, before any string, so they follow every non empty value
+// in descending order.
+func followingBSON(value interface{}, descending bool) bson.M {
+	if text, ok := value.(string); ok && descending && text != "" {
+		return bson.M{"$not": bson.M{"$gte": text}}
+	}
+	return bson.M{followingOperator(descending): value}
+}
+
+func followingOperator(descending bool) string {
+	if descending {
+		return "$lt"
+	}
+	return "$gt"
+}
+
+// ToSQL returns the WHERE condition matching the filter and its arguments,
+// placeholder builds the parameter marker for the n-th argument (e.g. $1 or ?).
+// The condition is empty if there's nothing to filter.
+func (u *UserFilter) ToSQL(placeholder func(n int) string) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	addCondition := func(column string, value *string) {
+		if value == nil {
+			return
+		}
+		args = append(args, *value)
+		conditions = append(conditions, fmt.Sprintf("%s = %s", column, placeholder(len(args))))
+	}
+
+	addCondition("first_name", u.FirstName)
+	addCondition("last_name", u.LastName)
+	addCondition("nickname", u.Nickname)
+	addCondition("country", u.Country)
+	addCondition("email", u.Email)
+
+	addComparison := func(condition string, value interface{}) {
+		if t, ok := value.(time.Time); ok {
+			value = t.UTC()
+		}
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, placeholder(len(args))))
+	}
+
+	// The prefix is compared as a string rather than with LIKE, which would need escaping
+	// and is case-insensitive in SQLite.
+	if u.NicknamePrefix != nil {
+		args = append(args, utf8.RuneCountInString(*u.NicknamePrefix), *u.NicknamePrefix)
+		conditions = append(conditions, fmt.Sprintf("substr(nickname, 1, %s) = %s", placeholder(len(args)-1), placeholder(len(args))))
+	}
+
+	if u.EmailIgnoringCase != nil {
+		addComparison("email_folded = %s", FoldCase(*u.EmailIgnoringCase))
+	}
+
+	if len(u.Countries) > 0 {
+		countryPlaceholders := make([]string, len(u.Countries))
+		for i, country := range u.Countries {
+			args = append(args, country)
+			countryPlaceholders[i] = placeholder(len(args))
+		}
+		conditions = append(conditions, fmt.Sprintf("country IN (%s)", strings.Join(countryPlaceholders, ", ")))
+	}
+
+	if u.CreatedAfter != nil {
+		addComparison("created_at > %s", *u.CreatedAfter)
+	}
+
+	if u.CreatedBefore != nil {
+		addComparison("created_at < %s", *u.CreatedBefore)
+	}
+
+	if u.UpdatedSince != nil {
+		addComparison("updated_at >= %s", *u.UpdatedSince)
+	}
+
+	if words := u.SearchWords(); len(words) > 0 {
+		searchConditions := make([]string, len(words))
+		for i, word := range words {
+			args = append(args, searchTermPattern(word))
+			searchConditions[i] = "search_terms LIKE " + placeholder(len(args))
+		}
+		conditions = append(conditions, "("+strings.Join(searchConditions, " OR ")+")")
+	}
+
+	if u.Cursor != nil {
+		var cursorConditions []string
+		cursorConditions, args = u.Cursor.toSQL(placeholder, args)
+		conditions = append(conditions, "("+strings.Join(cursorConditions, " OR ")+")")
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// toSQL is the SQL equivalent of toBSON, it returns the alternative conditions and the arguments
+// extended with their values.
+func (c *Cursor) toSQL(placeholder func(n int) string, args []interface{}) ([]string, []interface{}) {
+	addArg := func(value interface{}) string {
+		if t, ok := value.(time.Time); ok {
+			value = t.UTC()
+		}
+		args = append(args, value)
+		return placeholder(len(args))
+	}
+
+	var conditions []string
+	for i := 0; i <= len(c.Sort); i++ {
+		var terms []string
+		for j := 0; j < i; j++ {
+			terms = append(terms, fmt.Sprintf("%s = %s", c.Sort[j].Field, addArg(c.Values[j])))
+		}
+
+		if i < len(c.Sort) {
+			terms = append(terms, fmt.Sprintf("%s %s %s", c.Sort[i].Field, followingComparison(c.Sort[i].Descending), addArg(c.Values[i])))
+		} else {
+			terms = append(terms, fmt.Sprintf("id %s %s", followingComparison(IdDescending(c.Sort)), addArg(c.Id.Hex())))
+		}
+
+		if len(terms) == 1 {
+			conditions = append(conditions, terms[0])
+		} else {
+			conditions = append(conditions, "("+strings.Join(terms, " AND ")+")")
+		}
+	}
+	return conditions, args
+}
+
+func followingComparison(descending bool) string {
+	if descending {
+		return "<"
+	}
+	return ">"
+}
+
+// searchTermPattern matches a term in the stored search terms, the words of the search
+// are made of letters and digits only so they don't need to be escaped.
+func searchTermPattern(word string) string {
+	return "% " + word + " %"
+}
+
 func stringValue(s *string) string {
 	if s == nil {
 		return "empty"
@@ -61,6 +363,13 @@ func stringValue(s *string) string {
 	return *s
 }
 
+func timeValue(t *time.Time) string {
+	if t == nil {
+		return "empty"
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
 func int64Value(i *int64) string {
 	if i == nil {
 		return "empty"
@@ -101,6 +410,41 @@ func (f *filterBuilder) ByEmail(email *string) *filterBuilder {
 	return f
 }
 
+func (f *filterBuilder) ByNicknamePrefix(prefix *string) *filterBuilder {
+	f.filter.NicknamePrefix = prefix
+	return f
+}
+
+func (f *filterBuilder) ByEmailIgnoringCase(email *string) *filterBuilder {
+	f.filter.EmailIgnoringCase = email
+	return f
+}
+
+func (f *filterBuilder) ByCountries(countries ...string) *filterBuilder {
+	f.filter.Countries = countries
+	return f
+}
+
+func (f *filterBuilder) CreatedAfter(createdAfter *time.Time) *filterBuilder {
+	f.filter.CreatedAfter = createdAfter
+	return f
+}
+
+func (f *filterBuilder) CreatedBefore(createdBefore *time.Time) *filterBuilder {
+	f.filter.CreatedBefore = createdBefore
+	return f
+}
+
+func (f *filterBuilder) UpdatedSince(updatedSince *time.Time) *filterBuilder {
+	f.filter.UpdatedSince = updatedSince
+	return f
+}
+
+func (f *filterBuilder) Search(search *string) *filterBuilder {
+	f.filter.Search = search
+	return f
+}
+
 func (f *filterBuilder) WithLimit(limit *int64) *filterBuilder {
 	f.filter.Limit = limit
 	return f
@@ -111,6 +455,26 @@ func (f *filterBuilder) WithOffset(offset *int64) *filterBuilder {
 	return f
 }
 
+func (f *filterBuilder) SortBy(sort ...SortField) *filterBuilder {
+	f.filter.Sort = sort
+	return f
+}
+
+func (f *filterBuilder) WithPageToken(pageToken *string) *filterBuilder {
+	f.filter.PageToken = pageToken
+	return f
+}
+
+func (f *filterBuilder) After(cursor *Cursor) *filterBuilder {
+	f.filter.Cursor = cursor
+	return f
+}
+
+func (f *filterBuilder) IncludingTotal() *filterBuilder {
+	f.filter.IncludeTotal = true
+	return f
+}
+
 func (f *filterBuilder) Build() *UserFilter {
 	return f.filter
 }
@@ -1,10 +1,13 @@
 package filter
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestUserFilter(t *testing.T) {
@@ -19,4 +22,128 @@ func TestUserFilter(t *testing.T) {
 
 		assert.Equal(t, bson.M{"country": "UK", "email": "test@test.com"}, userFilter.ToBSON())
 	})
+
+	t.Run("Generate a parameterised SQL condition from a filter", func(t *testing.T) {
+		country := "UK"
+		email := "test@test.com"
+		userFilter := NewFilterBuilder().
+			ByCountry(&country).
+			ByEmail(&email).
+			Build()
+
+		condition, args := userFilter.ToSQL(func(n int) string { return fmt.Sprintf("$%d", n) })
+
+		assert.Equal(t, "country = $1 AND email = $2", condition)
+		assert.Equal(t, []interface{}{"UK", "test@test.com"}, args)
+	})
+
+	t.Run("Generate an empty SQL condition from an empty filter", func(t *testing.T) {
+		condition, args := NewFilterBuilder().Build().ToSQL(func(n int) string { return "?" })
+
+		assert.Empty(t, condition)
+		assert.Empty(t, args)
+	})
+
+	t.Run("Generate a BSON with the filter operators", func(t *testing.T) {
+		nickname := "johnd"
+		prefix := "jo.n*"
+		email := "John.Doe@Example.com"
+		createdAfter := time.Date(2024, 7, 19, 12, 25, 25, 0, time.UTC)
+		createdBefore := createdAfter.Add(time.Hour)
+		userFilter := NewFilterBuilder().
+			ByNickname(&nickname).
+			ByNicknamePrefix(&prefix).
+			ByEmailIgnoringCase(&email).
+			ByCountries("UK", "IE", "FR").
+			CreatedAfter(&createdAfter).
+			CreatedBefore(&createdBefore).
+			UpdatedSince(&createdAfter).
+			Build()
+
+		assert.Equal(t, bson.M{
+			"nickname":     bson.M{"$eq": "johnd", "$regex": primitive.Regex{Pattern: `^jo\.n\*`}},
+			"email_folded": "JOHN.DOE@EXAMPLE.COM",
+			"country":      bson.M{"$in": []string{"UK", "IE", "FR"}},
+			"created_at":   bson.M{"$gt": createdAfter, "$lt": createdBefore},
+			"updated_at":   bson.M{"$gte": createdAfter},
+		}, userFilter.ToBSON())
+	})
+
+	t.Run("Generate a parameterised SQL condition with the filter operators", func(t *testing.T) {
+		prefix := "jo%n_"
+		email := "John.Doe@Example.com"
+		createdAfter := time.Date(2024, 7, 19, 12, 25, 25, 0, time.UTC)
+		userFilter := NewFilterBuilder().
+			ByNicknamePrefix(&prefix).
+			ByEmailIgnoringCase(&email).
+			ByCountries("UK", "IE").
+			CreatedAfter(&createdAfter).
+			UpdatedSince(&createdAfter).
+			Build()
+
+		condition, args := userFilter.ToSQL(func(n int) string { return fmt.Sprintf("$%d", n) })
+
+		assert.Equal(t, "substr(nickname, 1, $1) = $2 AND email_folded = $3 AND country IN ($4, $5) AND created_at > $6 AND updated_at >= $7", condition)
+		assert.Equal(t, []interface{}{5, "jo%n_", "JOHN.DOE@EXAMPLE.COM", "UK", "IE", createdAfter, createdAfter}, args)
+	})
+
+	t.Run("Select the users following the cursor", func(t *testing.T) {
+		createdAt := time.Date(2024, 7, 19, 12, 25, 25, 0, time.UTC)
+		id := primitive.NewObjectIDFromTimestamp(createdAt)
+		userFilter := NewFilterBuilder().After(&Cursor{Sort: DEFAULT_SORT, Values: []interface{}{createdAt}, Id: id}).Build()
+
+		assert.Equal(t, bson.M{"$or": bson.A{
+			bson.M{"created_at": bson.M{"$lt": createdAt}},
+			bson.M{"created_at": createdAt, "_id": bson.M{"$lt": id}},
+		}}, userFilter.ToBSON())
+
+		condition, args := userFilter.ToSQL(func(n int) string { return fmt.Sprintf("$%d", n) })
+		assert.Equal(t, "(created_at < $1 OR (created_at = $2 AND id < $3))", condition)
+		assert.Equal(t, []interface{}{createdAt, createdAt, id.Hex()}, args)
+	})
+
+	t.Run("Select the users following the cursor of a listing sorted by more fields", func(t *testing.T) {
+		updatedAt := time.Date(2024, 7, 19, 12, 25, 25, 0, time.UTC)
+		id := primitive.NewObjectIDFromTimestamp(updatedAt)
+		country := "UK"
+		sort := []SortField{{Field: "updated_at", Descending: true}, {Field: "nickname"}}
+		userFilter := NewFilterBuilder().
+			ByCountry(&country).
+			SortBy(sort...).
+			After(&Cursor{Sort: sort, Values: []interface{}{updatedAt, "johnd"}, Id: id}).
+			Build()
+
+		assert.Equal(t, bson.M{"country": "UK", "$or": bson.A{
+			bson.M{"updated_at": bson.M{"$lt": updatedAt}},
+			bson.M{"updated_at": updatedAt, "nickname": bson.M{"$gt": "johnd"}},
+			bson.M{"updated_at": updatedAt, "nickname": "johnd", "_id": bson.M{"$gt": id}},
+		}}, userFilter.ToBSON())
+
+		condition, args := userFilter.ToSQL(func(n int) string { return fmt.Sprintf("$%d", n) })
+		assert.Equal(t, "country = $1 AND (updated_at < $2 OR (updated_at = $3 AND nickname > $4) OR (updated_at = $5 AND nickname = $6 AND id > $7))", condition)
+		assert.Equal(t, []interface{}{"UK", updatedAt, updatedAt, "johnd", updatedAt, "johnd", id.Hex()}, args)
+	})
+
+	t.Run("Match the empty fields missing from the documents", func(t *testing.T) {
+		empty := ""
+		id := primitive.NewObjectID()
+		sort := []SortField{{Field: "country", Descending: true}, {Field: "last_name", Descending: true}}
+		userFilter := NewFilterBuilder().
+			ByCountry(&empty).
+			ByCountries("UK", "").
+			SortBy(sort...).
+			After(&Cursor{Sort: sort, Values: []interface{}{"", "Doe"}, Id: id}).
+			Build()
+
+		emptyValue := bson.M{"$in": bson.A{"", nil}}
+		assert.Equal(t, bson.M{
+			"country": emptyValue,
+			"$and":    bson.A{bson.M{"country": bson.M{"$in": bson.A{nil, "UK", ""}}}},
+			"$or": bson.A{
+				bson.M{"country": bson.M{"$lt": ""}},
+				bson.M{"country": emptyValue, "last_name": bson.M{"$not": bson.M{"$gte": "Doe"}}},
+				bson.M{"country": emptyValue, "last_name": "Doe", "_id": bson.M{"$lt": id}},
+			},
+		}, userFilter.ToBSON())
+	})
 }
@@ -0,0 +1,62 @@
+package filter
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+)
+
+// Matches reports whether the user satisfies the conditions of the filter, the same selected
+// by ToBSON and ToSQL but the cursor. A nil filter matches every user.
+func (u *UserFilter) Matches(user *notifier.UserSnapshot) bool {
+	if u == nil {
+		return true
+	}
+
+	words := u.SearchWords()
+	return matchesField(user.FirstName, u.FirstName) &&
+		matchesField(user.LastName, u.LastName) &&
+		matchesField(user.Nickname, u.Nickname) &&
+		matchesField(user.Country, u.Country) &&
+		matchesField(user.Email, u.Email) &&
+		(u.NicknamePrefix == nil || strings.HasPrefix(user.Nickname, *u.NicknamePrefix)) &&
+		(u.EmailIgnoringCase == nil || FoldCase(user.Email) == FoldCase(*u.EmailIgnoringCase)) &&
+		(len(u.Countries) == 0 || slices.Contains(u.Countries, user.Country)) &&
+		(u.CreatedAfter == nil || user.CreatedAt.After(*u.CreatedAfter)) &&
+		(u.CreatedBefore == nil || user.CreatedAt.Before(*u.CreatedBefore)) &&
+		(u.UpdatedSince == nil || !user.UpdatedAt.Before(*u.UpdatedSince)) &&
+		(len(words) == 0 || CountSearchMatches(SearchTerms(user.FirstName, user.LastName, user.Nickname, user.Email), words) > 0)
+}
+
+// FoldCase maps every letter of the text to the smallest one of its Unicode case folding orbit,
+// so that two texts are equal regardless of the case, as by strings.EqualFold, when their folded
+// texts are equal. It's computed in Go rather than by the databases, whose LOWER functions differ:
+// the SQLite one only folds the ASCII letters.
+func FoldCase(text string) string {
+	return strings.Map(func(r rune) rune {
+		folded := r
+		for next := unicode.SimpleFold(r); next != r; next = unicode.SimpleFold(next) {
+			if next < folded {
+				folded = next
+			}
+		}
+		return folded
+	}, text)
+}
+
+// CountSearchMatches returns how many words of a search are among the sorted search terms of a user.
+func CountSearchMatches(terms []string, words []string) int {
+	matches := 0
+	for _, word := range words {
+		if _, found := slices.BinarySearch(terms, word); found {
+			matches++
+		}
+	}
+	return matches
+}
+
+func matchesField(value string, expected *string) bool {
+	return expected == nil || value == *expected
+}
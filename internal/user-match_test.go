@@ -0,0 +1,65 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatches(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	user := &notifier.UserSnapshot{
+		FirstName: "John",
+		LastName:  "Smith",
+		Nickname:  "johnny",
+		Email:     "John.Smith@example.com",
+		Country:   "UK",
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+	}
+
+	t.Run("Match every user without conditions", func(t *testing.T) {
+		var userFilter *UserFilter
+		assert.True(t, userFilter.Matches(user))
+		assert.True(t, NewFilterBuilder().Build().Matches(user))
+	})
+
+	t.Run("Match the users satisfying every condition", func(t *testing.T) {
+		prefix, email, search := "john", "john.smith@EXAMPLE.com", "smith"
+		createdAfter := createdAt.Add(-time.Hour)
+		userFilter := NewFilterBuilder().
+			ByNicknamePrefix(&prefix).
+			ByEmailIgnoringCase(&email).
+			ByCountries("ITA", "UK").
+			CreatedAfter(&createdAfter).
+			UpdatedSince(&createdAt).
+			Search(&search).
+			Build()
+
+		assert.True(t, userFilter.Matches(user))
+	})
+
+	t.Run("Don't match the users failing a condition", func(t *testing.T) {
+		country, search := "ITA", "doe"
+		createdBefore := createdAt
+
+		assert.False(t, NewFilterBuilder().ByCountry(&country).Build().Matches(user))
+		assert.False(t, NewFilterBuilder().ByCountries("ITA").Build().Matches(user))
+		assert.False(t, NewFilterBuilder().CreatedBefore(&createdBefore).Build().Matches(user))
+		assert.False(t, NewFilterBuilder().Search(&search).Build().Matches(user))
+	})
+}
+
+func TestFoldCase(t *testing.T) {
+	t.Run("Fold the texts equal regardless of the case to the same text", func(t *testing.T) {
+		assert.Equal(t, FoldCase("john.smith@example.com"), FoldCase("John.Smith@EXAMPLE.com"))
+		assert.Equal(t, FoldCase("örjan@example.com"), FoldCase("ÖRJAN@example.com"))
+		assert.Equal(t, FoldCase("στάσις"), FoldCase("ΣΤΆΣΙΣ"))
+	})
+
+	t.Run("Keep the texts differing by more than the case different", func(t *testing.T) {
+		assert.NotEqual(t, FoldCase("orjan@example.com"), FoldCase("örjan@example.com"))
+	})
+}
@@ -0,0 +1,88 @@
+package filter
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// MAX_SEARCH_WORD_LENGTH is the number of letters and digits of a word the search takes into account,
+// it bounds the number of prefixes stored for every word of the users.
+const MAX_SEARCH_WORD_LENGTH = 20
+
+// SEARCH_SCORE_FIELD is the MongoDB field the users are ranked by in a search, computed by SearchScoreBSON.
+const SEARCH_SCORE_FIELD = "search_score"
+
+// SearchWords splits a text in lowercase words made of letters and digits, without duplicates.
+// The longer words are cut at MAX_SEARCH_WORD_LENGTH.
+func SearchWords(text string) []string {
+	var words []string
+	seen := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), isSeparator) {
+		if runes := []rune(word); len(runes) > MAX_SEARCH_WORD_LENGTH {
+			word = string(runes[:MAX_SEARCH_WORD_LENGTH])
+		}
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// SearchTerms returns the terms a user is found by: every prefix of the words of its
+// searchable values, so that "jo" finds "John", up to MAX_SEARCH_WORD_LENGTH letters and digits.
+// The terms are sorted and without duplicates.
+func SearchTerms(values ...string) []string {
+	seen := map[string]bool{}
+	for _, value := range values {
+		for _, word := range SearchWords(value) {
+			runes := []rune(word)
+			for length := 1; length <= len(runes); length++ {
+				seen[string(runes[:length])] = true
+			}
+		}
+	}
+
+	terms := make([]string, 0, len(seen))
+	for term := range seen {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+	return terms
+}
+
+// JoinSearchTerms stores the terms in a single string, each one surrounded by spaces
+// so that a term is matched by the SQL pattern "% term %".
+func JoinSearchTerms(terms []string) string {
+	if len(terms) == 0 {
+		return ""
+	}
+	return " " + strings.Join(terms, " ") + " "
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// SearchWords returns the words of the full-text search, nil if there's no search.
+func (u *UserFilter) SearchWords() []string {
+	if u.Search == nil {
+		return nil
+	}
+	return SearchWords(*u.Search)
+}
+
+// SearchScoreBSON returns the MongoDB expression of the relevance of a user for the search:
+// how many words of the search are among its terms, as CountSearchMatches does.
+func (u *UserFilter) SearchScoreBSON() bson.M {
+	return bson.M{"$size": bson.M{"$setIntersection": bson.A{"$search_terms", u.SearchWords()}}}
+}
+
+// RanksByRelevance reports whether the users are sorted by how many words of the search they match,
+// that's the case of a search without an explicit sort. Every backend breaks the ties with the default sort.
+func (u *UserFilter) RanksByRelevance() bool {
+	return len(u.SearchWords()) > 0 && len(u.Sort) == 0
+}
@@ -0,0 +1,57 @@
+package filter
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSearch(t *testing.T) {
+	t.Run("Split a text in lowercase words without duplicates", func(t *testing.T) {
+		assert.Equal(t, []string{"john", "doe", "example", "com"}, SearchWords(" John.Doe@example.com  john "))
+		assert.Empty(t, SearchWords(" @. "))
+	})
+
+	t.Run("Return every prefix of the words as search terms", func(t *testing.T) {
+		assert.Equal(t, []string{"d", "do", "doe", "j", "jo", "joe"}, SearchTerms("Joe", "joe DOE"))
+		assert.Equal(t, " d do doe ", JoinSearchTerms(SearchTerms("doe")))
+		assert.Equal(t, "", JoinSearchTerms(nil))
+	})
+
+	t.Run("Cut the long words so that their prefixes are bounded", func(t *testing.T) {
+		word := "Pneumonoultramicroscopicsilicovolcanoconiosis"
+
+		assert.Equal(t, []string{"pneumonoultramicrosc"}, SearchWords(word))
+		assert.Len(t, SearchTerms(word), MAX_SEARCH_WORD_LENGTH)
+	})
+
+	t.Run("Generate a text search and a sort by relevance", func(t *testing.T) {
+		search := "John smith"
+		userFilter := NewFilterBuilder().Search(&search).Build()
+
+		assert.Equal(t, bson.M{"$text": bson.M{"$search": "john smith", "$language": "none"}}, userFilter.ToBSON())
+		assert.Equal(t, bson.D{
+			{Key: "search_score", Value: -1},
+			{Key: "created_at", Value: -1},
+			{Key: "_id", Value: -1},
+		}, userFilter.SortBSON())
+
+		placeholder := func(n int) string { return fmt.Sprintf("$%d", n) }
+		condition, args := userFilter.ToSQL(placeholder)
+		assert.Equal(t, "(search_terms LIKE $1 OR search_terms LIKE $2)", condition)
+		orderBy, args := userFilter.OrderBySQL(placeholder, args)
+		assert.Equal(t, "(CASE WHEN search_terms LIKE $3 THEN 1 ELSE 0 END + CASE WHEN search_terms LIKE $4 THEN 1 ELSE 0 END) DESC, created_at DESC, id DESC", orderBy)
+		assert.Equal(t, []interface{}{"% john %", "% smith %", "% john %", "% smith %"}, args)
+		assert.Equal(t, bson.M{"$size": bson.M{"$setIntersection": bson.A{"$search_terms", []string{"john", "smith"}}}}, userFilter.SearchScoreBSON())
+	})
+
+	t.Run("Don't rank by relevance a search with a sort", func(t *testing.T) {
+		search := "john"
+		userFilter := NewFilterBuilder().Search(&search).SortBy(SortField{Field: "nickname"}).Build()
+
+		assert.False(t, userFilter.RanksByRelevance())
+		assert.Equal(t, bson.D{{Key: "nickname", Value: 1}, {Key: "_id", Value: 1}}, userFilter.SortBSON())
+	})
+}
@@ -0,0 +1,159 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var ErrInvalidSort = errors.New("invalid sort")
+
+// SORTABLE_FIELDS are the fields the users can be sorted by, they're the names of both
+// the MongoDB fields and the SQL columns, each of them is indexed together with the id.
+var SORTABLE_FIELDS = []string{"first_name", "last_name", "nickname", "email", "country", "created_at", "updated_at"}
+
+// DEFAULT_SORT lists the most recently created users first.
+var DEFAULT_SORT = []SortField{{Field: "created_at", Descending: true}}
+
+// SortField is a field the users are sorted by, in ascending order unless Descending.
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+func (s SortField) String() string {
+	if s.Descending {
+		return "-" + s.Field
+	}
+	return s.Field
+}
+
+// ParseSort parses a comma separated list of fields, each one prefixed by "-" to sort it in
+// descending order (e.g. "-updated_at,nickname"). An empty sort is parsed as nil.
+func ParseSort(sort string) ([]SortField, error) {
+	if strings.TrimSpace(sort) == "" {
+		return nil, nil
+	}
+
+	var fields []SortField
+	for _, expression := range strings.Split(sort, ",") {
+		expression = strings.TrimSpace(expression)
+		fields = append(fields, SortField{
+			Field:      strings.TrimPrefix(expression, "-"),
+			Descending: strings.HasPrefix(expression, "-"),
+		})
+	}
+
+	if err := ValidateSort(fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// ValidateSort checks that every field is sortable and appears only once.
+func ValidateSort(sort []SortField) error {
+	seen := map[string]bool{}
+	for _, field := range sort {
+		if !IsSortable(field.Field) {
+			return fmt.Errorf("%w: %q is not sortable, the sortable fields are %s", ErrInvalidSort, field.Field, strings.Join(SORTABLE_FIELDS, ", "))
+		}
+
+		if seen[field.Field] {
+			return fmt.Errorf("%w: %q is repeated", ErrInvalidSort, field.Field)
+		}
+		seen[field.Field] = true
+	}
+	return nil
+}
+
+func IsSortable(field string) bool {
+	for _, sortable := range SORTABLE_FIELDS {
+		if field == sortable {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTimeField reports whether the values of the field are times rather than strings.
+func IsTimeField(field string) bool {
+	return field == "created_at" || field == "updated_at"
+}
+
+// FormatSort is the inverse of ParseSort.
+func FormatSort(sort []SortField) string {
+	expressions := make([]string, len(sort))
+	for i, field := range sort {
+		expressions[i] = field.String()
+	}
+	return strings.Join(expressions, ",")
+}
+
+// SortOrder returns the order of the listing, the default one if no sort is given.
+func (u *UserFilter) SortOrder() []SortField {
+	if len(u.Sort) == 0 {
+		return DEFAULT_SORT
+	}
+	return u.Sort
+}
+
+// IdDescending reports the direction of the id, which breaks the ties of the sort.
+// It follows the last sort field so that a single index on (field, id) serves both directions.
+func IdDescending(sort []SortField) bool {
+	return sort[len(sort)-1].Descending
+}
+
+// SortBSON returns the MongoDB sort of the listing, ranking by relevance sorts first by the search score
+// the users are given by SearchScoreBSON.
+func (u *UserFilter) SortBSON() bson.D {
+	sort := u.SortOrder()
+
+	sortBSON := bson.D{}
+	if u.RanksByRelevance() {
+		sortBSON = append(sortBSON, bson.E{Key: SEARCH_SCORE_FIELD, Value: -1})
+	}
+	for _, field := range sort {
+		sortBSON = append(sortBSON, bson.E{Key: field.Field, Value: direction(field.Descending)})
+	}
+	return append(sortBSON, bson.E{Key: "_id", Value: direction(IdDescending(sort))})
+}
+
+// OrderBySQL returns the expressions of the ORDER BY clause of the listing and the arguments extended with
+// their values, ranking by relevance sorts first by the number of words of the search matched by the users.
+func (u *UserFilter) OrderBySQL(placeholder func(n int) string, args []interface{}) (string, []interface{}) {
+	sort := u.SortOrder()
+
+	var expressions []string
+	if u.RanksByRelevance() {
+		var matches []string
+		for _, word := range u.SearchWords() {
+			args = append(args, searchTermPattern(word))
+			matches = append(matches, fmt.Sprintf("CASE WHEN search_terms LIKE %s THEN 1 ELSE 0 END", placeholder(len(args))))
+		}
+		expressions = append(expressions, "("+strings.Join(matches, " + ")+") DESC")
+	}
+
+	for _, field := range sort {
+		expressions = append(expressions, field.Field+sqlDirection(field.Descending))
+	}
+	expressions = append(expressions, "id"+sqlDirection(IdDescending(sort)))
+
+	return strings.Join(expressions, ", "), args
+}
+
+func direction(descending bool) int {
+	if descending {
+		return -1
+	}
+	return 1
+}
+
+func sqlDirection(descending bool) string {
+	if descending {
+		return " DESC"
+	}
+	return " ASC"
+}
@@ -0,0 +1,50 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSort(t *testing.T) {
+	t.Run("Parse the sort fields and their direction", func(t *testing.T) {
+		sort, err := ParseSort("-updated_at, nickname")
+
+		assert.NoError(t, err)
+		assert.Equal(t, []SortField{{Field: "updated_at", Descending: true}, {Field: "nickname"}}, sort)
+		assert.Equal(t, "-updated_at,nickname", FormatSort(sort))
+	})
+
+	t.Run("Parse an empty sort as the default one", func(t *testing.T) {
+		sort, err := ParseSort("")
+
+		assert.NoError(t, err)
+		assert.Nil(t, sort)
+		assert.Equal(t, DEFAULT_SORT, NewFilterBuilder().SortBy(sort...).Build().SortOrder())
+	})
+
+	t.Run("Reject the fields that aren't sortable", func(t *testing.T) {
+		for _, sort := range []string{"password", "-nickname,", "created_at;DROP TABLE users", "country,-country"} {
+			_, err := ParseSort(sort)
+			assert.ErrorIs(t, err, ErrInvalidSort, sort)
+		}
+	})
+
+	t.Run("Break the ties by id in the direction of the last field", func(t *testing.T) {
+		userFilter := NewFilterBuilder().SortBy(SortField{Field: "updated_at", Descending: true}, SortField{Field: "nickname"}).Build()
+
+		assert.Equal(t, bson.D{{Key: "updated_at", Value: -1}, {Key: "nickname", Value: 1}, {Key: "_id", Value: 1}}, userFilter.SortBSON())
+		orderBy, args := userFilter.OrderBySQL(func(n int) string { return "?" }, nil)
+		assert.Equal(t, "updated_at DESC, nickname ASC, id ASC", orderBy)
+		assert.Empty(t, args)
+	})
+
+	t.Run("Sort by creation time and id, most recent first, by default", func(t *testing.T) {
+		userFilter := NewFilterBuilder().Build()
+
+		assert.Equal(t, bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}, userFilter.SortBSON())
+		orderBy, _ := userFilter.OrderBySQL(func(n int) string { return "?" }, nil)
+		assert.Equal(t, "created_at DESC, id DESC", orderBy)
+	})
+}
@@ -0,0 +1,400 @@
+// Package webhook posts the changes of the users to the registered webhooks.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	"github.com/dlion/faceit_challenge/pkg/cloudevents"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DEFAULT_INTERVAL        = time.Second
+	DEFAULT_BATCH_SIZE      = 100
+	DEFAULT_MAX_ATTEMPTS    = 8
+	DEFAULT_INITIAL_BACKOFF = 5 * time.Second
+	DEFAULT_MAX_BACKOFF     = time.Hour
+	DEFAULT_TIMEOUT         = 10 * time.Second
+	DEFAULT_RETENTION       = 7 * 24 * time.Hour
+	DEFAULT_CONCURRENCY     = 8
+
+	SIGNATURE_HEADER  = "X-Webhook-Signature"
+	TIMESTAMP_HEADER  = "X-Webhook-Timestamp"
+	EVENT_ID_HEADER   = "X-Webhook-Event-Id"
+	WEBHOOK_ID_HEADER = "X-Webhook-Id"
+)
+
+// Dispatcher records a delivery of every change for each webhook accepting it, then posts the
+// deliveries retrying the failed ones with an exponential backoff. A delivery failing all its
+// attempts is dead, it's posted again only when it's redelivered.
+// The changes are published to the dispatcher by the outbox relay, which moves its cursor past a
+// change once its deliveries are recorded, so none is lost while the dispatcher is down. The deliveries are
+// recorded before being posted, so the pending ones are posted after a restart, and a change is
+// delivered at least once: an endpoint may receive it twice, with the same event id.
+type Dispatcher struct {
+	store          repositories.WebhookStore
+	client         *http.Client
+	interval       time.Duration
+	batchSize      int64
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	retention      time.Duration
+	concurrency    int
+	// mode is the CloudEvents mode of the requests, they post the bare changes if it's empty.
+	mode   cloudevents.Mode
+	source string
+	wake   chan struct{}
+}
+
+type Option func(*Dispatcher)
+
+// WithHTTPClient sets the client posting the deliveries, by default it times out after DEFAULT_TIMEOUT.
+func WithHTTPClient(client *http.Client) Option {
+	return func(d *Dispatcher) {
+		d.client = client
+	}
+}
+
+// WithInterval sets how often the due deliveries are looked for when the dispatcher isn't woken up.
+func WithInterval(interval time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.interval = interval
+	}
+}
+
+// WithBatchSize sets how many due deliveries are read at a time.
+func WithBatchSize(batchSize int64) Option {
+	return func(d *Dispatcher) {
+		d.batchSize = batchSize
+	}
+}
+
+// WithMaxAttempts sets how many times a delivery is attempted before it's dead.
+func WithMaxAttempts(maxAttempts int) Option {
+	return func(d *Dispatcher) {
+		d.maxAttempts = maxAttempts
+	}
+}
+
+// WithBackoff sets the wait after the first failed attempt, doubled after each of the following
+// ones up to maxBackoff.
+func WithBackoff(initialBackoff, maxBackoff time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.initialBackoff = initialBackoff
+		d.maxBackoff = maxBackoff
+	}
+}
+
+// WithConcurrency sets how many webhooks are posted to at the same time, the deliveries to the same
+// webhook are posted one at a time, in order. A non-positive concurrency is ignored.
+func WithConcurrency(concurrency int) Option {
+	return func(d *Dispatcher) {
+		if concurrency <= 0 {
+			log.Printf("Ignoring the webhook concurrency %d, it must be positive", concurrency)
+			return
+		}
+		d.concurrency = concurrency
+	}
+}
+
+// WithRetention sets how long the succeeded and the dead deliveries are kept before being purged.
+func WithRetention(retention time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.retention = retention
+	}
+}
+
+// WithCloudEvents makes the requests carry the changes as CloudEvents from the source, in the mode.
+func WithCloudEvents(source string, mode cloudevents.Mode) Option {
+	return func(d *Dispatcher) {
+		d.source = source
+		d.mode = mode
+	}
+}
+
+func NewDispatcher(store repositories.WebhookStore, options ...Option) *Dispatcher {
+	dispatcher := &Dispatcher{
+		store:          store,
+		client:         &http.Client{Timeout: DEFAULT_TIMEOUT},
+		interval:       DEFAULT_INTERVAL,
+		batchSize:      DEFAULT_BATCH_SIZE,
+		maxAttempts:    DEFAULT_MAX_ATTEMPTS,
+		initialBackoff: DEFAULT_INITIAL_BACKOFF,
+		maxBackoff:     DEFAULT_MAX_BACKOFF,
+		retention:      DEFAULT_RETENTION,
+		concurrency:    DEFAULT_CONCURRENCY,
+		wake:           make(chan struct{}, 1),
+	}
+	for _, option := range options {
+		option(dispatcher)
+	}
+	return dispatcher
+}
+
+// Wake makes the dispatcher post the due deliveries right away, instead of at the next poll.
+// It never blocks, the wake ups requested while the dispatcher is busy are coalesced.
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run posts the due deliveries whenever it's woken up or the interval elapses, until the context is done.
+// The succeeded and the dead deliveries are purged once their retention has passed.
+func (d *Dispatcher) Run(ctx context.Context) {
+	log.Printf("Starting the webhook dispatcher")
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	lastPurge := time.Now()
+	for {
+		if _, err := d.DeliverDue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Can't deliver the webhooks, %v", err)
+		}
+
+		if time.Since(lastPurge) > d.retention/10 {
+			if err := d.store.PurgeDeliveries(ctx, time.Now().Add(-d.retention)); err != nil && ctx.Err() == nil {
+				log.Printf("Can't purge the webhook deliveries, %v", err)
+			}
+			lastPurge = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping the webhook dispatcher")
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Publish records the deliveries of the change and wakes the dispatcher up to post them, it makes the
+// dispatcher a notifier.Publisher of the outbox relay. The change is published again while an error
+// is returned, the deliveries already recorded aren't recorded twice.
+func (d *Dispatcher) Publish(ctx context.Context, change notifier.ChangeData) error {
+	err := d.Record(ctx, change)
+	d.Wake()
+	return err
+}
+
+// Close does nothing, the deliveries are posted until the context of Run is done.
+func (d *Dispatcher) Close() error {
+	return nil
+}
+
+// Record adds a delivery of the change for every webhook accepting it. A delivery that can't be
+// added doesn't stop the others, the errors are returned joined.
+func (d *Dispatcher) Record(ctx context.Context, change notifier.ChangeData) error {
+	webhooks, err := d.store.GetWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, webhook := range webhooks {
+		if !webhook.Accepts(change) {
+			continue
+		}
+		if err := d.store.AddDelivery(ctx, repositories.NewWebhookDelivery(webhook.Id, change)); err != nil {
+			errs = append(errs, fmt.Errorf("can't record the delivery to the webhook %s: %w", webhook.Id.Hex(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DeliverDue posts all the due deliveries, the longest due first, returning how many were attempted.
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, error) {
+	attempted := 0
+	for {
+		deliveries, err := d.store.DueDeliveries(ctx, time.Now(), d.batchSize)
+		if err != nil {
+			return attempted, err
+		}
+
+		batchAttempted, err := d.deliverBatch(ctx, deliveries)
+		attempted += batchAttempted
+		if err != nil {
+			return attempted, err
+		}
+
+		if d.batchSize <= 0 || int64(len(deliveries)) < d.batchSize {
+			return attempted, nil
+		}
+	}
+}
+
+// deliverBatch posts the deliveries to different webhooks concurrently, to at most d.concurrency
+// webhooks at a time, and the ones to the same webhook one after the other in the given order, so a
+// slow endpoint doesn't hold back the others. A delivery whose attempt can't be recorded stops the
+// following ones to its webhook, the errors are returned joined.
+func (d *Dispatcher) deliverBatch(ctx context.Context, deliveries []*repositories.WebhookDelivery) (int, error) {
+	var webhookIds []primitive.ObjectID
+	deliveriesByWebhook := map[primitive.ObjectID][]*repositories.WebhookDelivery{}
+	for _, delivery := range deliveries {
+		if _, found := deliveriesByWebhook[delivery.WebhookId]; !found {
+			webhookIds = append(webhookIds, delivery.WebhookId)
+		}
+		deliveriesByWebhook[delivery.WebhookId] = append(deliveriesByWebhook[delivery.WebhookId], delivery)
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		attempted int
+		errs      []error
+	)
+	workers := make(chan struct{}, d.concurrency)
+	for _, webhookId := range webhookIds {
+		workers <- struct{}{}
+		wg.Add(1)
+		go func(webhookDeliveries []*repositories.WebhookDelivery) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+
+			for _, delivery := range webhookDeliveries {
+				err := d.deliver(ctx, delivery)
+
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					attempted++
+				}
+				mu.Unlock()
+
+				if err != nil {
+					return
+				}
+			}
+		}(deliveriesByWebhook[webhookId])
+	}
+	wg.Wait()
+
+	return attempted, errors.Join(errs...)
+}
+
+// deliver posts the delivery and records the attempt, it returns an error only if the attempt can't be recorded.
+func (d *Dispatcher) deliver(ctx context.Context, delivery *repositories.WebhookDelivery) error {
+	webhook, err := d.store.GetWebhook(ctx, delivery.WebhookId.Hex())
+	if errors.Is(err, repositories.ErrWebhookNotFound) {
+		// Removed together with its deliveries while they were being posted.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	attempt := d.post(ctx, webhook, delivery.Change)
+	attempts := len(delivery.Attempts) + 1
+
+	status := repositories.DeliverySucceeded
+	nextAttemptAt := attempt.AttemptedAt
+	if attempt.Error != "" {
+		log.Printf("Can't deliver the change %s to the webhook %s (attempt %d), %s", delivery.EventId, webhook.Id.Hex(), attempts, attempt.Error)
+		status = repositories.DeliveryPending
+		nextAttemptAt = attempt.AttemptedAt.Add(d.backoff(attempts))
+		if attempts >= d.maxAttempts {
+			log.Printf("The delivery %s to the webhook %s is dead after %d attempts", delivery.Id.Hex(), webhook.Id.Hex(), attempts)
+			status = repositories.DeliveryDead
+		}
+	}
+
+	return d.store.RecordAttempt(ctx, delivery.Id, attempt, status, nextAttemptAt)
+}
+
+// backoff returns the wait after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.initialBackoff
+	for i := 1; i < attempts && backoff < d.maxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, d.maxBackoff)
+}
+
+// post sends the change to the webhook, any response but a 2xx is a failed attempt.
+func (d *Dispatcher) post(ctx context.Context, webhook *repositories.Webhook, change notifier.ChangeData) repositories.WebhookAttempt {
+	attempt := repositories.WebhookAttempt{AttemptedAt: time.Now()}
+
+	header, body, err := d.encode(change)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	timestamp := strconv.FormatInt(attempt.AttemptedAt.Unix(), 10)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.Header.Set(WEBHOOK_ID_HEADER, webhook.Id.Hex())
+	request.Header.Set(EVENT_ID_HEADER, change.EventId)
+	request.Header.Set(TIMESTAMP_HEADER, timestamp)
+	request.Header.Set(SIGNATURE_HEADER, Sign(webhook.Secret, timestamp, body))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+
+	attempt.StatusCode = response.StatusCode
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("unexpected status %d", response.StatusCode)
+	}
+	return attempt
+}
+
+// encode returns the headers and the body of the request posting the change.
+func (d *Dispatcher) encode(change notifier.ChangeData) (http.Header, []byte, error) {
+	if d.mode != "" {
+		event, err := cloudevents.NewEvent(d.source, change)
+		if err != nil {
+			return nil, nil, err
+		}
+		return event.HTTPMessage(d.mode)
+	}
+
+	body, err := json.Marshal(change)
+	if err != nil {
+		return nil, nil, err
+	}
+	return http.Header{"Content-Type": {"application/json"}}, body, nil
+}
+
+// Sign returns the signature of a request: the hex HMAC-SHA256, keyed by the secret of the webhook,
+// of its timestamp and body joined by a dot, prefixed by "sha256=".
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,267 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dlion/faceit_challenge/internal/outbox"
+	"github.com/dlion/faceit_challenge/internal/repositories"
+	memoryRepositories "github.com/dlion/faceit_challenge/internal/repositories/memory"
+	"github.com/dlion/faceit_challenge/pkg/cloudevents"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDispatcher(t *testing.T) {
+	ctx := context.Background()
+
+	addWebhook := func(t *testing.T, store repositories.WebhookStore, url string, eventTypes ...string) *repositories.Webhook {
+		webhook, err := store.AddWebhook(ctx, repositories.NewWebhook(url, eventTypes, "testSecretOfTheWebhook"))
+		require.NoError(t, err)
+		return webhook
+	}
+
+	deliveriesOf := func(t *testing.T, store repositories.WebhookStore, webhook *repositories.Webhook) []*repositories.WebhookDelivery {
+		deliveries, err := store.GetDeliveries(ctx, repositories.DeliveryQuery{WebhookId: webhook.Id})
+		require.NoError(t, err)
+		return deliveries
+	}
+
+	change := notifier.ChangeData{EventId: "event1", Sequence: 1, OperationType: notifier.ChangeOperationDelete, UserId: "testUser"}
+
+	t.Run("Post the signed change to the webhooks accepting it", func(t *testing.T) {
+		requests := make(chan *http.Request, 1)
+		bodies := make(chan []byte, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, _ := io.ReadAll(req.Body)
+			requests <- req
+			bodies <- body
+		}))
+		defer server.Close()
+
+		store := memoryRepositories.NewUserRepositoryMemoryImpl()
+		webhook := addWebhook(t, store, server.URL, notifier.ChangeOperationDelete)
+		otherWebhook := addWebhook(t, store, server.URL, notifier.ChangeOperationInsert)
+		dispatcher := NewDispatcher(store)
+
+		require.NoError(t, dispatcher.Record(ctx, change))
+		require.NoError(t, dispatcher.Record(ctx, change))
+		delivered, err := dispatcher.DeliverDue(ctx)
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, delivered)
+		req, body := <-requests, <-bodies
+		assert.Equal(t, webhook.Id.Hex(), req.Header.Get(WEBHOOK_ID_HEADER))
+		assert.Equal(t, "event1", req.Header.Get(EVENT_ID_HEADER))
+		assert.Equal(t, Sign("testSecretOfTheWebhook", req.Header.Get(TIMESTAMP_HEADER), body), req.Header.Get(SIGNATURE_HEADER))
+		var received notifier.ChangeData
+		require.NoError(t, json.Unmarshal(body, &received))
+		assert.Equal(t, "testUser", received.UserId)
+
+		deliveries := deliveriesOf(t, store, webhook)
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, repositories.DeliverySucceeded, deliveries[0].Status)
+		assert.Equal(t, http.StatusOK, deliveries[0].Attempts[0].StatusCode)
+		assert.Empty(t, deliveriesOf(t, store, otherWebhook))
+	})
+
+	t.Run("Post the change as a CloudEvent", func(t *testing.T) {
+		for _, mode := range []cloudevents.Mode{cloudevents.ModeStructured, cloudevents.ModeBinary} {
+			requests := make(chan *http.Request, 1)
+			bodies := make(chan []byte, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				body, _ := io.ReadAll(req.Body)
+				requests <- req
+				bodies <- body
+			}))
+
+			store := memoryRepositories.NewUserRepositoryMemoryImpl()
+			addWebhook(t, store, server.URL)
+			dispatcher := NewDispatcher(store, WithCloudEvents("/test", mode))
+
+			require.NoError(t, dispatcher.Record(ctx, change))
+			_, err := dispatcher.DeliverDue(ctx)
+			server.Close()
+
+			require.NoError(t, err)
+			req, body := <-requests, <-bodies
+			assert.Equal(t, Sign("testSecretOfTheWebhook", req.Header.Get(TIMESTAMP_HEADER), body), req.Header.Get(SIGNATURE_HEADER), mode)
+			event, err := cloudevents.FromHTTP(req.Header, body)
+			require.NoError(t, err, mode)
+			assert.Equal(t, "event1", event.Id, mode)
+			assert.Equal(t, "/test", event.Source, mode)
+			assert.Equal(t, cloudevents.TYPE_USER_DELETED, event.Type, mode)
+			assert.Equal(t, "testUser", event.Subject, mode)
+		}
+	})
+
+	t.Run("Post the deliveries to a webhook in order while a slow webhook is posted to", func(t *testing.T) {
+		release := make(chan struct{})
+		slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			<-release
+		}))
+		defer slowServer.Close()
+		eventIds := make(chan string, 3)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			eventIds <- req.Header.Get(EVENT_ID_HEADER)
+		}))
+		defer server.Close()
+
+		store := memoryRepositories.NewUserRepositoryMemoryImpl()
+		addWebhook(t, store, slowServer.URL, notifier.ChangeOperationInsert)
+		addWebhook(t, store, server.URL, notifier.ChangeOperationDelete)
+		dispatcher := NewDispatcher(store)
+		require.NoError(t, dispatcher.Record(ctx, notifier.ChangeData{EventId: "slowEvent", OperationType: notifier.ChangeOperationInsert}))
+		for _, eventId := range []string{"event1", "event2", "event3"} {
+			require.NoError(t, dispatcher.Record(ctx, notifier.ChangeData{EventId: eventId, OperationType: notifier.ChangeOperationDelete}))
+		}
+
+		delivered := make(chan int, 1)
+		go func() {
+			attempted, err := dispatcher.DeliverDue(ctx)
+			assert.NoError(t, err)
+			delivered <- attempted
+		}()
+
+		for _, eventId := range []string{"event1", "event2", "event3"} {
+			select {
+			case received := <-eventIds:
+				assert.Equal(t, eventId, received)
+			case <-time.After(time.Second):
+				t.Fatal("Expected the delivery to the other webhook while the slow one is posted to")
+			}
+		}
+		close(release)
+		assert.Equal(t, 4, <-delivered)
+	})
+
+	t.Run("Retry the failed deliveries with an exponential backoff until they're dead", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		store := memoryRepositories.NewUserRepositoryMemoryImpl()
+		webhook := addWebhook(t, store, server.URL)
+		dispatcher := NewDispatcher(store, WithMaxAttempts(3), WithBackoff(time.Minute, 90*time.Second))
+		require.NoError(t, dispatcher.Record(ctx, change))
+
+		var nextAttempts []time.Duration
+		for i := 0; i < 3; i++ {
+			delivered, err := dispatcher.DeliverDue(ctx)
+			require.NoError(t, err)
+			require.Equal(t, 1, delivered)
+
+			delivery := deliveriesOf(t, store, webhook)[0]
+			lastAttempt := delivery.Attempts[len(delivery.Attempts)-1]
+			assert.Equal(t, http.StatusServiceUnavailable, lastAttempt.StatusCode)
+			nextAttempts = append(nextAttempts, delivery.NextAttemptAt.Sub(lastAttempt.AttemptedAt))
+			require.NoError(t, store.RetryDelivery(ctx, delivery.Id, time.Now()))
+			if i < 2 {
+				assert.Equal(t, repositories.DeliveryPending, delivery.Status)
+			} else {
+				assert.Equal(t, repositories.DeliveryDead, delivery.Status)
+			}
+		}
+
+		assert.Equal(t, []time.Duration{time.Minute, 90 * time.Second, 90 * time.Second}, nextAttempts)
+	})
+
+	t.Run("Purge the succeeded deliveries once their retention has passed", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+		defer server.Close()
+
+		store := memoryRepositories.NewUserRepositoryMemoryImpl()
+		webhook := addWebhook(t, store, server.URL)
+		dispatcher := NewDispatcher(store, WithInterval(10*time.Millisecond), WithRetention(50*time.Millisecond))
+		require.NoError(t, dispatcher.Record(ctx, change))
+		_, err := dispatcher.DeliverDue(ctx)
+		require.NoError(t, err)
+		require.Len(t, deliveriesOf(t, store, webhook), 1)
+
+		runCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go dispatcher.Run(runCtx)
+
+		assert.Eventually(t, func() bool {
+			return len(deliveriesOf(t, store, webhook)) == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Record the deliveries to the other webhooks when one can't be recorded", func(t *testing.T) {
+		store := memoryRepositories.NewUserRepositoryMemoryImpl()
+		failing := addWebhook(t, store, "http://localhost/failing")
+		other := addWebhook(t, store, "http://localhost/other")
+		dispatcher := NewDispatcher(&failingStore{WebhookStore: store, failing: failing.Id})
+
+		assert.Error(t, dispatcher.Record(ctx, change))
+		assert.Empty(t, deliveriesOf(t, store, failing))
+		assert.Len(t, deliveriesOf(t, store, other), 1)
+	})
+
+	t.Run("Record and post the changes published by the outbox relay", func(t *testing.T) {
+		var received atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			received.Add(1)
+		}))
+		defer server.Close()
+
+		store := memoryRepositories.NewUserRepositoryMemoryImpl()
+		webhook := addWebhook(t, store, server.URL)
+		_, err := store.AddUser(ctx, repositories.NewRepoUser("testName", "testLastName", "testNickname", "testPassword", "testEmail@email.com", "UK"))
+		require.NoError(t, err)
+
+		// The dispatcher is down when the change is relayed, so its cursor stays before the change.
+		unavailable := &failingStore{WebhookStore: store, down: true}
+		relay := outbox.NewRelay(store, notifier.NewNotifier(), outbox.WithPublisher("webhooks", NewDispatcher(unavailable)))
+		relayed, err := relay.RelayPending(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, relayed)
+		assert.Error(t, relay.PublishPending(ctx))
+		assert.Empty(t, deliveriesOf(t, store, webhook))
+
+		dispatcher := NewDispatcher(store, WithInterval(time.Hour))
+		runCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go dispatcher.Run(runCtx)
+
+		relay = outbox.NewRelay(store, notifier.NewNotifier(), outbox.WithPublisher("webhooks", dispatcher))
+		require.NoError(t, relay.PublishPending(ctx))
+
+		assert.Eventually(t, func() bool {
+			deliveries := deliveriesOf(t, store, webhook)
+			return len(deliveries) == 1 && deliveries[0].Status == repositories.DeliverySucceeded
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, int32(1), received.Load())
+	})
+}
+
+// failingStore fails to read the webhooks while it's down, and to add the deliveries to the failing webhook.
+type failingStore struct {
+	repositories.WebhookStore
+	down    bool
+	failing primitive.ObjectID
+}
+
+func (f *failingStore) GetWebhooks(ctx context.Context) ([]*repositories.Webhook, error) {
+	if f.down {
+		return nil, errors.New("connection refused")
+	}
+	return f.WebhookStore.GetWebhooks(ctx)
+}
+
+func (f *failingStore) AddDelivery(ctx context.Context, delivery *repositories.WebhookDelivery) error {
+	if delivery.WebhookId == f.failing {
+		return errors.New("connection refused")
+	}
+	return f.WebhookStore.AddDelivery(ctx, delivery)
+}
@@ -0,0 +1,249 @@
+// Package cloudevents describes the changes of the users as CloudEvents 1.0, in the structured
+// JSON and binary content modes.
+package cloudevents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+)
+
+const (
+	SPEC_VERSION = "1.0"
+
+	TYPE_USER_CREATED = "com.faceit.user.created"
+	TYPE_USER_UPDATED = "com.faceit.user.updated"
+	TYPE_USER_DELETED = "com.faceit.user.deleted"
+
+	// DEFAULT_SOURCE is the source of the events, when the service isn't given another one.
+	DEFAULT_SOURCE = "/faceit/user-service"
+
+	// STRUCTURED_CONTENT_TYPE is the content type of an event in the structured JSON mode.
+	STRUCTURED_CONTENT_TYPE = "application/cloudevents+json"
+	// DATA_CONTENT_TYPE is the content type of the data of the events, the JSON of the change.
+	DATA_CONTENT_TYPE = "application/json"
+
+	// The prefixes of the headers carrying the attributes in the binary mode, of HTTP and NATS
+	// messages, and of Kafka records.
+	HEADER_PREFIX       = "ce-"
+	KAFKA_HEADER_PREFIX = "ce_"
+)
+
+// Mode is how an event is carried by a message.
+type Mode string
+
+const (
+	// ModeStructured carries the whole event as the JSON body of the message.
+	ModeStructured Mode = "structured"
+	// ModeBinary carries the attributes of the event as headers and its data as the body.
+	ModeBinary Mode = "binary"
+)
+
+func ParseMode(name string) (Mode, error) {
+	switch mode := Mode(name); mode {
+	case ModeStructured, ModeBinary:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown CloudEvents mode %q, it must be %s or %s", name, ModeStructured, ModeBinary)
+	}
+}
+
+// Event is a change of a user as a CloudEvent. Its data is the change, as sent without the envelope.
+type Event struct {
+	SpecVersion string `json:"specversion"`
+	// Id is the event id of the change, an event delivered twice has the same id.
+	Id     string `json:"id"`
+	Source string `json:"source"`
+	Type   string `json:"type"`
+	// Subject is the id of the user.
+	Subject         string    `json:"subject,omitempty"`
+	Time            time.Time `json:"time,omitempty"`
+	DataContentType string    `json:"datacontenttype,omitempty"`
+	// Sequence is the sequence of the change in the change log, as a string as required by the
+	// sequence extension. It's empty if the change isn't logged.
+	Sequence string          `json:"sequence,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+// Attribute is a context attribute of an event, carried by a header in the binary mode.
+type Attribute struct {
+	Name  string
+	Value string
+}
+
+// Type returns the type of the events of the changes with the operation type.
+func Type(operationType string) (string, error) {
+	switch operationType {
+	case notifier.ChangeOperationInsert:
+		return TYPE_USER_CREATED, nil
+	case notifier.ChangeOperationUpdate:
+		return TYPE_USER_UPDATED, nil
+	case notifier.ChangeOperationDelete:
+		return TYPE_USER_DELETED, nil
+	default:
+		return "", fmt.Errorf("unknown operation type %q", operationType)
+	}
+}
+
+// NewEvent returns the event of the change sent from the source.
+func NewEvent(source string, change notifier.ChangeData) (*Event, error) {
+	eventType, err := Type(change.OperationType)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(change)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &Event{
+		SpecVersion:     SPEC_VERSION,
+		Id:              change.EventId,
+		Source:          source,
+		Type:            eventType,
+		Subject:         change.UserId,
+		DataContentType: DATA_CONTENT_TYPE,
+		Data:            data,
+	}
+	if !change.Timestamp.IsZero() {
+		event.Time = change.Timestamp.UTC()
+	}
+	if change.Sequence != 0 {
+		event.Sequence = strconv.FormatInt(change.Sequence, 10)
+	}
+	return event, nil
+}
+
+// MarshalJSON encodes the event in the structured mode, leaving out the time if it isn't known.
+func (e *Event) MarshalJSON() ([]byte, error) {
+	type event Event
+	var timestamp string
+	if !e.Time.IsZero() {
+		timestamp = e.Time.Format(time.RFC3339Nano)
+	}
+	return json.Marshal(struct {
+		*event
+		Time string `json:"time,omitempty"`
+	}{event: (*event)(e), Time: timestamp})
+}
+
+// Attributes returns the context attributes carried by the headers in the binary mode, without
+// the data content type, which is carried by the content type of the message.
+func (e *Event) Attributes() []Attribute {
+	attributes := []Attribute{
+		{Name: "specversion", Value: e.SpecVersion},
+		{Name: "id", Value: e.Id},
+		{Name: "source", Value: e.Source},
+		{Name: "type", Value: e.Type},
+	}
+	if e.Subject != "" {
+		attributes = append(attributes, Attribute{Name: "subject", Value: e.Subject})
+	}
+	if !e.Time.IsZero() {
+		attributes = append(attributes, Attribute{Name: "time", Value: e.Time.Format(time.RFC3339Nano)})
+	}
+	if e.Sequence != "" {
+		attributes = append(attributes, Attribute{Name: "sequence", Value: e.Sequence})
+	}
+	return attributes
+}
+
+// HTTPMessage returns the headers and the body of an HTTP message carrying the event in the mode.
+func (e *Event) HTTPMessage(mode Mode) (http.Header, []byte, error) {
+	header := http.Header{}
+	if mode == ModeBinary {
+		for _, attribute := range e.Attributes() {
+			header.Set(HEADER_PREFIX+attribute.Name, attribute.Value)
+		}
+		header.Set("Content-Type", e.DataContentType)
+		return header, e.Data, nil
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, nil, err
+	}
+	header.Set("Content-Type", STRUCTURED_CONTENT_TYPE)
+	return header, body, nil
+}
+
+// FromHTTP reads the event carried by an HTTP message, in the mode told by its content type.
+func FromHTTP(header http.Header, body []byte) (*Event, error) {
+	contentType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if contentType == STRUCTURED_CONTENT_TYPE {
+		var event struct {
+			Event
+			Time string `json:"time"`
+		}
+		if err := json.Unmarshal(body, &event); err != nil {
+			return nil, err
+		}
+		if err := event.setTime(event.Time); err != nil {
+			return nil, err
+		}
+		if err := event.validate(); err != nil {
+			return nil, err
+		}
+		return &event.Event, nil
+	}
+
+	event := &Event{
+		SpecVersion:     header.Get(HEADER_PREFIX + "specversion"),
+		Id:              header.Get(HEADER_PREFIX + "id"),
+		Source:          header.Get(HEADER_PREFIX + "source"),
+		Type:            header.Get(HEADER_PREFIX + "type"),
+		Subject:         header.Get(HEADER_PREFIX + "subject"),
+		DataContentType: header.Get("Content-Type"),
+		Sequence:        header.Get(HEADER_PREFIX + "sequence"),
+		Data:            body,
+	}
+	if err := event.setTime(header.Get(HEADER_PREFIX + "time")); err != nil {
+		return nil, err
+	}
+	if err := event.validate(); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func (e *Event) setTime(timestamp string) error {
+	if timestamp == "" {
+		return nil
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return fmt.Errorf("invalid time: %w", err)
+	}
+	e.Time = parsed
+	return nil
+}
+
+// validate checks the attributes required by the specification.
+func (e *Event) validate() error {
+	var missing []string
+	for _, attribute := range []Attribute{
+		{Name: "specversion", Value: e.SpecVersion},
+		{Name: "id", Value: e.Id},
+		{Name: "source", Value: e.Source},
+		{Name: "type", Value: e.Type},
+	} {
+		if attribute.Value == "" {
+			missing = append(missing, attribute.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return errors.New("the event is missing the required attributes " + strings.Join(missing, ", "))
+	}
+	if e.SpecVersion != SPEC_VERSION {
+		return fmt.Errorf("unsupported spec version %q", e.SpecVersion)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvent(t *testing.T) {
+	timestamp := time.Date(2024, 7, 1, 10, 30, 0, 0, time.UTC)
+	change := notifier.ChangeData{
+		EventId:       "event1",
+		Sequence:      42,
+		Timestamp:     timestamp,
+		OperationType: notifier.ChangeOperationUpdate,
+		UserId:        "user1",
+		ChangedFields: []string{"nickname"},
+	}
+
+	t.Run("Build the event of a change", func(t *testing.T) {
+		event, err := NewEvent("/test", change)
+
+		require.NoError(t, err)
+		assert.Equal(t, SPEC_VERSION, event.SpecVersion)
+		assert.Equal(t, "event1", event.Id)
+		assert.Equal(t, "/test", event.Source)
+		assert.Equal(t, TYPE_USER_UPDATED, event.Type)
+		assert.Equal(t, "user1", event.Subject)
+		assert.Equal(t, timestamp, event.Time)
+		assert.Equal(t, "42", event.Sequence)
+		assert.Equal(t, DATA_CONTENT_TYPE, event.DataContentType)
+
+		var data notifier.ChangeData
+		require.NoError(t, json.Unmarshal(event.Data, &data))
+		assert.Equal(t, change, data)
+	})
+
+	t.Run("Reject a change with an unknown operation type", func(t *testing.T) {
+		_, err := NewEvent("/test", notifier.ChangeData{EventId: "event1", OperationType: "replace"})
+
+		assert.EqualError(t, err, `unknown operation type "replace"`)
+	})
+
+	t.Run("Encode an event in the structured mode", func(t *testing.T) {
+		event, err := NewEvent("/test", notifier.ChangeData{EventId: "event1", OperationType: notifier.ChangeOperationDelete, UserId: "user1"})
+		require.NoError(t, err)
+
+		header, body, err := event.HTTPMessage(ModeStructured)
+
+		require.NoError(t, err)
+		assert.Equal(t, STRUCTURED_CONTENT_TYPE, header.Get("Content-Type"))
+		assert.JSONEq(t, `{
+			"specversion": "1.0",
+			"id": "event1",
+			"source": "/test",
+			"type": "com.faceit.user.deleted",
+			"subject": "user1",
+			"datacontenttype": "application/json",
+			"data": {"eventId": "event1", "timestamp": "0001-01-01T00:00:00Z", "operationType": "delete", "id": "user1"}
+		}`, string(body))
+	})
+
+	t.Run("Encode an event in the binary mode", func(t *testing.T) {
+		event, err := NewEvent("/test", change)
+		require.NoError(t, err)
+
+		header, body, err := event.HTTPMessage(ModeBinary)
+
+		require.NoError(t, err)
+		assert.Equal(t, "1.0", header.Get("ce-specversion"))
+		assert.Equal(t, "event1", header.Get("ce-id"))
+		assert.Equal(t, "/test", header.Get("ce-source"))
+		assert.Equal(t, TYPE_USER_UPDATED, header.Get("ce-type"))
+		assert.Equal(t, "user1", header.Get("ce-subject"))
+		assert.Equal(t, "2024-07-01T10:30:00Z", header.Get("ce-time"))
+		assert.Equal(t, "42", header.Get("ce-sequence"))
+		assert.Equal(t, DATA_CONTENT_TYPE, header.Get("Content-Type"))
+		assert.Equal(t, []byte(event.Data), body)
+	})
+
+	t.Run("Read back an event in both modes", func(t *testing.T) {
+		event, err := NewEvent("/test", change)
+		require.NoError(t, err)
+
+		for _, mode := range []Mode{ModeStructured, ModeBinary} {
+			header, body, err := event.HTTPMessage(mode)
+			require.NoError(t, err)
+
+			read, err := FromHTTP(header, body)
+
+			require.NoError(t, err, mode)
+			assert.Equal(t, event.Id, read.Id, mode)
+			assert.Equal(t, event.Type, read.Type, mode)
+			assert.Equal(t, event.Subject, read.Subject, mode)
+			assert.Equal(t, event.Sequence, read.Sequence, mode)
+			assert.True(t, event.Time.Equal(read.Time), mode)
+			assert.JSONEq(t, string(event.Data), string(read.Data), mode)
+		}
+	})
+
+	t.Run("Reject an event without the required attributes", func(t *testing.T) {
+		header := http.Header{}
+		header.Set("ce-specversion", "1.0")
+		header.Set("ce-id", "event1")
+
+		_, err := FromHTTP(header, nil)
+
+		assert.EqualError(t, err, "the event is missing the required attributes source, type")
+	})
+}
@@ -0,0 +1,163 @@
+// Package kafkapublisher publishes the changes of the users to a Kafka topic.
+package kafkapublisher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dlion/faceit_challenge/pkg/cloudevents"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	userproto "github.com/dlion/faceit_challenge/pkg/proto"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Encoding is the format of the value of the records.
+type Encoding string
+
+const (
+	// EncodingJSON encodes the WatchResponse of the change with the canonical JSON mapping of protobuf.
+	EncodingJSON Encoding = "json"
+	// EncodingProtobuf encodes the WatchResponse of the change in the protobuf wire format.
+	EncodingProtobuf Encoding = "protobuf"
+
+	DEFAULT_TOPIC = "user-changes"
+	// DEFAULT_LINGER is how long the records are held to be batched together.
+	DEFAULT_LINGER = 5 * time.Millisecond
+
+	EVENT_ID_HEADER       = "event-id"
+	OPERATION_TYPE_HEADER = "operation-type"
+	CONTENT_TYPE_HEADER   = "content-type"
+	SCHEMA_HEADER         = "schema"
+)
+
+func ParseEncoding(name string) (Encoding, error) {
+	switch encoding := Encoding(name); encoding {
+	case EncodingJSON, EncodingProtobuf:
+		return encoding, nil
+	default:
+		return "", fmt.Errorf("unknown encoding %q, it must be %s or %s", name, EncodingJSON, EncodingProtobuf)
+	}
+}
+
+// contentType returns the value of the content-type header of the records with the encoding.
+func (e Encoding) contentType() string {
+	if e == EncodingProtobuf {
+		return "application/x-protobuf"
+	}
+	return "application/json"
+}
+
+// Publisher publishes every change as a record keyed by the id of the user, so all the changes of
+// a user are in the same partition, in order. The producer is idempotent: the records retried by
+// the client aren't duplicated, and a change published twice has the same event-id header.
+// The records are CloudEvents in the binary mode, with the attributes in the ce_ headers.
+type Publisher struct {
+	client   *kgo.Client
+	topic    string
+	encoding Encoding
+	source   string
+	options  []kgo.Opt
+}
+
+type Option func(*Publisher)
+
+// WithTopic sets the topic of the records, DEFAULT_TOPIC by default.
+func WithTopic(topic string) Option {
+	return func(p *Publisher) {
+		p.topic = topic
+	}
+}
+
+// WithEncoding sets the encoding of the value of the records, EncodingJSON by default.
+func WithEncoding(encoding Encoding) Option {
+	return func(p *Publisher) {
+		p.encoding = encoding
+	}
+}
+
+// WithSource sets the CloudEvents source of the records, cloudevents.DEFAULT_SOURCE by default.
+func WithSource(source string) Option {
+	return func(p *Publisher) {
+		p.source = source
+	}
+}
+
+// WithClientOptions adds options to the client, as the ones authenticating it.
+func WithClientOptions(options ...kgo.Opt) Option {
+	return func(p *Publisher) {
+		p.options = append(p.options, options...)
+	}
+}
+
+func NewPublisher(brokers []string, options ...Option) (*Publisher, error) {
+	publisher := &Publisher{topic: DEFAULT_TOPIC, encoding: EncodingJSON, source: cloudevents.DEFAULT_SOURCE}
+	for _, option := range options {
+		option(publisher)
+	}
+
+	clientOptions := append([]kgo.Opt{
+		kgo.SeedBrokers(brokers...),
+		kgo.DefaultProduceTopic(publisher.topic),
+		// Acknowledged by all the in sync replicas, as required by the idempotent producer.
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		// The records with the same key go to the same partition, hashed as the Java client does.
+		kgo.RecordPartitioner(kgo.StickyKeyPartitioner(nil)),
+		kgo.ProducerLinger(DEFAULT_LINGER),
+	}, publisher.options...)
+
+	client, err := kgo.NewClient(clientOptions...)
+	if err != nil {
+		return nil, err
+	}
+	publisher.client = client
+	return publisher, nil
+}
+
+// Publish returns once the record is acknowledged by the brokers.
+func (p *Publisher) Publish(ctx context.Context, change notifier.ChangeData) error {
+	value, err := p.encode(change)
+	if err != nil {
+		return err
+	}
+	event, err := cloudevents.NewEvent(p.source, change)
+	if err != nil {
+		return err
+	}
+
+	record := &kgo.Record{
+		Key:   []byte(change.UserId),
+		Value: value,
+		Headers: []kgo.RecordHeader{
+			{Key: EVENT_ID_HEADER, Value: []byte(change.EventId)},
+			{Key: OPERATION_TYPE_HEADER, Value: []byte(change.OperationType)},
+			{Key: CONTENT_TYPE_HEADER, Value: []byte(p.encoding.contentType())},
+			{Key: SCHEMA_HEADER, Value: []byte(proto.MessageName(&userproto.WatchResponse{}))},
+		},
+	}
+	for _, attribute := range event.Attributes() {
+		record.Headers = append(record.Headers, kgo.RecordHeader{Key: cloudevents.KAFKA_HEADER_PREFIX + attribute.Name, Value: []byte(attribute.Value)})
+	}
+	if !change.Timestamp.IsZero() {
+		record.Timestamp = change.Timestamp
+	}
+
+	return p.client.ProduceSync(ctx, record).FirstErr()
+}
+
+func (p *Publisher) encode(change notifier.ChangeData) ([]byte, error) {
+	response := userproto.NewWatchResponse(change)
+	if p.encoding == EncodingProtobuf {
+		return proto.Marshal(response)
+	}
+	return protojson.Marshal(response)
+}
+
+// Close waits for the records being produced, then closes the client.
+func (p *Publisher) Close() error {
+	err := p.client.Flush(context.Background())
+	p.client.Close()
+	return err
+}
@@ -0,0 +1,131 @@
+package kafkapublisher
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dlion/faceit_challenge/pkg/cloudevents"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	userproto "github.com/dlion/faceit_challenge/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestPublisher(t *testing.T) {
+	ctx := context.Background()
+
+	startCluster := func(t *testing.T, topic string) []string {
+		cluster, err := kfake.NewCluster(kfake.NumBrokers(3), kfake.SeedTopics(6, topic))
+		require.NoError(t, err)
+		t.Cleanup(cluster.Close)
+		return cluster.ListenAddrs()
+	}
+
+	consume := func(t *testing.T, brokers []string, topic string, count int) []*kgo.Record {
+		client, err := kgo.NewClient(kgo.SeedBrokers(brokers...), kgo.ConsumeTopics(topic), kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()))
+		require.NoError(t, err)
+		defer client.Close()
+
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		var records []*kgo.Record
+		for len(records) < count {
+			fetches := client.PollFetches(ctx)
+			require.NoError(t, fetches.Err())
+			records = append(records, fetches.Records()...)
+		}
+		return records
+	}
+
+	changeOf := func(userId string, sequence int64) notifier.ChangeData {
+		return notifier.ChangeData{
+			EventId:       fmt.Sprintf("event-%d", sequence),
+			Sequence:      sequence,
+			Timestamp:     time.Date(2024, 7, 19, 12, 25, 25, 0, time.UTC),
+			OperationType: notifier.ChangeOperationUpdate,
+			UserId:        userId,
+			ChangedFields: []string{"nickname"},
+			Before:        &notifier.UserSnapshot{Id: userId, Nickname: "before", Country: "UK"},
+			After:         &notifier.UserSnapshot{Id: userId, Nickname: "after", Country: "UK"},
+		}
+	}
+
+	t.Run("Publish the changes of a user in order to the same partition", func(t *testing.T) {
+		brokers := startCluster(t, DEFAULT_TOPIC)
+		publisher, err := NewPublisher(brokers)
+		require.NoError(t, err)
+		defer publisher.Close()
+
+		userIds := []string{"669a5b3525ff5682bea961ba", "669a5b3525ff5682bea961bb", "669a5b3525ff5682bea961bc"}
+		for i := 0; i < 12; i++ {
+			require.NoError(t, publisher.Publish(ctx, changeOf(userIds[i%len(userIds)], int64(i+1))))
+		}
+
+		records := consume(t, brokers, DEFAULT_TOPIC, 12)
+		partitions := map[string]int32{}
+		lastSequences := map[string]int64{}
+		for _, record := range records {
+			userId := string(record.Key)
+			if partition, ok := partitions[userId]; ok {
+				assert.Equal(t, partition, record.Partition)
+			}
+			partitions[userId] = record.Partition
+
+			var response userproto.WatchResponse
+			require.NoError(t, protojson.Unmarshal(record.Value, &response))
+			assert.Equal(t, userId, response.UserId)
+			assert.Greater(t, response.Sequence, lastSequences[userId])
+			lastSequences[userId] = response.Sequence
+		}
+		assert.Len(t, partitions, len(userIds))
+	})
+
+	t.Run("Publish the WatchResponse of the change with its headers", func(t *testing.T) {
+		brokers := startCluster(t, "analytics.users")
+		publisher, err := NewPublisher(brokers, WithTopic("analytics.users"), WithEncoding(EncodingProtobuf), WithSource("/test"))
+		require.NoError(t, err)
+		defer publisher.Close()
+		change := changeOf("669a5b3525ff5682bea961ba", 1)
+
+		require.NoError(t, publisher.Publish(ctx, change))
+
+		records := consume(t, brokers, "analytics.users", 1)
+		require.Len(t, records, 1)
+		var response userproto.WatchResponse
+		require.NoError(t, proto.Unmarshal(records[0].Value, &response))
+		assert.True(t, proto.Equal(userproto.NewWatchResponse(change), &response))
+		assert.Equal(t, change.Timestamp, records[0].Timestamp.UTC())
+		headers := map[string]string{}
+		for _, header := range records[0].Headers {
+			headers[header.Key] = string(header.Value)
+		}
+		assert.Equal(t, map[string]string{
+			EVENT_ID_HEADER:       "event-1",
+			OPERATION_TYPE_HEADER: notifier.ChangeOperationUpdate,
+			CONTENT_TYPE_HEADER:   "application/x-protobuf",
+			SCHEMA_HEADER:         "user.WatchResponse",
+			"ce_specversion":      "1.0",
+			"ce_id":               "event-1",
+			"ce_source":           "/test",
+			"ce_type":             cloudevents.TYPE_USER_UPDATED,
+			"ce_subject":          "669a5b3525ff5682bea961ba",
+			"ce_time":             "2024-07-19T12:25:25Z",
+			"ce_sequence":         "1",
+		}, headers)
+	})
+
+	t.Run("Parse the encodings", func(t *testing.T) {
+		encoding, err := ParseEncoding("protobuf")
+		assert.NoError(t, err)
+		assert.Equal(t, EncodingProtobuf, encoding)
+
+		_, err = ParseEncoding("avro")
+		assert.Error(t, err)
+	})
+}
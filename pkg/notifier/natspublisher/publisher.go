@@ -0,0 +1,133 @@
+// Package natspublisher publishes the changes of the users to NATS JetStream.
+package natspublisher
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/dlion/faceit_challenge/pkg/cloudevents"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	DEFAULT_SUBJECT_PREFIX = "users"
+	DEFAULT_STREAM         = "USERS"
+	// DEFAULT_DUPLICATE_WINDOW is how long the stream remembers the event ids, discarding the
+	// changes published again within it.
+	DEFAULT_DUPLICATE_WINDOW = 2 * time.Minute
+)
+
+// Publisher publishes every change as a JSON message on the subject of its operation, with its
+// event id as the Nats-Msg-Id, so JetStream discards the changes published twice.
+// The messages are CloudEvents in the binary mode, with the attributes in the ce- headers.
+type Publisher struct {
+	conn     *nats.Conn
+	js       jetstream.JetStream
+	prefix   string
+	subjects map[string]string
+	stream   string
+	source   string
+}
+
+type Option func(*Publisher)
+
+// WithSubjectPrefix sets the prefix of the subjects, followed by the operation type:
+// the changes are published on users.insert, users.update and users.delete by default.
+func WithSubjectPrefix(prefix string) Option {
+	return func(p *Publisher) {
+		p.prefix = prefix
+	}
+}
+
+// WithSubject sets the subject of the changes with the operation type, overriding the prefix.
+func WithSubject(operationType, subject string) Option {
+	return func(p *Publisher) {
+		p.subjects[operationType] = subject
+	}
+}
+
+// WithStream sets the name of the stream created by EnsureStream, DEFAULT_STREAM by default.
+func WithStream(stream string) Option {
+	return func(p *Publisher) {
+		p.stream = stream
+	}
+}
+
+// WithSource sets the CloudEvents source of the messages, cloudevents.DEFAULT_SOURCE by default.
+func WithSource(source string) Option {
+	return func(p *Publisher) {
+		p.source = source
+	}
+}
+
+// NewPublisher publishes through the connection, which is drained and closed by Close.
+func NewPublisher(conn *nats.Conn, options ...Option) (*Publisher, error) {
+	js, err := jetstream.New(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	publisher := &Publisher{
+		conn:     conn,
+		js:       js,
+		prefix:   DEFAULT_SUBJECT_PREFIX,
+		subjects: map[string]string{},
+		stream:   DEFAULT_STREAM,
+		source:   cloudevents.DEFAULT_SOURCE,
+	}
+	for _, option := range options {
+		option(publisher)
+	}
+	return publisher, nil
+}
+
+// Subject returns the subject the changes with the operation type are published on.
+func (p *Publisher) Subject(operationType string) string {
+	if subject, ok := p.subjects[operationType]; ok {
+		return subject
+	}
+	return p.prefix + "." + operationType
+}
+
+// EnsureStream creates the stream storing the subjects of the changes, or updates its subjects.
+// Without a stream capturing them, the changes can't be published.
+func (p *Publisher) EnsureStream(ctx context.Context) error {
+	var subjects []string
+	for _, operationType := range []string{notifier.ChangeOperationInsert, notifier.ChangeOperationUpdate, notifier.ChangeOperationDelete} {
+		if subject := p.Subject(operationType); !slices.Contains(subjects, subject) {
+			subjects = append(subjects, subject)
+		}
+	}
+
+	_, err := p.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       p.stream,
+		Subjects:   subjects,
+		Duplicates: DEFAULT_DUPLICATE_WINDOW,
+	})
+	return err
+}
+
+// Publish returns once the change is stored by the stream.
+func (p *Publisher) Publish(ctx context.Context, change notifier.ChangeData) error {
+	event, err := cloudevents.NewEvent(p.source, change)
+	if err != nil {
+		return err
+	}
+
+	msg := nats.NewMsg(p.Subject(change.OperationType))
+	for _, attribute := range event.Attributes() {
+		msg.Header.Set(cloudevents.HEADER_PREFIX+attribute.Name, attribute.Value)
+	}
+	msg.Header.Set("Content-Type", event.DataContentType)
+	msg.Data = event.Data
+
+	_, err = p.js.PublishMsg(ctx, msg, jetstream.WithMsgID(change.EventId))
+	return err
+}
+
+func (p *Publisher) Close() error {
+	return p.conn.Drain()
+}
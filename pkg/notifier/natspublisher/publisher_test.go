@@ -0,0 +1,113 @@
+package natspublisher
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/dlion/faceit_challenge/pkg/cloudevents"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublisher(t *testing.T) {
+	ctx := context.Background()
+
+	startServer := func(t *testing.T) *nats.Conn {
+		natsServer, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: server.RANDOM_PORT, JetStream: true, StoreDir: t.TempDir()})
+		require.NoError(t, err)
+		go natsServer.Start()
+		t.Cleanup(natsServer.Shutdown)
+		require.True(t, natsServer.ReadyForConnections(5*time.Second))
+
+		conn, err := nats.Connect(natsServer.ClientURL())
+		require.NoError(t, err)
+		t.Cleanup(conn.Close)
+		return conn
+	}
+
+	consume := func(t *testing.T, conn *nats.Conn, stream string, count int) []jetstream.Msg {
+		js, err := jetstream.New(conn)
+		require.NoError(t, err)
+		consumer, err := js.CreateOrUpdateConsumer(ctx, stream, jetstream.ConsumerConfig{AckPolicy: jetstream.AckExplicitPolicy})
+		require.NoError(t, err)
+
+		batch, err := consumer.FetchNoWait(count + 1)
+		require.NoError(t, err)
+		var msgs []jetstream.Msg
+		for msg := range batch.Messages() {
+			msgs = append(msgs, msg)
+		}
+		require.NoError(t, batch.Error())
+		return msgs
+	}
+
+	change := notifier.ChangeData{
+		EventId:       "event-1",
+		Sequence:      1,
+		Timestamp:     time.Date(2024, 7, 19, 12, 25, 25, 0, time.UTC),
+		OperationType: notifier.ChangeOperationInsert,
+		UserId:        "669a5b3525ff5682bea961ba",
+		ChangedFields: []string{"first_name"},
+		After:         &notifier.UserSnapshot{Id: "669a5b3525ff5682bea961ba", FirstName: "John", Country: "UK"},
+	}
+
+	t.Run("Publish the changes on the subject of their operation once", func(t *testing.T) {
+		conn := startServer(t)
+		publisher, err := NewPublisher(conn)
+		require.NoError(t, err)
+		require.NoError(t, publisher.EnsureStream(ctx))
+
+		require.NoError(t, publisher.Publish(ctx, change))
+		require.NoError(t, publisher.Publish(ctx, change))
+		deletion := notifier.ChangeData{EventId: "event-2", Sequence: 2, OperationType: notifier.ChangeOperationDelete, UserId: change.UserId}
+		require.NoError(t, publisher.Publish(ctx, deletion))
+
+		msgs := consume(t, conn, DEFAULT_STREAM, 3)
+		require.Len(t, msgs, 2)
+		assert.Equal(t, "users.insert", msgs[0].Subject())
+		assert.Equal(t, "event-1", msgs[0].Headers().Get(jetstream.MsgIDHeader))
+		assert.Equal(t, "application/json", msgs[0].Headers().Get("Content-Type"))
+		assert.Equal(t, "event-1", msgs[0].Headers().Get("ce-id"))
+		assert.Equal(t, cloudevents.DEFAULT_SOURCE, msgs[0].Headers().Get("ce-source"))
+		assert.Equal(t, cloudevents.TYPE_USER_CREATED, msgs[0].Headers().Get("ce-type"))
+		assert.Equal(t, change.UserId, msgs[0].Headers().Get("ce-subject"))
+		var published notifier.ChangeData
+		require.NoError(t, json.Unmarshal(msgs[0].Data(), &published))
+		assert.Equal(t, change, published)
+		assert.Equal(t, "users.delete", msgs[1].Subject())
+	})
+
+	t.Run("Publish on the configured subjects", func(t *testing.T) {
+		conn := startServer(t)
+		publisher, err := NewPublisher(conn,
+			WithSubjectPrefix("faceit.users"),
+			WithSubject(notifier.ChangeOperationDelete, "faceit.users.removed"),
+			WithStream("FACEIT_USERS"),
+		)
+		require.NoError(t, err)
+		require.NoError(t, publisher.EnsureStream(ctx))
+
+		assert.Equal(t, "faceit.users.update", publisher.Subject(notifier.ChangeOperationUpdate))
+		require.NoError(t, publisher.Publish(ctx, notifier.ChangeData{EventId: "event-3", OperationType: notifier.ChangeOperationDelete}))
+
+		msgs := consume(t, conn, "FACEIT_USERS", 1)
+		require.Len(t, msgs, 1)
+		assert.Equal(t, "faceit.users.removed", msgs[0].Subject())
+	})
+
+	t.Run("Fail to publish without a stream", func(t *testing.T) {
+		conn := startServer(t)
+		publisher, err := NewPublisher(conn)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		assert.Error(t, publisher.Publish(ctx, change))
+	})
+}
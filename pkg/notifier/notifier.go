@@ -1,8 +1,13 @@
 package notifier
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"maps"
+	"slices"
 	"sync"
+	"time"
 )
 
 const (
@@ -11,69 +16,408 @@ const (
 	ChangeOperationDelete string = "delete"
 )
 
+const (
+	DEFAULT_BUFFER_SIZE   = 10
+	DEFAULT_BLOCK_TIMEOUT = 100 * time.Millisecond
+)
+
+// ErrSlowConsumer is returned by Err for a subscriber disconnected by the DisconnectSlowConsumer policy.
+var ErrSlowConsumer = errors.New("the subscriber didn't keep up with the changes")
+
+// BackpressurePolicy decides what happens to a change broadcast while the buffer of a subscriber is full.
+type BackpressurePolicy string
+
+const (
+	// DropNewest drops the change broadcast.
+	DropNewest BackpressurePolicy = "drop-newest"
+	// DropOldest drops the oldest change in the buffer, making room for the one broadcast.
+	DropOldest BackpressurePolicy = "drop-oldest"
+	// BlockWithTimeout waits for the subscriber to make room, up to the block timeout, then drops
+	// the change. The changes wait in line for the subscriber alone, the broadcast doesn't wait for them.
+	BlockWithTimeout BackpressurePolicy = "block"
+	// DisconnectSlowConsumer closes the channel of the subscriber, then Err returns ErrSlowConsumer.
+	DisconnectSlowConsumer BackpressurePolicy = "disconnect"
+)
+
+// ParseBackpressurePolicy returns the policy with the given name.
+func ParseBackpressurePolicy(name string) (BackpressurePolicy, error) {
+	policy := BackpressurePolicy(name)
+	switch policy {
+	case DropNewest, DropOldest, BlockWithTimeout, DisconnectSlowConsumer:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown backpressure policy %q, must be %s, %s, %s or %s",
+			name, DropNewest, DropOldest, BlockWithTimeout, DisconnectSlowConsumer)
+	}
+}
+
 type ChangeData struct {
-	OperationType string `json:"operationType"`
-	UserId        string `json:"id"`
+	// EventId identifies the change, a change delivered twice has the same id.
+	EventId string `json:"eventId"`
+	// Sequence is the position of the change in the change log, zero if it isn't logged.
+	Sequence      int64     `json:"sequence,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+	OperationType string    `json:"operationType"`
+	UserId        string    `json:"id"`
+	// ChangedFields are the names of the fields whose value changed, all the ones with a value
+	// for an inserted or deleted user.
+	ChangedFields []string `json:"changedFields,omitempty"`
+	// Before is the user before the change, nil if it was inserted or isn't known.
+	Before *UserSnapshot `json:"before,omitempty"`
+	// After is the user after the change, nil if it was deleted or isn't known.
+	After *UserSnapshot `json:"after,omitempty"`
+}
+
+// Skipped reports whether the change only carries the sequence of a logged change not selected for the
+// subscriber, see ChangeFilter.NotifySkipped.
+func (c ChangeData) Skipped() bool {
+	return c.EventId == "" && c.Sequence != 0
+}
+
+// UserSnapshot is a user as it was at the time of a change, without its password.
+type UserSnapshot struct {
+	Id        string    `json:"id" bson:"id"`
+	FirstName string    `json:"first_name" bson:"first_name"`
+	LastName  string    `json:"last_name" bson:"last_name"`
+	Nickname  string    `json:"nickname" bson:"nickname"`
+	Email     string    `json:"email" bson:"email"`
+	Country   string    `json:"country" bson:"country"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+	// Version is the version of the user at the time of the change, zero if it isn't known.
+	Version int64 `json:"version,omitempty" bson:"version,omitempty"`
+}
+
+// ChangeFilter selects the changes sent to a subscriber, a nil filter selects all of them.
+type ChangeFilter struct {
+	// OperationTypes are the ChangeOperation constants of the changes selected, all of them if empty.
+	OperationTypes []string
+	// MatchesUser selects the changes of the users it returns true for, either before or after the change,
+	// so a subscriber learns about a user leaving the selection too. The changes without the user are
+	// always selected, since it's unknown whether they're relevant. Every user is selected if it's nil.
+	MatchesUser func(user *UserSnapshot) bool
+	// NotifySkipped sends the logged changes that aren't selected too, with their Sequence only,
+	// so that a subscriber following the sequence tells them apart from the changes it missed.
+	NotifySkipped bool
+}
+
+// Matches reports whether the change is selected by the filter.
+func (f *ChangeFilter) Matches(change ChangeData) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.OperationTypes) > 0 && !slices.Contains(f.OperationTypes, change.OperationType) {
+		return false
+	}
+
+	if f.MatchesUser == nil || (change.Before == nil && change.After == nil) {
+		return true
+	}
+	return (change.Before != nil && f.MatchesUser(change.Before)) ||
+		(change.After != nil && f.MatchesUser(change.After))
 }
 
 type Notifier interface {
-	AddSubscriber(id string) <-chan ChangeData
+	// AddSubscriber returns the channel receiving the changes selected by the filter, nil selects all of them.
+	// The options override the ones the notifier gives to every subscriber.
+	AddSubscriber(id string, changeFilter *ChangeFilter, options ...SubscriberOption) <-chan ChangeData
 	RemoveSubscriber(id string)
+	// Err returns why the channel of the subscriber has been closed before its removal, nil if it hasn't.
+	Err(id string) error
+	// Dropped returns how many changes selected for the subscriber it didn't receive.
+	Dropped(id string) uint64
+	// Broadcast sends the change to the subscribers selecting it, without waiting for any of them.
 	Broadcast(msg ChangeData)
 	Close()
 }
 
+// SubscriberOption configures how the changes are buffered for a subscriber.
+type SubscriberOption func(*subscriberOptions)
+
+type subscriberOptions struct {
+	bufferSize   int
+	policy       BackpressurePolicy
+	blockTimeout time.Duration
+}
+
+// WithBufferSize sets how many changes are kept for a subscriber until it receives them. A size that
+// isn't positive is ignored, keeping the previous one: a subscriber without a buffer would miss every
+// change broadcast while it's busy.
+func WithBufferSize(bufferSize int) SubscriberOption {
+	return func(o *subscriberOptions) {
+		if bufferSize < 1 {
+			log.Printf("Ignoring the non-positive buffer size %d for a subscriber, keeping %d", bufferSize, o.bufferSize)
+			return
+		}
+		o.bufferSize = bufferSize
+	}
+}
+
+// WithBackpressurePolicy sets what happens to the changes broadcast while the buffer is full.
+func WithBackpressurePolicy(policy BackpressurePolicy) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.policy = policy
+	}
+}
+
+// WithBlockTimeout sets how long the BlockWithTimeout policy waits for room in the buffer.
+func WithBlockTimeout(blockTimeout time.Duration) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.blockTimeout = blockTimeout
+	}
+}
+
+type subscriber struct {
+	ch      chan ChangeData
+	filter  *ChangeFilter
+	options subscriberOptions
+	// mu guards the state of the subscriber, the notifier's lock isn't held while sending to it.
+	mu sync.Mutex
+	// dropped counts the changes selected for the subscriber that it didn't receive.
+	dropped uint64
+	// err is why the channel has been closed, it's kept until the subscriber is removed.
+	err error
+	// done is closed together with the channel, it stops the goroutine sending the waiting changes.
+	done chan struct{}
+	// waiting are the changes waiting for room in the buffer with the BlockWithTimeout policy,
+	// sent in order by a goroutine of the subscriber while sending is true.
+	waiting []waitingChange
+	sending bool
+}
+
+type waitingChange struct {
+	change   ChangeData
+	deadline time.Time
+}
+
+func newSubscriber(filter *ChangeFilter, options subscriberOptions) *subscriber {
+	return &subscriber{
+		ch:      make(chan ChangeData, options.bufferSize),
+		filter:  filter,
+		options: options,
+		done:    make(chan struct{}),
+	}
+}
+
+// close closes the channel, once, it's called holding the lock of the subscriber. While the waiting changes
+// are being sent the channel is closed by their goroutine, so it's never closed during a send.
+func (s *subscriber) close(err error) {
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	close(s.done)
+	if !s.sending {
+		close(s.ch)
+	}
+}
+
+// send delivers the change following the policy of the subscriber, it returns false if it's dropped.
+// It's called holding the lock of the subscriber and never blocks.
+func (s *subscriber) send(msg ChangeData) bool {
+	// The changes already waiting are received first, to keep the order.
+	if len(s.waiting) == 0 {
+		select {
+		case s.ch <- msg:
+			return true
+		default:
+		}
+	}
+
+	switch s.options.policy {
+	case DropOldest:
+		select {
+		case <-s.ch:
+			s.dropped++
+		default:
+		}
+		select {
+		case s.ch <- msg:
+			return true
+		default:
+		}
+	case BlockWithTimeout:
+		s.waiting = append(s.waiting, waitingChange{change: msg, deadline: time.Now().Add(s.options.blockTimeout)})
+		if !s.sending {
+			s.sending = true
+			go s.sendWaiting()
+		}
+		return true
+	case DisconnectSlowConsumer:
+		s.close(ErrSlowConsumer)
+	}
+
+	s.dropped++
+	return false
+}
+
+// sendWaiting sends the waiting changes in order, dropping the ones the subscriber doesn't make room for
+// before their deadline, until there are no more or the subscriber is closed.
+func (s *subscriber) sendWaiting() {
+	for {
+		s.mu.Lock()
+		if s.err != nil || len(s.waiting) == 0 {
+			s.sending = false
+			s.waiting = nil
+			if s.err != nil {
+				close(s.ch)
+			}
+			s.mu.Unlock()
+			return
+		}
+		next := s.waiting[0]
+		s.mu.Unlock()
+
+		sent := false
+		timer := time.NewTimer(time.Until(next.deadline))
+		select {
+		case s.ch <- next.change:
+			sent = true
+		case <-timer.C:
+		case <-s.done:
+		}
+		timer.Stop()
+
+		s.mu.Lock()
+		s.waiting = s.waiting[1:]
+		if !sent && s.err == nil {
+			s.dropped++
+			log.Printf("Dropping message for a subscriber after waiting for it (%s, %d dropped)", s.options.policy, s.dropped)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// errRemoved is the reason of the channels closed by RemoveSubscriber and Close, it isn't reported.
+var errRemoved = errors.New("the subscriber has been removed")
+
 type NotifierImpl struct {
-	receiverChannels map[string]chan ChangeData
-	mu               sync.Mutex
+	subscribers map[string]*subscriber
+	// defaults are the options of every subscriber, before its own.
+	defaults []SubscriberOption
+	// mu guards the subscribers map only, the subscribers are sent the changes after releasing it.
+	mu sync.Mutex
+	// broadcastMu is held while sending a change, so every subscriber receives the concurrent
+	// broadcasts in the same order.
+	broadcastMu sync.Mutex
 }
 
-func NewNotifier() *NotifierImpl {
-	return &NotifierImpl{receiverChannels: map[string]chan ChangeData{}}
+// NewNotifier returns a notifier giving the options to every subscriber. Without them a subscriber
+// has a buffer of DEFAULT_BUFFER_SIZE changes and the changes broadcast while it's full are dropped.
+func NewNotifier(defaults ...SubscriberOption) *NotifierImpl {
+	return &NotifierImpl{subscribers: map[string]*subscriber{}, defaults: defaults}
 }
 
-func (n *NotifierImpl) AddSubscriber(id string) <-chan ChangeData {
+func (n *NotifierImpl) AddSubscriber(id string, changeFilter *ChangeFilter, options ...SubscriberOption) <-chan ChangeData {
 	log.Print("Adding a new subscriber, ", id)
 
+	subscriberOptions := subscriberOptions{
+		bufferSize:   DEFAULT_BUFFER_SIZE,
+		policy:       DropNewest,
+		blockTimeout: DEFAULT_BLOCK_TIMEOUT,
+	}
+	for _, option := range append(slices.Clone(n.defaults), options...) {
+		option(&subscriberOptions)
+	}
+	newSubscriber := newSubscriber(changeFilter, subscriberOptions)
+
 	n.mu.Lock()
-	defer n.mu.Unlock()
+	previous, exists := n.subscribers[id]
+	n.subscribers[id] = newSubscriber
+	n.mu.Unlock()
 
-	ch := make(chan ChangeData, 10)
-	n.receiverChannels[id] = ch
+	if exists {
+		previous.mu.Lock()
+		previous.close(errRemoved)
+		previous.mu.Unlock()
+	}
 
-	return ch
+	return newSubscriber.ch
 }
 
 func (n *NotifierImpl) RemoveSubscriber(id string) {
 	n.mu.Lock()
-	defer n.mu.Unlock()
+	subscriber, exists := n.subscribers[id]
+	delete(n.subscribers, id)
+	n.mu.Unlock()
 
-	ch, exists := n.receiverChannels[id]
 	if !exists {
 		return
 	}
 
-	select {
-	case <-ch:
-	default:
-		close(ch)
+	subscriber.mu.Lock()
+	defer subscriber.mu.Unlock()
+
+	if subscriber.dropped > 0 {
+		log.Printf("Removing the subscriber %s, %d messages were dropped", id, subscriber.dropped)
+	}
+	subscriber.close(errRemoved)
+}
+
+func (n *NotifierImpl) Err(id string) error {
+	subscriber := n.subscriber(id)
+	if subscriber == nil {
+		return nil
+	}
+
+	subscriber.mu.Lock()
+	defer subscriber.mu.Unlock()
+
+	if subscriber.err == errRemoved {
+		return nil
+	}
+	return subscriber.err
+}
+
+func (n *NotifierImpl) Dropped(id string) uint64 {
+	subscriber := n.subscriber(id)
+	if subscriber == nil {
+		return 0
 	}
 
-	delete(n.receiverChannels, id)
+	subscriber.mu.Lock()
+	defer subscriber.mu.Unlock()
+
+	return subscriber.dropped
+}
+
+func (n *NotifierImpl) subscriber(id string) *subscriber {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.subscribers[id]
 }
 
 func (n *NotifierImpl) Broadcast(msg ChangeData) {
 	log.Printf("Broadcasting a message to subscribers, (%s from %s)", msg.OperationType, msg.UserId)
 
+	n.broadcastMu.Lock()
+	defer n.broadcastMu.Unlock()
+
+	// The subscribers are sent the change after releasing the lock, so that adding and removing
+	// them doesn't wait for the broadcast.
 	n.mu.Lock()
-	defer n.mu.Unlock()
+	subscribers := maps.Clone(n.subscribers)
+	n.mu.Unlock()
 
-	for _, ch := range n.receiverChannels {
-		select {
-		case ch <- msg:
-		default:
-			log.Print("Dropping message for subscriber")
+	for id, subscriber := range subscribers {
+		change := msg
+		if !subscriber.filter.Matches(msg) {
+			if !subscriber.filter.NotifySkipped || msg.Sequence == 0 {
+				continue
+			}
+			change = ChangeData{Sequence: msg.Sequence}
 		}
+
+		subscriber.mu.Lock()
+		if subscriber.err == nil && !subscriber.send(change) {
+			log.Printf("Dropping message for subscriber %s (%s, %d dropped)", id, subscriber.options.policy, subscriber.dropped)
+			if subscriber.err != nil {
+				log.Printf("Disconnecting the subscriber %s, %v", id, subscriber.err)
+			}
+		}
+		subscriber.mu.Unlock()
 	}
 }
 
@@ -81,14 +425,13 @@ func (n *NotifierImpl) Close() {
 	log.Print("Closing the notifier")
 
 	n.mu.Lock()
-	defer n.mu.Unlock()
+	subscribers := n.subscribers
+	n.subscribers = map[string]*subscriber{}
+	n.mu.Unlock()
 
-	for id, ch := range n.receiverChannels {
-		select {
-		case <-ch:
-		default:
-			close(ch)
-		}
-		delete(n.receiverChannels, id)
+	for _, subscriber := range subscribers {
+		subscriber.mu.Lock()
+		subscriber.close(errRemoved)
+		subscriber.mu.Unlock()
 	}
 }
@@ -1,6 +1,7 @@
 package notifier
 
 import (
+	"sync"
 	"testing"
 	"time"
 
@@ -12,19 +13,19 @@ func TestNotifier(t *testing.T) {
 	defer notifier.Close()
 
 	t.Run("AddSubscriber", func(t *testing.T) {
-		ch := notifier.AddSubscriber("user1")
+		ch := notifier.AddSubscriber("user1", nil)
 		assert.NotNil(t, ch)
-		assert.Equal(t, 1, len(notifier.receiverChannels))
+		assert.Equal(t, 1, len(notifier.subscribers))
 	})
 
 	t.Run("RemoveSubscriber", func(t *testing.T) {
-		notifier.AddSubscriber("user1")
+		notifier.AddSubscriber("user1", nil)
 		notifier.RemoveSubscriber("user1")
-		assert.Equal(t, 0, len(notifier.receiverChannels))
+		assert.Equal(t, 0, len(notifier.subscribers))
 	})
 
 	t.Run("Broadcast", func(t *testing.T) {
-		ch := notifier.AddSubscriber("user1")
+		ch := notifier.AddSubscriber("user1", nil)
 		msg := ChangeData{OperationType: ChangeOperationInsert, UserId: "user1"}
 		notifier.Broadcast(msg)
 
@@ -37,7 +38,7 @@ func TestNotifier(t *testing.T) {
 	})
 
 	t.Run("BroadcastNonBlocking", func(t *testing.T) {
-		ch := notifier.AddSubscriber("user2")
+		ch := notifier.AddSubscriber("user2", nil)
 
 		for i := 0; i < 10; i++ {
 			notifier.Broadcast(ChangeData{OperationType: ChangeOperationInsert, UserId: "user2"})
@@ -57,8 +58,44 @@ func TestNotifier(t *testing.T) {
 		}
 	})
 
+	t.Run("BroadcastFiltered", func(t *testing.T) {
+		filteredNotifier := NewNotifier()
+		defer filteredNotifier.Close()
+		ch := filteredNotifier.AddSubscriber("user4", &ChangeFilter{OperationTypes: []string{ChangeOperationDelete}})
+
+		filteredNotifier.Broadcast(ChangeData{OperationType: ChangeOperationInsert, UserId: "inserted"})
+		filteredNotifier.Broadcast(ChangeData{OperationType: ChangeOperationDelete, UserId: "deleted"})
+
+		select {
+		case received := <-ch:
+			assert.Equal(t, "deleted", received.UserId)
+		case <-time.After(time.Second):
+			t.Fatal("Expected to receive a message")
+		}
+		assert.Empty(t, ch)
+	})
+
+	t.Run("BroadcastSkipped", func(t *testing.T) {
+		filteredNotifier := NewNotifier()
+		defer filteredNotifier.Close()
+		ch := filteredNotifier.AddSubscriber("user5", &ChangeFilter{OperationTypes: []string{ChangeOperationDelete}, NotifySkipped: true})
+
+		filteredNotifier.Broadcast(ChangeData{EventId: "1", Sequence: 1, OperationType: ChangeOperationInsert, UserId: "inserted"})
+		filteredNotifier.Broadcast(ChangeData{EventId: "unlogged", OperationType: ChangeOperationInsert, UserId: "unlogged"})
+		filteredNotifier.Broadcast(ChangeData{EventId: "2", Sequence: 2, OperationType: ChangeOperationDelete, UserId: "deleted"})
+
+		skipped := <-ch
+		assert.True(t, skipped.Skipped())
+		assert.Equal(t, ChangeData{Sequence: 1}, skipped)
+
+		deleted := <-ch
+		assert.False(t, deleted.Skipped())
+		assert.Equal(t, "deleted", deleted.UserId)
+		assert.Empty(t, ch)
+	})
+
 	t.Run("CloseNotifier", func(t *testing.T) {
-		ch := notifier.AddSubscriber("user3")
+		ch := notifier.AddSubscriber("user3", nil)
 		notifier.Close()
 
 		select {
@@ -69,3 +106,188 @@ func TestNotifier(t *testing.T) {
 		}
 	})
 }
+
+func TestBackpressure(t *testing.T) {
+	broadcast := func(notifier *NotifierImpl, count int) {
+		for i := 1; i <= count; i++ {
+			notifier.Broadcast(ChangeData{Sequence: int64(i), OperationType: ChangeOperationInsert})
+		}
+	}
+
+	receiveAll := func(ch <-chan ChangeData) []int64 {
+		var sequences []int64
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					return sequences
+				}
+				sequences = append(sequences, msg.Sequence)
+			case <-time.After(100 * time.Millisecond):
+				return sequences
+			}
+		}
+	}
+
+	t.Run("Drop the newest changes by default", func(t *testing.T) {
+		notifier := NewNotifier(WithBufferSize(2))
+		defer notifier.Close()
+		ch := notifier.AddSubscriber("subscriber", nil)
+
+		broadcast(notifier, 5)
+
+		assert.Equal(t, uint64(3), notifier.Dropped("subscriber"))
+		assert.Equal(t, []int64{1, 2}, receiveAll(ch))
+		assert.NoError(t, notifier.Err("subscriber"))
+	})
+
+	t.Run("Drop the oldest changes", func(t *testing.T) {
+		notifier := NewNotifier()
+		defer notifier.Close()
+		ch := notifier.AddSubscriber("subscriber", nil, WithBufferSize(2), WithBackpressurePolicy(DropOldest))
+
+		broadcast(notifier, 5)
+
+		assert.Equal(t, uint64(3), notifier.Dropped("subscriber"))
+		assert.Equal(t, []int64{4, 5}, receiveAll(ch))
+	})
+
+	t.Run("Wait for the subscriber to receive the changes", func(t *testing.T) {
+		notifier := NewNotifier(WithBufferSize(1), WithBackpressurePolicy(BlockWithTimeout), WithBlockTimeout(time.Second))
+		defer notifier.Close()
+		ch := notifier.AddSubscriber("subscriber", nil)
+
+		received := make(chan []int64)
+		go func() {
+			var sequences []int64
+			for len(sequences) < 5 {
+				time.Sleep(10 * time.Millisecond)
+				sequences = append(sequences, (<-ch).Sequence)
+			}
+			received <- sequences
+		}()
+		broadcast(notifier, 5)
+
+		assert.Equal(t, []int64{1, 2, 3, 4, 5}, <-received)
+		assert.Zero(t, notifier.Dropped("subscriber"))
+	})
+
+	t.Run("Drop the changes after the block timeout", func(t *testing.T) {
+		notifier := NewNotifier(WithBufferSize(1), WithBackpressurePolicy(BlockWithTimeout), WithBlockTimeout(10*time.Millisecond))
+		defer notifier.Close()
+		ch := notifier.AddSubscriber("subscriber", nil)
+
+		broadcast(notifier, 3)
+
+		assert.Eventually(t, func() bool {
+			return notifier.Dropped("subscriber") == 2
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, []int64{1}, receiveAll(ch))
+	})
+
+	t.Run("Don't make the other subscribers wait for a blocked one", func(t *testing.T) {
+		notifier := NewNotifier(WithBufferSize(1))
+		defer notifier.Close()
+		notifier.AddSubscriber("blocked", nil, WithBackpressurePolicy(BlockWithTimeout), WithBlockTimeout(time.Minute))
+		other := notifier.AddSubscriber("other", nil, WithBufferSize(5))
+
+		start := time.Now()
+		broadcast(notifier, 3)
+
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, []int64{1, 2, 3}, receiveAll(other))
+		assert.Zero(t, notifier.Dropped("blocked"))
+	})
+
+	t.Run("Send the concurrent broadcasts in the same order to every subscriber", func(t *testing.T) {
+		notifier := NewNotifier(WithBufferSize(100))
+		defer notifier.Close()
+		first := notifier.AddSubscriber("first", nil)
+		second := notifier.AddSubscriber("second", nil)
+
+		var wg sync.WaitGroup
+		for i := 1; i <= 50; i++ {
+			wg.Add(1)
+			go func(sequence int64) {
+				defer wg.Done()
+				notifier.Broadcast(ChangeData{Sequence: sequence, OperationType: ChangeOperationInsert})
+			}(int64(i))
+		}
+		wg.Wait()
+
+		sequences := receiveAll(first)
+		assert.Len(t, sequences, 50)
+		assert.Equal(t, sequences, receiveAll(second))
+	})
+
+	t.Run("Disconnect a slow subscriber", func(t *testing.T) {
+		notifier := NewNotifier()
+		defer notifier.Close()
+		slow := notifier.AddSubscriber("slow", nil, WithBufferSize(2), WithBackpressurePolicy(DisconnectSlowConsumer))
+		other := notifier.AddSubscriber("other", nil)
+
+		broadcast(notifier, 5)
+
+		assert.Equal(t, []int64{1, 2}, receiveAll(slow))
+		assert.ErrorIs(t, notifier.Err("slow"), ErrSlowConsumer)
+		assert.Equal(t, []int64{1, 2, 3, 4, 5}, receiveAll(other))
+		assert.NoError(t, notifier.Err("other"))
+
+		notifier.RemoveSubscriber("slow")
+		assert.NoError(t, notifier.Err("slow"))
+	})
+
+	t.Run("Keep the buffer of a subscriber given a non-positive size", func(t *testing.T) {
+		notifier := NewNotifier(WithBufferSize(2))
+		ch := notifier.AddSubscriber("subscriber", nil, WithBufferSize(0))
+		assert.Equal(t, 2, cap(ch))
+
+		ch = NewNotifier().AddSubscriber("subscriber", nil, WithBufferSize(-1))
+		assert.Equal(t, DEFAULT_BUFFER_SIZE, cap(ch))
+	})
+
+	t.Run("Parse the policies", func(t *testing.T) {
+		policy, err := ParseBackpressurePolicy("drop-oldest")
+		assert.NoError(t, err)
+		assert.Equal(t, DropOldest, policy)
+
+		_, err = ParseBackpressurePolicy("unknown")
+		assert.Error(t, err)
+	})
+}
+
+func TestChangeFilter(t *testing.T) {
+	inCountry := func(country string) func(user *UserSnapshot) bool {
+		return func(user *UserSnapshot) bool {
+			return user.Country == country
+		}
+	}
+
+	t.Run("Select every change without a filter", func(t *testing.T) {
+		var changeFilter *ChangeFilter
+		assert.True(t, changeFilter.Matches(ChangeData{OperationType: ChangeOperationUpdate}))
+	})
+
+	t.Run("Select the changes of the given operation types", func(t *testing.T) {
+		changeFilter := &ChangeFilter{OperationTypes: []string{ChangeOperationInsert, ChangeOperationDelete}}
+
+		assert.True(t, changeFilter.Matches(ChangeData{OperationType: ChangeOperationInsert}))
+		assert.True(t, changeFilter.Matches(ChangeData{OperationType: ChangeOperationDelete}))
+		assert.False(t, changeFilter.Matches(ChangeData{OperationType: ChangeOperationUpdate}))
+	})
+
+	t.Run("Select the changes of the users matching before or after the change", func(t *testing.T) {
+		changeFilter := &ChangeFilter{MatchesUser: inCountry("UK")}
+
+		assert.True(t, changeFilter.Matches(ChangeData{After: &UserSnapshot{Country: "UK"}}))
+		assert.True(t, changeFilter.Matches(ChangeData{Before: &UserSnapshot{Country: "UK"}, After: &UserSnapshot{Country: "ITA"}}))
+		assert.False(t, changeFilter.Matches(ChangeData{Before: &UserSnapshot{Country: "ITA"}, After: &UserSnapshot{Country: "ES"}}))
+		assert.False(t, changeFilter.Matches(ChangeData{Before: &UserSnapshot{Country: "ITA"}}))
+	})
+
+	t.Run("Select the changes without the user", func(t *testing.T) {
+		changeFilter := &ChangeFilter{MatchesUser: inCountry("UK")}
+
+		assert.True(t, changeFilter.Matches(ChangeData{OperationType: ChangeOperationDelete}))
+	})
+}
@@ -0,0 +1,88 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	DEFAULT_PUBLISH_ATTEMPTS = 3
+	DEFAULT_PUBLISH_BACKOFF  = 100 * time.Millisecond
+)
+
+// Publisher sends the changes to a message broker, for the consumers outside the service.
+// The changes are published at least once, the consumers can discard the duplicates by their EventId.
+type Publisher interface {
+	Publish(ctx context.Context, change ChangeData) error
+	// Close releases the connection to the broker, once the changes published so far are sent.
+	Close() error
+}
+
+// RetryPublisher publishes through another publisher, retrying the failed attempts with an
+// exponential backoff.
+type RetryPublisher struct {
+	publisher   Publisher
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// NewRetryPublisher makes up to maxAttempts attempts to publish a change, waiting backoff after
+// the first failed one, doubled after each of the following ones.
+func NewRetryPublisher(publisher Publisher, maxAttempts int, backoff time.Duration) *RetryPublisher {
+	return &RetryPublisher{publisher: publisher, maxAttempts: maxAttempts, backoff: backoff}
+}
+
+// Publish returns the error of the last attempt if all of them fail, or the error of the context
+// if it's done while waiting for the next one.
+func (r *RetryPublisher) Publish(ctx context.Context, change ChangeData) error {
+	backoff := r.backoff
+	for attempt := 1; ; attempt++ {
+		err := r.publisher.Publish(ctx, change)
+		if err == nil || attempt >= r.maxAttempts {
+			return err
+		}
+		log.Printf("Can't publish the change %s (attempt %d), %v", change.EventId, attempt, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (r *RetryPublisher) Close() error {
+	return r.publisher.Close()
+}
+
+// MultiPublisher publishes every change through all its publishers.
+type MultiPublisher []Publisher
+
+func NewMultiPublisher(publishers ...Publisher) MultiPublisher {
+	return MultiPublisher(publishers)
+}
+
+// Publish tries all the publishers even if some fail, returning their errors joined. A change
+// published again after an error is published again by all of them.
+func (m MultiPublisher) Publish(ctx context.Context, change ChangeData) error {
+	var errs []error
+	for _, publisher := range m {
+		if err := publisher.Publish(ctx, change); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m MultiPublisher) Close() error {
+	var errs []error
+	for _, publisher := range m {
+		if err := publisher.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
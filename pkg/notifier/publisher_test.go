@@ -0,0 +1,88 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyPublisher fails the first attempts to publish a change.
+type flakyPublisher struct {
+	failures int
+	attempts int
+	closed   bool
+}
+
+func (f *flakyPublisher) Publish(ctx context.Context, change ChangeData) error {
+	f.attempts++
+	if f.attempts <= f.failures {
+		return errors.New("no responders")
+	}
+	return nil
+}
+
+func (f *flakyPublisher) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestRetryPublisher(t *testing.T) {
+	change := ChangeData{EventId: "event", OperationType: ChangeOperationInsert, UserId: "user1"}
+
+	t.Run("Retry until the change is published", func(t *testing.T) {
+		publisher := &flakyPublisher{failures: 2}
+
+		err := NewRetryPublisher(publisher, 3, time.Millisecond).Publish(context.Background(), change)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 3, publisher.attempts)
+	})
+
+	t.Run("Return the last error after the last attempt", func(t *testing.T) {
+		publisher := &flakyPublisher{failures: 5}
+
+		err := NewRetryPublisher(publisher, 3, time.Millisecond).Publish(context.Background(), change)
+
+		assert.EqualError(t, err, "no responders")
+		assert.Equal(t, 3, publisher.attempts)
+	})
+
+	t.Run("Stop retrying when the context is done", func(t *testing.T) {
+		publisher := &flakyPublisher{failures: 5}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := NewRetryPublisher(publisher, 3, time.Minute).Publish(ctx, change)
+
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 1, publisher.attempts)
+	})
+
+	t.Run("Close the publisher", func(t *testing.T) {
+		publisher := &flakyPublisher{}
+
+		assert.NoError(t, NewRetryPublisher(publisher, 3, time.Millisecond).Close())
+		assert.True(t, publisher.closed)
+	})
+}
+
+func TestMultiPublisher(t *testing.T) {
+	change := ChangeData{EventId: "event", OperationType: ChangeOperationInsert, UserId: "user1"}
+
+	t.Run("Publish through all the publishers joining their errors", func(t *testing.T) {
+		failing, working := &flakyPublisher{failures: 1}, &flakyPublisher{}
+		publisher := NewMultiPublisher(failing, working)
+
+		assert.EqualError(t, publisher.Publish(context.Background(), change), "no responders")
+		assert.Equal(t, 1, failing.attempts)
+		assert.Equal(t, 1, working.attempts)
+
+		assert.NoError(t, publisher.Publish(context.Background(), change))
+		assert.NoError(t, publisher.Close())
+		assert.True(t, failing.closed)
+		assert.True(t, working.closed)
+	})
+}
@@ -0,0 +1,59 @@
+package proto
+
+import (
+	"time"
+
+	"github.com/dlion/faceit_challenge/pkg/cloudevents"
+	"github.com/dlion/faceit_challenge/pkg/notifier"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// NewWatchResponse describes the change as it's streamed by Watch, and published to the brokers.
+func NewWatchResponse(change notifier.ChangeData) *WatchResponse {
+	response := &WatchResponse{
+		ChangeType:    change.OperationType,
+		UserId:        change.UserId,
+		Sequence:      change.Sequence,
+		EventId:       change.EventId,
+		ChangedFields: change.ChangedFields,
+		Before:        newSnapshotUser(change.Before),
+		After:         newSnapshotUser(change.After),
+	}
+	if !change.Timestamp.IsZero() {
+		response.Timestamp = timestamppb.New(change.Timestamp)
+	}
+	return response
+}
+
+// NewWatchEvent describes the change as NewWatchResponse, with the attributes of its CloudEvent sent from the source.
+func NewWatchEvent(source string, change notifier.ChangeData) (*WatchResponse, error) {
+	event, err := cloudevents.NewEvent(source, change)
+	if err != nil {
+		return nil, err
+	}
+
+	response := NewWatchResponse(change)
+	response.Type = event.Type
+	response.Source = event.Source
+	response.SpecVersion = event.SpecVersion
+	response.Subject = event.Subject
+	return response, nil
+}
+
+func newSnapshotUser(snapshot *notifier.UserSnapshot) *User {
+	if snapshot == nil {
+		return nil
+	}
+
+	return &User{
+		Id:        snapshot.Id,
+		FirstName: snapshot.FirstName,
+		LastName:  snapshot.LastName,
+		Nickname:  snapshot.Nickname,
+		Email:     snapshot.Email,
+		Country:   snapshot.Country,
+		CreatedAt: snapshot.CreatedAt.Format(time.RFC3339),
+		UpdatedAt: snapshot.UpdatedAt.Format(time.RFC3339),
+		Version:   snapshot.Version,
+	}
+}